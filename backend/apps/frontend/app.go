@@ -0,0 +1,22 @@
+// Package frontend registers the server-rendered pages and their form
+// endpoints.
+package frontend
+
+import (
+	"github.com/beego/beego/v2/server/web"
+
+	"github.com/mymi14s/goconda/apps"
+	"github.com/mymi14s/goconda/apps/frontend/controllers"
+)
+
+func init() {
+	apps.Register(apps.App{
+		Name: "frontend",
+		// pages live outside /api/v1
+		Setup: func() {
+			web.Router("/", &controllers.FrontendController{}, "get:Index")
+			web.Router("/frontend/api/get-info", &controllers.FrontendController{}, "get:GetInfo")
+			web.Router("/frontend/api/contact-form", &controllers.FrontendController{}, "post:ContactForm")
+		},
+	})
+}
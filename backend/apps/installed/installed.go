@@ -0,0 +1,10 @@
+// Package installed links the apps into the binary. To install an app, add
+// a blank import of its package here; [apps] enabled decides which of them
+// run.
+package installed
+
+import (
+	_ "github.com/mymi14s/goconda/apps/frontend"
+	_ "github.com/mymi14s/goconda/apps/items"
+	_ "github.com/mymi14s/goconda/apps/notes"
+)
@@ -0,0 +1,36 @@
+// Package items registers the sample items app: CRUD with sharing.
+package items
+
+import (
+	"github.com/beego/beego/v2/server/web"
+
+	"github.com/mymi14s/goconda/apps"
+	"github.com/mymi14s/goconda/apps/items/controllers"
+	"github.com/mymi14s/goconda/apps/items/models"
+	base_models "github.com/mymi14s/goconda/models"
+)
+
+func init() {
+	apps.Register(apps.App{
+		Name:       "items",
+		Models:     []any{new(models.Item), new(models.ItemShare)},
+		Migrations: Migrations,
+		Routes: []web.LinkNamespace{
+			web.NSRouter("/items", &controllers.ItemController{}, "get:List;post:Create"),
+			web.NSRouter("/items/:id", &controllers.ItemController{}, "get:GetOne;put:Update;delete:Delete"),
+			web.NSRouter("/items/:id/shares", &controllers.ItemController{}, "get:Shares;post:Share"),
+			web.NSRouter("/items/:id/shares/:share_id", &controllers.ItemController{}, "delete:Unshare"),
+		},
+		Protected: []string{
+			"/api/v1/items",
+			"/api/v1/items/*", // covers /items/:id paths
+		},
+		Setup: func() {
+			base_models.RegisterTenantModel(new(models.Item))
+			for _, r := range models.UserRelations {
+				base_models.RegisterUserRelation(r)
+			}
+			base_models.RegisterUserPurge(models.PurgeUserItems)
+		},
+	})
+}
@@ -1,21 +1,40 @@
 package controllers
 
 import (
-	"encoding/json"
+	"errors"
 	"strconv"
+	"strings"
 
 	"github.com/mymi14s/goconda/apps/items/models"
 	base_controller "github.com/mymi14s/goconda/controllers"
+	base_models "github.com/mymi14s/goconda/models"
+	"github.com/mymi14s/goconda/utils/query"
 
 	"github.com/beego/beego/v2/client/orm"
 )
 
 type ItemController struct {
 	base_controller.BaseController
+	user *base_models.User
+	org  int64 // active organization; items are scoped to it
 }
 
+// Prepare authenticates once for every action. Personal access tokens need
+// an "items" scope, or "item_shares" for the sharing endpoints.
 func (c *ItemController) Prepare() {
-	c.MustAuth()
+	user, ok := c.MustAuth()
+	if !ok {
+		c.StopRun()
+	}
+	resource := "items"
+	if strings.Contains(c.Ctx.Input.URL(), "/shares") {
+		resource = "item_shares"
+	}
+	if !c.RequireScope(resource) {
+		c.StopRun()
+	}
+	c.user = user
+	c.org = c.CurrentOrg()
 }
 
 type itemReq struct {
@@ -23,34 +42,57 @@ type itemReq struct {
 	Description string `json:"description"`
 }
 
+// roles returns the current user's effective roles, for role shares.
+func (c *ItemController) roles() []string {
+	set, err := base_models.EffectivePermissionsIn(c.user.ID, c.org)
+	if err != nil {
+		return nil
+	}
+	return append(append([]string{}, set.Roles...), set.OrgRoles...)
+}
+
+// List returns the user's own items, or with ?scope=shared the items other
+// users shared with them. It takes the filters, sort, q and cursor paging of
+// models.ItemQuery; next and prev link to the neighbouring pages.
 // @router /api/v1/items [get]
 func (c *ItemController) List() {
-	user, ok := c.MustAuth()
-	if !ok {
+	user := c.user
+	q, err := query.Parse(c.Ctx.Request.URL.Query(), models.ItemQuery)
+	if err != nil {
+		c.JSONError(400, err.Error())
+		return
+	}
+	var (
+		items []*models.Item
+		page  *query.Page
+	)
+	switch c.GetString("scope", "owned") {
+	case "owned":
+		items, page, err = models.ListItemsByOwner(c.org, user.ID, q)
+	case "shared":
+		items, page, err = models.ListItemsSharedWith(c.org, user.ID, c.roles(), q)
+	default:
+		c.JSONError(400, "scope must be owned or shared")
 		return
 	}
-	limit, _ := c.GetInt64("limit", 20)
-	offset, _ := c.GetInt64("offset", 0)
-	items, total, err := models.ListItemsByOwner(user.Email, limit, offset)
 	if err != nil {
 		c.JSONError(500, "failed to list items")
 		return
 	}
 	c.JSONOK(map[string]interface{}{
-		"total": total,
+		"total": page.Total,
 		"items": items,
+		"next":  query.Link(c.Ctx.Request.URL, page.Next),
+		"prev":  query.Link(c.Ctx.Request.URL, page.Prev),
 	})
 }
 
 // @router /api/v1/items [post]
 func (c *ItemController) Create() {
-	user, ok := c.MustAuth()
-	if !ok {
-		return
-	}
+	user := c.user
 
 	var req itemReq
-	if err := json.Unmarshal(c.Ctx.Input.RequestBody, &req); err != nil {
+	if err := c.ParseJSON(&req); err != nil {
 		c.JSONError(400, "invalid json")
 		return
 	}
@@ -63,57 +105,59 @@ func (c *ItemController) Create() {
 		Description: req.Description,
 		Owner:       user,
 	}
-	o := orm.NewOrm()
-	if _, err := o.Insert(&it); err != nil {
+	if err := models.CreateItem(c.org, &it); err != nil {
 		c.JSONError(500, "failed to create item")
 		return
 	}
 	c.JSONOK(it)
 }
 
-// @router /api/v1/items/:id [get]
-func (c *ItemController) GetOne() {
-	user, ok := c.MustAuth()
-	if !ok {
-		return
-	}
-	idStr := c.Ctx.Input.Param(":id")
-	id, _ := strconv.ParseInt(idStr, 10, 64)
-	o := orm.NewOrm()
-	it := models.Item{ID: id}
-	if err := o.Read(&it); err != nil {
+// load reads the :id item and checks the user has at least need access.
+func (c *ItemController) load(need string) (*models.Item, bool) {
+	id, _ := strconv.ParseInt(c.Ctx.Input.Param(":id"), 10, 64)
+	it, err := models.GetItemByID(c.org, id)
+	if err != nil || it == nil {
 		c.JSONError(404, "not found")
-		return
+		return nil, false
+	}
+	access, err := models.ItemAccess(it, c.user.ID, c.roles())
+	if err != nil {
+		c.JSONError(500, "failed to check access")
+		return nil, false
 	}
-	o.LoadRelated(&it, "Owner")
-	if it.Owner == nil || it.Owner.Email != user.Email {
+	if accessRank[access] < accessRank[need] {
 		c.JSONError(403, "forbidden")
+		return nil, false
+	}
+	return it, true
+}
+
+var accessRank = map[string]int{
+	models.AccessNone:   0,
+	models.AccessViewer: 1,
+	models.AccessEditor: 2,
+	models.AccessOwner:  3,
+}
+
+// @router /api/v1/items/:id [get]
+func (c *ItemController) GetOne() {
+	it, ok := c.load(models.AccessViewer)
+	if !ok {
 		return
 	}
+	orm.NewOrm().LoadRelated(it, "Owner")
 	c.JSONOK(it)
 }
 
+// Update is allowed for the owner and editors.
 // @router /api/v1/items/:id [put]
 func (c *ItemController) Update() {
-	user, ok := c.MustAuth()
+	it, ok := c.load(models.AccessEditor)
 	if !ok {
 		return
 	}
-	idStr := c.Ctx.Input.Param(":id")
-	id, _ := strconv.ParseInt(idStr, 10, 64)
-	o := orm.NewOrm()
-	it := models.Item{ID: id}
-	if err := o.Read(&it); err != nil {
-		c.JSONError(404, "not found")
-		return
-	}
-	o.LoadRelated(&it, "Owner")
-	if it.Owner == nil || it.Owner.Email != user.Email {
-		c.JSONError(403, "forbidden")
-		return
-	}
 	var req itemReq
-	if err := json.Unmarshal(c.Ctx.Input.RequestBody, &req); err != nil {
+	if err := c.ParseJSON(&req); err != nil {
 		c.JSONError(400, "invalid json")
 		return
 	}
@@ -123,35 +167,119 @@ func (c *ItemController) Update() {
 	if req.Description != "" {
 		it.Description = req.Description
 	}
-	if _, err := o.Update(&it); err != nil {
+	o := base_models.ForOrg(c.org)
+	if _, err := o.Update(it); err != nil {
 		c.JSONError(500, "failed to update item")
 		return
 	}
+	o.LoadRelated(it, "Owner")
 	c.JSONOK(it)
 }
 
+// Delete is allowed for the owner only.
 // @router /api/v1/items/:id [delete]
 func (c *ItemController) Delete() {
-	user, ok := c.MustAuth()
+	it, ok := c.load(models.AccessOwner)
 	if !ok {
 		return
 	}
-	idStr := c.Ctx.Input.Param(":id")
-	id, _ := strconv.ParseInt(idStr, 10, 64)
-	o := orm.NewOrm()
-	it := models.Item{ID: id}
-	if err := o.Read(&it); err != nil {
-		c.JSONError(404, "not found")
+	if _, err := base_models.ForOrg(c.org).Delete(it); err != nil {
+		c.JSONError(500, "failed to delete")
 		return
 	}
-	o.LoadRelated(&it, "Owner")
-	if it.Owner == nil || it.Owner.Email != user.Email {
-		c.JSONError(403, "forbidden")
+	c.JSONOK(map[string]any{"deleted": it.ID})
+}
+
+type shareReq struct {
+	Email      string `json:"email"`
+	Role       string `json:"role"`
+	Permission string `json:"permission"`
+}
+
+// Shares lists who the item is shared with (owner only).
+// @router /api/v1/items/:id/shares [get]
+func (c *ItemController) Shares() {
+	it, ok := c.load(models.AccessOwner)
+	if !ok {
 		return
 	}
-	if _, err := o.Delete(&it); err != nil {
-		c.JSONError(500, "failed to delete")
+	shares, err := models.ListItemShares(it.ID)
+	if err != nil {
+		c.JSONError(500, "failed to list shares")
+		return
+	}
+	if shares == nil {
+		shares = []models.ItemShare{}
+	}
+	c.JSONOK(shares)
+}
+
+// Share grants a user or a role viewer or editor access (owner only).
+// Sharing again with the same user or role changes the permission.
+// @router /api/v1/items/:id/shares [post]
+func (c *ItemController) Share() {
+	it, ok := c.load(models.AccessOwner)
+	if !ok {
+		return
+	}
+	var req shareReq
+	if err := c.ParseJSON(&req); err != nil {
+		c.JSONError(400, "invalid json")
+		return
+	}
+	email := strings.ToLower(strings.TrimSpace(req.Email))
+	role := strings.TrimSpace(req.Role)
+	if (email == "") == (role == "") {
+		c.JSONError(400, "set either email or role")
+		return
+	}
+	if req.Permission == "" {
+		req.Permission = models.AccessViewer
+	}
+	if req.Permission != models.AccessViewer && req.Permission != models.AccessEditor {
+		c.JSONError(400, "permission must be viewer or editor")
+		return
+	}
+	var userID string
+	if email != "" {
+		if email == c.user.Email {
+			c.JSONError(400, "you already own this item")
+			return
+		}
+		u, _ := base_models.GetUserByEmail(email)
+		if u == nil {
+			c.JSONError(404, "user not found")
+			return
+		}
+		userID = u.ID
+	} else if r, _ := base_models.GetRole(role); r == nil {
+		c.JSONError(404, "role not found")
+		return
+	}
+	share, err := models.ShareItem(it.ID, userID, role, req.Permission, c.user.ID)
+	if err != nil {
+		c.JSONError(500, "failed to share item")
+		return
+	}
+	c.JSONOK(share)
+}
+
+// Unshare revokes one share (owner only).
+// @router /api/v1/items/:id/shares/:share_id [delete]
+func (c *ItemController) Unshare() {
+	it, ok := c.load(models.AccessOwner)
+	if !ok {
+		return
+	}
+	shareID, _ := strconv.ParseInt(c.Ctx.Input.Param(":share_id"), 10, 64)
+	err := models.UnshareItem(it.ID, shareID)
+	if errors.Is(err, models.ErrShareNotFound) {
+		c.JSONError(404, err.Error())
+		return
+	}
+	if err != nil {
+		c.JSONError(500, "failed to revoke share")
 		return
 	}
-	c.JSONOK(map[string]any{"deleted": id})
+	c.JSONOK(map[string]any{"deleted": shareID})
 }
@@ -0,0 +1,13 @@
+package items
+
+import (
+	"embed"
+
+	"github.com/mymi14s/goconda/utils/migrate"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// Migrations are the schema migrations of the items tables.
+var Migrations = migrate.MustLoad("items", migrationFiles, "migrations")
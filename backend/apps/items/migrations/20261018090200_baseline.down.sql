@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS `item_share`;
+DROP TABLE IF EXISTS `item`;
@@ -0,0 +1,29 @@
+-- Schema of the items tables as created by orm.RunSyncdb before
+-- migrations; existing tables and indexes are left alone.
+
+-- Item
+CREATE TABLE IF NOT EXISTS `item` (
+    `id` bigint AUTO_INCREMENT NOT NULL PRIMARY KEY,
+    `name` varchar(200) NOT NULL DEFAULT '',
+    `description` longtext NOT NULL,
+    `owner_id` varchar(36) NOT NULL,
+    `org_id` bigint NOT NULL DEFAULT 0,
+    `created_at` datetime NOT NULL,
+    `updated_at` datetime NOT NULL
+) ENGINE=INNODB;
+CREATE INDEX `item_owner_id` ON `item` (`owner_id`);
+CREATE INDEX `item_org_id` ON `item` (`org_id`);
+
+-- ItemShare
+CREATE TABLE IF NOT EXISTS `item_share` (
+    `id` bigint AUTO_INCREMENT NOT NULL PRIMARY KEY,
+    `item_id` bigint NOT NULL,
+    `user_id` varchar(36) NOT NULL DEFAULT '',
+    `role` varchar(100) NOT NULL DEFAULT '',
+    `permission` varchar(10) NOT NULL DEFAULT '',
+    `created_by` varchar(36) NOT NULL DEFAULT '',
+    `created_at` datetime NOT NULL,
+   UNIQUE (`item_id`, `user_id`, `role`)
+) ENGINE=INNODB;
+CREATE INDEX `item_share_user_id` ON `item_share` (`user_id`);
+CREATE INDEX `item_share_role` ON `item_share` (`role`);
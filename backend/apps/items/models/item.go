@@ -4,6 +4,7 @@ import (
 	"time"
 
 	"github.com/mymi14s/goconda/models"
+	"github.com/mymi14s/goconda/utils/query"
 
 	"github.com/beego/beego/v2/client/orm"
 )
@@ -12,20 +13,25 @@ type Item struct {
 	ID          int64        `orm:"auto;pk;column(id)" json:"id"`
 	Name        string       `orm:"size(200)" json:"name"`
 	Description string       `orm:"type(text)" json:"description"`
-	Owner       *models.User `orm:"rel(fk);column(owner_email);index" json:"owner"`
+	Owner       *models.User `orm:"rel(fk);column(owner_id);index" json:"owner"`
+	OrgID       int64        `orm:"column(org_id);index;default(0)" json:"org_id,omitempty"`
 	CreatedAt   time.Time    `orm:"auto_now_add;type(datetime)" json:"created_at"`
 	UpdatedAt   time.Time    `orm:"auto_now;type(datetime)" json:"updated_at"`
 }
 
 func (i *Item) TableName() string { return "item" }
 
-func CreateItem(i *Item) error {
-	_, err := orm.NewOrm().Insert(i)
+// Items belong to the organization they were created in.
+func (i *Item) TenantID() int64       { return i.OrgID }
+func (i *Item) SetTenantID(org int64) { i.OrgID = org }
+
+func CreateItem(org int64, i *Item) error {
+	_, err := models.ForOrg(org).Insert(i)
 	return err
 }
 
-func GetItemByID(id int64) (*Item, error) {
-	o := orm.NewOrm()
+func GetItemByID(org, id int64) (*Item, error) {
+	o := models.ForOrg(org)
 	it := Item{ID: id}
 	if err := o.Read(&it); err != nil {
 		if err == orm.ErrNoRows {
@@ -36,18 +42,55 @@ func GetItemByID(id int64) (*Item, error) {
 	return &it, nil
 }
 
-func ListItemsByOwner(ownerEmail string, offset, limit int64) ([]*Item, int64, error) {
-	o := orm.NewOrm()
-	qs := o.QueryTable(new(Item)).Filter("Owner__Email", ownerEmail)
-	total, err := qs.Count()
-	if err != nil {
-		return nil, 0, err
-	}
-	var items []*Item
-	_, err = qs.OrderBy("-ID").Limit(limit, offset).All(&items)
-	return items, total, err
+// ItemQuery is what the item list accepts: filters and sort on these
+// fields, and q matched against the name and description.
+var ItemQuery = query.Spec{
+	Fields: map[string]query.Field{
+		"id":          {Name: "ID", Kind: query.Int, Sort: true},
+		"name":        {Name: "Name", Kind: query.String, Sort: true},
+		"description": {Name: "Description", Kind: query.String},
+		"created_at":  {Name: "CreatedAt", Kind: query.Time, Sort: true},
+		"updated_at":  {Name: "UpdatedAt", Kind: query.Time, Sort: true},
+	},
+	Search:      []string{"Name", "Description"},
+	DefaultSort: "-id",
+}
+
+// ListItemsByOwner returns a page of the owner's items in organization org.
+func ListItemsByOwner(org int64, ownerID string, q *query.Query) ([]*Item, *query.Page, error) {
+	qs := models.ForOrg(org).QueryTable(new(Item)).Filter("Owner", ownerID)
+	items := []*Item{}
+	page, err := q.Find(qs, &items)
+	return items, page, err
+}
+
+// UserRelations are the item tables that referred to users by email, for
+// the user ID migration.
+var UserRelations = []models.UserRelation{
+	{Table: "item", Columns: map[string]string{"owner_id": "owner_email"}},
+	{
+		Table:    "item_share",
+		Columns:  map[string]string{"user_id": "email", "created_by": "created_by"},
+		Optional: []string{"created_by"},
+	},
 }
 
-func init() {
-	orm.RegisterModel(new(Item))
+// PurgeUserItems deletes a purged user's personal items and the shares made
+// to the user. Items created in an organization stay with it, owned by the
+// anonymized account.
+func PurgeUserItems(tx orm.TxOrmer, userID string) error {
+	var ids orm.ParamsList
+	if _, err := tx.QueryTable(new(Item)).Filter("Owner", userID).Filter("OrgID", 0).ValuesFlat(&ids, "ID"); err != nil {
+		return err
+	}
+	if len(ids) > 0 {
+		if _, err := tx.QueryTable(new(ItemShare)).Filter("Item__in", ids...).Delete(); err != nil {
+			return err
+		}
+		if _, err := tx.QueryTable(new(Item)).Filter("ID__in", ids...).Delete(); err != nil {
+			return err
+		}
+	}
+	_, err := tx.QueryTable(new(ItemShare)).Filter("UserID", userID).Delete()
+	return err
 }
@@ -0,0 +1,123 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/beego/beego/v2/client/orm"
+
+	"github.com/mymi14s/goconda/models"
+	"github.com/mymi14s/goconda/utils/query"
+)
+
+// Access levels on an item, weakest first.
+const (
+	AccessNone   = ""
+	AccessViewer = "viewer"
+	AccessEditor = "editor"
+	AccessOwner  = "owner"
+)
+
+var ErrShareNotFound = errors.New("share not found")
+
+// ItemShare grants a user (UserID) or everyone holding a role (Role) viewer
+// or editor access to an item. Exactly one of UserID and Role is set.
+type ItemShare struct {
+	ID         int64     `orm:"auto;pk;column(id)" json:"id"`
+	Item       *Item     `orm:"rel(fk);column(item_id);on_delete(cascade)" json:"-"`
+	UserID     string    `orm:"size(36);column(user_id);index" json:"user_id,omitempty"`
+	Role       string    `orm:"size(100);index" json:"role,omitempty"`
+	Permission string    `orm:"size(10)" json:"permission"`
+	CreatedBy  string    `orm:"size(36)" json:"created_by"` // user ID
+	CreatedAt  time.Time `orm:"auto_now_add;type(datetime)" json:"created_at"`
+}
+
+func (s *ItemShare) TableName() string { return "item_share" }
+
+func (s *ItemShare) TableUnique() [][]string {
+	return [][]string{{"Item", "UserID", "Role"}}
+}
+
+// ShareItem grants or updates access for the user userID or role.
+func ShareItem(itemID int64, userID, role, permission, by string) (*ItemShare, error) {
+	o := orm.NewOrm()
+	s := ItemShare{}
+	err := o.QueryTable(new(ItemShare)).Filter("Item", itemID).Filter("UserID", userID).Filter("Role", role).One(&s)
+	if err == nil {
+		s.Permission = permission
+		_, err = o.Update(&s, "Permission")
+		return &s, err
+	}
+	if err != orm.ErrNoRows {
+		return nil, err
+	}
+	s = ItemShare{Item: &Item{ID: itemID}, UserID: userID, Role: role, Permission: permission, CreatedBy: by}
+	_, err = o.Insert(&s)
+	return &s, err
+}
+
+// UnshareItem removes one share of the item.
+func UnshareItem(itemID, shareID int64) error {
+	n, err := orm.NewOrm().QueryTable(new(ItemShare)).Filter("ID", shareID).Filter("Item", itemID).Delete()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrShareNotFound
+	}
+	return nil
+}
+
+func ListItemShares(itemID int64) ([]ItemShare, error) {
+	var out []ItemShare
+	_, err := orm.NewOrm().QueryTable(new(ItemShare)).Filter("Item", itemID).OrderBy("ID").All(&out)
+	return out, err
+}
+
+// sharesFor matches shares made to the user directly or to any of roles.
+func sharesFor(userID string, roles []string) orm.QuerySeter {
+	cond := orm.NewCondition().Or("UserID", userID)
+	if len(roles) > 0 {
+		cond = cond.Or("Role__in", roles)
+	}
+	return orm.NewOrm().QueryTable(new(ItemShare)).SetCond(cond)
+}
+
+// ItemAccess returns the strongest access the user (holding roles) has to it.
+func ItemAccess(it *Item, userID string, roles []string) (string, error) {
+	if it.Owner != nil && it.Owner.ID == userID {
+		return AccessOwner, nil
+	}
+	var shares []ItemShare
+	if _, err := sharesFor(userID, roles).Filter("Item", it.ID).All(&shares, "Permission"); err != nil {
+		return AccessNone, err
+	}
+	access := AccessNone
+	for _, s := range shares {
+		if s.Permission == AccessEditor {
+			return AccessEditor, nil
+		}
+		access = AccessViewer
+	}
+	return access, nil
+}
+
+// ListItemsSharedWith returns a page of the items of organization org
+// shared with the user or its roles.
+func ListItemsSharedWith(org int64, userID string, roles []string, q *query.Query) ([]*Item, *query.Page, error) {
+	items := []*Item{}
+	var shares []ItemShare
+	if _, err := sharesFor(userID, roles).All(&shares, "Item"); err != nil {
+		return nil, nil, err
+	}
+	if len(shares) == 0 {
+		return items, &query.Page{}, nil
+	}
+	ids := make([]int64, 0, len(shares))
+	for _, s := range shares {
+		ids = append(ids, s.Item.ID)
+	}
+	qs := models.ForOrg(org).QueryTable(new(Item)).Filter("ID__in", ids).Exclude("Owner", userID)
+	page, err := q.Find(qs, &items)
+	return items, page, err
+}
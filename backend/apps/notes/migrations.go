@@ -0,0 +1,13 @@
+package notes
+
+import (
+	"embed"
+
+	"github.com/mymi14s/goconda/utils/migrate"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// Migrations are the schema migrations of the notes tables.
+var Migrations = migrate.MustLoad("notes", migrationFiles, "migrations")
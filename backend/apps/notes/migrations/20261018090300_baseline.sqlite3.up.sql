@@ -0,0 +1,16 @@
+-- Schema of the notes tables as created by orm.RunSyncdb before
+-- migrations; existing tables and indexes are left alone.
+
+-- Note
+CREATE TABLE IF NOT EXISTS `note` (
+    `id` integer NOT NULL PRIMARY KEY AUTOINCREMENT,
+    `title` varchar(200) NOT NULL DEFAULT '',
+    `body` text NOT NULL,
+    `pinned` bool NOT NULL DEFAULT false,
+    `owner_id` varchar(36) NOT NULL DEFAULT '',
+    `org_id` integer NOT NULL DEFAULT 0,
+    `created_at` datetime NOT NULL,
+    `updated_at` datetime NOT NULL
+);
+CREATE INDEX `note_owner_id` ON `note` (`owner_id`);
+CREATE INDEX `note_org_id` ON `note` (`org_id`);
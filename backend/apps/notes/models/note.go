@@ -0,0 +1,46 @@
+package models
+
+import (
+	"time"
+
+	"github.com/beego/beego/v2/client/orm"
+
+	"github.com/mymi14s/goconda/utils/query"
+)
+
+// Note is a private note, served by the generic ResourceController.
+type Note struct {
+	ID        int64     `orm:"auto;pk;column(id)" json:"id"`
+	Title     string    `orm:"size(200)" json:"title"`
+	Body      string    `orm:"type(text)" json:"body"`
+	Pinned    bool      `orm:"default(false)" json:"pinned"`
+	OwnerID   string    `orm:"size(36);column(owner_id);index" json:"owner_id"`
+	OrgID     int64     `orm:"column(org_id);index;default(0)" json:"org_id,omitempty"`
+	CreatedAt time.Time `orm:"auto_now_add;type(datetime)" json:"created_at"`
+	UpdatedAt time.Time `orm:"auto_now;type(datetime)" json:"updated_at"`
+}
+
+func (n *Note) TableName() string { return "note" }
+
+func (n *Note) TenantID() int64       { return n.OrgID }
+func (n *Note) SetTenantID(org int64) { n.OrgID = org }
+
+// NoteQuery is what the note list accepts.
+var NoteQuery = query.Spec{
+	Fields: map[string]query.Field{
+		"id":         {Name: "ID", Kind: query.Int, Sort: true},
+		"title":      {Name: "Title", Kind: query.String, Sort: true},
+		"pinned":     {Name: "Pinned", Kind: query.Bool, Sort: true},
+		"created_at": {Name: "CreatedAt", Kind: query.Time, Sort: true},
+		"updated_at": {Name: "UpdatedAt", Kind: query.Time, Sort: true},
+	},
+	Search:      []string{"Title", "Body"},
+	DefaultSort: "-pinned,-updated_at",
+}
+
+// PurgeUserNotes deletes every note of a purged user; notes are private,
+// even in an organization.
+func PurgeUserNotes(tx orm.TxOrmer, userID string) error {
+	_, err := tx.QueryTable(new(Note)).Filter("OwnerID", userID).Delete()
+	return err
+}
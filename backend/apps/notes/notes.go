@@ -0,0 +1,53 @@
+// Package notes is a private notes app built entirely on the generic
+// controllers.ResourceController.
+package notes
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/beego/beego/v2/server/web"
+
+	"github.com/mymi14s/goconda/apps"
+	"github.com/mymi14s/goconda/apps/notes/models"
+	"github.com/mymi14s/goconda/controllers"
+	base_models "github.com/mymi14s/goconda/models"
+)
+
+func init() {
+	apps.Register(apps.App{
+		Name:       "notes",
+		Models:     []any{new(models.Note)},
+		Migrations: Migrations,
+		Routes:     []web.LinkNamespace{controllers.ResourceRoutes("/notes", Resource)},
+		Protected:  []string{"/api/v1/notes", "/api/v1/notes/*"},
+		Setup: func() {
+			base_models.RegisterTenantModel(new(models.Note))
+			base_models.RegisterUserPurge(models.PurgeUserNotes)
+		},
+	})
+}
+
+// Resource serves /api/v1/notes. Personal access tokens need a "notes"
+// scope.
+var Resource = &controllers.Resource{
+	Name:     "notes",
+	New:      func() any { return new(models.Note) },
+	Writable: []string{"title", "body", "pinned"},
+	Required: []string{"title"},
+	Query:    models.NoteQuery,
+	Owner:    "OwnerID",
+	Validate: validate,
+}
+
+func validate(obj any) error {
+	n := obj.(*models.Note)
+	n.Title = strings.TrimSpace(n.Title)
+	switch {
+	case n.Title == "":
+		return errors.New("title is required")
+	case len(n.Title) > 200:
+		return errors.New("title must be at most 200 characters")
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+// Package apps is the plugin registry for the modules under apps/. Each app
+// registers itself from an init function:
+//
+//	func init() {
+//		apps.Register(apps.App{
+//			Name:      "notes",
+//			Models:    []any{new(models.Note)},
+//			Routes:    []web.LinkNamespace{controllers.ResourceRoutes("/notes", Resource)},
+//			Protected: []string{"/api/v1/notes", "/api/v1/notes/*"},
+//		})
+//	}
+//
+// is linked into the binary by a blank import in apps/installed, and is
+// switched on with [apps] enabled.
+package apps
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/beego/beego/v2/client/orm"
+	"github.com/beego/beego/v2/server/web"
+
+	"github.com/mymi14s/goconda/middleware"
+	"github.com/mymi14s/goconda/models"
+	"github.com/mymi14s/goconda/utils/migrate"
+	"github.com/mymi14s/goconda/utils/scheduler"
+)
+
+// App is what a module contributes to the server.
+type App struct {
+	Name string
+	// Models are registered with the ORM; Migrations create their tables.
+	Models     []any
+	Migrations []migrate.Migration
+	// Routes are mounted under /api/v1.
+	Routes []web.LinkNamespace
+	// Protected paths need an access token (middleware.ProtectMany); Public
+	// ones are reachable without one.
+	Protected []string
+	Public    []string
+	// Permissions are granted when the app starts (see models.EnsurePermission).
+	Permissions []Permission
+	// Jobs are added to the task scheduler.
+	Jobs []Job
+	// Setup runs when the app is loaded, before the database is opened, for
+	// anything else: tenant models, user relations, user purgers, pages
+	// outside /api/v1, ...
+	Setup func()
+}
+
+// Permission is an allow rule seeded for a role.
+type Permission struct {
+	Role, Resource, Action string
+}
+
+// Job is a scheduled task; Spec is a cron expression with seconds.
+type Job struct {
+	Name string
+	Spec string
+	Run  func()
+}
+
+var (
+	registry = map[string]*App{}
+	enabled  []*App
+)
+
+// Register adds an app to the registry. It panics on a missing or repeated
+// name, as it runs from init.
+func Register(a App) {
+	if a.Name == "" {
+		panic("apps: Register with an empty name")
+	}
+	if _, dup := registry[a.Name]; dup {
+		panic("apps: " + a.Name + " registered twice")
+	}
+	registry[a.Name] = &a
+}
+
+// Registered returns the names of every registered app, sorted.
+func Registered() []string {
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Enabled returns the loaded apps, in load order.
+func Enabled() []*App {
+	return enabled
+}
+
+// enabledNames reads apps::enabled, a comma-separated list; empty or "*"
+// enables every registered app.
+func enabledNames() []string {
+	raw := strings.TrimSpace(web.AppConfig.DefaultString("apps::enabled", ""))
+	if raw == "" || raw == "*" {
+		return Registered()
+	}
+	var names []string
+	for _, n := range strings.Split(raw, ",") {
+		if n = strings.TrimSpace(n); n != "" {
+			names = append(names, n)
+		}
+	}
+	return names
+}
+
+// Load enables the apps in apps::enabled: it registers their models, auth
+// rules and routes and runs Setup. Call it once, after the config is loaded
+// and before models.InitDB.
+func Load() error {
+	if enabled != nil {
+		return fmt.Errorf("apps: already loaded")
+	}
+	enabled = []*App{}
+	for _, name := range enabledNames() {
+		a, ok := registry[name]
+		if !ok {
+			return fmt.Errorf("apps: unknown app %q (registered: %s)", name, strings.Join(Registered(), ", "))
+		}
+		if len(a.Models) > 0 {
+			orm.RegisterModel(a.Models...)
+		}
+		if len(a.Protected) > 0 {
+			middleware.ProtectMany(a.Protected...)
+		}
+		if len(a.Public) > 0 {
+			middleware.Public(a.Public...)
+		}
+		if len(a.Routes) > 0 {
+			web.AddNamespace(web.NewNamespace("/api/v1", a.Routes...))
+		}
+		if a.Setup != nil {
+			a.Setup()
+		}
+		enabled = append(enabled, a)
+	}
+	return nil
+}
+
+// Start seeds the permissions and schedules the jobs of the enabled apps.
+// Call it after the schema is migrated and the scheduler started.
+func Start() error {
+	for _, a := range enabled {
+		for _, p := range a.Permissions {
+			if err := models.EnsurePermission(p.Role, p.Resource, p.Action); err != nil {
+				return fmt.Errorf("%s: seed %s %s:%s: %w", a.Name, p.Role, p.Resource, p.Action, err)
+			}
+		}
+		for _, j := range a.Jobs {
+			if _, err := scheduler.Register(j.Name, j.Spec, j.Run); err != nil {
+				log.Printf("%s: schedule %s: %v", a.Name, j.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Migrations returns the core migrations followed by those of the enabled
+// apps.
+func Migrations() []migrate.Migration {
+	out := append([]migrate.Migration(nil), models.Migrations...)
+	for _, a := range enabled {
+		out = append(out, a.Migrations...)
+	}
+	return out
+}
+
+// Migrator returns a migrator over Migrations for the database alias.
+func Migrator(alias string) (*migrate.Migrator, error) {
+	return migrate.New(alias, Migrations())
+}
@@ -0,0 +1,433 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/beego/beego/v2/client/orm"
+	"github.com/beego/beego/v2/server/web"
+
+	"github.com/mymi14s/goconda/apps"
+	"github.com/mymi14s/goconda/controllers"
+	"github.com/mymi14s/goconda/models"
+	"github.com/mymi14s/goconda/utils/hash"
+	jwtutil "github.com/mymi14s/goconda/utils/jwt"
+	"github.com/mymi14s/goconda/utils/mailer"
+	"github.com/mymi14s/goconda/utils/scheduler"
+	"github.com/mymi14s/goconda/utils/validators"
+)
+
+// command is a goconda subcommand. Commands that need the database get it
+// opened first; all of them see the loaded config and apps.
+type command struct {
+	usage string
+	help  string
+	db    bool
+	run   func(args []string) int
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"serve":           {usage: "serve", help: "run the web server (the default)", run: serve},
+		"migrate":         {usage: "migrate up|down|status|new", help: "manage schema migrations", run: runMigrate},
+		"createsuperuser": {usage: "createsuperuser --email <email> [--first-name <name>] [--last-name <name>]", help: "create a superuser; the password is read from stdin", db: true, run: createSuperuser},
+		"changepassword":  {usage: "changepassword <email>", help: "set a user's password, read from stdin, and sign them out", db: true, run: changePassword},
+		"roles":           {usage: "roles grant <role> <resource> <action> | roles assign <email> <role>", help: "grant a permission to a role or assign a role to a user", db: true, run: roles},
+		"tokens":          {usage: "tokens revoke <email> [token-id]", help: "revoke a user's sessions and access tokens, or one access token", db: true, run: tokens},
+		"settings":        {usage: "settings get [key] | settings set <key> <value>", help: "read or change the site settings", db: true, run: settings},
+		"jobs":            {usage: "jobs list | jobs run <name>", help: "list the scheduled jobs or run one now", db: true, run: jobs},
+		"check":           {usage: "check", help: "validate the config, database connection and SMTP settings", run: check},
+	}
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "usage: goconda <command> [arguments]")
+	fmt.Fprintln(w)
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, name := range names {
+		fmt.Fprintf(tw, "  %s\t%s\n", name, commands[name].help)
+	}
+	tw.Flush()
+	fmt.Fprintln(w, "\nRun `goconda help <command>` for its arguments.")
+}
+
+// run dispatches a command line and returns the exit code.
+func run(args []string) int {
+	name := "serve"
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
+	}
+	if name == "help" || name == "-h" || name == "--help" {
+		if len(args) > 0 {
+			if cmd, ok := commands[args[0]]; ok {
+				fmt.Printf("usage: goconda %s\n\n%s\n", cmd.usage, cmd.help)
+				return 0
+			}
+		}
+		usage(os.Stdout)
+		return 0
+	}
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "goconda: unknown command %q\n\n", name)
+		usage(os.Stderr)
+		return 2
+	}
+	mustLoadConfig()
+	mailer.Site = models.MailerSite{}
+	if err := apps.Load(); err != nil {
+		return fail("apps: %v", err)
+	}
+	if cmd.db {
+		if err := models.InitDB(); err != nil {
+			return fail("DB init failed: %v", err)
+		}
+	}
+	return cmd.run(args)
+}
+
+// fail prints an error and returns the exit code for it.
+func fail(format string, args ...any) int {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	return 1
+}
+
+// badUsage prints the command's usage and returns the exit code for it.
+func badUsage(name string) int {
+	fmt.Fprintf(os.Stderr, "usage: goconda %s\n", commands[name].usage)
+	return 2
+}
+
+// findUser looks a user up by email.
+func findUser(email string) (*models.User, error) {
+	u, err := models.GetUserByEmail(strings.ToLower(strings.TrimSpace(email)))
+	if err != nil {
+		return nil, err
+	}
+	if u == nil {
+		return nil, fmt.Errorf("no user with email %s", email)
+	}
+	return u, nil
+}
+
+// readPassword reads a password from stdin. On a terminal it prompts with
+// echo off and asks twice; otherwise it reads the first line, so scripts can
+// pipe it in.
+func readPassword() (string, error) {
+	in := bufio.NewReader(os.Stdin)
+	fi, err := os.Stdin.Stat()
+	if err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		line, err := in.ReadString('\n')
+		if err != nil && line == "" {
+			return "", errors.New("no password on stdin")
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+	if err := stty("-echo"); err == nil {
+		defer stty("echo")
+	}
+	prompt := func(label string) (string, error) {
+		fmt.Fprint(os.Stderr, label)
+		line, err := in.ReadString('\n')
+		fmt.Fprintln(os.Stderr)
+		return strings.TrimRight(line, "\r\n"), err
+	}
+	pw, err := prompt("Password: ")
+	if err != nil {
+		return "", err
+	}
+	again, err := prompt("Password (again): ")
+	if err != nil {
+		return "", err
+	}
+	if pw != again {
+		return "", errors.New("passwords do not match")
+	}
+	return pw, nil
+}
+
+func stty(arg string) error {
+	cmd := exec.Command("stty", arg)
+	cmd.Stdin = os.Stdin
+	return cmd.Run()
+}
+
+func createSuperuser(args []string) int {
+	fs := flag.NewFlagSet("createsuperuser", flag.ContinueOnError)
+	email := fs.String("email", "", "email address")
+	first := fs.String("first-name", "Admin", "first name")
+	last := fs.String("last-name", "User", "last name")
+	if err := fs.Parse(args); err != nil || fs.NArg() > 0 || *email == "" {
+		return badUsage("createsuperuser")
+	}
+	addr := strings.ToLower(strings.TrimSpace(*email))
+	if err := validators.ValidateEmail(addr); err != nil {
+		return fail("%v", err)
+	}
+	if models.EmailInUse(addr) {
+		return fail("a user with email %s already exists; use changepassword", addr)
+	}
+	pw, err := readPassword()
+	if err != nil {
+		return fail("%v", err)
+	}
+	if err := validators.ValidatePassword(pw, addr, *first, *last); err != nil {
+		return fail("%v", err)
+	}
+	pwHash, err := hash.Make(pw)
+	if err != nil {
+		return fail("hash password: %v", err)
+	}
+	u := &models.User{Email: addr, FirstName: *first, LastName: *last, PasswordHash: pwHash}
+	if err := models.CreateUser(u); err != nil {
+		return fail("create user: %v", err)
+	}
+	// the operator vouches for the address
+	if err := models.MarkUserVerified(u.ID, u.Email); err != nil {
+		return fail("verify email: %v", err)
+	}
+	if err := promoteSuperuser(u); err != nil {
+		return fail("promote: %v", err)
+	}
+	fmt.Printf("created superuser %s (%s)\n", u.Email, u.ID)
+	return 0
+}
+
+func changePassword(args []string) int {
+	if len(args) != 1 {
+		return badUsage("changepassword")
+	}
+	u, err := findUser(args[0])
+	if err != nil {
+		return fail("%v", err)
+	}
+	pw, err := readPassword()
+	if err != nil {
+		return fail("%v", err)
+	}
+	if err := controllers.SetUserPassword(u, pw); err != nil {
+		return fail("%v", err)
+	}
+	fmt.Printf("password changed for %s\n", u.Email)
+	return 0
+}
+
+func roles(args []string) int {
+	switch {
+	case len(args) == 4 && args[0] == "grant":
+		if err := models.EnsurePermission(args[1], args[2], args[3]); err != nil {
+			return fail("grant: %v", err)
+		}
+		fmt.Printf("granted %s %s:%s\n", args[1], args[2], args[3])
+	case len(args) == 3 && args[0] == "assign":
+		u, err := findUser(args[1])
+		if err != nil {
+			return fail("%v", err)
+		}
+		if err := models.EnsureRole(args[2]); err != nil {
+			return fail("assign: %v", err)
+		}
+		if err := models.AssignRole(u.ID, args[2]); err != nil {
+			return fail("assign: %v", err)
+		}
+		fmt.Printf("assigned %s to %s\n", args[2], u.Email)
+	default:
+		return badUsage("roles")
+	}
+	return 0
+}
+
+func tokens(args []string) int {
+	if len(args) < 2 || len(args) > 3 || args[0] != "revoke" {
+		return badUsage("tokens")
+	}
+	u, err := findUser(args[1])
+	if err != nil {
+		return fail("%v", err)
+	}
+	if len(args) == 3 {
+		id, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			return badUsage("tokens")
+		}
+		if err := models.RevokePersonalAccessToken(u.ID, id); err != nil {
+			return fail("revoke token %d: %v", id, err)
+		}
+		fmt.Printf("revoked access token %d of %s\n", id, u.Email)
+		return 0
+	}
+	sessions, err := models.RevokeOtherSessions(u.ID, "")
+	if err != nil {
+		return fail("revoke sessions: %v", err)
+	}
+	pats, err := models.RevokeAllPersonalAccessTokens(u.ID)
+	if err != nil {
+		return fail("revoke access tokens: %v", err)
+	}
+	fmt.Printf("revoked %d sessions and %d access tokens of %s\n", sessions, pats, u.Email)
+	return 0
+}
+
+// siteSettings are the site settings the command line can read and change.
+var siteSettings = map[string]func(s *models.SiteSetting) *string{
+	"title":     func(s *models.SiteSetting) *string { return &s.Title },
+	"site_name": func(s *models.SiteSetting) *string { return &s.SiteName },
+	"base_url":  func(s *models.SiteSetting) *string { return &s.BaseURL },
+	"email":     func(s *models.SiteSetting) *string { return &s.Email },
+	"tagline":   func(s *models.SiteSetting) *string { return &s.Tagline },
+	"header":    func(s *models.SiteSetting) *string { return &s.Header },
+}
+
+func settings(args []string) int {
+	switch {
+	case len(args) >= 1 && len(args) <= 2 && args[0] == "get":
+		s, err := (&models.SiteSetting{}).Get()
+		if err != nil {
+			return fail("read settings: %v", err)
+		}
+		if len(args) == 2 {
+			field, ok := siteSettings[args[1]]
+			if !ok {
+				return fail("unknown setting %q", args[1])
+			}
+			fmt.Println(*field(s))
+			return 0
+		}
+		keys := make([]string, 0, len(siteSettings))
+		for k := range siteSettings {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Printf("%s=%s\n", k, *siteSettings[k](s))
+		}
+	case len(args) == 3 && args[0] == "set":
+		field, ok := siteSettings[args[1]]
+		if !ok {
+			return fail("unknown setting %q", args[1])
+		}
+		// make sure the singleton row exists before locking it
+		if _, err := (&models.SiteSetting{}).Get(); err != nil {
+			return fail("read settings: %v", err)
+		}
+		err := models.Update(func(s *models.SiteSetting) error {
+			*field(s) = args[2]
+			return nil
+		})
+		if err != nil {
+			return fail("update settings: %v", err)
+		}
+		fmt.Printf("%s=%s\n", args[1], args[2])
+	default:
+		return badUsage("settings")
+	}
+	return 0
+}
+
+func jobs(args []string) int {
+	// the scheduler is not started, so the jobs only run when asked
+	if err := registerJobs(); err != nil {
+		return fail("apps: %v", err)
+	}
+	switch {
+	case len(args) == 1 && args[0] == "list":
+		for _, name := range scheduler.Names() {
+			fmt.Println(name)
+		}
+	case len(args) == 2 && args[0] == "run":
+		if err := scheduler.Run(args[1]); err != nil {
+			return fail("%v", err)
+		}
+		fmt.Printf("ran %s\n", args[1])
+	default:
+		return badUsage("jobs")
+	}
+	return 0
+}
+
+// check validates the config, the database connection and schema, and the
+// SMTP settings, reporting each; it fails if any of them does.
+func check(args []string) int {
+	if len(args) > 0 {
+		return badUsage("check")
+	}
+	failed := false
+	report := func(name string, err error) {
+		if err != nil {
+			failed = true
+			fmt.Printf("FAIL  %s: %v\n", name, err)
+			return
+		}
+		fmt.Printf("ok    %s\n", name)
+	}
+
+	report("jwt", jwtutil.Load())
+	var err error
+	if port, perr := web.AppConfig.Int("httpport"); perr != nil || port <= 0 {
+		err = fmt.Errorf("httpport must be a port number")
+	}
+	report("httpport", err)
+	switch driver := web.AppConfig.DefaultString("db::driver", "sqlite3"); driver {
+	case "sqlite3", "mysql":
+		err = nil
+	default:
+		err = fmt.Errorf("unsupported db::driver %q", driver)
+	}
+	report("db::driver", err)
+
+	if err := models.InitDB(); err != nil {
+		report("database", err)
+	} else {
+		db, err := orm.GetDB("default")
+		if err == nil {
+			err = db.Ping()
+		}
+		report("database", err)
+		if err == nil {
+			report("migrations", checkMigrations())
+			report("base_url", checkBaseURL())
+		}
+	}
+	report("smtp", mailer.Check())
+
+	if failed {
+		return 1
+	}
+	return 0
+}
+
+// checkMigrations fails when migrations are pending.
+func checkMigrations() error {
+	m, err := apps.Migrator("default")
+	if err != nil {
+		return err
+	}
+	pending, err := m.Pending()
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		names := make([]string, len(pending))
+		for i, mig := range pending {
+			names[i] = mig.String()
+		}
+		return fmt.Errorf("%d pending: %s", len(pending), strings.Join(names, ", "))
+	}
+	return nil
+}
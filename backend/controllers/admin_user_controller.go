@@ -0,0 +1,291 @@
+package controllers
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/beego/beego/v2/server/web"
+
+	"github.com/mymi14s/goconda/models"
+	jwtutil "github.com/mymi14s/goconda/utils/jwt"
+)
+
+// AdminUserController is the user directory for administrators: search,
+// disable and enable accounts, force password resets and impersonate users.
+// Every endpoint needs the matching "users" permission, and every change is
+// written to the audit log.
+type AdminUserController struct {
+	BaseController
+}
+
+// impersonationTTL is the lifetime of an impersonation token, from
+// admin::impersonation_minutes.
+func impersonationTTL() time.Duration {
+	mins := web.AppConfig.DefaultInt("admin::impersonation_minutes", 30)
+	if mins <= 0 {
+		mins = 30
+	}
+	return time.Duration(mins) * time.Minute
+}
+
+// boolParam reads an optional true/false query parameter, writing 400 if it
+// is malformed.
+func (c *BaseController) boolParam(name string) (*bool, bool) {
+	raw := strings.TrimSpace(c.GetString(name))
+	if raw == "" {
+		return nil, true
+	}
+	v, err := strconv.ParseBool(raw)
+	if err != nil {
+		c.JSONError(400, name+" must be true or false")
+		return nil, false
+	}
+	return &v, true
+}
+
+// target loads the :user_id account, including disabled ones, writing 404
+// if it does not exist.
+func (c *AdminUserController) target() (*models.User, bool) {
+	u, err := models.FindUser(c.Ctx.Input.Param(":user_id"))
+	if err != nil {
+		c.JSONError(500, "failed to load user")
+		return nil, false
+	}
+	if u == nil || u.DeletedAt != nil {
+		c.JSONError(404, "user not found")
+		return nil, false
+	}
+	return u, true
+}
+
+// isSuperuser reports whether u is a superuser by flag or role.
+func isSuperuser(u *models.User) bool {
+	if u.IsSuperuser {
+		return true
+	}
+	ok, _ := models.HasRole(u.ID, "Superuser")
+	return ok
+}
+
+// guard refuses actions on the administrator's own account, and on
+// superusers unless the administrator is one.
+func (c *AdminUserController) guard(admin, u *models.User) bool {
+	if admin.ID == u.ID {
+		c.JSONError(400, "cannot do this to your own account")
+		return false
+	}
+	if isSuperuser(u) && !isSuperuser(admin) {
+		c.JSONError(403, "only superusers can manage superusers")
+		return false
+	}
+	return true
+}
+
+func (c *AdminUserController) audit(admin *models.User, action, targetID, details string) {
+	_ = models.RecordAudit(admin.ID, action, targetID, c.Ctx.Input.IP(), details)
+}
+
+// List searches the directory: q (words matched against name and email),
+// verified, superuser, disabled (true/false) and role, paged with limit and
+// offset.
+// @router /api/v1/admin/users [get]
+func (c *AdminUserController) List() {
+	if !c.RequirePermission("users", "read") {
+		return
+	}
+	f := models.UserFilter{
+		Query: strings.TrimSpace(c.GetString("q")),
+		Role:  strings.TrimSpace(c.GetString("role")),
+	}
+	var ok bool
+	if f.Verified, ok = c.boolParam("verified"); !ok {
+		return
+	}
+	if f.Superuser, ok = c.boolParam("superuser"); !ok {
+		return
+	}
+	if f.Disabled, ok = c.boolParam("disabled"); !ok {
+		return
+	}
+	limit, _ := c.GetInt64("limit", 20)
+	offset, _ := c.GetInt64("offset", 0)
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	users, total, err := models.SearchUsers(f, limit, offset)
+	if err != nil {
+		c.JSONError(500, "failed to list users")
+		return
+	}
+	c.JSONOK(map[string]any{"total": total, "users": users})
+}
+
+// Get returns one account with its verification state and roles.
+// @router /api/v1/admin/users/:user_id [get]
+func (c *AdminUserController) Get() {
+	if !c.RequirePermission("users", "read") {
+		return
+	}
+	u, ok := c.target()
+	if !ok {
+		return
+	}
+	verified, _ := models.IsUserVerified(u)
+	roles, err := models.ListUserRoles(u.ID)
+	if err != nil {
+		c.JSONError(500, "failed to list roles")
+		return
+	}
+	if roles == nil {
+		roles = []string{}
+	}
+	c.JSONOK(models.UserSummary{User: *u, Verified: verified, Roles: roles})
+}
+
+// Disable blocks the account from signing in and ends its sessions.
+// @router /api/v1/admin/users/:user_id/disable [post]
+func (c *AdminUserController) Disable() {
+	c.setDisabled(true)
+}
+
+// @router /api/v1/admin/users/:user_id/enable [post]
+func (c *AdminUserController) Enable() {
+	c.setDisabled(false)
+}
+
+func (c *AdminUserController) setDisabled(disabled bool) {
+	if !c.RequirePermission("users", "update") {
+		return
+	}
+	admin, _ := c.GetCurrentUser()
+	u, ok := c.target()
+	if !ok || !c.guard(admin, u) {
+		return
+	}
+	if err := models.SetUserDisabled(u.ID, disabled); err != nil {
+		c.JSONError(500, "failed to update user")
+		return
+	}
+	action := models.AuditUserEnabled
+	if disabled {
+		action = models.AuditUserDisabled
+	}
+	c.audit(admin, action, u.ID, "")
+	c.JSONOK(map[string]any{"user_id": u.ID, "disabled": disabled})
+}
+
+// ForcePasswordReset signs the account out, blocks password sign-in until
+// the password is reset and emails the user a reset link.
+// @router /api/v1/admin/users/:user_id/force-password-reset [post]
+func (c *AdminUserController) ForcePasswordReset() {
+	if !c.RequirePermission("users", "update") {
+		return
+	}
+	admin, _ := c.GetCurrentUser()
+	u, ok := c.target()
+	if !ok || !c.guard(admin, u) {
+		return
+	}
+	if err := models.RequirePasswordReset(u.ID); err != nil {
+		c.JSONError(500, "failed to update user")
+		return
+	}
+	c.audit(admin, models.AuditPasswordResetForced, u.ID, "")
+	if _, err := c.sendPasswordReset(u); err != nil {
+		c.JSONError(500, err.Error())
+		return
+	}
+	c.JSONOK(map[string]any{"user_id": u.ID, "password_reset_required": true})
+}
+
+type impersonatePayload struct {
+	Reason string `json:"reason"`
+}
+
+// Impersonate issues a short-lived access token for the user whose act
+// claim names the administrator. No refresh token or cookie is set; the
+// session ends when the token expires or is logged out. Superusers cannot
+// be impersonated.
+// @router /api/v1/admin/users/:user_id/impersonate [post]
+func (c *AdminUserController) Impersonate() {
+	if !c.RequirePermission("users", "impersonate") {
+		return
+	}
+	admin, _ := c.GetCurrentUser()
+	var p impersonatePayload
+	if err := c.ParseJSON(&p); err != nil {
+		c.JSONError(400, err.Error())
+		return
+	}
+	reason := strings.TrimSpace(p.Reason)
+	if reason == "" {
+		c.JSONError(400, "reason is required")
+		return
+	}
+	u, ok := c.target()
+	if !ok || !c.guard(admin, u) {
+		return
+	}
+	if u.DisabledAt != nil {
+		c.JSONError(400, "account is disabled")
+		return
+	}
+	if isSuperuser(u) {
+		c.JSONError(403, "superusers cannot be impersonated")
+		return
+	}
+	ttl := impersonationTTL()
+	token, claims, err := jwtutil.Issue(u.ID, jwtutil.IssueOptions{
+		Email:     u.Email,
+		UserAgent: c.Ctx.Input.UserAgent(),
+		IP:        c.Ctx.Input.IP(),
+		Act:       &jwtutil.Actor{Subject: admin.ID, Email: admin.Email},
+		TTL:       ttl,
+	})
+	if err != nil {
+		c.JSONError(500, "failed to generate token")
+		return
+	}
+	c.audit(admin, models.AuditImpersonationStart, u.ID, reason)
+	c.JSONOK(map[string]any{
+		"token":      token,
+		"token_type": "Bearer",
+		"expires_in": int(ttl.Seconds()),
+		"jti":        claims.ID,
+		"user_id":    u.ID,
+	})
+}
+
+// AuditLogs lists audit entries, newest first, filtered by actor_id,
+// target_id and action.
+// @router /api/v1/admin/audit-logs [get]
+func (c *AdminUserController) AuditLogs() {
+	if !c.RequirePermission("audit", "read") {
+		return
+	}
+	limit, _ := c.GetInt64("limit", 50)
+	offset, _ := c.GetInt64("offset", 0)
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	entries, total, err := models.ListAuditLogs(models.AuditFilter{
+		ActorID:  c.GetString("actor_id"),
+		TargetID: c.GetString("target_id"),
+		Action:   c.GetString("action"),
+	}, limit, offset)
+	if err != nil {
+		c.JSONError(500, "failed to list audit logs")
+		return
+	}
+	if entries == nil {
+		entries = []models.AuditLog{}
+	}
+	c.JSONOK(map[string]any{"total": total, "entries": entries})
+}
@@ -1,12 +1,12 @@
 package controllers
 
 import (
-	"net/http"
-	"github.com/beego/beego/v2/server/web"
+	"errors"
+	"net/url"
 	"strings"
 	"time"
 
-	"github.com/beego/beego/v2/client/orm"
+	"github.com/beego/beego/v2/server/web"
 
 	"github.com/mymi14s/goconda/models"
 	"github.com/mymi14s/goconda/utils/hash"
@@ -47,79 +47,48 @@ func (c *AuthController) Register() {
 	// ensure email normalized
 	email := strings.ToLower(strings.TrimSpace(p.Email))
 
-	// Check exists
-	if existing, _ := models.GetUserByEmail(email); existing != nil {
+	// Check exists, including accounts pending deletion
+	if models.EmailInUse(email) {
 		c.JSONError(409, "user already exists")
 		return
 	}
 
+	u := &models.User{
+		Email:     email,
+		FirstName: p.FirstName,
+		LastName:  p.LastName,
+	}
+	if err := checkNewPassword(u, p.Password); err != nil {
+		c.JSONError(400, err.Error())
+		return
+	}
 	pwHash, err := hash.HashPassword(p.Password)
 	if err != nil {
 		c.JSONError(500, "failed to hash password")
 		return
 	}
-
-	u := &models.User{
-		Email:        email,
-		FirstName:    p.FirstName,
-		LastName:     p.LastName,
-		PasswordHash: pwHash,
-	}
-	if _, err := orm.NewOrm().Insert(u); err != nil {
+	u.PasswordHash = pwHash
+	if err := models.CreateUser(u); err != nil {
 		c.JSONError(500, "failed to create user")
 		return
 	}
+	if depth := passwordHistoryDepth(); depth > 0 {
+		_ = models.AddPasswordHistory(u.ID, pwHash, depth)
+	}
 
-	// Issue token
-	token, err := jwtutil.Generate(email)
+	// Issue access + refresh tokens and set them as HttpOnly cookies
+	resp, err := c.issueTokens(u, nil, "pwd")
 	if err != nil {
 		c.JSONError(500, "failed to generate token")
 		return
 	}
-
-	
-
-// --- BFF: set HttpOnly auth cookie instead of relying on sessionStorage ---
-{
-    // Use JWT as cookie value; browser won't see it (HttpOnly)
-    // Cookie attrs depend on runmode for cross-site dev vs same-origin prod
-    runMode := web.BConfig.RunMode
-    secure := true // default
-    sameSite := http.SameSiteLaxMode
-    if runMode == "dev" {
-        // Dev runs cross-site (frontend :3000 over http, backend :8080 over https)
-        // Cookies for cross-site must be SameSite=None and Secure
-        sameSite = http.SameSiteNoneMode
-        secure = true
-    }
-    // Expiration: align with JWT exp, fallback to 60m
-    expMin, _ := web.AppConfig.Int64("jwt::expiration_minutes")
-    if expMin <= 0 { expMin = 60 }
-    expires := time.Now().Add(time.Duration(expMin) * time.Minute)
-    cookie := &http.Cookie{
-        Name:     "goconda_auth",
-        Value:    token,
-        Path:     "/",
-        HttpOnly: true,
-        Secure:   secure,
-        SameSite: sameSite,
-        Expires:  expires,
-        MaxAge:   int(time.Until(expires).Seconds()),
-    }
-    http.SetCookie(c.Ctx.ResponseWriter, cookie)
-}
-// --- end BFF cookie ---
-
-c.JSONOK(map[string]any{
-		"token":      token,
-		"token_type": "Bearer",
-		"expires_in": int(time.Hour.Seconds()) * 24, // ideally from jwtutil
-		"user": map[string]any{
-			"email":      u.Email,
-			"first_name": u.FirstName,
-			"last_name":  u.LastName,
-		},
-	})
+	resp["user"] = map[string]any{
+		"id":         u.ID,
+		"email":      u.Email,
+		"first_name": u.FirstName,
+		"last_name":  u.LastName,
+	}
+	c.JSONOK(resp)
 }
 
 type loginPayload struct {
@@ -143,29 +112,77 @@ func (c *AuthController) Login() {
 		return
 	}
 
+	ip := clientIP(c.Ctx)
+	keys := loginKeys(email, ip)
+	if wait := loginLimiter().Check(keys...); wait > 0 {
+		c.tooManyRequests(wait)
+		return
+	}
+
 	u, err := models.GetUserByEmail(email)
-	if err != nil || u == nil {
+	if err != nil || u == nil || !hash.CheckPassword(p.Password, u.PasswordHash) {
+		_ = models.RecordLoginAttempt(email, ip, c.Ctx.Input.UserAgent(), false)
+		if wait := loginLimiter().Fail(keys...); wait > 0 {
+			c.tooManyRequests(wait)
+			return
+		}
 		c.JSONError(401, "invalid credentials")
 		return
 	}
-	if !hash.CheckPassword(p.Password, u.PasswordHash) {
-		c.JSONError(401, "invalid credentials")
+	if u.PasswordResetRequired {
+		c.JSONError(403, "password reset required; use forgot-password")
 		return
 	}
+	_ = models.RecordLoginAttempt(email, ip, c.Ctx.Input.UserAgent(), true)
+	upgradePasswordHash(u, p.Password)
 
-	token, err := jwtutil.Generate(email)
+	resp, err := c.completeLogin(u, "pwd")
 	if err != nil {
 		c.JSONError(500, "failed to generate token")
 		return
 	}
+	if resp["mfa_required"] == true {
+		// the counter is cleared by mfa/verify, where bad codes count too
+		c.JSONOK(resp)
+		return
+	}
+	// only the account's counter is cleared; the IP keeps its history
+	_ = loginLimiter().Reset(keys[1])
+	resp["id"] = u.ID
+	addLoginUser(resp, u)
+	c.JSONOK(resp)
+}
 
-	c.JSONOK(map[string]any{
-		"token":      token,
-		"token_type": "Bearer",
-		"email":      u.Email,
-		"first_name": u.FirstName,
-		"lastname":   u.LastName,
-	})
+// Refresh rotates the presented refresh token (body or cookie) and returns a
+// new access/refresh pair. Replaying an already-rotated token revokes the
+// whole family, including the access tokens issued from it.
+func (c *AuthController) Refresh() {
+	raw := strings.TrimSpace(c.refreshTokenFromRequest())
+	if raw == "" {
+		c.JSONError(400, "refresh_token is required")
+		return
+	}
+	rt, err := models.ConsumeRefreshToken(raw)
+	if errors.Is(err, models.ErrRefreshTokenReused) {
+		c.clearAuthCookies()
+		c.JSONError(401, err.Error())
+		return
+	}
+	if err != nil {
+		c.JSONError(401, "invalid or expired refresh token")
+		return
+	}
+	u, _ := models.GetUserByID(rt.UserID)
+	if u == nil {
+		c.JSONError(401, "account not found")
+		return
+	}
+	resp, err := c.issueTokens(u, rt)
+	if err != nil {
+		c.JSONError(500, "failed to generate token")
+		return
+	}
+	c.JSONOK(resp)
 }
 
 type logoutPayload struct {
@@ -182,26 +199,17 @@ func (c *LogoutController) Logout() {
 	if !ok || u == nil {
 		return
 	}
+	// Revoke the refresh family and clear both auth cookies regardless of
+	// whether the access token itself can be revoked.
+	_ = models.RevokeRefreshToken(c.refreshTokenFromRequest())
+	c.clearAuthCookies()
+
 	// The token's JTI is provided by jwtutil via Parse() but we don't get it here.
 	// In a real app, you'd extract the bearer and revoke by claims.ID.
 	// We'll best-effort parse the header.
 	auth := c.Ctx.Request.Header.Get("Authorization")
 	if auth == "" {
-		
-
-// Clear auth cookie on logout
-http.SetCookie(c.Ctx.ResponseWriter, &http.Cookie{
-    Name:     "goconda_auth",
-    Value:    "",
-    Path:     "/",
-    HttpOnly: true,
-    Secure:   true,
-    SameSite: http.SameSiteLaxMode,
-    MaxAge:   -1,
-    Expires:  time.Unix(0,0),
-})
-
-c.JSONOK(map[string]any{"revoked": false})
+		c.JSONOK(map[string]any{"revoked": false})
 		return
 	}
 	parts := strings.SplitN(auth, " ", 2)
@@ -225,22 +233,31 @@ func (c *AuthController) SendVerification() {
 		c.JSONError(400, "email is required")
 		return
 	}
-	// Ensure user exists
+	if !c.throttleMail(email) {
+		return
+	}
+	// Unknown and already verified accounts get the same answer.
 	u, _ := models.GetUserByEmail(email)
-	if u == nil {
-		c.JSONError(404, "account not found")
+	if u == nil || c.IsEmailVerified(u) {
+		c.mailAccepted("")
 		return
 	}
-	// Create token (valid 24h)
-	t, err := models.CreateVerificationToken(email, 24*time.Hour)
+	ttl := 24 * time.Hour
+	t, err := models.CreateVerificationToken(u, ttl)
 	if err != nil {
 		c.JSONError(500, "could not create token")
 		return
 	}
-	// "Send" email (for now, log to server; in production wire SMTP)
-	// Example message a real mailer would send:
-	// Verify link: {BASE_URL}/api/v1/auth/verify?token=t.Token
-	c.Ctx.WriteString("Verification token: " + t.Token)
+	verifyURL := web.AppConfig.DefaultString("mailer::verify_url", "/api/v1/auth/verify")
+	if err := sendLink("verify_email", []string{u.Email}, map[string]any{
+		"FirstName": u.FirstName,
+		"Email":     u.Email,
+		"ExpiresIn": humanDuration(ttl),
+	}, verifyURL, url.Values{"token": {t.Token}}); err != nil {
+		c.JSONError(500, "could not send email")
+		return
+	}
+	c.mailAccepted(t.Token)
 }
 
 func (c *AuthController) VerifyEmail() {
@@ -249,16 +266,16 @@ func (c *AuthController) VerifyEmail() {
 		c.JSONError(400, "token is required")
 		return
 	}
-	email, err := models.ConsumeVerificationToken(token)
+	t, err := models.ConsumeVerificationToken(token)
 	if err != nil {
 		c.JSONError(400, err.Error())
 		return
 	}
-	if err := models.MarkUserVerified(email); err != nil {
+	if err := models.MarkUserVerified(t.UserID, t.Email); err != nil {
 		c.JSONError(500, "could not mark verified")
 		return
 	}
-	c.JSONOK(map[string]any{"email": email, "verified": true})
+	c.JSONOK(map[string]any{"email": t.Email, "verified": true})
 }
 
 func (c *AuthController) ForgotPassword() {
@@ -267,45 +284,74 @@ func (c *AuthController) ForgotPassword() {
 		c.JSONError(400, "email is required")
 		return
 	}
+	if !c.throttleMail(email) {
+		return
+	}
 	u, _ := models.GetUserByEmail(email)
 	if u == nil {
 		// don't reveal account existence
-		c.JSONOK(map[string]any{"ok": true})
+		c.mailAccepted("")
 		return
 	}
-	t, err := models.CreatePasswordResetToken(email, 1*time.Hour)
+	token, err := c.sendPasswordReset(u)
 	if err != nil {
-		c.JSONError(500, "could not create token")
+		c.JSONError(500, err.Error())
 		return
 	}
-	// In production, send via email. For now, write token to body.
-	c.Ctx.WriteString("Password reset token: " + t.Token)
+	c.mailAccepted(token)
+}
+
+// sendPasswordReset mails u a one-hour password reset link and returns its
+// token. The error is safe to show.
+func (c *BaseController) sendPasswordReset(u *models.User) (string, error) {
+	ttl := time.Hour
+	t, err := models.CreatePasswordResetToken(u.ID, ttl)
+	if err != nil {
+		return "", errors.New("could not create token")
+	}
+	resetURL := web.AppConfig.DefaultString("mailer::reset_url", "/reset-password")
+	if err := sendLink("reset_password", []string{u.Email}, map[string]any{
+		"FirstName": u.FirstName,
+		"Email":     u.Email,
+		"ExpiresIn": humanDuration(ttl),
+	}, resetURL, url.Values{"token": {t.Token}}); err != nil {
+		return "", errors.New("could not send email")
+	}
+	return t.Token, nil
 }
 
 func (c *AuthController) ResetPassword() {
 	token := strings.TrimSpace(c.GetString("token"))
 	newPass := strings.TrimSpace(c.GetString("new_password"))
-	if token == "" || len(newPass) < 6 {
-		c.JSONError(400, "invalid token or password too short")
+	if token == "" {
+		c.JSONError(400, "invalid token")
 		return
 	}
-	email, err := models.ConsumePasswordResetToken(token)
+	userID, err := models.PeekPasswordResetToken(token)
 	if err != nil {
 		c.JSONError(400, err.Error())
 		return
 	}
-	o := orm.NewOrm()
-	u, _ := models.GetUserByEmail(email)
+	u, _ := models.GetUserByID(userID)
 	if u == nil {
 		c.JSONError(404, "account not found")
 		return
 	}
-	hv, _ := hash.Make(newPass)
-	u.PasswordHash = hv
-	if _, err := o.Update(u, "PasswordHash"); err != nil {
+	// check the password before using up the token, so a rejected one can be retried
+	if err := checkNewPassword(u, newPass); err != nil {
+		c.JSONError(400, err.Error())
+		return
+	}
+	if _, err := models.ConsumePasswordResetToken(token); err != nil {
+		c.JSONError(400, err.Error())
+		return
+	}
+	if err := setPassword(u, newPass); err != nil {
 		c.JSONError(500, "failed to update password")
 		return
 	}
+	// whoever held the old password must not stay signed in
+	_, _ = models.RevokeOtherSessions(u.ID, "")
 	c.JSONOK(map[string]any{"reset": true})
 }
 
@@ -317,23 +363,30 @@ func (c *AuthController) ChangePassword() {
 	}
 	current := strings.TrimSpace(c.GetString("current_password"))
 	newPass := strings.TrimSpace(c.GetString("new_password"))
-	if len(newPass) < 6 {
-		c.JSONError(400, "password too short")
-		return
-	}
 	if !hash.Check(current, u.PasswordHash) {
 		c.JSONError(400, "current password incorrect")
 		return
 	}
-	hv, _ := hash.Make(newPass)
-	u.PasswordHash = hv
-	if _, err := orm.NewOrm().Update(u, "PasswordHash"); err != nil {
+	if err := checkNewPassword(u, newPass); err != nil {
+		c.JSONError(400, err.Error())
+		return
+	}
+	if err := setPassword(u, newPass); err != nil {
 		c.JSONError(500, "failed to change password")
 		return
 	}
+	// keep this session, sign out everywhere else
+	keep := ""
+	if claims := c.CurrentClaims(); claims != nil {
+		keep = claims.ID
+	}
+	_, _ = models.RevokeOtherSessions(u.ID, keep)
 	c.JSONOK(map[string]any{"changed": true})
 }
 
+// ChangeEmail starts moving the account to new_email. The switch happens
+// only once the link mailed to the new address is opened; the old address
+// is told about it and gets a link to undo it.
 func (c *AuthController) ChangeEmail() {
 	u, err := c.GetCurrentUser()
 	if err != nil || u == nil {
@@ -341,27 +394,130 @@ func (c *AuthController) ChangeEmail() {
 		return
 	}
 	pwd := strings.TrimSpace(c.GetString("password"))
-	newEmail := strings.TrimSpace(c.GetString("new_email"))
+	newEmail := normalizeEmail(c.GetString("new_email"))
 	if newEmail == "" || !validators.IsEmailValid(newEmail) {
 		c.JSONError(400, "invalid email")
 		return
 	}
-	if !hash.Check(pwd, u.PasswordHash) && !u.IsSuperuser {
+	if !hash.Check(pwd, u.PasswordHash) {
 		c.JSONError(400, "password incorrect")
 		return
 	}
+	if newEmail == u.Email {
+		c.JSONError(400, "new email is the current one")
+		return
+	}
 	// prevent collision
-	if existing, _ := models.GetUserByEmail(newEmail); existing != nil {
+	if models.EmailInUse(newEmail) {
 		c.JSONError(400, "email already in use")
 		return
 	}
-	old := u.Email
-	u.Email = newEmail
-	if _, err := orm.NewOrm().Update(u, "Email"); err != nil {
+	if !c.throttleMail(newEmail) {
+		return
+	}
+	ttl := emailChangeTTL()
+	r, confirm, revert, err := models.CreateEmailChange(u, newEmail, ttl)
+	if err != nil {
+		c.JSONError(500, "could not create request")
+		return
+	}
+	confirmURL := web.AppConfig.DefaultString("email_change::confirm_url", "/api/v1/auth/change-email/confirm")
+	if err := sendLink("email_change_confirm", []string{newEmail}, map[string]any{
+		"FirstName": u.FirstName,
+		"Email":     newEmail,
+		"OldEmail":  u.Email,
+		"ExpiresIn": humanDuration(ttl),
+	}, confirmURL, url.Values{"token": {confirm}}); err != nil {
+		c.JSONError(500, "could not send email")
+		return
+	}
+	revertURL := web.AppConfig.DefaultString("email_change::revert_url", "/api/v1/auth/change-email/revert")
+	if err := sendLink("email_change_notice", []string{u.Email}, map[string]any{
+		"FirstName": u.FirstName,
+		"Email":     u.Email,
+		"NewEmail":  newEmail,
+		"RevertFor": humanDuration(emailChangeRevertWindow()),
+	}, revertURL, url.Values{"token": {revert}}); err != nil {
+		c.JSONError(500, "could not send email")
+		return
+	}
+	data := map[string]any{"sent": true, "new_email": newEmail, "expires_at": r.ExpiresAt}
+	if exposeTokens() {
+		data["token"] = confirm
+		data["revert_token"] = revert
+	}
+	c.JSONAccepted(data)
+}
+
+// ConfirmEmailChange switches the account to the new address, which the
+// click verifies.
+func (c *AuthController) ConfirmEmailChange() {
+	token := strings.TrimSpace(c.GetString("token"))
+	if token == "" {
+		c.JSONError(400, "token is required")
+		return
+	}
+	r, err := models.ConfirmEmailChange(token, emailChangeRevertWindow())
+	if errors.Is(err, models.ErrInvalidEmailChange) {
+		c.JSONError(400, err.Error())
+		return
+	}
+	if errors.Is(err, models.ErrEmailTaken) {
+		c.JSONError(409, err.Error())
+		return
+	}
+	if err != nil {
 		c.JSONError(500, "failed to change email")
 		return
 	}
-	// migrate roles to new email
-	_ = models.MigrateUserEmail(old, newEmail)
-	c.JSONOK(map[string]any{"email": newEmail})
+	c.JSONOK(map[string]any{"email": r.NewEmail, "verified": true, "revert_until": r.RevertUntil})
+}
+
+// RevertEmailChange is the "this wasn't me" link sent to the old address.
+// It cancels a pending change or, within the rollback window, restores the
+// old address and signs the account out everywhere.
+func (c *AuthController) RevertEmailChange() {
+	token := strings.TrimSpace(c.GetString("token"))
+	if token == "" {
+		c.JSONError(400, "token is required")
+		return
+	}
+	r, err := models.RevertEmailChange(token)
+	if errors.Is(err, models.ErrInvalidEmailChange) {
+		c.JSONError(400, err.Error())
+		return
+	}
+	if errors.Is(err, models.ErrEmailTaken) {
+		c.JSONError(409, err.Error())
+		return
+	}
+	if err != nil {
+		c.JSONError(500, "failed to revert email change")
+		return
+	}
+	if r.ConfirmedAt != nil {
+		// whoever made the change must not stay signed in
+		_, _ = models.RevokeOtherSessions(r.UserID, "")
+	}
+	c.JSONOK(map[string]any{"email": r.OldEmail, "reverted": true})
+}
+
+// emailChangeTTL is how long the confirmation link stays valid, from
+// email_change::expiration_hours.
+func emailChangeTTL() time.Duration {
+	hours := web.AppConfig.DefaultInt("email_change::expiration_hours", 24)
+	if hours <= 0 {
+		hours = 24
+	}
+	return time.Duration(hours) * time.Hour
+}
+
+// emailChangeRevertWindow is how long after confirmation the old address
+// can undo a change, from email_change::revert_window_hours.
+func emailChangeRevertWindow() time.Duration {
+	hours := web.AppConfig.DefaultInt("email_change::revert_window_hours", 168)
+	if hours <= 0 {
+		hours = 168
+	}
+	return time.Duration(hours) * time.Hour
 }
@@ -0,0 +1,199 @@
+package controllers
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/beego/beego/v2/server/web"
+
+	"github.com/mymi14s/goconda/models"
+	jwtutil "github.com/mymi14s/goconda/utils/jwt"
+)
+
+const (
+	authCookieName    = "goconda_auth"
+	refreshCookieName = "goconda_refresh"
+	// refreshCookiePath limits the refresh cookie to the auth endpoints.
+	refreshCookiePath = "/api/v1/auth"
+)
+
+// refreshTTL is the lifetime of a refresh token, from jwt::refresh_expiration_minutes.
+func refreshTTL() time.Duration {
+	mins, _ := web.AppConfig.Int64("jwt::refresh_expiration_minutes")
+	if mins <= 0 {
+		mins = 60 * 24 * 30
+	}
+	return time.Duration(mins) * time.Minute
+}
+
+// cookieAttrs returns Secure/SameSite for auth cookies. Dev runs cross-site
+// (frontend :3000, backend :8080) so cookies must be SameSite=None and Secure.
+func cookieAttrs() (bool, http.SameSite) {
+	if web.BConfig.RunMode == "dev" {
+		return true, http.SameSiteNoneMode
+	}
+	return true, http.SameSiteLaxMode
+}
+
+func (c *BaseController) setCookie(name, value, path string, expires time.Time) {
+	secure, sameSite := cookieAttrs()
+	http.SetCookie(c.Ctx.ResponseWriter, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     path,
+		HttpOnly: true,
+		Secure:   secure,
+		SameSite: sameSite,
+		Expires:  expires,
+		MaxAge:   int(time.Until(expires).Seconds()),
+	})
+}
+
+func (c *BaseController) clearCookie(name, path string) {
+	secure, sameSite := cookieAttrs()
+	http.SetCookie(c.Ctx.ResponseWriter, &http.Cookie{
+		Name:     name,
+		Value:    "",
+		Path:     path,
+		HttpOnly: true,
+		Secure:   secure,
+		SameSite: sameSite,
+		MaxAge:   -1,
+		Expires:  time.Unix(0, 0),
+	})
+}
+
+// clearAuthCookies removes both the access and refresh cookies.
+func (c *BaseController) clearAuthCookies() {
+	c.clearCookie(authCookieName, "/")
+	c.clearCookie(refreshCookieName, refreshCookiePath)
+}
+
+// issueTokens signs an access token for u and sets it, together with a
+// refresh token, as HttpOnly cookies. amr lists how the user authenticated.
+// prev is the refresh token consumed by a refresh (nil on login): the new
+// token continues its family, session and authentication methods.
+// The returned map is merged into the JSON response.
+func (c *BaseController) issueTokens(u *models.User, prev *models.RefreshToken, amr ...string) (map[string]any, error) {
+	return c.issueOrgTokens(u, 0, prev, amr...)
+}
+
+// issueOrgTokens is issueTokens with the tokens scoped to organization org
+// (0 for the personal space). A refresh keeps prev's organization as long
+// as the user is still a member.
+func (c *BaseController) issueOrgTokens(u *models.User, org int64, prev *models.RefreshToken, amr ...string) (map[string]any, error) {
+	family := ""
+	if prev != nil {
+		family = prev.Family
+		org = prev.Org
+		amr = nil
+		if prev.AMR != "" {
+			amr = strings.Split(prev.AMR, ",")
+		}
+	}
+	if org != 0 && !models.IsOrgMember(org, u.ID) {
+		org = 0
+	}
+	token, claims, err := jwtutil.Issue(u.ID, jwtutil.IssueOptions{
+		Email:     u.Email,
+		UserAgent: c.Ctx.Input.UserAgent(),
+		IP:        c.Ctx.Input.IP(),
+		AMR:       amr,
+		Org:       org,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if prev != nil {
+		_ = models.ReplaceSession(prev.AccessJTI, claims.ID)
+	}
+	ttl := refreshTTL()
+	rt := &models.RefreshToken{
+		Family:          family,
+		UserID:          u.ID,
+		AccessJTI:       claims.ID,
+		AccessExpiresAt: claims.ExpiresAt.Time,
+		AMR:             strings.Join(amr, ","),
+		Org:             org,
+	}
+	refresh, err := models.CreateRefreshToken(rt, ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	c.setCookie(authCookieName, token, "/", claims.ExpiresAt.Time)
+	c.setCookie(refreshCookieName, refresh, refreshCookiePath, rt.ExpiresAt)
+
+	resp := map[string]any{
+		"token":              token,
+		"token_type":         "Bearer",
+		"expires_in":         int(time.Until(claims.ExpiresAt.Time).Seconds()),
+		"refresh_token":      refresh,
+		"refresh_expires_in": int(ttl.Seconds()),
+	}
+	if org != 0 {
+		resp["org"] = org
+	}
+	return resp, nil
+}
+
+// addLoginUser adds the signed-in user's details to a login response.
+// "lastname" is a deprecated alias of "last_name", kept for older clients.
+func addLoginUser(resp map[string]any, u *models.User) {
+	resp["email"] = u.Email
+	resp["first_name"] = u.FirstName
+	resp["last_name"] = u.LastName
+	resp["lastname"] = u.LastName
+}
+
+// completeLogin finishes a first-factor login (amr, e.g. "pwd"). Users with
+// two-factor authentication get a short-lived mfa_pending token to exchange
+// at /auth/mfa/verify instead of a session.
+func (c *BaseController) completeLogin(u *models.User, amr ...string) (map[string]any, error) {
+	m, err := models.GetUserMFA(u.ID)
+	if err != nil {
+		return nil, err
+	}
+	if m != nil && m.Enabled {
+		token, _, err := jwtutil.Issue(u.ID, jwtutil.IssueOptions{
+			Email:   u.Email,
+			AMR:     amr,
+			Purpose: mfaPendingPurpose,
+			TTL:     mfaPendingTTL,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{
+			"mfa_required": true,
+			"mfa_token":    token,
+			"expires_in":   int(mfaPendingTTL.Seconds()),
+		}, nil
+	}
+	resp, err := c.issueTokens(u, nil, amr...)
+	if err != nil {
+		return nil, err
+	}
+	if m != nil && m.Required {
+		resp["mfa_enrollment_required"] = true
+	}
+	return resp, nil
+}
+
+// refreshTokenFromRequest reads the refresh token from the JSON body, falling
+// back to the refresh cookie.
+func (c *BaseController) refreshTokenFromRequest() string {
+	var p struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	// A missing or non-JSON body simply means the cookie is used.
+	_ = c.ParseJSON(&p)
+	if p.RefreshToken != "" {
+		return p.RefreshToken
+	}
+	if ck, err := c.Ctx.Request.Cookie(refreshCookieName); err == nil && ck != nil {
+		return ck.Value
+	}
+	return ""
+}
@@ -14,7 +14,12 @@ import (
 )
 
 // Context keys
-const ctxUserKey = "current_user"
+const (
+	ctxUserKey   = "current_user"
+	ctxClaimsKey = "current_claims"
+	ctxPATKey    = "current_pat"
+	ctxActKey    = "impersonation_audited"
+)
 
 type BaseController struct {
 	web.Controller
@@ -29,6 +34,10 @@ func (c *BaseController) JSONError(code int, msg string) {
 	response.JSONError(c.Ctx, code, msg)
 }
 
+func (c *BaseController) JSONAccepted(data interface{}) {
+	response.JSONAccepted(c.Ctx, data)
+}
+
 // GetCurrentUser returns the authenticated user set by middleware.
 // If not set, it attempts to resolve from the Authorization header.
 func (c *BaseController) GetCurrentUser() (*models.User, error) {
@@ -50,19 +59,128 @@ func (c *BaseController) GetCurrentUser() (*models.User, error) {
 	if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") {
 		return nil, errors.New("invalid authorization header")
 	}
+	if strings.HasPrefix(parts[1], models.PATPrefix) {
+		u, _, err := authenticatePAT(c.Ctx, parts[1])
+		return u, err
+	}
 	claims, err := jwtutil.Parse(parts[1])
 	if err != nil {
 		return nil, err
 	}
-	u, err := models.GetUserByEmail(claims.Email)
+	if claims.Purpose != "" {
+		return nil, errors.New("token cannot be used for this request")
+	}
+	u, err := userFromClaims(claims)
 	if err != nil || u == nil {
 		return nil, errors.New("user not found")
 	}
+	if claims.Org != 0 && !models.IsOrgMember(claims.Org, u.ID) {
+		return nil, models.ErrNotOrgMember
+	}
+	if _, err := checkActor(c.Ctx, claims, u); err != nil {
+		return nil, err
+	}
 	// cache in context for the remainder of the request
 	c.Ctx.Input.SetData(ctxUserKey, u)
+	c.Ctx.Input.SetData(ctxClaimsKey, claims)
 	return u, nil
 }
 
+// userFromClaims loads the token's user by its subject, the user ID.
+// Tokens issued before users had IDs carry the email as subject; they are
+// still honoured until they expire.
+func userFromClaims(claims *jwtutil.Claims) (*models.User, error) {
+	if strings.Contains(claims.Subject, "@") {
+		return models.GetUserByEmail(claims.Subject)
+	}
+	return models.GetUserByID(claims.Subject)
+}
+
+// CurrentClaims returns the claims of the token that authenticated the
+// request, or nil when the request is not authenticated or used a personal
+// access token.
+func (c *BaseController) CurrentClaims() *jwtutil.Claims {
+	if _, err := c.GetCurrentUser(); err != nil {
+		return nil
+	}
+	claims, _ := c.Ctx.Input.GetData(ctxClaimsKey).(*jwtutil.Claims)
+	return claims
+}
+
+// CurrentAccessToken returns the personal access token that authenticated
+// the request, or nil for JWT-authenticated requests.
+func (c *BaseController) CurrentAccessToken() *models.PersonalAccessToken {
+	if _, err := c.GetCurrentUser(); err != nil {
+		return nil
+	}
+	pat, _ := c.Ctx.Input.GetData(ctxPATKey).(*models.PersonalAccessToken)
+	return pat
+}
+
+// CurrentOrg returns the organization the request acts in (the token's
+// org claim), or 0 for the user's personal space.
+func (c *BaseController) CurrentOrg() int64 {
+	if claims := c.CurrentClaims(); claims != nil {
+		return claims.Org
+	}
+	if pat := c.CurrentAccessToken(); pat != nil {
+		return pat.OrgID
+	}
+	return 0
+}
+
+// RequireScope checks a personal access token covers resource with the
+// action of the request method (GET read, POST create, PUT/PATCH update,
+// DELETE delete), writing 403 otherwise. Requests authenticated with a JWT
+// always pass; use it in endpoints that authorize by ownership rather than
+// RBAC.
+func (c *BaseController) RequireScope(resource string) bool {
+	pat := c.CurrentAccessToken()
+	if pat != nil && !pat.Allows(resource, methodAction(c.Ctx.Input.Method())) {
+		c.JSONError(403, "token scope does not allow this")
+		return false
+	}
+	return true
+}
+
+// methodAction maps an HTTP method to the action of a token scope.
+func methodAction(method string) string {
+	switch method {
+	case "GET", "HEAD":
+		return "read"
+	case "POST":
+		return "create"
+	case "PUT", "PATCH":
+		return "update"
+	case "DELETE":
+		return "delete"
+	}
+	return strings.ToLower(method)
+}
+
+// authenticatePAT resolves a personal access token and stores its user on
+// ctx. On failure it returns the status code to answer with. Tokens cannot
+// reach /api/v1/auth/*: account, session and token management need a login.
+func authenticatePAT(ctx *context.Context, raw string) (*models.User, int, error) {
+	if strings.HasPrefix(ctx.Input.URL(), "/api/v1/auth/") {
+		return nil, 403, errors.New("personal access tokens cannot be used here")
+	}
+	pat, err := models.AuthenticatePersonalAccessToken(raw, ctx.Input.IP())
+	if err != nil {
+		return nil, 401, err
+	}
+	u, err := models.GetUserByID(pat.UserID)
+	if err != nil || u == nil {
+		return nil, 401, errors.New("account not found")
+	}
+	if pat.OrgID != 0 && !models.IsOrgMember(pat.OrgID, u.ID) {
+		return nil, 403, models.ErrNotOrgMember
+	}
+	ctx.Input.SetData(ctxUserKey, u)
+	ctx.Input.SetData(ctxPATKey, pat)
+	return u, 0, nil
+}
+
 // MustAuth aborts the request with 401 if user is not authenticated
 func (c *BaseController) MustAuth() (*models.User, bool) {
 	u, err := c.GetCurrentUser()
@@ -93,8 +211,21 @@ func RequireAuth(ctx *context.Context) bool {
 		return false
 	}
 
+	if strings.HasPrefix(parts[1], models.PATPrefix) {
+		u, code, err := authenticatePAT(ctx, parts[1])
+		if err != nil {
+			response.JSONError(ctx, code, err.Error())
+			return false
+		}
+		if models.MFAEnrollmentPending(u.ID) {
+			response.JSONError(ctx, 403, "two-factor enrollment required")
+			return false
+		}
+		return true
+	}
+
 	claims, err := jwtutil.Parse(parts[1])
-	if err != nil {
+	if err != nil || claims.Purpose != "" {
 		response.JSONError(ctx, 401, "invalid or expired token")
 		return false
 	}
@@ -105,24 +236,83 @@ func RequireAuth(ctx *context.Context) bool {
 		return false
 	}
 
-	u, err := models.GetUserByEmail(claims.Email)
+	u, err := userFromClaims(claims)
 	if err != nil || u == nil {
 		response.JSONError(ctx, 401, "account not found")
 		return false
 	}
 
+	// membership is rechecked on every request, so removal takes effect
+	// before the token expires
+	if claims.Org != 0 && !models.IsOrgMember(claims.Org, u.ID) {
+		response.JSONError(ctx, 403, models.ErrNotOrgMember.Error())
+		return false
+	}
+
+	if code, err := checkActor(ctx, claims, u); err != nil {
+		response.JSONError(ctx, code, err.Error())
+		return false
+	}
+
+	// users required to enroll in MFA may only reach the auth endpoints
+	if !strings.HasPrefix(ctx.Input.URL(), "/api/v1/auth/") && models.MFAEnrollmentPending(u.ID) {
+		response.JSONError(ctx, 403, "two-factor enrollment required")
+		return false
+	}
+
+	_ = models.TouchSession(claims.ID)
+
 	// Store the user on the context for controllers to read later
 	ctx.Input.SetData(ctxUserKey, u)
+	ctx.Input.SetData(ctxClaimsKey, claims)
 	return true
 }
 
+// checkActor enforces the act claim of an impersonation token: the
+// administrator must still be allowed to impersonate, and the session can
+// neither manage the account, switch organization (which would mint an
+// ordinary session) nor reach the admin API. Every write made
+// through it is audited. On failure it returns the status to answer with.
+func checkActor(ctx *context.Context, claims *jwtutil.Claims, u *models.User) (int, error) {
+	if claims.Act == nil {
+		return 0, nil
+	}
+	admin, _ := models.GetUserByID(claims.Act.Subject)
+	if admin == nil || !canImpersonate(admin) {
+		return 401, errors.New("impersonation is no longer allowed")
+	}
+	path, method := ctx.Input.URL(), ctx.Input.Method()
+	if (strings.HasPrefix(path, "/api/v1/auth/") && path != "/api/v1/auth/logout") ||
+		strings.HasPrefix(path, "/api/v1/admin/") ||
+		path == "/api/v1/orgs/switch" ||
+		(path == "/api/v1/users/me" && method == "DELETE") {
+		return 403, errors.New("not allowed while impersonating")
+	}
+	// several auth filters may match one request; audit it only once
+	if method != "GET" && method != "HEAD" && method != "OPTIONS" && ctx.Input.GetData(ctxActKey) == nil {
+		ctx.Input.SetData(ctxActKey, true)
+		_ = models.RecordAudit(admin.ID, models.AuditImpersonatedRequest, u.ID, ctx.Input.IP(), method+" "+path)
+	}
+	return 0, nil
+}
+
+// canImpersonate reports whether u holds the users/impersonate permission,
+// directly or as a superuser.
+func canImpersonate(u *models.User) bool {
+	if isSuperuser(u) {
+		return true
+	}
+	ok, _ := models.HasPermission(u.ID, "users", "impersonate")
+	return ok
+}
+
 // superuser bypass
-func (c *BaseController) IsEmailVerified(email string) bool {
+func (c *BaseController) IsEmailVerified(user *models.User) bool {
     u, _ := c.GetCurrentUser()
     if u != nil && (u.IsSuperuser) {
         return true
     }
-    ok, _ := models.IsUserVerified(email)
+    ok, _ := models.IsUserVerified(user)
     return ok
 }
 
@@ -132,15 +322,60 @@ func (c *BaseController) RequirePermission(resource, action string) bool {
 		response.JSONError(c.Ctx, 401, "unauthorized")
 		return false
 	}
+	return authorize(c.Ctx, u, c.CurrentClaims(), resource, action)
+}
+
+// RequireRoutePermission is the middleware form of RequirePermission: it
+// authenticates the request like RequireAuth, then applies the same RBAC
+// check, writing 401 or 403 on failure.
+func RequireRoutePermission(ctx *context.Context, resource, action string) bool {
+	u, _ := ctx.Input.GetData(ctxUserKey).(*models.User)
+	if u == nil {
+		if !RequireAuth(ctx) {
+			return false
+		}
+		u, _ = ctx.Input.GetData(ctxUserKey).(*models.User)
+	}
+	claims, _ := ctx.Input.GetData(ctxClaimsKey).(*jwtutil.Claims)
+	return authorize(ctx, u, claims, resource, action)
+}
+
+func authorize(ctx *context.Context, u *models.User, claims *jwtutil.Claims, resource, action string) bool {
+	if models.MFAEnrollmentPending(u.ID) {
+		response.JSONError(ctx, 403, "two-factor enrollment required")
+		return false
+	}
+	// a personal access token is limited to its scopes, even for superusers
+	pat, _ := ctx.Input.GetData(ctxPATKey).(*models.PersonalAccessToken)
+	if pat != nil && !pat.Allows(resource, action) {
+		response.JSONError(ctx, 403, "token scope does not allow this")
+		return false
+	}
+	// resources may demand a token obtained with a second factor, even from superusers
+	if need, _ := models.ResourceRequiresMFA(resource); need {
+		if claims == nil || !claims.HasAMR("mfa") {
+			response.JSONError(ctx, 403, "two-factor authentication required")
+			return false
+		}
+	}
 	// bypass if superuser or has Superuser role
 	if u.IsSuperuser {
 		return true
 	}
-	if ok, _ := models.HasRole(u.Email, "Superuser"); ok {
+	if ok, _ := models.HasRole(u.ID, "Superuser"); ok {
 		return true
 	}
-	if err := models.RequirePermission(u.Email, resource, action); err != nil {
-		response.JSONError(c.Ctx, 403, "forbidden")
+	org := int64(0)
+	switch {
+	case strings.HasPrefix(ctx.Input.URL(), "/api/v1/admin/"):
+		// site administration is never granted by an organization role
+	case claims != nil:
+		org = claims.Org
+	case pat != nil:
+		org = pat.OrgID
+	}
+	if err := models.RequirePermissionIn(u.ID, org, resource, action); err != nil {
+		response.JSONError(ctx, 403, "forbidden")
 		return false
 	}
 	return true
@@ -0,0 +1,82 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/beego/beego/v2/server/web"
+
+	"github.com/mymi14s/goconda/utils/mailer"
+)
+
+// errNoBaseURL is returned for a link to a path when the site has no base
+// URL. The request's Host header is never used instead: a client can set
+// it, and so point reset and sign-in links at its own server.
+var errNoBaseURL = errors.New("no base URL configured: set the site base_url or mailer::base_url")
+
+// emailLink turns target (an absolute URL, or a path on the site) into an
+// absolute link with q appended. Paths are resolved against the site
+// BaseURL; in dev, without one, against localhost.
+func emailLink(target string, q url.Values) (string, error) {
+	if !strings.HasPrefix(target, "http://") && !strings.HasPrefix(target, "https://") {
+		base := mailer.SiteBaseURL()
+		if base == "" {
+			if web.BConfig.RunMode != "dev" {
+				return "", errNoBaseURL
+			}
+			base = fmt.Sprintf("http://localhost:%d", web.AppConfig.DefaultInt("httpport", 8080))
+		}
+		target = base + "/" + strings.TrimLeft(target, "/")
+	}
+	sep := "?"
+	if strings.Contains(target, "?") {
+		sep = "&"
+	}
+	return target + sep + q.Encode(), nil
+}
+
+// sendLink mails a template with data plus Link, the emailLink of target
+// and q.
+func sendLink(template string, to []string, data map[string]any, target string, q url.Values) error {
+	link, err := emailLink(target, q)
+	if err != nil {
+		log.Printf("email %s: %v", template, err)
+		return err
+	}
+	data["Link"] = link
+	return mailer.SendTemplate(template, to, data)
+}
+
+// exposeTokens lets local development read mailed tokens from the response
+// (mailer::expose_tokens). It is ignored outside dev.
+func exposeTokens() bool {
+	return web.BConfig.RunMode == "dev" && web.AppConfig.DefaultBool("mailer::expose_tokens", false)
+}
+
+// mailAccepted writes the neutral 202 response for endpoints that may send
+// an email, so callers cannot tell whether an account exists.
+func (c *BaseController) mailAccepted(token string) {
+	data := map[string]any{"sent": true}
+	if token != "" && exposeTokens() {
+		data["token"] = token
+	}
+	c.JSONAccepted(data)
+}
+
+// humanDuration renders a token lifetime for email copy ("24 hours").
+func humanDuration(d time.Duration) string {
+	plural := func(n int, unit string) string {
+		if n == 1 {
+			return "1 " + unit
+		}
+		return fmt.Sprintf("%d %ss", n, unit)
+	}
+	if d >= time.Hour && d%time.Hour == 0 {
+		return plural(int(d/time.Hour), "hour")
+	}
+	return plural(int(d.Round(time.Minute)/time.Minute), "minute")
+}
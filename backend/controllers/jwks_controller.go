@@ -0,0 +1,22 @@
+package controllers
+
+import (
+	jwtutil "github.com/mymi14s/goconda/utils/jwt"
+)
+
+type JWKSController struct {
+	BaseController
+}
+
+// Keys serves the public token verification keys as a standard JWK Set so
+// other services can verify goconda tokens without the signing secret.
+// @router /.well-known/jwks.json [get]
+func (c *JWKSController) Keys() {
+	set, err := jwtutil.JWKS()
+	if err != nil {
+		c.JSONError(500, "signing keys unavailable")
+		return
+	}
+	c.Ctx.Output.Header("Cache-Control", "public, max-age=300")
+	c.Ctx.Output.JSON(set, false, false)
+}
@@ -0,0 +1,73 @@
+package controllers
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/mymi14s/goconda/models"
+)
+
+// LockoutController lets admins inspect and clear rate-limiter lockouts.
+type LockoutController struct {
+	BaseController
+}
+
+// List returns the currently locked keys ("login:ip:…", "login:email:…",
+// "mail:…") with the time they unlock.
+// @router /api/v1/admin/lockouts [get]
+func (c *LockoutController) List() {
+	if !c.RequirePermission("lockouts", "read") {
+		return
+	}
+	locked, err := loginLimiter().Locked()
+	if err != nil {
+		c.JSONError(500, "failed to list lockouts")
+		return
+	}
+	sort.Slice(locked, func(i, j int) bool { return locked[i].Key < locked[j].Key })
+	email := normalizeEmail(c.GetString("email"))
+	var recent []models.LoginAttempt
+	if email != "" {
+		recent, _ = models.RecentLoginAttempts(email, 20)
+	}
+	c.JSONOK(map[string]any{"lockouts": locked, "recent_attempts": recent})
+}
+
+type clearLockoutPayload struct {
+	Key   string `json:"key"`
+	Email string `json:"email"`
+	IP    string `json:"ip"`
+}
+
+// Clear removes a lockout by exact key, or every key for an email or IP.
+// @router /api/v1/admin/lockouts [delete]
+func (c *LockoutController) Clear() {
+	if !c.RequirePermission("lockouts", "delete") {
+		return
+	}
+	var p clearLockoutPayload
+	if err := c.ParseJSON(&p); err != nil {
+		c.JSONError(400, err.Error())
+		return
+	}
+	var keys []string
+	if k := strings.TrimSpace(p.Key); k != "" {
+		keys = append(keys, k)
+	}
+	if e := normalizeEmail(p.Email); e != "" {
+		keys = append(keys, "login:email:"+e, "mail:email:"+e)
+	}
+	if ip := strings.TrimSpace(p.IP); ip != "" {
+		keys = append(keys, "login:ip:"+ip, "mail:ip:"+ip)
+	}
+	if len(keys) == 0 {
+		c.JSONError(400, "key, email or ip is required")
+		return
+	}
+	// both limiters share one store
+	if err := loginLimiter().Reset(keys...); err != nil {
+		c.JSONError(500, "failed to clear lockout")
+		return
+	}
+	c.JSONOK(map[string]any{"cleared": keys})
+}
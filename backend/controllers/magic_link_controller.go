@@ -0,0 +1,135 @@
+package controllers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/beego/beego/v2/server/web"
+
+	"github.com/mymi14s/goconda/models"
+	"github.com/mymi14s/goconda/utils/validators"
+)
+
+const (
+	// magicLinkCookieName holds the nonce that binds a link to the browser
+	// that requested it.
+	magicLinkCookieName = "goconda_magic"
+	magicLinkCookiePath = "/api/v1/auth/magic-link"
+)
+
+type MagicLinkController struct {
+	BaseController
+}
+
+func magicLinkTTL() time.Duration {
+	mins := web.AppConfig.DefaultInt("magic_link::expiration_minutes", 15)
+	if mins <= 0 {
+		mins = 15
+	}
+	return time.Duration(mins) * time.Minute
+}
+
+type magicLinkPayload struct {
+	Email string `json:"email"`
+}
+
+// Request emails a one-time sign-in link. The response is the same whether
+// or not the account exists.
+// @router /api/v1/auth/magic-link [post]
+func (c *MagicLinkController) Request() {
+	var p magicLinkPayload
+	if err := c.ParseJSON(&p); err != nil {
+		c.JSONError(400, err.Error())
+		return
+	}
+	email := normalizeEmail(p.Email)
+	if err := validators.ValidateEmail(email); err != nil {
+		c.JSONError(400, "invalid email")
+		return
+	}
+	if !c.throttleMail(email) {
+		return
+	}
+	ttl := magicLinkTTL()
+	nonce := c.magicLinkNonce()
+	u, _ := models.GetUserByEmail(email)
+	if u == nil {
+		// set the cookie anyway so the response does not reveal the account
+		c.setCookie(magicLinkCookieName, nonce, magicLinkCookiePath, time.Now().Add(ttl))
+		c.mailAccepted("")
+		return
+	}
+	t, nonce, err := models.CreateMagicLinkToken(u.ID, ttl, nonce)
+	if err != nil {
+		c.JSONError(500, "could not create link")
+		return
+	}
+	c.setCookie(magicLinkCookieName, nonce, magicLinkCookiePath, t.ExpiresAt)
+	// magic_link::url may point at a frontend page that calls consume
+	target := web.AppConfig.DefaultString("magic_link::url", magicLinkCookiePath+"/consume")
+	if err := sendLink("magic_link", []string{u.Email}, map[string]any{
+		"FirstName": u.FirstName,
+		"Email":     u.Email,
+		"ExpiresIn": humanDuration(ttl),
+	}, target, url.Values{"token": {t.Token}}); err != nil {
+		c.JSONError(500, "could not send email")
+		return
+	}
+	c.mailAccepted(t.Token)
+}
+
+// magicLinkNonce returns the browser's nonce cookie, or a new nonce. A
+// browser keeps one nonce for all its links, so asking again does not break
+// a link already sent.
+func (c *MagicLinkController) magicLinkNonce() string {
+	if ck, err := c.Ctx.Request.Cookie(magicLinkCookieName); err == nil && len(ck.Value) == 32 {
+		if _, err := hex.DecodeString(ck.Value); err == nil {
+			return ck.Value
+		}
+	}
+	nonce := make([]byte, 16)
+	_, _ = rand.Read(nonce)
+	return hex.EncodeToString(nonce)
+}
+
+// Consume redeems a link and signs the user in like Login.
+// @router /api/v1/auth/magic-link/consume [get]
+func (c *MagicLinkController) Consume() {
+	token := strings.TrimSpace(c.GetString("token"))
+	if token == "" {
+		c.JSONError(400, "token is required")
+		return
+	}
+	nonce := ""
+	if ck, err := c.Ctx.Request.Cookie(magicLinkCookieName); err == nil && ck != nil {
+		nonce = ck.Value
+	}
+	userID, err := models.ConsumeMagicLinkToken(token, nonce)
+	if err != nil {
+		c.JSONError(400, err.Error())
+		return
+	}
+	c.clearCookie(magicLinkCookieName, magicLinkCookiePath)
+	u, _ := models.GetUserByID(userID)
+	if u == nil {
+		c.JSONError(404, "account not found")
+		return
+	}
+	// receiving the link proves the user controls the address
+	_ = models.MarkUserVerified(u.ID, u.Email)
+
+	resp, err := c.completeLogin(u, "email")
+	if err != nil {
+		c.JSONError(500, "failed to generate token")
+		return
+	}
+	if target := web.AppConfig.DefaultString("magic_link::success_redirect", ""); target != "" && resp["mfa_required"] != true {
+		c.Redirect(target, 302)
+		return
+	}
+	addLoginUser(resp, u)
+	c.JSONOK(resp)
+}
@@ -0,0 +1,242 @@
+package controllers
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/beego/beego/v2/server/web"
+
+	"github.com/mymi14s/goconda/models"
+	"github.com/mymi14s/goconda/utils/hash"
+	jwtutil "github.com/mymi14s/goconda/utils/jwt"
+	"github.com/mymi14s/goconda/utils/totp"
+)
+
+const (
+	mfaPendingPurpose = "mfa_pending"
+	mfaPendingTTL     = 5 * time.Minute
+	// maxMFAFailures invalidates an mfa_pending token after this many bad codes.
+	maxMFAFailures = 5
+)
+
+type MFAController struct {
+	BaseController
+}
+
+type mfaCodePayload struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+	Password     string `json:"password"`
+	MFAToken     string `json:"mfa_token"`
+}
+
+// checkSecondFactor accepts a current TOTP code (once per time step) or an
+// unused recovery code.
+func checkSecondFactor(m *models.UserMFA, p mfaCodePayload) bool {
+	if m == nil || m.Secret == "" {
+		return false
+	}
+	if p.RecoveryCode != "" {
+		ok, _ := models.UseRecoveryCode(m.UserID, p.RecoveryCode)
+		return ok
+	}
+	step, ok := totp.Validate(m.Secret, p.Code, time.Now(), 1)
+	if !ok {
+		return false
+	}
+	fresh, _ := models.MarkMFAStep(m.UserID, step)
+	return fresh
+}
+
+// Enroll starts enrollment and returns the secret and otpauth URI.
+// @router /api/v1/auth/mfa/enroll [post]
+func (c *MFAController) Enroll() {
+	u, ok := c.MustAuth()
+	if !ok {
+		return
+	}
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		c.JSONError(500, "could not create secret")
+		return
+	}
+	if err := models.StartMFAEnrollment(u.ID, secret); err != nil {
+		if errors.Is(err, models.ErrMFAAlreadyEnabled) {
+			c.JSONError(409, err.Error())
+			return
+		}
+		c.JSONError(500, "could not start enrollment")
+		return
+	}
+	issuer := web.AppConfig.DefaultString("mfa::issuer", web.AppConfig.DefaultString("appname", "goconda"))
+	c.JSONOK(map[string]any{
+		"secret":      secret,
+		"otpauth_uri": totp.URI(issuer, u.Email, secret),
+	})
+}
+
+// ConfirmEnroll verifies the first code, enables MFA and returns the
+// recovery codes (shown once).
+// @router /api/v1/auth/mfa/enroll/verify [post]
+func (c *MFAController) ConfirmEnroll() {
+	u, ok := c.MustAuth()
+	if !ok {
+		return
+	}
+	var p mfaCodePayload
+	if err := c.ParseJSON(&p); err != nil {
+		c.JSONError(400, err.Error())
+		return
+	}
+	m, _ := models.GetUserMFA(u.ID)
+	if m == nil || m.Secret == "" {
+		c.JSONError(400, "no pending enrollment")
+		return
+	}
+	if m.Enabled {
+		c.JSONError(409, models.ErrMFAAlreadyEnabled.Error())
+		return
+	}
+	p.RecoveryCode = ""
+	if !checkSecondFactor(m, p) {
+		c.JSONError(400, "invalid code")
+		return
+	}
+	codes, err := models.EnableMFA(u.ID)
+	if err != nil {
+		c.JSONError(500, "could not enable two-factor authentication")
+		return
+	}
+	c.JSONOK(map[string]any{"enabled": true, "recovery_codes": codes})
+}
+
+// Disable turns MFA off after re-checking the password and a second factor.
+// @router /api/v1/auth/mfa/disable [post]
+func (c *MFAController) Disable() {
+	u, ok := c.MustAuth()
+	if !ok {
+		return
+	}
+	var p mfaCodePayload
+	if err := c.ParseJSON(&p); err != nil {
+		c.JSONError(400, err.Error())
+		return
+	}
+	m, _ := models.GetUserMFA(u.ID)
+	if m == nil || !m.Enabled {
+		c.JSONError(400, "two-factor authentication is not enabled")
+		return
+	}
+	if m.Required {
+		c.JSONError(403, "two-factor authentication is required for this account")
+		return
+	}
+	if !hash.Check(p.Password, u.PasswordHash) {
+		c.JSONError(400, "password incorrect")
+		return
+	}
+	if !checkSecondFactor(m, p) {
+		c.JSONError(400, "invalid code")
+		return
+	}
+	if err := models.DisableMFA(u.ID); err != nil {
+		c.JSONError(500, "could not disable two-factor authentication")
+		return
+	}
+	c.JSONOK(map[string]any{"enabled": false})
+}
+
+// RecoveryCodes replaces the recovery codes after checking a current code.
+// @router /api/v1/auth/mfa/recovery-codes [post]
+func (c *MFAController) RecoveryCodes() {
+	u, ok := c.MustAuth()
+	if !ok {
+		return
+	}
+	var p mfaCodePayload
+	if err := c.ParseJSON(&p); err != nil {
+		c.JSONError(400, err.Error())
+		return
+	}
+	m, _ := models.GetUserMFA(u.ID)
+	if m == nil || !m.Enabled {
+		c.JSONError(400, "two-factor authentication is not enabled")
+		return
+	}
+	p.RecoveryCode = ""
+	if !checkSecondFactor(m, p) {
+		c.JSONError(400, "invalid code")
+		return
+	}
+	codes, err := models.RegenerateRecoveryCodes(u.ID)
+	if err != nil {
+		c.JSONError(500, "could not create recovery codes")
+		return
+	}
+	c.JSONOK(map[string]any{"recovery_codes": codes})
+}
+
+// Verify exchanges an mfa_pending token plus a TOTP or recovery code for
+// the normal access/refresh tokens.
+// @router /api/v1/auth/mfa/verify [post]
+func (c *MFAController) Verify() {
+	var p mfaCodePayload
+	if err := c.ParseJSON(&p); err != nil {
+		c.JSONError(400, err.Error())
+		return
+	}
+	claims, err := jwtutil.Parse(strings.TrimSpace(p.MFAToken))
+	if err != nil || claims.Purpose != mfaPendingPurpose {
+		c.JSONError(401, "invalid or expired mfa token")
+		return
+	}
+	if revoked, _ := models.IsTokenRevoked(claims.ID); revoked {
+		c.JSONError(401, "invalid or expired mfa token")
+		return
+	}
+	u, _ := userFromClaims(claims)
+	var m *models.UserMFA
+	if u != nil {
+		m, _ = models.GetUserMFA(u.ID)
+	}
+	if u == nil || m == nil || !m.Enabled {
+		c.JSONError(401, "invalid or expired mfa token")
+		return
+	}
+	// bad codes count as failed logins, so signing in again for a fresh
+	// mfa_token does not give unlimited guesses
+	keys := loginKeys(u.Email, clientIP(c.Ctx))
+	if wait := loginLimiter().Check(keys...); wait > 0 {
+		c.tooManyRequests(wait)
+		return
+	}
+	if !checkSecondFactor(m, p) {
+		wait := loginLimiter().Fail(keys...)
+		// the counter is only cleared by a correct code, so once it is
+		// reached every new mfa_token allows a single guess
+		if n, _ := models.RecordMFAFailure(u.ID); n >= maxMFAFailures {
+			_ = models.RevokeToken(claims.ID, claims.ExpiresAt.Time)
+			c.JSONError(401, "too many invalid codes, please sign in again")
+			return
+		}
+		if wait > 0 {
+			c.tooManyRequests(wait)
+			return
+		}
+		c.JSONError(401, "invalid code")
+		return
+	}
+	_ = models.ResetMFAFailures(u.ID)
+	_ = loginLimiter().Reset(keys[1])
+	// the pending token is single use
+	_ = models.RevokeToken(claims.ID, claims.ExpiresAt.Time)
+
+	resp, err := c.issueTokens(u, nil, append(claims.AMR, "mfa")...)
+	if err != nil {
+		c.JSONError(500, "failed to generate token")
+		return
+	}
+	addLoginUser(resp, u)
+	c.JSONOK(resp)
+}
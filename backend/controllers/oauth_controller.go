@@ -0,0 +1,212 @@
+package controllers
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/beego/beego/v2/server/web"
+
+	"github.com/mymi14s/goconda/models"
+	"github.com/mymi14s/goconda/utils/oidc"
+)
+
+const (
+	// oauthCookieName carries state, nonce and PKCE verifier between start
+	// and callback. It is HttpOnly and only sent to the oauth endpoints.
+	oauthCookieName = "goconda_oauth"
+	oauthCookiePath = "/api/v1/auth/oauth"
+	oauthFlowTTL    = 10 * time.Minute
+)
+
+type OAuthController struct {
+	BaseController
+}
+
+type oauthFlow struct {
+	Provider string `json:"p"`
+	State    string `json:"s"`
+	Nonce    string `json:"n"`
+	Verifier string `json:"v"`
+}
+
+// provider resolves :provider, writing the error response on failure.
+func (c *OAuthController) provider() (*oidc.Provider, bool) {
+	p, err := oidc.Get(c.Ctx.Input.Param(":provider"))
+	if errors.Is(err, oidc.ErrUnknownProvider) {
+		c.JSONError(404, err.Error())
+		return nil, false
+	}
+	if err != nil {
+		c.JSONError(502, "provider unavailable")
+		return nil, false
+	}
+	return p, true
+}
+
+// Providers lists the configured provider names for the login page.
+// @router /api/v1/auth/oauth/providers [get]
+func (c *OAuthController) Providers() {
+	names := oidc.Enabled()
+	if names == nil {
+		names = []string{}
+	}
+	c.JSONOK(map[string]any{"providers": names})
+}
+
+// redirectURL is the configured redirect_url, or this server's callback.
+func (c *OAuthController) redirectURL(p *oidc.Provider) string {
+	if p.RedirectURL != "" {
+		return p.RedirectURL
+	}
+	return c.Ctx.Input.Scheme() + "://" + c.Ctx.Request.Host + oauthCookiePath + "/" + p.Name + "/callback"
+}
+
+// Start redirects to the provider. With ?format=json it returns the URL instead.
+// @router /api/v1/auth/oauth/:provider/start [get]
+func (c *OAuthController) Start() {
+	p, ok := c.provider()
+	if !ok {
+		return
+	}
+	flow := oauthFlow{Provider: p.Name}
+	var err error
+	for _, v := range []*string{&flow.State, &flow.Nonce, &flow.Verifier} {
+		if *v, err = oidc.RandomString(32); err != nil {
+			c.JSONError(500, "could not start sign-in")
+			return
+		}
+	}
+	raw, _ := json.Marshal(flow)
+	c.setCookie(oauthCookieName, base64.RawURLEncoding.EncodeToString(raw), oauthCookiePath, time.Now().Add(oauthFlowTTL))
+
+	authURL := p.AuthCodeURL(c.redirectURL(p), flow.State, flow.Nonce, flow.Verifier)
+	if c.GetString("format") == "json" {
+		c.JSONOK(map[string]any{"auth_url": authURL})
+		return
+	}
+	c.Redirect(authURL, 302)
+}
+
+// Callback completes the code flow, creates or links the user and signs
+// them in exactly like Login.
+// @router /api/v1/auth/oauth/:provider/callback [get]
+func (c *OAuthController) Callback() {
+	p, ok := c.provider()
+	if !ok {
+		return
+	}
+	if e := c.GetString("error"); e != "" {
+		c.JSONError(400, "sign-in cancelled: "+e)
+		return
+	}
+	flow, ok := c.readFlow()
+	c.clearCookie(oauthCookieName, oauthCookiePath)
+	state := c.GetString("state")
+	if !ok || flow.Provider != p.Name || state == "" ||
+		subtle.ConstantTimeCompare([]byte(state), []byte(flow.State)) != 1 {
+		c.JSONError(400, "invalid or expired sign-in state")
+		return
+	}
+	code := c.GetString("code")
+	if code == "" {
+		c.JSONError(400, "code is required")
+		return
+	}
+	tok, err := p.Exchange(code, c.redirectURL(p), flow.Verifier)
+	if err != nil {
+		c.JSONError(401, "could not complete sign-in")
+		return
+	}
+	id, err := p.Identify(tok, flow.Nonce)
+	if err != nil {
+		c.JSONError(401, "could not verify identity")
+		return
+	}
+	u, err := oauthUser(p.Name, id)
+	if err != nil {
+		c.JSONError(403, err.Error())
+		return
+	}
+	resp, err := c.completeLogin(u, "oauth")
+	if err != nil {
+		c.JSONError(500, "failed to generate token")
+		return
+	}
+	if target := web.AppConfig.DefaultString("oauth::success_redirect", ""); target != "" && resp["mfa_required"] != true {
+		c.Redirect(target, 302)
+		return
+	}
+	addLoginUser(resp, u)
+	c.JSONOK(resp)
+}
+
+func (c *OAuthController) readFlow() (oauthFlow, bool) {
+	var f oauthFlow
+	ck, err := c.Ctx.Request.Cookie(oauthCookieName)
+	if err != nil || ck == nil {
+		return f, false
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(ck.Value)
+	if err != nil || json.Unmarshal(raw, &f) != nil {
+		return f, false
+	}
+	return f, true
+}
+
+// oauthUser finds the user linked to the identity. Otherwise it links the
+// account with the same email, or creates one. Either way it needs an email
+// the provider has verified, so nobody can claim an existing account through
+// an unverified address. An existing account is only linked once its own
+// email is verified: otherwise whoever registered it first (and knows its
+// password) could be waiting for the real owner to sign in.
+func oauthUser(provider string, id *oidc.Identity) (*models.User, error) {
+	link, err := models.GetUserIdentity(provider, id.Subject)
+	if err != nil {
+		return nil, err
+	}
+	if link != nil {
+		u, err := models.GetUserByID(link.UserID)
+		if err != nil || u == nil {
+			return nil, errors.New("linked account not found")
+		}
+		_ = models.TouchUserIdentity(link)
+		return u, nil
+	}
+	email := strings.ToLower(strings.TrimSpace(id.Email))
+	if email == "" || !id.EmailVerified {
+		return nil, errors.New("the provider did not return a verified email")
+	}
+	u, err := models.GetUserByEmail(email)
+	if err != nil {
+		return nil, err
+	}
+	if u != nil {
+		verified, err := models.IsUserVerified(u)
+		if err != nil {
+			return nil, err
+		}
+		if !verified {
+			return nil, errors.New("an account with this email exists but is not verified; sign in and verify it first")
+		}
+	} else {
+		first, last := id.GivenName, id.FamilyName
+		if first == "" && last == "" {
+			first, last, _ = strings.Cut(strings.TrimSpace(id.Name), " ")
+		}
+		// no password: the user signs in through the provider, or sets one
+		// with forgot-password
+		u = &models.User{Email: email, FirstName: first, LastName: last}
+		if err := models.CreateUser(u); err != nil {
+			return nil, errors.New("failed to create user")
+		}
+	}
+	if _, err := models.LinkUserIdentity(u.ID, provider, id.Subject); err != nil {
+		return nil, errors.New("failed to link account")
+	}
+	_ = models.MarkUserVerified(u.ID, u.Email)
+	return u, nil
+}
@@ -0,0 +1,467 @@
+package controllers
+
+import (
+	"errors"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/beego/beego/v2/server/web"
+
+	"github.com/mymi14s/goconda/models"
+	"github.com/mymi14s/goconda/utils/validators"
+)
+
+// OrgController manages organizations, their members and invitations, and
+// switches the organization a session acts in.
+type OrgController struct {
+	BaseController
+}
+
+// Prepare limits personal access tokens to their "orgs" scopes.
+func (c *OrgController) Prepare() {
+	if !c.RequireScope("orgs") {
+		c.StopRun()
+	}
+}
+
+var (
+	slugRe      = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{1,62}$`)
+	slugInvalid = regexp.MustCompile(`[^a-z0-9]+`)
+)
+
+// slugify derives a slug from an organization name ("Acme, Inc." -> "acme-inc").
+func slugify(name string) string {
+	s := strings.Trim(slugInvalid.ReplaceAllString(strings.ToLower(name), "-"), "-")
+	if len(s) > 63 {
+		s = strings.TrimRight(s[:63], "-")
+	}
+	return s
+}
+
+func invitationTTL() time.Duration {
+	hours := web.AppConfig.DefaultInt("orgs::invitation_expiration_hours", 72)
+	if hours <= 0 {
+		hours = 72
+	}
+	return time.Duration(hours) * time.Hour
+}
+
+type orgPayload struct {
+	Name string `json:"name"`
+	Slug string `json:"slug"`
+}
+
+type memberPayload struct {
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
+
+// membership loads the :id organization and the current user's role in it,
+// writing 404 when it does not exist or the user is not a member, and 403
+// when the role is not one of allowed (none = any member).
+func (c *OrgController) membership(allowed ...string) (*models.Organization, string, bool) {
+	u, ok := c.MustAuth()
+	if !ok {
+		return nil, "", false
+	}
+	id, _ := strconv.ParseInt(c.Ctx.Input.Param(":id"), 10, 64)
+	org, err := models.GetOrganization(id)
+	if err != nil {
+		c.JSONError(500, "failed to load organization")
+		return nil, "", false
+	}
+	role := ""
+	if org != nil {
+		if role, err = models.OrgRole(org.ID, u.ID); err != nil {
+			c.JSONError(500, "failed to load organization")
+			return nil, "", false
+		}
+	}
+	// non-members cannot tell whether the organization exists
+	if role == "" {
+		c.JSONError(404, models.ErrOrgNotFound.Error())
+		return nil, "", false
+	}
+	if len(allowed) == 0 {
+		return org, role, true
+	}
+	for _, r := range allowed {
+		if r == role {
+			return org, role, true
+		}
+	}
+	c.JSONError(403, "forbidden")
+	return nil, "", false
+}
+
+// List returns the organizations the user belongs to.
+// @router /api/v1/orgs [get]
+func (c *OrgController) List() {
+	u, ok := c.MustAuth()
+	if !ok {
+		return
+	}
+	orgs, err := models.ListUserOrganizations(u.ID)
+	if err != nil {
+		c.JSONError(500, "failed to list organizations")
+		return
+	}
+	c.JSONOK(orgs)
+}
+
+// Create makes a new organization owned by the user. The slug defaults to
+// one derived from the name.
+// @router /api/v1/orgs [post]
+func (c *OrgController) Create() {
+	u, ok := c.MustAuth()
+	if !ok {
+		return
+	}
+	var p orgPayload
+	if err := c.ParseJSON(&p); err != nil {
+		c.JSONError(400, err.Error())
+		return
+	}
+	name := strings.TrimSpace(p.Name)
+	if name == "" {
+		c.JSONError(400, "name is required")
+		return
+	}
+	slug := strings.ToLower(strings.TrimSpace(p.Slug))
+	if slug == "" {
+		slug = slugify(name)
+	}
+	if !slugRe.MatchString(slug) {
+		c.JSONError(400, "slug must be 2-63 lowercase letters, digits or dashes")
+		return
+	}
+	org, err := models.CreateOrganization(name, slug, u.ID)
+	if errors.Is(err, models.ErrOrgExists) {
+		c.JSONError(409, err.Error())
+		return
+	}
+	if err != nil {
+		c.JSONError(500, "failed to create organization")
+		return
+	}
+	c.JSONOK(models.UserOrg{Organization: *org, Role: models.OrgRoleOwner})
+}
+
+// @router /api/v1/orgs/:id [get]
+func (c *OrgController) Get() {
+	org, role, ok := c.membership()
+	if !ok {
+		return
+	}
+	c.JSONOK(models.UserOrg{Organization: *org, Role: role})
+}
+
+// Update renames the organization (owners and admins).
+// @router /api/v1/orgs/:id [put]
+func (c *OrgController) Update() {
+	org, role, ok := c.membership(models.OrgRoleOwner, models.OrgRoleAdmin)
+	if !ok {
+		return
+	}
+	var p orgPayload
+	if err := c.ParseJSON(&p); err != nil {
+		c.JSONError(400, err.Error())
+		return
+	}
+	name := strings.TrimSpace(p.Name)
+	if name == "" {
+		c.JSONError(400, "name is required")
+		return
+	}
+	if err := models.RenameOrganization(org.ID, name); err != nil {
+		c.JSONError(500, "failed to update organization")
+		return
+	}
+	org.Name = name
+	c.JSONOK(models.UserOrg{Organization: *org, Role: role})
+}
+
+// Delete removes the organization and all of its data (owners only).
+// @router /api/v1/orgs/:id [delete]
+func (c *OrgController) Delete() {
+	org, _, ok := c.membership(models.OrgRoleOwner)
+	if !ok {
+		return
+	}
+	if err := models.DeleteOrganization(org.ID); err != nil {
+		c.JSONError(500, "failed to delete organization")
+		return
+	}
+	c.JSONOK(map[string]any{"deleted": org.ID})
+}
+
+// @router /api/v1/orgs/:id/members [get]
+func (c *OrgController) Members() {
+	org, _, ok := c.membership()
+	if !ok {
+		return
+	}
+	members, err := models.ListOrgMembers(org.ID)
+	if err != nil {
+		c.JSONError(500, "failed to list members")
+		return
+	}
+	if members == nil {
+		members = []models.OrgMember{}
+	}
+	c.JSONOK(members)
+}
+
+// checkGrant validates role for a member and whether actor (the current
+// user's role) may hand it out: only owners make owners.
+func (c *OrgController) checkGrant(actor, role string) bool {
+	if err := models.CheckOrgRole(role); err != nil {
+		c.JSONError(400, err.Error())
+		return false
+	}
+	if role == models.OrgRoleOwner && actor != models.OrgRoleOwner {
+		c.JSONError(403, "only owners can grant the owner role")
+		return false
+	}
+	return true
+}
+
+// UpdateMember changes a member's role (owners and admins; only owners may
+// change an owner).
+// @router /api/v1/orgs/:id/members/:user_id [put]
+func (c *OrgController) UpdateMember() {
+	org, actor, ok := c.membership(models.OrgRoleOwner, models.OrgRoleAdmin)
+	if !ok {
+		return
+	}
+	userID := c.Ctx.Input.Param(":user_id")
+	var p memberPayload
+	if err := c.ParseJSON(&p); err != nil {
+		c.JSONError(400, err.Error())
+		return
+	}
+	role := strings.TrimSpace(p.Role)
+	if !c.checkGrant(actor, role) {
+		return
+	}
+	current, _ := models.OrgRole(org.ID, userID)
+	if current == models.OrgRoleOwner && actor != models.OrgRoleOwner {
+		c.JSONError(403, "only owners can change an owner")
+		return
+	}
+	err := models.SetOrgMemberRole(org.ID, userID, role)
+	switch {
+	case errors.Is(err, models.ErrNotOrgMember):
+		c.JSONError(404, err.Error())
+	case errors.Is(err, models.ErrLastOrgOwner):
+		c.JSONError(400, err.Error())
+	case err != nil:
+		c.JSONError(500, "failed to update member")
+	default:
+		c.JSONOK(map[string]any{"user_id": userID, "role": role})
+	}
+}
+
+// RemoveMember removes a member (owners and admins; only owners may remove
+// an owner). Any member may remove themselves to leave.
+// @router /api/v1/orgs/:id/members/:user_id [delete]
+func (c *OrgController) RemoveMember() {
+	org, actor, ok := c.membership()
+	if !ok {
+		return
+	}
+	u, _ := c.GetCurrentUser()
+	userID := c.Ctx.Input.Param(":user_id")
+	if userID != u.ID {
+		current, _ := models.OrgRole(org.ID, userID)
+		if actor != models.OrgRoleOwner && (actor != models.OrgRoleAdmin || current == models.OrgRoleOwner) {
+			c.JSONError(403, "forbidden")
+			return
+		}
+	}
+	err := models.RemoveOrgMember(org.ID, userID)
+	switch {
+	case errors.Is(err, models.ErrNotOrgMember):
+		c.JSONError(404, err.Error())
+	case errors.Is(err, models.ErrLastOrgOwner):
+		c.JSONError(400, err.Error())
+	case err != nil:
+		c.JSONError(500, "failed to remove member")
+	default:
+		c.JSONOK(map[string]any{"deleted": userID})
+	}
+}
+
+// Invitations lists pending invitations (owners and admins).
+// @router /api/v1/orgs/:id/invitations [get]
+func (c *OrgController) Invitations() {
+	org, _, ok := c.membership(models.OrgRoleOwner, models.OrgRoleAdmin)
+	if !ok {
+		return
+	}
+	invs, err := models.ListOrgInvitations(org.ID)
+	if err != nil {
+		c.JSONError(500, "failed to list invitations")
+		return
+	}
+	if invs == nil {
+		invs = []models.OrgInvitation{}
+	}
+	c.JSONOK(invs)
+}
+
+// Invite emails an invitation to join with a role (default member). The
+// address does not need an account yet.
+// @router /api/v1/orgs/:id/invitations [post]
+func (c *OrgController) Invite() {
+	org, actor, ok := c.membership(models.OrgRoleOwner, models.OrgRoleAdmin)
+	if !ok {
+		return
+	}
+	u, _ := c.GetCurrentUser()
+	var p memberPayload
+	if err := c.ParseJSON(&p); err != nil {
+		c.JSONError(400, err.Error())
+		return
+	}
+	email := normalizeEmail(p.Email)
+	if err := validators.ValidateEmail(email); err != nil {
+		c.JSONError(400, "invalid email")
+		return
+	}
+	role := strings.TrimSpace(p.Role)
+	if role == "" {
+		role = models.OrgRoleMember
+	}
+	if !c.checkGrant(actor, role) {
+		return
+	}
+	if invitee, _ := models.GetUserByEmail(email); invitee != nil && models.IsOrgMember(org.ID, invitee.ID) {
+		c.JSONError(409, "already a member")
+		return
+	}
+	if !c.throttleMail(email) {
+		return
+	}
+	ttl := invitationTTL()
+	inv, token, err := models.CreateOrgInvitation(org.ID, email, role, u.ID, ttl)
+	if err != nil {
+		c.JSONError(500, "could not create invitation")
+		return
+	}
+	// orgs::invite_url is the frontend page that posts the token to
+	// /api/v1/orgs/invitations/accept once the invitee is signed in
+	target := web.AppConfig.DefaultString("orgs::invite_url", "/invitations/accept")
+	inviter := strings.TrimSpace(u.FirstName + " " + u.LastName)
+	if inviter == "" {
+		inviter = u.Email
+	}
+	if err := sendLink("org_invite", []string{email}, map[string]any{
+		"OrgName":   org.Name,
+		"InvitedBy": inviter,
+		"Role":      role,
+		"ExpiresIn": humanDuration(ttl),
+	}, target, url.Values{"token": {token}}); err != nil {
+		c.JSONError(500, "could not send email")
+		return
+	}
+	data := map[string]any{"invitation": inv}
+	if exposeTokens() {
+		data["token"] = token
+	}
+	c.JSONAccepted(data)
+}
+
+// RevokeInvitation deletes a pending invitation (owners and admins).
+// @router /api/v1/orgs/:id/invitations/:invite_id [delete]
+func (c *OrgController) RevokeInvitation() {
+	org, _, ok := c.membership(models.OrgRoleOwner, models.OrgRoleAdmin)
+	if !ok {
+		return
+	}
+	id, _ := strconv.ParseInt(c.Ctx.Input.Param(":invite_id"), 10, 64)
+	err := models.DeleteOrgInvitation(org.ID, id)
+	if errors.Is(err, models.ErrInvitationNotFound) {
+		c.JSONError(404, err.Error())
+		return
+	}
+	if err != nil {
+		c.JSONError(500, "failed to revoke invitation")
+		return
+	}
+	c.JSONOK(map[string]any{"deleted": id})
+}
+
+// Accept joins the organization with an emailed invitation. The signed-in
+// user must own the address it was sent to.
+// @router /api/v1/orgs/invitations/accept [post]
+func (c *OrgController) Accept() {
+	u, ok := c.MustAuth()
+	if !ok {
+		return
+	}
+	var p struct {
+		Token string `json:"token"`
+	}
+	if err := c.ParseJSON(&p); err != nil {
+		c.JSONError(400, err.Error())
+		return
+	}
+	if strings.TrimSpace(p.Token) == "" {
+		c.JSONError(400, "token is required")
+		return
+	}
+	inv, err := models.AcceptOrgInvitation(strings.TrimSpace(p.Token), u)
+	if errors.Is(err, models.ErrInvalidInvitation) {
+		c.JSONError(400, err.Error())
+		return
+	}
+	if err != nil {
+		c.JSONError(500, "failed to accept invitation")
+		return
+	}
+	org, _ := models.GetOrganization(inv.OrgID)
+	if org == nil {
+		c.JSONError(404, models.ErrOrgNotFound.Error())
+		return
+	}
+	role, _ := models.OrgRole(org.ID, u.ID)
+	c.JSONOK(models.UserOrg{Organization: *org, Role: role})
+}
+
+// Switch ends the current session and starts one acting in org_id (0 for
+// the personal space). The new tokens carry the organization in their
+// "org" claim, and refreshing keeps it.
+// @router /api/v1/orgs/switch [post]
+func (c *OrgController) Switch() {
+	u, ok := c.MustAuth()
+	if !ok {
+		return
+	}
+	var p struct {
+		OrgID int64 `json:"org_id"`
+	}
+	if err := c.ParseJSON(&p); err != nil {
+		c.JSONError(400, err.Error())
+		return
+	}
+	if p.OrgID != 0 && !models.IsOrgMember(p.OrgID, u.ID) {
+		c.JSONError(404, models.ErrOrgNotFound.Error())
+		return
+	}
+	claims := c.CurrentClaims()
+	if claims == nil {
+		c.JSONError(403, "personal access tokens cannot switch organization")
+		return
+	}
+	resp, err := c.issueOrgTokens(u, p.OrgID, nil, claims.AMR...)
+	if err != nil {
+		c.JSONError(500, "failed to generate token")
+		return
+	}
+	_ = models.RevokeSession(u.ID, claims.ID)
+	c.JSONOK(resp)
+}
@@ -0,0 +1,95 @@
+package controllers
+
+import (
+	"errors"
+
+	"github.com/beego/beego/v2/client/orm"
+	"github.com/beego/beego/v2/server/web"
+
+	"github.com/mymi14s/goconda/models"
+	"github.com/mymi14s/goconda/utils/hash"
+	"github.com/mymi14s/goconda/utils/validators"
+)
+
+var errPasswordReused = errors.New("password was used recently; choose a different one")
+
+// passwordHistoryDepth is how many previous passwords may not be reused
+// (password::history, 0 disables the check).
+func passwordHistoryDepth() int {
+	return web.AppConfig.DefaultInt("password::history", 5)
+}
+
+// checkNewPassword applies the password policy to pw for u, including the
+// reuse check against u's current and recent passwords. The error is safe to
+// show to the user.
+func checkNewPassword(u *models.User, pw string) error {
+	if err := validators.ValidatePassword(pw, u.Email, u.FirstName, u.LastName); err != nil {
+		return err
+	}
+	depth := passwordHistoryDepth()
+	if depth <= 0 {
+		return nil
+	}
+	if u.PasswordHash != "" && hash.Check(pw, u.PasswordHash) {
+		return errPasswordReused
+	}
+	previous, _ := models.RecentPasswordHashes(u.ID, depth)
+	for _, h := range previous {
+		if hash.Check(pw, h) {
+			return errPasswordReused
+		}
+	}
+	return nil
+}
+
+// setPassword stores a new hash for u and remembers it in the history.
+func setPassword(u *models.User, pw string) error {
+	hv, err := hash.Make(pw)
+	if err != nil {
+		return err
+	}
+	u.PasswordHash = hv
+	// a new password satisfies a reset an administrator required
+	u.PasswordResetRequired = false
+	if _, err := orm.NewOrm().Update(u, "PasswordHash", "PasswordResetRequired"); err != nil {
+		return err
+	}
+	if depth := passwordHistoryDepth(); depth > 0 {
+		return models.AddPasswordHistory(u.ID, hv, depth)
+	}
+	return nil
+}
+
+// SetUserPassword applies the password policy to pw and stores it for u,
+// signing the account out everywhere. It is for the command line; the
+// error is safe to show.
+func SetUserPassword(u *models.User, pw string) error {
+	if err := checkNewPassword(u, pw); err != nil {
+		return err
+	}
+	if err := setPassword(u, pw); err != nil {
+		return err
+	}
+	_, err := models.RevokeOtherSessions(u.ID, "")
+	return err
+}
+
+// upgradePasswordHash rehashes pw after a successful login when the stored
+// hash uses an older algorithm or cost. Failures are ignored; the old hash
+// keeps working.
+func upgradePasswordHash(u *models.User, pw string) {
+	if !hash.NeedsRehash(u.PasswordHash) {
+		return
+	}
+	hv, err := hash.Make(pw)
+	if err != nil {
+		return
+	}
+	old := u.PasswordHash
+	// only replace the hash we checked, in case the password changed meanwhile
+	n, err := orm.NewOrm().QueryTable(new(models.User)).Filter("ID", u.ID).
+		Filter("PasswordHash", old).Update(orm.Params{"PasswordHash": hv})
+	if err == nil && n == 1 {
+		u.PasswordHash = hv
+	}
+}
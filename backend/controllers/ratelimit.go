@@ -0,0 +1,150 @@
+package controllers
+
+import (
+	"math"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/beego/beego/v2/server/web"
+	"github.com/beego/beego/v2/server/web/context"
+
+	"github.com/mymi14s/goconda/models"
+	"github.com/mymi14s/goconda/utils/ratelimit"
+)
+
+var (
+	limitersOnce sync.Once
+	loginLim     *ratelimit.Limiter
+	mailLim      *ratelimit.Limiter
+)
+
+// initLimiters builds the limiters from the [ratelimit] config section.
+// store = memory (default, per instance) or db (shared between instances).
+func initLimiters() {
+	limitersOnce.Do(func() {
+		var store ratelimit.Store = ratelimit.NewMemoryStore()
+		if strings.EqualFold(web.AppConfig.DefaultString("ratelimit::store", "memory"), "db") {
+			store = models.LockoutStore{}
+		}
+		loginLim = ratelimit.New(store, ratelimit.Policy{
+			MaxFailures: web.AppConfig.DefaultInt("ratelimit::login_max_failures", 5),
+			Window:      configDuration("ratelimit::login_window", 15*time.Minute),
+			Lockout:     configDuration("ratelimit::login_lockout", 15*time.Minute),
+			BaseDelay:   configDuration("ratelimit::login_base_delay", time.Second),
+		})
+		// every mail sent counts as a "failure": at most mail_max per window
+		mailLim = ratelimit.New(store, ratelimit.Policy{
+			MaxFailures: web.AppConfig.DefaultInt("ratelimit::mail_max", 5),
+			Window:      configDuration("ratelimit::mail_window", time.Hour),
+			Lockout:     configDuration("ratelimit::mail_window", time.Hour),
+		})
+	})
+}
+
+// loginLimiter throttles failed logins per IP and per email.
+func loginLimiter() *ratelimit.Limiter {
+	initLimiters()
+	return loginLim
+}
+
+// mailLimiter throttles endpoints that send email (verification, reset).
+func mailLimiter() *ratelimit.Limiter {
+	initLimiters()
+	return mailLim
+}
+
+func configDuration(key string, def time.Duration) time.Duration {
+	if d, err := time.ParseDuration(web.AppConfig.DefaultString(key, "")); err == nil {
+		return d
+	}
+	return def
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// clientIP is the address the limiters key on. It is the peer address of
+// the connection; X-Forwarded-For is only honoured when that peer is listed
+// in ratelimit::trusted_proxies (comma separated addresses or CIDRs), and
+// then the right-most hop that is not itself a trusted proxy is used.
+func clientIP(ctx *context.Context) string {
+	host, _, err := net.SplitHostPort(ctx.Request.RemoteAddr)
+	if err != nil {
+		host = ctx.Request.RemoteAddr
+	}
+	trusted := trustedProxies()
+	if !isTrusted(host, trusted) {
+		return host
+	}
+	hops := strings.Split(strings.Join(ctx.Request.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		if !isTrusted(hop, trusted) {
+			return hop
+		}
+		host = hop
+	}
+	return host
+}
+
+func trustedProxies() []netip.Prefix {
+	var out []netip.Prefix
+	for _, s := range strings.Split(web.AppConfig.DefaultString("ratelimit::trusted_proxies", ""), ",") {
+		s = strings.TrimSpace(s)
+		if p, err := netip.ParsePrefix(s); err == nil {
+			out = append(out, p.Masked())
+		} else if a, err := netip.ParseAddr(s); err == nil {
+			out = append(out, netip.PrefixFrom(a, a.BitLen()))
+		}
+	}
+	return out
+}
+
+func isTrusted(ip string, trusted []netip.Prefix) bool {
+	a, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	a = a.Unmap()
+	for _, p := range trusted {
+		if p.Contains(a) {
+			return true
+		}
+	}
+	return false
+}
+
+// loginKeys are the limiter keys for a login attempt.
+func loginKeys(email, ip string) []string {
+	return []string{"login:ip:" + ip, "login:email:" + normalizeEmail(email)}
+}
+
+func mailKeys(email, ip string) []string {
+	return []string{"mail:ip:" + ip, "mail:email:" + normalizeEmail(email)}
+}
+
+// tooManyRequests answers 429 with a Retry-After header in whole seconds.
+func (c *BaseController) tooManyRequests(wait time.Duration) {
+	c.Ctx.Output.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	c.JSONError(429, "too many attempts, try again later")
+}
+
+// throttleMail counts one email send for email and the client IP. It writes
+// a 429 and returns false when the limit is reached.
+func (c *BaseController) throttleMail(email string) bool {
+	keys := mailKeys(email, clientIP(c.Ctx))
+	if wait := mailLimiter().Check(keys...); wait > 0 {
+		c.tooManyRequests(wait)
+		return false
+	}
+	mailLimiter().Fail(keys...)
+	return true
+}
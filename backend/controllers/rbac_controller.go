@@ -0,0 +1,496 @@
+package controllers
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/mymi14s/goconda/models"
+)
+
+// RBACController manages roles, their permission rules and user-role
+// assignments. Every endpoint needs the matching "rbac" permission.
+type RBACController struct {
+	BaseController
+}
+
+type rolePayload struct {
+	Name      string  `json:"name"`
+	Parent    *string `json:"parent"`
+	OrgScoped *bool   `json:"org_scoped"`
+}
+
+type permissionPayload struct {
+	Resource string `json:"resource"`
+	Action   string `json:"action"`
+	Effect   string `json:"effect"`
+}
+
+// validate trims the rule and defaults the effect to allow.
+func (p *permissionPayload) validate() error {
+	p.Resource, p.Action = strings.TrimSpace(p.Resource), strings.TrimSpace(p.Action)
+	p.Effect = strings.ToLower(strings.TrimSpace(p.Effect))
+	if p.Resource == "" || p.Action == "" {
+		return errors.New("resource and action are required")
+	}
+	if p.Effect == "" {
+		p.Effect = models.EffectAllow
+	}
+	if p.Effect != models.EffectAllow && p.Effect != models.EffectDeny {
+		return errors.New("effect must be allow or deny")
+	}
+	return nil
+}
+
+// grantor returns the current user's own permissions and whether they are a
+// superuser, writing 500 on failure.
+func (c *RBACController) grantor() (*models.PermissionSet, bool, bool) {
+	u, err := c.GetCurrentUser()
+	if err != nil || u == nil {
+		c.JSONError(401, "unauthorized")
+		return nil, false, false
+	}
+	set, err := models.EffectivePermissions(u.ID)
+	if err != nil {
+		c.JSONError(500, "failed to resolve permissions")
+		return nil, false, false
+	}
+	return set, u.IsSuperuser || set.HasRole("Superuser"), true
+}
+
+// mayGrant checks allow rules the current user is about to give a role:
+// superusers may give any, others only rules they hold themselves and no
+// wildcards. It writes 403 when not.
+func (c *RBACController) mayGrant(rules ...models.Permission) bool {
+	set, superuser, ok := c.grantor()
+	if !ok || superuser {
+		return ok
+	}
+	for _, p := range rules {
+		if p.Effect == models.EffectDeny {
+			continue
+		}
+		wildcard := p.Action == "*" || strings.HasSuffix(p.Resource, "*")
+		if wildcard || !set.Allows(p.Resource, p.Action) {
+			c.JSONError(403, "only superusers can grant "+p.Resource+":"+p.Action)
+			return false
+		}
+	}
+	return true
+}
+
+// mayGrantRole is mayGrant for everything role grants, inherited rules
+// included. Superuser and its descendants are for superusers to give.
+func (c *RBACController) mayGrantRole(role string) bool {
+	inherits, err := models.InheritsSuperuser(role)
+	if err != nil {
+		c.JSONError(500, "failed to resolve role")
+		return false
+	}
+	if inherits {
+		_, superuser, ok := c.grantor()
+		if ok && !superuser {
+			c.JSONError(403, "only superusers can grant Superuser")
+		}
+		return ok && superuser
+	}
+	rules, err := models.RoleRules(role)
+	if err != nil {
+		c.JSONError(500, "failed to resolve role")
+		return false
+	}
+	return c.mayGrant(rules...)
+}
+
+// mayLift is mayGrant for the deny rules of role about to be removed or
+// changed, as lifting a deny grants what it denied.
+func (c *RBACController) mayLift(role string, match func(models.Permission) bool) bool {
+	rules, err := models.ListPermissions(role)
+	if err != nil {
+		c.JSONError(500, "failed to list permissions")
+		return false
+	}
+	var lifted []models.Permission
+	for _, p := range rules {
+		if p.Effect == models.EffectDeny && match(p) {
+			p.Effect = models.EffectAllow
+			lifted = append(lifted, p)
+		}
+	}
+	return c.mayGrant(lifted...)
+}
+
+// role loads the :name role, writing 404 if it does not exist.
+func (c *RBACController) role() (*models.Role, bool) {
+	r, err := models.GetRole(c.Ctx.Input.Param(":name"))
+	if err != nil {
+		c.JSONError(500, "failed to load role")
+		return nil, false
+	}
+	if r == nil {
+		c.JSONError(404, models.ErrRoleNotFound.Error())
+		return nil, false
+	}
+	return r, true
+}
+
+// @router /api/v1/admin/roles [get]
+func (c *RBACController) ListRoles() {
+	if !c.RequirePermission("rbac", "read") {
+		return
+	}
+	roles, err := models.ListRoles()
+	if err != nil {
+		c.JSONError(500, "failed to list roles")
+		return
+	}
+	if roles == nil {
+		roles = []models.Role{}
+	}
+	c.JSONOK(roles)
+}
+
+// @router /api/v1/admin/roles [post]
+func (c *RBACController) CreateRole() {
+	if !c.RequirePermission("rbac", "create") {
+		return
+	}
+	var p rolePayload
+	if err := c.ParseJSON(&p); err != nil {
+		c.JSONError(400, err.Error())
+		return
+	}
+	name := strings.TrimSpace(p.Name)
+	if name == "" {
+		c.JSONError(400, "name is required")
+		return
+	}
+	parent := ""
+	if p.Parent != nil {
+		parent = strings.TrimSpace(*p.Parent)
+	}
+	if parent != "" && !c.mayGrantRole(parent) {
+		return
+	}
+	r, err := models.CreateRole(name, parent, p.OrgScoped != nil && *p.OrgScoped)
+	switch {
+	case errors.Is(err, models.ErrRoleExists):
+		c.JSONError(409, err.Error())
+	case errors.Is(err, models.ErrRoleNotFound):
+		c.JSONError(400, "parent role not found")
+	case errors.Is(err, models.ErrOrgRoleSuperuser):
+		c.JSONError(400, err.Error())
+	case err != nil:
+		c.JSONError(500, "failed to create role")
+	default:
+		c.JSONOK(r)
+	}
+}
+
+// GetRole returns the role with its own rules.
+// @router /api/v1/admin/roles/:name [get]
+func (c *RBACController) GetRole() {
+	if !c.RequirePermission("rbac", "read") {
+		return
+	}
+	r, ok := c.role()
+	if !ok {
+		return
+	}
+	perms, err := models.ListPermissions(r.Name)
+	if err != nil {
+		c.JSONError(500, "failed to list permissions")
+		return
+	}
+	if perms == nil {
+		perms = []models.Permission{}
+	}
+	c.JSONOK(map[string]any{"name": r.Name, "parent": r.Parent, "org_scoped": r.OrgScoped, "permissions": perms})
+}
+
+// UpdateRole changes the role's parent ("" or null removes it) and, when
+// given, whether it is an organization role.
+// @router /api/v1/admin/roles/:name [put]
+func (c *RBACController) UpdateRole() {
+	if !c.RequirePermission("rbac", "update") {
+		return
+	}
+	r, ok := c.role()
+	if !ok {
+		return
+	}
+	var p rolePayload
+	if err := c.ParseJSON(&p); err != nil {
+		c.JSONError(400, err.Error())
+		return
+	}
+	parent := ""
+	if p.Parent != nil {
+		parent = strings.TrimSpace(*p.Parent)
+	}
+	if parent != "" {
+		if pr, _ := models.GetRole(parent); pr == nil {
+			c.JSONError(400, "parent role not found")
+			return
+		}
+		if parent != r.Parent && !c.mayGrantRole(parent) {
+			return
+		}
+	}
+	// clear the flag before a new parent is checked against it, set it after
+	scoped := r.OrgScoped
+	if p.OrgScoped != nil {
+		scoped = *p.OrgScoped
+	}
+	var err error
+	if !scoped && r.OrgScoped {
+		err = models.SetRoleOrgScoped(r.Name, false)
+	}
+	if err == nil {
+		err = models.SetRoleParent(r.Name, parent)
+	}
+	if err == nil && scoped && !r.OrgScoped {
+		err = models.SetRoleOrgScoped(r.Name, true)
+	}
+	if err != nil {
+		if errors.Is(err, models.ErrRoleCycle) || errors.Is(err, models.ErrOrgRoleSuperuser) {
+			c.JSONError(400, err.Error())
+			return
+		}
+		c.JSONError(500, "failed to update role")
+		return
+	}
+	r.Parent, r.OrgScoped = parent, scoped
+	c.JSONOK(r)
+}
+
+// DeleteRole removes the role, its rules and its assignments.
+// @router /api/v1/admin/roles/:name [delete]
+func (c *RBACController) DeleteRole() {
+	if !c.RequirePermission("rbac", "delete") {
+		return
+	}
+	err := models.DeleteRole(c.Ctx.Input.Param(":name"))
+	switch {
+	case errors.Is(err, models.ErrRoleNotFound):
+		c.JSONError(404, err.Error())
+	case errors.Is(err, models.ErrRoleProtected):
+		c.JSONError(400, err.Error())
+	case err != nil:
+		c.JSONError(500, "failed to delete role")
+	default:
+		c.JSONOK(map[string]any{"deleted": true})
+	}
+}
+
+// @router /api/v1/admin/roles/:name/permissions [get]
+func (c *RBACController) ListPermissions() {
+	if !c.RequirePermission("rbac", "read") {
+		return
+	}
+	r, ok := c.role()
+	if !ok {
+		return
+	}
+	perms, err := models.ListPermissions(r.Name)
+	if err != nil {
+		c.JSONError(500, "failed to list permissions")
+		return
+	}
+	if perms == nil {
+		perms = []models.Permission{}
+	}
+	c.JSONOK(perms)
+}
+
+// AddPermission adds an allow (default) or deny rule to the role.
+// @router /api/v1/admin/roles/:name/permissions [post]
+func (c *RBACController) AddPermission() {
+	if !c.RequirePermission("rbac", "create") {
+		return
+	}
+	r, ok := c.role()
+	if !ok {
+		return
+	}
+	var p permissionPayload
+	if err := c.ParseJSON(&p); err != nil {
+		c.JSONError(400, err.Error())
+		return
+	}
+	if err := p.validate(); err != nil {
+		c.JSONError(400, err.Error())
+		return
+	}
+	if !c.mayGrant(models.Permission{Resource: p.Resource, Action: p.Action, Effect: p.Effect}) {
+		return
+	}
+	perm, err := models.AddPermission(r.Name, p.Resource, p.Action, p.Effect)
+	if err != nil {
+		c.JSONError(500, "failed to add permission")
+		return
+	}
+	c.JSONOK(perm)
+}
+
+// RevokePermission deletes the role's rules for a resource and action.
+// @router /api/v1/admin/roles/:name/permissions [delete]
+func (c *RBACController) RevokePermission() {
+	if !c.RequirePermission("rbac", "delete") {
+		return
+	}
+	r, ok := c.role()
+	if !ok {
+		return
+	}
+	var p permissionPayload
+	if err := c.ParseJSON(&p); err != nil {
+		c.JSONError(400, err.Error())
+		return
+	}
+	if err := p.validate(); err != nil {
+		c.JSONError(400, err.Error())
+		return
+	}
+	if !c.mayLift(r.Name, func(rule models.Permission) bool {
+		return rule.Resource == p.Resource && rule.Action == p.Action
+	}) {
+		return
+	}
+	n, err := models.Revoke(r.Name, p.Resource, p.Action)
+	if err != nil {
+		c.JSONError(500, "failed to revoke permission")
+		return
+	}
+	c.JSONOK(map[string]any{"revoked": n})
+}
+
+// @router /api/v1/admin/roles/:name/permissions/:id [put]
+func (c *RBACController) UpdatePermission() {
+	if !c.RequirePermission("rbac", "update") {
+		return
+	}
+	id, err := strconv.ParseInt(c.Ctx.Input.Param(":id"), 10, 64)
+	if err != nil {
+		c.JSONError(400, "invalid id")
+		return
+	}
+	var p permissionPayload
+	if err := c.ParseJSON(&p); err != nil {
+		c.JSONError(400, err.Error())
+		return
+	}
+	if err := p.validate(); err != nil {
+		c.JSONError(400, err.Error())
+		return
+	}
+	name := c.Ctx.Input.Param(":name")
+	if !c.mayLift(name, func(rule models.Permission) bool { return rule.ID == id }) ||
+		!c.mayGrant(models.Permission{Resource: p.Resource, Action: p.Action, Effect: p.Effect}) {
+		return
+	}
+	perm, err := models.UpdatePermission(name, id, p.Resource, p.Action, p.Effect)
+	if errors.Is(err, models.ErrPermissionNotFound) {
+		c.JSONError(404, err.Error())
+		return
+	}
+	if err != nil {
+		c.JSONError(500, "failed to update permission")
+		return
+	}
+	c.JSONOK(perm)
+}
+
+// @router /api/v1/admin/roles/:name/permissions/:id [delete]
+func (c *RBACController) DeletePermission() {
+	if !c.RequirePermission("rbac", "delete") {
+		return
+	}
+	id, err := strconv.ParseInt(c.Ctx.Input.Param(":id"), 10, 64)
+	if err != nil {
+		c.JSONError(400, "invalid id")
+		return
+	}
+	name := c.Ctx.Input.Param(":name")
+	if !c.mayLift(name, func(rule models.Permission) bool { return rule.ID == id }) {
+		return
+	}
+	err = models.DeletePermission(name, id)
+	if errors.Is(err, models.ErrPermissionNotFound) {
+		c.JSONError(404, err.Error())
+		return
+	}
+	if err != nil {
+		c.JSONError(500, "failed to delete permission")
+		return
+	}
+	c.JSONOK(map[string]any{"deleted": true})
+}
+
+// UserRoles lists the user's assigned roles plus the effective roles and
+// rules after inheritance.
+// @router /api/v1/admin/users/:user_id/roles [get]
+func (c *RBACController) UserRoles() {
+	if !c.RequirePermission("rbac", "read") {
+		return
+	}
+	userID := c.Ctx.Input.Param(":user_id")
+	roles, err := models.ListUserRoles(userID)
+	if err != nil {
+		c.JSONError(500, "failed to list roles")
+		return
+	}
+	effective, err := models.EffectivePermissions(userID)
+	if err != nil {
+		c.JSONError(500, "failed to resolve permissions")
+		return
+	}
+	c.JSONOK(map[string]any{"user_id": userID, "roles": roles, "effective": effective})
+}
+
+// @router /api/v1/admin/users/:user_id/roles [post]
+func (c *RBACController) AssignRole() {
+	if !c.RequirePermission("rbac", "update") {
+		return
+	}
+	userID := c.Ctx.Input.Param(":user_id")
+	var p rolePayload
+	if err := c.ParseJSON(&p); err != nil {
+		c.JSONError(400, err.Error())
+		return
+	}
+	name := strings.TrimSpace(p.Name)
+	if u, _ := models.GetUserByID(userID); u == nil {
+		c.JSONError(404, "user not found")
+		return
+	}
+	if r, _ := models.GetRole(name); r == nil {
+		c.JSONError(404, models.ErrRoleNotFound.Error())
+		return
+	}
+	if !c.mayGrantRole(name) {
+		return
+	}
+	if err := models.AssignRole(userID, name); err != nil {
+		c.JSONError(500, "failed to assign role")
+		return
+	}
+	c.JSONOK(map[string]any{"user_id": userID, "role": name})
+}
+
+// @router /api/v1/admin/users/:user_id/roles/:role [delete]
+func (c *RBACController) UnassignRole() {
+	if !c.RequirePermission("rbac", "update") {
+		return
+	}
+	removed, err := models.UnassignRole(c.Ctx.Input.Param(":user_id"), c.Ctx.Input.Param(":role"))
+	if err != nil {
+		c.JSONError(500, "failed to unassign role")
+		return
+	}
+	if !removed {
+		c.JSONError(404, "role not assigned")
+		return
+	}
+	c.JSONOK(map[string]any{"deleted": true})
+}
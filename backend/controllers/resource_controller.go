@@ -0,0 +1,356 @@
+package controllers
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/beego/beego/v2/client/orm"
+	"github.com/beego/beego/v2/server/web"
+
+	"github.com/mymi14s/goconda/models"
+	"github.com/mymi14s/goconda/utils/query"
+)
+
+// Resource describes a model served by ResourceController. The model must
+// be registered with the ORM and keep its primary key in ID.
+type Resource struct {
+	// Name is the permission and token-scope resource, e.g. "notes".
+	Name string
+	// New returns an empty model, e.g. func() any { return new(Note) }.
+	New func() any
+	// Writable lists the json fields clients may set. Required lists those
+	// create and PUT must include and PATCH must not clear.
+	Writable []string
+	Required []string
+	// Query is what List accepts.
+	Query query.Spec
+	// Owner is the ORM field holding the owning user: a string user ID or
+	// a rel(fk) to User. Each user then sees only their own rows, and is
+	// set as the owner on create. Leave empty for rows everyone may reach.
+	Owner string
+	// Permissions requires the RBAC permission Name with the request's
+	// action (read, create, update, delete) on top of ownership.
+	Permissions bool
+	// Validate runs before every insert and update; its error is answered
+	// with 400.
+	Validate func(obj any) error
+}
+
+// ResourceRoutes routes path and path/:id to a ResourceController for r:
+//
+//	GET    path       List
+//	POST   path       Create
+//	GET    path/:id   Get
+//	PUT    path/:id   Update (replaces every writable field)
+//	PATCH  path/:id   Patch (sets the fields sent)
+//	DELETE path/:id   Delete
+//
+// It panics if r does not match its model, like other route mistakes.
+func ResourceRoutes(path string, r *Resource) web.LinkNamespace {
+	if err := r.check(); err != nil {
+		panic(fmt.Sprintf("resource %s: %v", path, err))
+	}
+	c := &ResourceController{Resource: r}
+	return func(ns *web.Namespace) {
+		ns.Router(path, c, "get:List;post:Create")
+		ns.Router(path+"/:id", c, "get:Get;put:Update;patch:Patch;delete:Delete")
+	}
+}
+
+func (r *Resource) check() error {
+	if r.Name == "" || r.New == nil {
+		return fmt.Errorf("Name and New are required")
+	}
+	obj := r.New()
+	if !field(obj, "ID").IsValid() {
+		return fmt.Errorf("%T has no ID field", obj)
+	}
+	if r.Owner != "" && !field(obj, r.Owner).IsValid() {
+		return fmt.Errorf("%T has no %s field", obj, r.Owner)
+	}
+	for _, list := range [][]string{r.Writable, r.Required} {
+		for _, name := range list {
+			if !jsonField(obj, name).IsValid() {
+				return fmt.Errorf("%T has no %q field", obj, name)
+			}
+		}
+	}
+	return nil
+}
+
+// ResourceController is a REST controller for any Resource, so apps do not
+// hand-write CRUD. Rows are scoped to the active organization for
+// tenant-aware models.
+type ResourceController struct {
+	BaseController
+	Resource *Resource
+	user     *models.User
+	org      int64
+}
+
+// Prepare authenticates every action and applies the token scope and the
+// RBAC permission.
+func (c *ResourceController) Prepare() {
+	user, ok := c.MustAuth()
+	if !ok {
+		c.StopRun()
+	}
+	r := c.Resource
+	if !c.RequireScope(r.Name) {
+		c.StopRun()
+	}
+	if r.Permissions && !c.RequirePermission(r.Name, methodAction(c.Ctx.Input.Method())) {
+		c.StopRun()
+	}
+	c.user = user
+	c.org = c.CurrentOrg()
+}
+
+func (c *ResourceController) ormer() *models.TenantOrmer {
+	return models.ForOrg(c.org)
+}
+
+// List returns a page of rows with the filters, sort, q and cursor paging
+// of Resource.Query.
+func (c *ResourceController) List() {
+	r := c.Resource
+	q, err := query.Parse(c.Ctx.Request.URL.Query(), r.Query)
+	if err != nil {
+		c.JSONError(400, err.Error())
+		return
+	}
+	qs := c.ormer().QueryTable(r.New())
+	if r.Owner != "" {
+		qs = qs.Filter(r.Owner, c.user.ID)
+	}
+	rows := reflect.New(reflect.SliceOf(reflect.TypeOf(r.New())))
+	rows.Elem().Set(reflect.MakeSlice(rows.Elem().Type(), 0, 0))
+	page, err := q.Find(qs, rows.Interface())
+	if err != nil {
+		c.JSONError(500, "failed to list "+r.Name)
+		return
+	}
+	for i := 0; i < rows.Elem().Len(); i++ {
+		c.showOwner(rows.Elem().Index(i).Interface())
+	}
+	c.JSONOK(map[string]any{
+		"total": page.Total,
+		"items": rows.Elem().Interface(),
+		"next":  query.Link(c.Ctx.Request.URL, page.Next),
+		"prev":  query.Link(c.Ctx.Request.URL, page.Prev),
+	})
+}
+
+// Get returns one row.
+func (c *ResourceController) Get() {
+	obj, ok := c.load()
+	if !ok {
+		return
+	}
+	c.JSONOK(obj)
+}
+
+// Create inserts a row from the JSON body.
+func (c *ResourceController) Create() {
+	data, ok := c.body(true)
+	if !ok {
+		return
+	}
+	obj := c.Resource.New()
+	if err := (models.BaseModel{}).Decode(obj, data); err != nil {
+		c.JSONError(400, err.Error())
+		return
+	}
+	if c.Resource.Owner != "" {
+		setOwner(field(obj, c.Resource.Owner), c.user)
+	}
+	if !c.validate(obj) {
+		return
+	}
+	if _, err := (models.BaseModel{}).Insert(c.ormer(), obj); err != nil {
+		c.JSONError(500, "failed to create")
+		return
+	}
+	c.JSONOK(obj)
+}
+
+// Update replaces every writable field; those missing from the body are
+// cleared.
+func (c *ResourceController) Update() {
+	c.save(true)
+}
+
+// Patch sets only the fields in the body.
+func (c *ResourceController) Patch() {
+	c.save(false)
+}
+
+func (c *ResourceController) save(replace bool) {
+	obj, ok := c.load()
+	if !ok {
+		return
+	}
+	data, ok := c.body(replace)
+	if !ok {
+		return
+	}
+	if replace {
+		for _, name := range c.Resource.Writable {
+			if f := jsonField(obj, name); f.IsValid() {
+				f.Set(reflect.Zero(f.Type()))
+			}
+		}
+	}
+	if err := (models.BaseModel{}).Decode(obj, data); err != nil {
+		c.JSONError(400, err.Error())
+		return
+	}
+	if !c.validate(obj) {
+		return
+	}
+	if _, err := c.ormer().Update(obj); err != nil {
+		c.JSONError(500, "failed to update")
+		return
+	}
+	c.JSONOK(obj)
+}
+
+// Delete removes one row.
+func (c *ResourceController) Delete() {
+	obj, ok := c.load()
+	if !ok {
+		return
+	}
+	if _, err := c.ormer().Delete(obj); err != nil {
+		c.JSONError(500, "failed to delete")
+		return
+	}
+	c.JSONOK(map[string]any{"deleted": field(obj, "ID").Interface()})
+}
+
+// load reads the :id row, writing 404 if it does not exist, is in another
+// organization or belongs to another user.
+func (c *ResourceController) load() (any, bool) {
+	obj := c.Resource.New()
+	id := field(obj, "ID")
+	raw := c.Ctx.Input.Param(":id")
+	switch id.Kind() {
+	case reflect.String:
+		id.SetString(raw)
+	default:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			c.JSONError(404, "not found")
+			return nil, false
+		}
+		id.SetInt(n)
+	}
+	if err := c.ormer().Read(obj); err != nil {
+		if err == orm.ErrNoRows {
+			c.JSONError(404, "not found")
+		} else {
+			c.JSONError(500, "failed to load")
+		}
+		return nil, false
+	}
+	if c.Resource.Owner != "" && ownerID(field(obj, c.Resource.Owner)) != c.user.ID {
+		c.JSONError(404, "not found")
+		return nil, false
+	}
+	c.showOwner(obj)
+	return obj, true
+}
+
+// body parses the JSON body, refusing fields that are not writable and,
+// when full, a body missing a required field. Required fields may never be
+// emptied.
+func (c *ResourceController) body(full bool) (map[string]any, bool) {
+	var data map[string]any
+	if err := c.ParseJSON(&data); err != nil {
+		c.JSONError(400, "invalid json")
+		return nil, false
+	}
+	for name := range data {
+		if !contains(c.Resource.Writable, name) {
+			c.JSONError(400, fmt.Sprintf("%s cannot be set", name))
+			return nil, false
+		}
+	}
+	for _, name := range c.Resource.Required {
+		v, ok := data[name]
+		if (!ok && full) || (ok && (v == nil || v == "")) {
+			c.JSONError(400, name+" is required")
+			return nil, false
+		}
+	}
+	return data, true
+}
+
+func (c *ResourceController) validate(obj any) bool {
+	if c.Resource.Validate == nil {
+		return true
+	}
+	if err := c.Resource.Validate(obj); err != nil {
+		c.JSONError(400, err.Error())
+		return false
+	}
+	return true
+}
+
+// showOwner fills a rel(fk) owner, which the ORM loads with only its ID,
+// with the current user, who owns every row the controller returns.
+func (c *ResourceController) showOwner(obj any) {
+	if c.Resource.Owner == "" {
+		return
+	}
+	if f := field(obj, c.Resource.Owner); f.Kind() == reflect.Ptr {
+		setOwner(f, c.user)
+	}
+}
+
+func field(obj any, name string) reflect.Value {
+	return reflect.Indirect(reflect.ValueOf(obj)).FieldByName(name)
+}
+
+// jsonField finds obj's field by its json name.
+func jsonField(obj any, name string) reflect.Value {
+	v := reflect.Indirect(reflect.ValueOf(obj))
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		tag, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if tag == name || (tag == "" && strings.EqualFold(t.Field(i).Name, name)) {
+			return v.Field(i)
+		}
+	}
+	return reflect.Value{}
+}
+
+// setOwner stores u in an owner field, a user ID or a *models.User.
+func setOwner(f reflect.Value, u *models.User) {
+	if f.Kind() == reflect.String {
+		f.SetString(u.ID)
+	} else {
+		f.Set(reflect.ValueOf(u))
+	}
+}
+
+func ownerID(f reflect.Value) string {
+	if f.Kind() == reflect.String {
+		return f.String()
+	}
+	if u, ok := f.Interface().(*models.User); ok && u != nil {
+		return u.ID
+	}
+	return ""
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
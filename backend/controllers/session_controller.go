@@ -0,0 +1,90 @@
+package controllers
+
+import (
+	"errors"
+
+	"github.com/mymi14s/goconda/models"
+	jwtutil "github.com/mymi14s/goconda/utils/jwt"
+)
+
+// Every issued access token is recorded as a session.
+func init() {
+	jwtutil.OnIssue(func(claims *jwtutil.Claims, opts jwtutil.IssueOptions) {
+		if claims.Purpose != "" {
+			return
+		}
+		_ = models.RecordSession(claims.ID, claims.Subject, opts.UserAgent, opts.IP,
+			claims.IssuedAt.Time, claims.ExpiresAt.Time)
+	})
+}
+
+type SessionController struct {
+	BaseController
+}
+
+// @router /api/v1/auth/sessions [get]
+func (c *SessionController) List() {
+	u, ok := c.MustAuth()
+	if !ok {
+		return
+	}
+	sessions, err := models.ListSessions(u.ID)
+	if err != nil {
+		c.JSONError(500, "failed to list sessions")
+		return
+	}
+	current := ""
+	if claims := c.CurrentClaims(); claims != nil {
+		current = claims.ID
+	}
+	out := make([]map[string]any, 0, len(sessions))
+	for _, s := range sessions {
+		out = append(out, map[string]any{
+			"jti":          s.JTI,
+			"user_agent":   s.UserAgent,
+			"ip":           s.IP,
+			"issued_at":    s.IssuedAt,
+			"last_seen_at": s.LastSeenAt,
+			"expires_at":   s.ExpiresAt,
+			"current":      s.JTI == current,
+		})
+	}
+	c.JSONOK(map[string]any{"sessions": out})
+}
+
+// @router /api/v1/auth/sessions/:jti [delete]
+func (c *SessionController) Revoke() {
+	u, ok := c.MustAuth()
+	if !ok {
+		return
+	}
+	jti := c.Ctx.Input.Param(":jti")
+	if err := models.RevokeSession(u.ID, jti); err != nil {
+		if errors.Is(err, models.ErrSessionNotFound) {
+			c.JSONError(404, err.Error())
+			return
+		}
+		c.JSONError(500, "failed to revoke session")
+		return
+	}
+	c.JSONOK(map[string]any{"revoked": jti})
+}
+
+// @router /api/v1/auth/sessions/revoke-others [post]
+func (c *SessionController) RevokeOthers() {
+	u, ok := c.MustAuth()
+	if !ok {
+		return
+	}
+	claims := c.CurrentClaims()
+	if claims == nil {
+		c.JSONError(401, "unauthorized")
+		return
+	}
+	n, err := models.RevokeOtherSessions(u.ID, claims.ID)
+	if err != nil {
+		c.JSONError(500, "failed to revoke sessions")
+		return
+	}
+	c.JSONOK(map[string]any{"revoked": n})
+}
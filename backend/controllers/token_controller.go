@@ -0,0 +1,126 @@
+package controllers
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/beego/beego/v2/server/web"
+
+	"github.com/mymi14s/goconda/models"
+)
+
+// TokenController manages the user's personal access tokens.
+type TokenController struct {
+	BaseController
+}
+
+type tokenPayload struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	// ExpiresInDays of 0 means the token never expires, unless
+	// tokens::max_expiration_days sets a limit.
+	ExpiresInDays int `json:"expires_in_days"`
+}
+
+// tokenView is a token as shown to its owner, with the scopes as a list.
+func tokenView(t *models.PersonalAccessToken) map[string]any {
+	return map[string]any{
+		"id":           t.ID,
+		"name":         t.Name,
+		"hint":         t.Hint,
+		"scopes":       t.ScopeList(),
+		"org_id":       t.OrgID,
+		"expires_at":   t.ExpiresAt,
+		"last_used_at": t.LastUsedAt,
+		"last_used_ip": t.LastUsedIP,
+		"created_at":   t.CreatedAt,
+	}
+}
+
+// @router /api/v1/auth/tokens [get]
+func (c *TokenController) List() {
+	u, ok := c.MustAuth()
+	if !ok {
+		return
+	}
+	tokens, err := models.ListPersonalAccessTokens(u.ID)
+	if err != nil {
+		c.JSONError(500, "failed to list tokens")
+		return
+	}
+	out := make([]map[string]any, 0, len(tokens))
+	for i := range tokens {
+		out = append(out, tokenView(&tokens[i]))
+	}
+	c.JSONOK(out)
+}
+
+// Create issues a token acting in the current organization. The token is
+// in the response only; it cannot be shown again.
+// @router /api/v1/auth/tokens [post]
+func (c *TokenController) Create() {
+	u, ok := c.MustAuth()
+	if !ok {
+		return
+	}
+	var p tokenPayload
+	if err := c.ParseJSON(&p); err != nil {
+		c.JSONError(400, err.Error())
+		return
+	}
+	name := strings.TrimSpace(p.Name)
+	if name == "" || len(name) > 100 {
+		c.JSONError(400, "name is required (at most 100 characters)")
+		return
+	}
+	scopes, err := models.ParseScopes(p.Scopes)
+	if err != nil {
+		c.JSONError(400, err.Error())
+		return
+	}
+	days := p.ExpiresInDays
+	if days < 0 {
+		c.JSONError(400, "expires_in_days cannot be negative")
+		return
+	}
+	if max := web.AppConfig.DefaultInt("tokens::max_expiration_days", 0); max > 0 {
+		if days == 0 || days > max {
+			c.JSONError(400, "expires_in_days must be between 1 and "+strconv.Itoa(max))
+			return
+		}
+	}
+	var expiresAt *time.Time
+	if days > 0 {
+		t := time.Now().AddDate(0, 0, days)
+		expiresAt = &t
+	}
+	t, raw, err := models.CreatePersonalAccessToken(u.ID, name, c.CurrentOrg(), scopes, expiresAt)
+	if err != nil {
+		c.JSONError(500, "failed to create token")
+		return
+	}
+	view := tokenView(t)
+	view["token"] = raw
+	c.JSONOK(view)
+}
+
+// @router /api/v1/auth/tokens/:id [delete]
+func (c *TokenController) Revoke() {
+	u, ok := c.MustAuth()
+	if !ok {
+		return
+	}
+	id, _ := strconv.ParseInt(c.Ctx.Input.Param(":id"), 10, 64)
+	err := models.RevokePersonalAccessToken(u.ID, id)
+	if errors.Is(err, models.ErrTokenNotFound) {
+		c.JSONError(404, err.Error())
+		return
+	}
+	if err != nil {
+		c.JSONError(500, "failed to revoke token")
+		return
+	}
+	c.JSONOK(map[string]any{"deleted": id})
+}
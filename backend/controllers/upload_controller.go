@@ -1,8 +1,10 @@
 package controllers
 
 import (
+    "errors"
     "fmt"
     "io"
+    "log"
     "os"
     "path/filepath"
     "strings"
@@ -17,6 +19,9 @@ type UploadController struct {
 
 func (c *UploadController) Prepare() {
     c.MustAuth()
+    if !c.RequireScope("uploads") {
+        c.StopRun()
+    }
 }
 
 // @router /api/v1/upload [post]
@@ -28,32 +33,48 @@ func (c *UploadController) Upload() {
     }
     defer f.Close()
 
+    dst, err := storeUpload(f, h.Filename)
+    if err != nil {
+        c.JSONError(500, err.Error())
+        return
+    }
+
+    c.JSONOK(map[string]interface{}{
+        "filename": h.Filename,
+        "stored_as": dst,
+        "size": h.Size,
+    })
+}
+
+// storeUpload writes r to a new file in upload::dir named after filename
+// and returns its path.
+func storeUpload(r io.Reader, filename string) (string, error) {
     uploadDir := web.AppConfig.DefaultString("upload::dir", "./uploads")
     if err := os.MkdirAll(uploadDir, 0o755); err != nil {
-        c.JSONError(500, "could not create upload dir")
-        return
+        return "", errors.New("could not create upload dir")
     }
 
-    name := filepath.Base(h.Filename)
+    name := filepath.Base(filename)
     name = strings.ReplaceAll(name, "..", "")
     ts := time.Now().UnixNano()
     dst := filepath.Join(uploadDir, fmt.Sprintf("%d_%s", ts, name))
 
     out, err := os.Create(dst)
     if err != nil {
-        c.JSONError(500, "could not save file")
-        return
+        return "", errors.New("could not save file")
     }
     defer out.Close()
 
-    if _, err := io.Copy(out, f); err != nil {
-        c.JSONError(500, "could not write file")
-        return
+    if _, err := io.Copy(out, r); err != nil {
+        _ = os.Remove(dst)
+        return "", errors.New("could not write file")
     }
+    return dst, nil
+}
 
-    c.JSONOK(map[string]interface{}{
-        "filename": h.Filename,
-        "stored_as": dst,
-        "size": h.Size,
-    })
+// removeUpload deletes a file stored by storeUpload that is no longer used.
+func removeUpload(path string) {
+    if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+        log.Printf("remove upload %s: %v", path, err)
+    }
 }
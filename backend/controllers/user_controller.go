@@ -1,24 +1,246 @@
 package controllers
 
-import "fmt"
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/beego/beego/v2/client/orm"
+	"github.com/beego/beego/v2/server/web"
+
+	"github.com/mymi14s/goconda/models"
+	"github.com/mymi14s/goconda/utils/hash"
+	"github.com/mymi14s/goconda/utils/validators"
+)
 
 type UserController struct {
 	BaseController
 }
 
-func (c *UserController) Me() {
+// Prepare limits personal access tokens to their "users" scope; the
+// endpoints act on the caller's own account, so RBAC is not consulted.
+func (c *UserController) Prepare() {
+	if !c.RequireScope("users") {
+		c.StopRun()
+	}
+}
 
-	u, err := c.GetCurrentUser()
-	fmt.Println(u)
-	if err != nil || u == nil {
-		c.JSONError(401, "unauthorized")
-		return
+// AccountDeletionGrace is how long a deleted account is kept before it is
+// purged, from account::deletion_grace_days.
+func AccountDeletionGrace() time.Duration {
+	days := web.AppConfig.DefaultInt("account::deletion_grace_days", 30)
+	if days < 0 {
+		days = 30
 	}
-	c.JSONOK(map[string]interface{}{
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// avatarMaxBytes caps avatar uploads, from account::avatar_max_bytes.
+func avatarMaxBytes() int64 {
+	n := web.AppConfig.DefaultInt64("account::avatar_max_bytes", 2<<20)
+	if n <= 0 {
+		n = 2 << 20
+	}
+	return n
+}
+
+// avatarTypes are the image types accepted as avatars.
+var avatarTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+func profile(u *models.User) map[string]any {
+	return map[string]any{
+		"id":         u.ID,
 		"email":      u.Email,
 		"first_name": u.FirstName,
 		"last_name":  u.LastName,
+		"avatar":     u.Avatar,
+		"locale":     u.Locale,
+		"timezone":   u.Timezone,
 		"created_at": u.CreatedAt,
 		"updated_at": u.UpdatedAt,
+	}
+}
+
+// @router /api/v1/users/me [get]
+func (c *UserController) Me() {
+	u, err := c.GetCurrentUser()
+	if err != nil || u == nil {
+		c.JSONError(401, "unauthorized")
+		return
+	}
+	c.JSONOK(profile(u))
+}
+
+// profilePayload holds the fields a PATCH sets; absent fields are left
+// alone. Avatar may only be "" to remove the current one.
+type profilePayload struct {
+	FirstName *string `json:"first_name"`
+	LastName  *string `json:"last_name"`
+	Locale    *string `json:"locale"`
+	Timezone  *string `json:"timezone"`
+	Avatar    *string `json:"avatar"`
+}
+
+// UpdateMe changes the profile. It takes JSON, or multipart/form-data with
+// the same fields plus an "avatar" image file, which is stored through the
+// upload directory.
+// @router /api/v1/users/me [patch]
+func (c *UserController) UpdateMe() {
+	u, err := c.GetCurrentUser()
+	if err != nil || u == nil {
+		c.JSONError(401, "unauthorized")
+		return
+	}
+	var p profilePayload
+	multipart := strings.HasPrefix(c.Ctx.Input.Header("Content-Type"), "multipart/form-data")
+	if multipart {
+		if err := c.Ctx.Request.ParseMultipartForm(avatarMaxBytes()); err != nil {
+			c.JSONError(400, "invalid form")
+			return
+		}
+		field := func(name string) *string {
+			if v, ok := c.Ctx.Request.MultipartForm.Value[name]; ok && len(v) > 0 {
+				return &v[0]
+			}
+			return nil
+		}
+		p = profilePayload{FirstName: field("first_name"), LastName: field("last_name"), Locale: field("locale"), Timezone: field("timezone")}
+	} else if err := c.ParseJSON(&p); err != nil {
+		c.JSONError(400, err.Error())
+		return
+	}
+
+	var cols []string
+	set := func(dst *string, v *string, col string) {
+		if v != nil {
+			*dst = strings.TrimSpace(*v)
+			cols = append(cols, col)
+		}
+	}
+	set(&u.FirstName, p.FirstName, "FirstName")
+	set(&u.LastName, p.LastName, "LastName")
+	set(&u.Locale, p.Locale, "Locale")
+	set(&u.Timezone, p.Timezone, "Timezone")
+	if (p.FirstName != nil && u.FirstName == "") || (p.LastName != nil && u.LastName == "") {
+		c.JSONError(400, "name cannot be empty")
+		return
+	}
+	if len(u.FirstName) > 100 || len(u.LastName) > 100 {
+		c.JSONError(400, "name is too long")
+		return
+	}
+	if err := validators.ValidateLocale(u.Locale); err != nil {
+		c.JSONError(400, err.Error())
+		return
+	}
+	if err := validators.ValidateTimezone(u.Timezone); err != nil {
+		c.JSONError(400, err.Error())
+		return
+	}
+
+	oldAvatar := u.Avatar
+	if p.Avatar != nil {
+		if *p.Avatar != "" {
+			c.JSONError(400, "upload the avatar as a multipart file")
+			return
+		}
+		u.Avatar = ""
+		cols = append(cols, "Avatar")
+	}
+	if multipart {
+		if path, ok := c.saveAvatar(); !ok {
+			return
+		} else if path != "" {
+			u.Avatar = path
+			cols = append(cols, "Avatar")
+		}
+	}
+
+	if len(cols) > 0 {
+		if _, err := orm.NewOrm().Update(u, append(cols, "UpdatedAt")...); err != nil {
+			c.JSONError(500, "failed to update profile")
+			return
+		}
+		if u.Avatar != oldAvatar && oldAvatar != "" {
+			removeUpload(oldAvatar)
+		}
+	}
+	c.JSONOK(profile(u))
+}
+
+// saveAvatar stores the "avatar" file of a multipart request, if any, and
+// returns its path. It writes the error response and returns false when the
+// file is not an accepted image.
+func (c *UserController) saveAvatar() (string, bool) {
+	f, h, err := c.GetFile("avatar")
+	if err == http.ErrMissingFile {
+		return "", true
+	}
+	if err != nil {
+		c.JSONError(400, "invalid avatar")
+		return "", false
+	}
+	defer f.Close()
+	if h.Size > avatarMaxBytes() {
+		c.JSONError(400, "avatar is too large")
+		return "", false
+	}
+	head := make([]byte, 512)
+	n, _ := f.Read(head)
+	if !avatarTypes[http.DetectContentType(head[:n])] {
+		c.JSONError(400, "avatar must be a PNG, JPEG, GIF or WebP image")
+		return "", false
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		c.JSONError(500, "could not read avatar")
+		return "", false
+	}
+	path, err := storeUpload(f, h.Filename)
+	if err != nil {
+		c.JSONError(500, err.Error())
+		return "", false
+	}
+	return path, true
+}
+
+type deleteAccountPayload struct {
+	Password string `json:"password"`
+}
+
+// DeleteMe deletes the account after checking the password. It is signed
+// out at once and purged after the grace period.
+// @router /api/v1/users/me [delete]
+func (c *UserController) DeleteMe() {
+	u, err := c.GetCurrentUser()
+	if err != nil || u == nil {
+		c.JSONError(401, "unauthorized")
+		return
+	}
+	var p deleteAccountPayload
+	if err := c.ParseJSON(&p); err != nil {
+		c.JSONError(400, err.Error())
+		return
+	}
+	if u.PasswordHash == "" {
+		c.JSONError(400, "set a password with forgot-password first")
+		return
+	}
+	if !hash.Check(p.Password, u.PasswordHash) {
+		c.JSONError(400, "password incorrect")
+		return
+	}
+	if err := models.SoftDeleteUser(u.ID); err != nil {
+		c.JSONError(500, "failed to delete account")
+		return
+	}
+	c.clearAuthCookies()
+	c.JSONOK(map[string]any{
+		"deleted":     true,
+		"purge_after": time.Now().Add(AccountDeletionGrace()),
 	})
 }
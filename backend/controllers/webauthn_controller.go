@@ -0,0 +1,307 @@
+package controllers
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/beego/beego/v2/server/web"
+
+	"github.com/mymi14s/goconda/models"
+	"github.com/mymi14s/goconda/utils/webauthn"
+)
+
+const (
+	// webauthnSessionKey holds the pending ceremony as
+	// "<kind>|<expires unix>|<challenge>|<account>": the user ID when
+	// registering, the email typed at login (if any).
+	webauthnSessionKey  = "webauthn_ceremony"
+	webauthnCeremonyTTL = 5 * time.Minute
+)
+
+type WebAuthnController struct {
+	BaseController
+}
+
+// relyingParty reads [webauthn]; rp_id and origins default to this request's host.
+func (c *WebAuthnController) relyingParty() *webauthn.RelyingParty {
+	host := c.Ctx.Request.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	rp := &webauthn.RelyingParty{
+		ID:                      web.AppConfig.DefaultString("webauthn::rp_id", host),
+		Name:                    web.AppConfig.DefaultString("webauthn::rp_name", web.AppConfig.DefaultString("appname", "goconda")),
+		RequireUserVerification: web.AppConfig.DefaultBool("webauthn::require_user_verification", false),
+	}
+	for _, o := range strings.Split(web.AppConfig.DefaultString("webauthn::origins", ""), ",") {
+		if o = strings.TrimRight(strings.TrimSpace(o), "/"); o != "" {
+			rp.Origins = append(rp.Origins, o)
+		}
+	}
+	if len(rp.Origins) == 0 {
+		rp.Origins = []string{c.Ctx.Input.Scheme() + "://" + c.Ctx.Request.Host}
+	}
+	return rp
+}
+
+// startCeremony stores a new challenge in the session.
+func (c *WebAuthnController) startCeremony(kind, account string) (string, bool) {
+	if c.Ctx.Input.CruSession == nil {
+		c.JSONError(500, "sessions are disabled")
+		return "", false
+	}
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		c.JSONError(500, "could not create challenge")
+		return "", false
+	}
+	exp := strconv.FormatInt(time.Now().Add(webauthnCeremonyTTL).Unix(), 10)
+	if err := c.SetSession(webauthnSessionKey, kind+"|"+exp+"|"+challenge+"|"+account); err != nil {
+		c.JSONError(500, "could not store challenge")
+		return "", false
+	}
+	return challenge, true
+}
+
+// takeCeremony returns and clears the pending challenge of kind; each
+// challenge can be answered once.
+func (c *WebAuthnController) takeCeremony(kind string) (challenge, account string, err error) {
+	if c.Ctx.Input.CruSession == nil {
+		return "", "", errors.New("sessions are disabled")
+	}
+	raw, _ := c.GetSession(webauthnSessionKey).(string)
+	_ = c.DelSession(webauthnSessionKey)
+	parts := strings.SplitN(raw, "|", 4)
+	if len(parts) != 4 || parts[0] != kind {
+		return "", "", errors.New("no pending ceremony")
+	}
+	if exp, _ := strconv.ParseInt(parts[1], 10, 64); time.Now().Unix() > exp {
+		return "", "", errors.New("ceremony expired")
+	}
+	return parts[2], parts[3], nil
+}
+
+type webauthnResponse struct {
+	ClientDataJSON    string `json:"clientDataJSON"`
+	AttestationObject string `json:"attestationObject"`
+	AuthenticatorData string `json:"authenticatorData"`
+	Signature         string `json:"signature"`
+	UserHandle        string `json:"userHandle"`
+}
+
+// webauthnCredentialPayload is the JSON form of a PublicKeyCredential
+// (binary fields base64url encoded), plus a name for new passkeys.
+type webauthnCredentialPayload struct {
+	ID       string           `json:"id"`
+	RawID    string           `json:"rawId"`
+	Type     string           `json:"type"`
+	Response webauthnResponse `json:"response"`
+	Name     string           `json:"name"`
+	Email    string           `json:"email"`
+}
+
+func decodeB64(s string) []byte {
+	b, err := webauthn.B64.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+// RegisterBegin returns creation options for a new passkey.
+// @router /api/v1/auth/webauthn/register/begin [post]
+func (c *WebAuthnController) RegisterBegin() {
+	u, ok := c.MustAuth()
+	if !ok {
+		return
+	}
+	handle, err := models.WebAuthnUserHandle(u.ID)
+	if err != nil {
+		c.JSONError(500, "could not start registration")
+		return
+	}
+	existing, _ := models.ListWebAuthnCredentials(u.ID)
+	exclude := make([]string, 0, len(existing))
+	for _, cr := range existing {
+		exclude = append(exclude, cr.ID)
+	}
+	challenge, ok := c.startCeremony("register", u.ID)
+	if !ok {
+		return
+	}
+	display := strings.TrimSpace(u.FirstName + " " + u.LastName)
+	if display == "" {
+		display = u.Email
+	}
+	opts := c.relyingParty().CreationOptions(challenge, webauthn.User{
+		ID: decodeB64(handle), Name: u.Email, DisplayName: display,
+	}, exclude)
+	c.JSONOK(map[string]any{"publicKey": opts})
+}
+
+// RegisterFinish verifies the attestation and stores the passkey.
+// @router /api/v1/auth/webauthn/register/finish [post]
+func (c *WebAuthnController) RegisterFinish() {
+	u, ok := c.MustAuth()
+	if !ok {
+		return
+	}
+	var p webauthnCredentialPayload
+	if err := c.ParseJSON(&p); err != nil {
+		c.JSONError(400, err.Error())
+		return
+	}
+	challenge, userID, err := c.takeCeremony("register")
+	if err != nil || userID != u.ID {
+		c.JSONError(400, "no pending registration")
+		return
+	}
+	cred, err := c.relyingParty().FinishRegistration(challenge,
+		decodeB64(p.Response.ClientDataJSON), decodeB64(p.Response.AttestationObject))
+	if err != nil {
+		c.JSONError(400, err.Error())
+		return
+	}
+	id := webauthn.B64.EncodeToString(cred.ID)
+	if existing, _ := models.GetWebAuthnCredential(id); existing != nil {
+		c.JSONError(409, "passkey already registered")
+		return
+	}
+	handle, _ := models.WebAuthnUserHandle(u.ID)
+	name := strings.TrimSpace(p.Name)
+	if name == "" {
+		name = "Passkey"
+	}
+	row := &models.WebAuthnCredential{
+		ID:             id,
+		UserID:         u.ID,
+		UserHandle:     handle,
+		PublicKey:      webauthn.B64.EncodeToString(cred.PublicKey),
+		SignCount:      int64(cred.SignCount),
+		AAGUID:         webauthn.B64.EncodeToString(cred.AAGUID),
+		Name:           name,
+		BackupEligible: cred.BackupEligible,
+	}
+	if err := models.CreateWebAuthnCredential(row); err != nil {
+		c.JSONError(500, "could not save passkey")
+		return
+	}
+	c.JSONOK(row)
+}
+
+// LoginBegin returns request options. With an email only that account's
+// passkeys are allowed; without one the browser offers discoverable passkeys.
+// @router /api/v1/auth/webauthn/login/begin [post]
+func (c *WebAuthnController) LoginBegin() {
+	var p webauthnCredentialPayload
+	_ = c.ParseJSON(&p)
+	email := normalizeEmail(p.Email)
+	var allow []string
+	if email != "" {
+		if u, _ := models.GetUserByEmail(email); u != nil {
+			creds, _ := models.ListWebAuthnCredentials(u.ID)
+			for _, cr := range creds {
+				allow = append(allow, cr.ID)
+			}
+		}
+	}
+	challenge, ok := c.startCeremony("login", email)
+	if !ok {
+		return
+	}
+	c.JSONOK(map[string]any{"publicKey": c.relyingParty().RequestOptions(challenge, allow)})
+}
+
+// LoginFinish verifies the assertion and signs the user in like Login. A
+// passkey with user verification counts as two factors; otherwise users
+// with TOTP enabled still get the mfa_required step.
+// @router /api/v1/auth/webauthn/login/finish [post]
+func (c *WebAuthnController) LoginFinish() {
+	var p webauthnCredentialPayload
+	if err := c.ParseJSON(&p); err != nil {
+		c.JSONError(400, err.Error())
+		return
+	}
+	challenge, email, err := c.takeCeremony("login")
+	if err != nil {
+		c.JSONError(400, "no pending login")
+		return
+	}
+	id := p.RawID
+	if id == "" {
+		id = p.ID
+	}
+	cred, _ := models.GetWebAuthnCredential(strings.TrimRight(id, "="))
+	if cred == nil ||
+		(p.Response.UserHandle != "" && strings.TrimRight(p.Response.UserHandle, "=") != cred.UserHandle) {
+		c.JSONError(401, "unknown passkey")
+		return
+	}
+	res, err := c.relyingParty().FinishLogin(challenge, decodeB64(cred.PublicKey), uint32(cred.SignCount),
+		decodeB64(p.Response.ClientDataJSON), decodeB64(p.Response.AuthenticatorData), decodeB64(p.Response.Signature))
+	if err != nil {
+		c.JSONError(401, err.Error())
+		return
+	}
+	_ = models.MarkWebAuthnCredentialUsed(cred.ID, int64(res.SignCount))
+
+	u, _ := models.GetUserByID(cred.UserID)
+	if u == nil || (email != "" && u.Email != email) {
+		c.JSONError(401, "unknown passkey")
+		return
+	}
+	var resp map[string]any
+	if res.UserVerified {
+		resp, err = c.issueTokens(u, nil, "hwk", "user", "mfa")
+	} else {
+		resp, err = c.completeLogin(u, "hwk")
+	}
+	if err != nil {
+		c.JSONError(500, "failed to generate token")
+		return
+	}
+	if resp["mfa_required"] != true {
+		addLoginUser(resp, u)
+	}
+	c.JSONOK(resp)
+}
+
+// Credentials lists the current user's passkeys.
+// @router /api/v1/auth/webauthn/credentials [get]
+func (c *WebAuthnController) Credentials() {
+	u, ok := c.MustAuth()
+	if !ok {
+		return
+	}
+	creds, err := models.ListWebAuthnCredentials(u.ID)
+	if err != nil {
+		c.JSONError(500, "failed to list passkeys")
+		return
+	}
+	if creds == nil {
+		creds = []models.WebAuthnCredential{}
+	}
+	c.JSONOK(creds)
+}
+
+// DeleteCredential removes one of the current user's passkeys.
+// @router /api/v1/auth/webauthn/credentials/:id [delete]
+func (c *WebAuthnController) DeleteCredential() {
+	u, ok := c.MustAuth()
+	if !ok {
+		return
+	}
+	err := models.DeleteWebAuthnCredential(u.ID, c.Ctx.Input.Param(":id"))
+	if errors.Is(err, models.ErrWebAuthnCredentialNotFound) {
+		c.JSONError(404, err.Error())
+		return
+	}
+	if err != nil {
+		c.JSONError(500, "failed to delete passkey")
+		return
+	}
+	c.JSONOK(map[string]any{"deleted": true})
+}
@@ -13,9 +13,16 @@ import (
 	_ "github.com/go-sql-driver/mysql"
 	_ "github.com/mattn/go-sqlite3"
 
+	"github.com/mymi14s/goconda/apps"
+	_ "github.com/mymi14s/goconda/apps/installed"
+	"github.com/mymi14s/goconda/controllers"
+	"github.com/mymi14s/goconda/middleware"
 	"github.com/mymi14s/goconda/models"
 	_ "github.com/mymi14s/goconda/routers"
 	"github.com/mymi14s/goconda/utils/hash"
+	jwtutil "github.com/mymi14s/goconda/utils/jwt"
+	"github.com/mymi14s/goconda/utils/mailer"
+	"github.com/mymi14s/goconda/utils/scheduler"
 )
 
 func mustLoadConfig() {
@@ -44,6 +51,8 @@ func setupSessionsAndStatic() {
 	web.SetStaticPath("/static", "static")
 }
 
+// bootstrapAdmin creates or promotes the [admin] account on start; the
+// createsuperuser command does the same on demand.
 func bootstrapAdmin() error {
 	// Read admin from config
 	adminEmail := web.AppConfig.DefaultString("admin::email", "")
@@ -51,48 +60,89 @@ func bootstrapAdmin() error {
 	if adminEmail == "" || adminPass == "" {
 		return nil
 	}
-	// Ensure Superuser role exists
-	_ = models.EnsureRole("Superuser")
-
 	// Create user if not exists
-	o := orm.NewOrm()
 	existing, _ := models.GetUserByEmail(adminEmail)
 	if existing == nil {
 		_hash, _ := hash.Make(adminPass)
-		u := &models.User{Email: adminEmail, FirstName: "Admin", LastName: "User", PasswordHash: _hash, IsSuperuser: true}
-		if _, err := o.Insert(u); err != nil {
+		existing = &models.User{Email: adminEmail, FirstName: "Admin", LastName: "User", PasswordHash: _hash}
+		if err := models.CreateUser(existing); err != nil {
 			return err
 		}
-	} else {
-		if !existing.IsSuperuser {
-			existing.IsSuperuser = true
-			_, _ = o.Update(existing, "IsSuperuser")
-		}
 	}
+	return promoteSuperuser(existing)
+}
 
-	// assign role
-	_ = models.AssignRole(adminEmail, "Superuser")
+// promoteSuperuser makes u a superuser with the Superuser role.
+func promoteSuperuser(u *models.User) error {
+	// Ensure Superuser role exists
+	_ = models.EnsureRole("Superuser")
+	if !u.IsSuperuser {
+		u.IsSuperuser = true
+		if _, err := orm.NewOrm().Update(u, "IsSuperuser"); err != nil {
+			return err
+		}
+	}
+	if err := models.AssignRole(u.ID, "Superuser"); err != nil {
+		return err
+	}
 
+	// optionally force the admin to enroll in two-factor authentication
+	if web.AppConfig.DefaultBool("mfa::require_superuser", false) {
+		if err := models.RequireMFAEnrollment(u.ID); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-func main() {
-	// Enable sessions
-	web.BConfig.WebConfig.Session.SessionOn = true
-	web.BConfig.WebConfig.Session.SessionName = "bffsid"
-	web.BConfig.WebConfig.Session.SessionCookieLifeTime = 86400 // 1 day
-	mustLoadConfig()
-	setupSessionsAndStatic()
+// scheduleAccountPurge registers the job that purges accounts whose
+// deletion grace period is over (account::purge_schedule, with seconds).
+func scheduleAccountPurge() {
+	spec := web.AppConfig.DefaultString("account::purge_schedule", "0 30 3 * * *")
+	_, err := scheduler.Register("purge-deleted-users", spec, func() {
+		n, err := models.PurgeDeletedUsers(controllers.AccountDeletionGrace())
+		if err != nil {
+			log.Printf("purge deleted users: %v", err)
+		}
+		if n > 0 {
+			log.Printf("purged %d deleted users", n)
+		}
+	})
+	if err != nil {
+		log.Printf("schedule account purge: %v", err)
+	}
+}
 
+// serve runs the web server; it is the default command.
+func serve(args []string) int {
+	if len(args) > 0 {
+		fmt.Fprintln(os.Stderr, "usage: goconda serve")
+		return 2
+	}
+	if err := jwtutil.Load(); err != nil {
+		log.Fatalf("JWT config: %v", err)
+	}
+	setupSessionsAndStatic()
 	if err := models.InitDB(); err != nil {
 		log.Fatalf("DB init failed: %v", err)
 	}
-	if err := orm.RunSyncdb("default", false, true); err != nil {
-		log.Fatalf("RunSyncdb error: %v", err)
+	if web.AppConfig.DefaultBool("db::migrate_on_start", false) {
+		if err := migrateUp(0); err != nil {
+			log.Fatalf("migrate: %v", err)
+		}
+	} else {
+		checkPendingMigrations()
+	}
+	if err := checkBaseURL(); err != nil {
+		log.Fatalf("mailer: %v", err)
 	}
 	if err := bootstrapAdmin(); err != nil {
 		log.Printf("bootstrap admin: %v", err)
 	}
+	scheduler.Start()
+	if err := registerJobs(); err != nil {
+		log.Fatalf("apps: %v", err)
+	}
 
 	port, _ := web.AppConfig.Int("httpport")
 	appname := web.AppConfig.DefaultString("appname", "goconda")
@@ -106,8 +156,35 @@ func main() {
 		MaxAge:           600,
 	}))
 
+	middleware.ReportUnprotectedRoutes("/api/v1")
+
 	web.BConfig.Listen.HTTPPort = port
 	web.SetStaticPath("/static", "static")
 
 	web.Run()
+	return 0
+}
+
+// checkBaseURL fails outside dev when neither the site settings nor
+// mailer::base_url give the public URL that emailed links point to.
+func checkBaseURL() error {
+	if web.BConfig.RunMode != "dev" && mailer.SiteBaseURL() == "" {
+		return fmt.Errorf("no base URL for emailed links: set mailer::base_url (MAILER_BASE_URL) or the site base_url")
+	}
+	return nil
+}
+
+// registerJobs adds the core and app jobs to the scheduler, seeding the
+// app permissions on the way.
+func registerJobs() error {
+	scheduleAccountPurge()
+	return apps.Start()
+}
+
+func main() {
+	// Enable sessions
+	web.BConfig.WebConfig.Session.SessionOn = true
+	web.BConfig.WebConfig.Session.SessionName = "bffsid"
+	web.BConfig.WebConfig.Session.SessionCookieLifeTime = 86400 // 1 day
+	os.Exit(run(os.Args[1:]))
 }
@@ -32,6 +32,7 @@ func SetupCookieAuthBridge() {
 }
 
 func Protect(path string) {
+	addRule(path, "*")
 	web.InsertFilter(path, web.BeforeRouter, func(ctx *context.Context) {
 		if !controllers.RequireAuth(ctx) { // return bool to indicate pass/fail
 			return // IMPORTANT: stop here; Output.JSON marks response as started
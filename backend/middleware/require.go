@@ -0,0 +1,132 @@
+package middleware
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/beego/beego/v2/server/web"
+	"github.com/beego/beego/v2/server/web/context"
+
+	"github.com/mymi14s/goconda/controllers"
+)
+
+// routeRule records what Protect, Require and Public declared, so the
+// startup report can tell which routes have no auth rule.
+type routeRule struct {
+	pattern string
+	methods map[string]bool // nil = every method
+	tree    *web.Tree
+}
+
+var (
+	rulesMu sync.Mutex
+	rules   []routeRule
+)
+
+func parseMethods(method string) map[string]bool {
+	if method == "" || method == "*" {
+		return nil
+	}
+	set := map[string]bool{}
+	for _, m := range strings.Split(method, ",") {
+		if m = strings.ToUpper(strings.TrimSpace(m)); m != "" {
+			set[m] = true
+		}
+	}
+	return set
+}
+
+func addRule(pattern, method string) {
+	t := web.NewTree()
+	t.AddRouter(pattern, true)
+	rulesMu.Lock()
+	defer rulesMu.Unlock()
+	rules = append(rules, routeRule{pattern: pattern, methods: parseMethods(method), tree: t})
+}
+
+// Require declares that requests matching pattern (a Beego filter pattern
+// such as "/api/v1/reports/*") and method ("GET", "POST,PUT" or "*") need
+// the RBAC permission resource/action. The check runs before the router, so
+// the controller is never reached without it: 401 without a valid token,
+// 403 without the permission.
+func Require(pattern, method, resource, action string) {
+	methods := parseMethods(method)
+	addRule(pattern, method)
+	web.InsertFilter(pattern, web.BeforeRouter, func(ctx *context.Context) {
+		if ctx.Input.IsOptions() {
+			return
+		}
+		if methods != nil && !methods[ctx.Input.Method()] {
+			return
+		}
+		controllers.RequireRoutePermission(ctx, resource, action)
+	})
+}
+
+// Public marks routes that are meant to be reachable without a token, so
+// the startup report does not flag them.
+func Public(patterns ...string) {
+	for _, p := range patterns {
+		addRule(p, "*")
+	}
+}
+
+// samplePath turns a route pattern into a URL it matches, e.g.
+// "/items/:id" -> "/items/1", so it can be tested against filter patterns.
+func samplePath(pattern string) string {
+	segs := strings.Split(pattern, "/")
+	for i, s := range segs {
+		if strings.HasPrefix(s, ":") || strings.HasPrefix(s, "*") {
+			segs[i] = "1"
+		}
+	}
+	return strings.Join(segs, "/")
+}
+
+// UnprotectedRoutes lists the registered routes under prefix, as
+// "METHOD /pattern", that no Protect, Require or Public rule covers.
+func UnprotectedRoutes(prefix string) []string {
+	data, _ := web.PrintTree()["Data"].(web.M)
+	rulesMu.Lock()
+	defer rulesMu.Unlock()
+	var out []string
+	for method, v := range data {
+		list, _ := v.(*[][]string)
+		if list == nil {
+			continue
+		}
+		for _, r := range *list {
+			pattern := r[0]
+			if !strings.HasPrefix(pattern, prefix) {
+				continue
+			}
+			path := samplePath(pattern)
+			covered := false
+			for _, rule := range rules {
+				if rule.methods != nil && !rule.methods[method] {
+					continue
+				}
+				if rule.tree.Match(path, context.NewContext()) != nil {
+					covered = true
+					break
+				}
+			}
+			if !covered {
+				out = append(out, fmt.Sprintf("%s %s", method, pattern))
+			}
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// ReportUnprotectedRoutes logs every route under prefix without an auth
+// rule. Call it once all routes are registered.
+func ReportUnprotectedRoutes(prefix string) {
+	for _, r := range UnprotectedRoutes(prefix) {
+		log.Printf("warn: route has no auth rule (Protect, Require or Public): %s", r)
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/mymi14s/goconda/apps"
+	"github.com/mymi14s/goconda/models"
+	"github.com/mymi14s/goconda/utils/migrate"
+)
+
+const migrateUsage = `usage: goconda migrate <command>
+
+  up [n]           apply all pending migrations, or the next n
+  down [n]         roll back the last applied migration, or the last n
+  status           list migrations and whether they are applied
+  new <app> <name> create empty up/down SQL files for an app ("core" for models)`
+
+// runMigrate runs `goconda migrate ...` and returns the exit code.
+func runMigrate(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+	n := 0
+	if (args[0] == "up" || args[0] == "down") && len(args) > 1 {
+		var err error
+		if n, err = strconv.Atoi(args[1]); err != nil || n < 1 {
+			fmt.Fprintf(os.Stderr, "migrate %s: invalid count %q\n", args[0], args[1])
+			return 2
+		}
+	}
+	if args[0] != "new" {
+		if err := models.InitDB(); err != nil {
+			fmt.Fprintf(os.Stderr, "DB init failed: %v\n", err)
+			return 1
+		}
+	}
+	var err error
+	switch args[0] {
+	case "up":
+		err = migrateUp(n)
+	case "down":
+		err = migrateDown(n)
+	case "status":
+		err = migrateStatus()
+	case "new":
+		if len(args) != 3 {
+			fmt.Fprintln(os.Stderr, migrateUsage)
+			return 2
+		}
+		err = migrateNew(args[1], args[2])
+	default:
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// migrateUp applies n pending migrations (all if n is 0).
+func migrateUp(n int) error {
+	m, err := apps.Migrator("default")
+	if err != nil {
+		return err
+	}
+	done, err := m.Up(n)
+	for _, mig := range done {
+		log.Printf("migrated %s", mig)
+	}
+	return err
+}
+
+func migrateDown(n int) error {
+	m, err := apps.Migrator("default")
+	if err != nil {
+		return err
+	}
+	done, err := m.Down(n)
+	for _, mig := range done {
+		log.Printf("rolled back %s", mig)
+	}
+	return err
+}
+
+func migrateStatus() error {
+	m, err := apps.Migrator("default")
+	if err != nil {
+		return err
+	}
+	status, err := m.Status()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tAPP\tNAME\tAPPLIED")
+	for _, s := range status {
+		applied := "pending"
+		switch {
+		case s.Unknown:
+			applied = s.AppliedAt.Format("2006-01-02 15:04:05") + " (unknown)"
+		case s.Applied:
+			applied = s.AppliedAt.Format("2006-01-02 15:04:05")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", s.Version, s.App, s.Name, applied)
+	}
+	return w.Flush()
+}
+
+// migrateNew scaffolds a migration in the app's migrations directory,
+// relative to the backend directory.
+func migrateNew(app, name string) error {
+	dir := filepath.Join("apps", app, "migrations")
+	if app == "core" {
+		dir = filepath.Join("models", "migrations")
+	} else if !slices.Contains(apps.Registered(), app) {
+		return fmt.Errorf("unknown app %q", app)
+	}
+	paths, err := migrate.Create(dir, name)
+	for _, p := range paths {
+		fmt.Println("created", p)
+	}
+	return err
+}
+
+// checkPendingMigrations warns when the schema is behind the code.
+func checkPendingMigrations() {
+	m, err := apps.Migrator("default")
+	if err != nil {
+		log.Fatalf("migrations: %v", err)
+	}
+	pending, err := m.Pending()
+	if err != nil {
+		log.Fatalf("migrations: %v", err)
+	}
+	if len(pending) > 0 {
+		log.Printf("warn: %d pending migrations, starting with %s; run `goconda migrate up`", len(pending), pending[0])
+	}
+}
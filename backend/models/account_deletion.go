@@ -0,0 +1,130 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/beego/beego/v2/client/orm"
+)
+
+// UserPurger removes or anonymizes an app's rows for a purged user, inside
+// the purge transaction.
+type UserPurger func(tx orm.TxOrmer, userID string) error
+
+var userPurgers []UserPurger
+
+// RegisterUserPurge adds an app's clean-up to PurgeUser.
+func RegisterUserPurge(fn UserPurger) {
+	userPurgers = append(userPurgers, fn)
+}
+
+// userOwnedTables hold rows that exist only for their user and are
+// deleted with it.
+var userOwnedTables = []string{
+	"refresh_token",
+	"user_session",
+	"user_mfa",
+	"mfa_recovery_code",
+	"user_identity",
+	"webauthn_credential",
+	"password_history",
+	"email_verification_token",
+	"verified_user",
+	"password_reset_token",
+	"magic_link_token",
+	"email_change_request",
+	"user_role",
+	"org_membership",
+	"personal_access_token",
+}
+
+// SoftDeleteUser marks the account deleted and signs it out everywhere. It
+// disappears from GetUserByID and GetUserByEmail at once; its data is kept
+// until PurgeDeletedUsers runs after the grace period.
+func SoftDeleteUser(userID string) error {
+	n, err := orm.NewOrm().QueryTable(new(User)).
+		Filter("ID", userID).
+		Filter("DeletedAt__isnull", true).
+		Update(orm.Params{"DeletedAt": time.Now()})
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return orm.ErrNoRows
+	}
+	_, err = RevokeOtherSessions(userID, "")
+	invalidatePermissions()
+	return err
+}
+
+// PurgeDeletedUsers purges every account deleted more than grace ago and
+// returns how many it purged.
+func PurgeDeletedUsers(grace time.Duration) (int, error) {
+	var ids orm.ParamsList
+	_, err := orm.NewOrm().QueryTable(new(User)).
+		Filter("DeletedAt__lte", time.Now().Add(-grace)).
+		Filter("PurgedAt__isnull", true).
+		ValuesFlat(&ids, "ID")
+	if err != nil {
+		return 0, err
+	}
+	for i, id := range ids {
+		if err := PurgeUser(fmt.Sprint(id)); err != nil {
+			return i, err
+		}
+	}
+	return len(ids), nil
+}
+
+// PurgeUser deletes the user's tokens, credentials, roles and memberships,
+// runs the registered app purgers and anonymizes the user row. The row
+// itself stays so that IDs recorded elsewhere (creators, inviters) remain
+// valid.
+func PurgeUser(userID string) error {
+	o := orm.NewOrm()
+	u := User{ID: userID}
+	if err := o.Read(&u); err != nil {
+		return err
+	}
+	now := time.Now()
+	err := o.DoTx(func(ctx context.Context, tx orm.TxOrmer) error {
+		for _, table := range userOwnedTables {
+			if _, err := tx.Raw("DELETE FROM `"+table+"` WHERE `user_id` = ?", userID).Exec(); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.QueryTable(new(LoginAttempt)).Filter("Email", u.Email).Delete(); err != nil {
+			return err
+		}
+		for _, fn := range userPurgers {
+			if err := fn(tx, userID); err != nil {
+				return err
+			}
+		}
+		_, err := tx.QueryTable(new(User)).Filter("ID", userID).Update(orm.Params{
+			"Email":        "deleted-" + userID + "@invalid",
+			"FirstName":    "",
+			"LastName":     "",
+			"Avatar":       "",
+			"Locale":       "",
+			"Timezone":     "",
+			"PasswordHash": "",
+			"IsSuperuser":  false,
+			"PurgedAt":     now,
+		})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	invalidatePermissions()
+	if u.Avatar != "" {
+		if err := os.Remove(u.Avatar); err != nil && !os.IsNotExist(err) {
+			log.Printf("purge user %s: remove avatar: %v", userID, err)
+		}
+	}
+	return nil
+}
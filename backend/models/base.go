@@ -15,7 +15,16 @@ type BaseModel struct{}
 
 // Generic: fill any struct from map using `json` tags (or field names, case-insensitive),
 // then insert with Beego ORM. Works for any model type.
-func (BaseModel) Create(dst any, data map[string]any) (int64, error) {
+func (b BaseModel) Create(dst any, data map[string]any) (int64, error) {
+	if err := b.Decode(dst, data); err != nil {
+		return 0, err
+	}
+	return b.Insert(orm.NewOrm(), dst)
+}
+
+// Decode fills dst from data like Create, leaving fields missing from data
+// untouched, so it also applies partial updates to a loaded model.
+func (BaseModel) Decode(dst any, data map[string]any) error {
 	dec, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
 		TagName:          "json", // uses your `json:"..."` tags
 		WeaklyTypedInput: true,   // "123" -> 123 etc.
@@ -26,16 +35,15 @@ func (BaseModel) Create(dst any, data map[string]any) (int64, error) {
 		},
 	})
 	if err != nil {
-		return 0, err
-	}
-	if err := dec.Decode(data); err != nil {
-		return 0, err
+		return err
 	}
+	return dec.Decode(data)
+}
 
+// Insert inserts dst through o, e.g. a ForOrg ormer.
+func (BaseModel) Insert(o orm.Ormer, dst any) (int64, error) {
 	// Optional: if model has a string `ID` field and it's empty, populate a UUID.
 	autoUUIDIfStringID(dst)
-
-	o := orm.NewOrm()
 	return o.Insert(dst)
 }
 
@@ -71,13 +79,30 @@ func InitDB() error {
 	orm.RegisterModel(
 		new(User),
 		new(RevokedToken),
+		new(RefreshToken),
+		new(UserSession),
+		new(UserMFA),
+		new(MFARecoveryCode),
+		new(ResourcePolicy),
+		new(LoginAttempt),
+		new(LoginLockout),
+		new(UserIdentity),
+		new(WebAuthnCredential),
+		new(PasswordHistory),
 		new(EmailVerificationToken),
 		new(VerifiedUser),
+		new(EmailChangeRequest),
 		new(Role),
 		new(UserRole),
 		new(Permission),
+		new(Organization),
+		new(OrgMembership),
+		new(OrgInvitation),
+		new(PersonalAccessToken),
 		new(PasswordResetToken),
+		new(MagicLinkToken),
 		new(ErrorLog),
+		new(AuditLog),
 	)
 	return nil
 }
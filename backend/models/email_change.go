@@ -0,0 +1,171 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/beego/beego/v2/client/orm"
+)
+
+var (
+	ErrInvalidEmailChange = errors.New("invalid or expired link")
+	ErrEmailTaken         = errors.New("email already in use")
+)
+
+// EmailChangeRequest is a pending switch of a user's address. The new
+// address confirms it; the old one can revert it until RevertUntil, which
+// runs past the confirmation by the rollback window. Only token hashes are
+// stored.
+type EmailChangeRequest struct {
+	ID              int64      `orm:"auto;pk;column(id)" json:"id"`
+	UserID          string     `orm:"size(36);column(user_id);index" json:"user_id"`
+	OldEmail        string     `orm:"size(191)" json:"old_email"`
+	NewEmail        string     `orm:"size(191);index" json:"new_email"`
+	TokenHash       string     `orm:"size(64);unique" json:"-"`
+	RevertTokenHash string     `orm:"size(64);unique" json:"-"`
+	ExpiresAt       time.Time  `orm:"type(datetime)" json:"expires_at"`
+	RevertUntil     time.Time  `orm:"type(datetime)" json:"revert_until"`
+	ConfirmedAt     *time.Time `orm:"null;type(datetime)" json:"confirmed_at"`
+	RevertedAt      *time.Time `orm:"null;type(datetime)" json:"reverted_at"`
+	CreatedAt       time.Time  `orm:"auto_now_add;type(datetime)" json:"created_at"`
+}
+
+func (r *EmailChangeRequest) TableName() string { return "email_change_request" }
+
+// CreateEmailChange records u's request to move to newEmail, replacing any
+// earlier unconfirmed one, and returns it with the raw confirm and revert
+// tokens to mail to the new and old addresses.
+func CreateEmailChange(u *User, newEmail string, ttl time.Duration) (*EmailChangeRequest, string, string, error) {
+	confirm, err := randomToken(32)
+	if err != nil {
+		return nil, "", "", err
+	}
+	revert, err := randomToken(32)
+	if err != nil {
+		return nil, "", "", err
+	}
+	expires := time.Now().Add(ttl)
+	r := &EmailChangeRequest{
+		UserID:          u.ID,
+		OldEmail:        u.Email,
+		NewEmail:        newEmail,
+		TokenHash:       hashToken(confirm),
+		RevertTokenHash: hashToken(revert),
+		ExpiresAt:       expires,
+		RevertUntil:     expires,
+	}
+	err = orm.NewOrm().DoTx(func(ctx context.Context, tx orm.TxOrmer) error {
+		if _, err := tx.QueryTable(new(EmailChangeRequest)).
+			Filter("UserID", u.ID).
+			Filter("ConfirmedAt__isnull", true).
+			Filter("RevertedAt__isnull", true).
+			Delete(); err != nil {
+			return err
+		}
+		_, err := tx.Insert(r)
+		return err
+	})
+	if err != nil {
+		return nil, "", "", err
+	}
+	return r, confirm, revert, nil
+}
+
+// ConfirmEmailChange applies the request behind the raw confirm token: the
+// user's address becomes the new one, already verified, and the old address
+// can revert the change for window.
+func ConfirmEmailChange(raw string, window time.Duration) (*EmailChangeRequest, error) {
+	o := orm.NewOrm()
+	var r EmailChangeRequest
+	if err := o.QueryTable(new(EmailChangeRequest)).Filter("TokenHash", hashToken(raw)).One(&r); err != nil {
+		return nil, ErrInvalidEmailChange
+	}
+	if r.ConfirmedAt != nil || r.RevertedAt != nil || time.Now().After(r.ExpiresAt) {
+		return nil, ErrInvalidEmailChange
+	}
+	if o.QueryTable(new(User)).Filter("Email", r.NewEmail).Exclude("ID", r.UserID).Exist() {
+		return nil, ErrEmailTaken
+	}
+	now := time.Now()
+	err := o.DoTx(func(ctx context.Context, tx orm.TxOrmer) error {
+		// a concurrent confirmation or revert must not win as well
+		n, err := tx.QueryTable(new(EmailChangeRequest)).
+			Filter("ID", r.ID).
+			Filter("ConfirmedAt__isnull", true).
+			Filter("RevertedAt__isnull", true).
+			Update(orm.Params{"ConfirmedAt": now, "RevertUntil": now.Add(window)})
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return ErrInvalidEmailChange
+		}
+		return switchUserEmail(tx, r.UserID, r.OldEmail, r.NewEmail, now)
+	})
+	if err != nil {
+		return nil, err
+	}
+	r.ConfirmedAt = &now
+	r.RevertUntil = now.Add(window)
+	return &r, nil
+}
+
+// RevertEmailChange undoes the request behind the raw revert token. Before
+// confirmation it just cancels the request; afterwards it restores the old
+// address, which the click proves the user still controls.
+func RevertEmailChange(raw string) (*EmailChangeRequest, error) {
+	o := orm.NewOrm()
+	var r EmailChangeRequest
+	if err := o.QueryTable(new(EmailChangeRequest)).Filter("RevertTokenHash", hashToken(raw)).One(&r); err != nil {
+		return nil, ErrInvalidEmailChange
+	}
+	if r.RevertedAt != nil || time.Now().After(r.RevertUntil) {
+		return nil, ErrInvalidEmailChange
+	}
+	if r.ConfirmedAt != nil && o.QueryTable(new(User)).Filter("Email", r.OldEmail).Exclude("ID", r.UserID).Exist() {
+		return nil, ErrEmailTaken
+	}
+	now := time.Now()
+	err := o.DoTx(func(ctx context.Context, tx orm.TxOrmer) error {
+		n, err := tx.QueryTable(new(EmailChangeRequest)).
+			Filter("ID", r.ID).
+			Filter("RevertedAt__isnull", true).
+			Update(orm.Params{"RevertedAt": now})
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return ErrInvalidEmailChange
+		}
+		if r.ConfirmedAt == nil {
+			return nil
+		}
+		return switchUserEmail(tx, r.UserID, r.NewEmail, r.OldEmail, now)
+	})
+	if err != nil {
+		return nil, err
+	}
+	r.RevertedAt = &now
+	return &r, nil
+}
+
+// switchUserEmail moves the user from address from to to and records to as
+// verified. It fails if the address changed in the meantime.
+func switchUserEmail(tx orm.TxOrmer, userID, from, to string, at time.Time) error {
+	n, err := tx.QueryTable(new(User)).
+		Filter("ID", userID).
+		Filter("Email", from).
+		Update(orm.Params{"Email": to, "UpdatedAt": at})
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrInvalidEmailChange
+	}
+	if _, err := tx.QueryTable(new(VerifiedUser)).Filter("UserID", userID).Delete(); err != nil {
+		return err
+	}
+	_, err = tx.Insert(&VerifiedUser{UserID: userID, Email: to, VerifiedAt: at})
+	return err
+}
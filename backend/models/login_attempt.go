@@ -0,0 +1,126 @@
+package models
+
+import (
+	"time"
+
+	"github.com/beego/beego/v2/client/orm"
+
+	"github.com/mymi14s/goconda/utils/ratelimit"
+)
+
+// LoginAttempt is an audit record of one login attempt.
+type LoginAttempt struct {
+	ID        int64     `orm:"auto;column(id)" json:"id"`
+	Email     string    `orm:"size(191);index" json:"email"`
+	IP        string    `orm:"size(64);column(ip);index" json:"ip"`
+	UserAgent string    `orm:"size(512)" json:"user_agent"`
+	Success   bool      `orm:"default(false)" json:"success"`
+	CreatedAt time.Time `orm:"auto_now_add;type(datetime);index" json:"created_at"`
+}
+
+func (a *LoginAttempt) TableName() string { return "login_attempt" }
+
+// LoginLockout backs LockoutStore, the database rate limiter store, so that
+// failure counts are shared between instances. Key is the limiter key, e.g.
+// "login:email:x@y.z".
+type LoginLockout struct {
+	Key           string    `orm:"size(191);pk" json:"key"`
+	Failures      int       `orm:"default(0)" json:"failures"`
+	LastFailureAt time.Time `orm:"type(datetime)" json:"last_failure_at"`
+	LockedUntil   time.Time `orm:"null;type(datetime);index" json:"locked_until"`
+}
+
+func (l *LoginLockout) TableName() string { return "login_lockout" }
+
+func RecordLoginAttempt(email, ip, userAgent string, success bool) error {
+	if len(userAgent) > 512 {
+		userAgent = userAgent[:512]
+	}
+	_, err := orm.NewOrm().Insert(&LoginAttempt{Email: email, IP: ip, UserAgent: userAgent, Success: success})
+	return err
+}
+
+// RecentLoginAttempts returns the latest attempts for email, newest first.
+func RecentLoginAttempts(email string, limit int) ([]LoginAttempt, error) {
+	var out []LoginAttempt
+	_, err := orm.NewOrm().QueryTable(new(LoginAttempt)).
+		Filter("Email", email).OrderBy("-ID").Limit(limit).All(&out)
+	return out, err
+}
+
+// GetLoginLockout returns the record for key, or (nil, nil).
+func GetLoginLockout(key string) (*LoginLockout, error) {
+	l := LoginLockout{Key: key}
+	if err := orm.NewOrm().Read(&l); err != nil {
+		if err == orm.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &l, nil
+}
+
+func SaveLoginLockout(l *LoginLockout) error {
+	o := orm.NewOrm()
+	if n, err := o.Update(l); err != nil || n > 0 {
+		return err
+	}
+	_, err := o.Insert(l)
+	return err
+}
+
+func DeleteLoginLockout(key string) error {
+	_, err := orm.NewOrm().QueryTable(new(LoginLockout)).Filter("Key", key).Delete()
+	return err
+}
+
+// ListLoginLockouts returns the keys locked at now.
+func ListLoginLockouts(now time.Time) ([]LoginLockout, error) {
+	var out []LoginLockout
+	_, err := orm.NewOrm().QueryTable(new(LoginLockout)).
+		Filter("LockedUntil__gt", now).OrderBy("-LockedUntil").All(&out)
+	return out, err
+}
+
+// LockoutStore is the ratelimit.Store over the login_lockout table, for
+// limiters whose counts every instance should see.
+type LockoutStore struct{}
+
+var _ ratelimit.Store = LockoutStore{}
+
+func (LockoutStore) Get(key string) (ratelimit.State, error) {
+	l, err := GetLoginLockout(key)
+	if err != nil || l == nil {
+		return ratelimit.State{Key: key}, err
+	}
+	return l.state(), nil
+}
+
+func (LockoutStore) Put(s ratelimit.State) error {
+	return SaveLoginLockout(&LoginLockout{
+		Key:           s.Key,
+		Failures:      s.Failures,
+		LastFailureAt: s.LastFailure,
+		LockedUntil:   s.LockedUntil,
+	})
+}
+
+func (LockoutStore) Delete(key string) error {
+	return DeleteLoginLockout(key)
+}
+
+func (LockoutStore) Locked(now time.Time) ([]ratelimit.State, error) {
+	rows, err := ListLoginLockouts(now)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]ratelimit.State, 0, len(rows))
+	for _, l := range rows {
+		out = append(out, l.state())
+	}
+	return out, nil
+}
+
+func (l LoginLockout) state() ratelimit.State {
+	return ratelimit.State{Key: l.Key, Failures: l.Failures, LastFailure: l.LastFailureAt, LockedUntil: l.LockedUntil}
+}
@@ -0,0 +1,78 @@
+package models
+
+import (
+	"crypto/subtle"
+	"errors"
+	"time"
+
+	"github.com/beego/beego/v2/client/orm"
+)
+
+// MagicLinkToken is a single-use passwordless login token. NonceHash binds
+// it to the browser that asked for it: the raw nonce lives only in that
+// browser's cookie.
+type MagicLinkToken struct {
+	Token     string     `orm:"size(64);pk" json:"token"`
+	UserID    string     `orm:"size(36);column(user_id);index" json:"user_id"`
+	NonceHash string     `orm:"size(64)" json:"-"`
+	ExpiresAt time.Time  `orm:"type(datetime)" json:"expires_at"`
+	UsedAt    *time.Time `orm:"null;type(datetime)" json:"used_at"`
+	CreatedAt time.Time  `orm:"auto_now_add;type(datetime)" json:"created_at"`
+}
+
+func (t *MagicLinkToken) TableName() string { return "magic_link_token" }
+
+var errInvalidMagicLink = errors.New("invalid or expired link")
+
+// CreateMagicLinkToken stores a new token for userID bound to nonce, the
+// browser's existing nonce or "" for a new one, and returns it with the
+// nonce to set as a cookie.
+func CreateMagicLinkToken(userID string, ttl time.Duration, nonce string) (*MagicLinkToken, string, error) {
+	tok, err := randomToken(16)
+	if err != nil {
+		return nil, "", err
+	}
+	if nonce == "" {
+		if nonce, err = randomToken(16); err != nil {
+			return nil, "", err
+		}
+	}
+	t := &MagicLinkToken{
+		Token:     tok,
+		UserID:    userID,
+		NonceHash: hashToken(nonce),
+		ExpiresAt: time.Now().Add(ttl),
+	}
+	if _, err := orm.NewOrm().Insert(t); err != nil {
+		return nil, "", err
+	}
+	return t, nonce, nil
+}
+
+// ConsumeMagicLinkToken redeems token if nonce matches the requesting
+// browser and returns the userID it was issued for.
+func ConsumeMagicLinkToken(token, nonce string) (string, error) {
+	o := orm.NewOrm()
+	t := MagicLinkToken{Token: token}
+	if err := o.Read(&t); err != nil {
+		return "", errInvalidMagicLink
+	}
+	if t.UsedAt != nil || time.Now().After(t.ExpiresAt) {
+		return "", errInvalidMagicLink
+	}
+	if subtle.ConstantTimeCompare([]byte(hashToken(nonce)), []byte(t.NonceHash)) != 1 {
+		return "", errors.New("open the link in the browser you requested it from")
+	}
+	// a concurrent redemption must not win twice
+	n, err := o.QueryTable(new(MagicLinkToken)).
+		Filter("Token", token).
+		Filter("UsedAt__isnull", true).
+		Update(orm.Params{"UsedAt": time.Now()})
+	if err != nil {
+		return "", err
+	}
+	if n == 0 {
+		return "", errInvalidMagicLink
+	}
+	return t.UserID, nil
+}
@@ -0,0 +1,240 @@
+package models
+
+import (
+	"crypto/rand"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/beego/beego/v2/client/orm"
+)
+
+const recoveryCodeCount = 10
+
+var ErrMFAAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+
+// UserMFA holds a user's TOTP secret. The secret is pending until the first
+// code is verified. Required forces the user to enroll before using the API.
+type UserMFA struct {
+	UserID         string     `orm:"size(36);pk;column(user_id)" json:"user_id"`
+	Secret         string     `orm:"size(64)" json:"-"`
+	Enabled        bool       `orm:"default(false)" json:"enabled"`
+	Required       bool       `orm:"default(false)" json:"required"`
+	LastUsedStep   int64      `orm:"default(0)" json:"-"`
+	FailedAttempts int        `orm:"default(0)" json:"-"`
+	EnabledAt      *time.Time `orm:"null;type(datetime)" json:"enabled_at"`
+	UpdatedAt      time.Time  `orm:"auto_now;type(datetime)" json:"updated_at"`
+}
+
+func (m *UserMFA) TableName() string { return "user_mfa" }
+
+// MFARecoveryCode is a single-use fallback code; only its hash is stored.
+type MFARecoveryCode struct {
+	ID        int64      `orm:"auto;column(id)" json:"id"`
+	UserID    string     `orm:"size(36);column(user_id);index" json:"user_id"`
+	CodeHash  string     `orm:"size(64)" json:"-"`
+	UsedAt    *time.Time `orm:"null;type(datetime)" json:"used_at"`
+	CreatedAt time.Time  `orm:"auto_now_add;type(datetime)" json:"created_at"`
+}
+
+func (r *MFARecoveryCode) TableName() string { return "mfa_recovery_code" }
+
+// ResourcePolicy holds per-resource RBAC settings, such as requiring a
+// token obtained with a second factor.
+type ResourcePolicy struct {
+	Resource   string `orm:"size(191);pk" json:"resource"`
+	RequireMFA bool   `orm:"default(false);column(require_mfa)" json:"require_mfa"`
+}
+
+func (p *ResourcePolicy) TableName() string { return "resource_policy" }
+
+func GetUserMFA(userID string) (*UserMFA, error) {
+	m := UserMFA{UserID: userID}
+	if err := orm.NewOrm().Read(&m); err != nil {
+		if err == orm.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &m, nil
+}
+
+// MFAEnrollmentPending reports whether the user must enroll before using the API.
+func MFAEnrollmentPending(userID string) bool {
+	m, _ := GetUserMFA(userID)
+	return m != nil && m.Required && !m.Enabled
+}
+
+// readOrInsertMFA loads the user's row, inserting an empty one if needed.
+// (orm.ReadOrCreate only supports integer primary keys.)
+func readOrInsertMFA(o orm.Ormer, userID string) (*UserMFA, error) {
+	m := UserMFA{UserID: userID}
+	err := o.Read(&m)
+	if err == orm.ErrNoRows {
+		_, err = o.Insert(&m)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// StartMFAEnrollment stores a new pending secret, replacing any earlier
+// unconfirmed one.
+func StartMFAEnrollment(userID, secret string) error {
+	o := orm.NewOrm()
+	m, err := readOrInsertMFA(o, userID)
+	if err != nil {
+		return err
+	}
+	if m.Enabled {
+		return ErrMFAAlreadyEnabled
+	}
+	m.Secret = secret
+	m.LastUsedStep = 0
+	_, err = o.Update(m, "Secret", "LastUsedStep")
+	return err
+}
+
+// EnableMFA activates the pending secret and returns fresh recovery codes.
+func EnableMFA(userID string) ([]string, error) {
+	now := time.Now()
+	n, err := orm.NewOrm().QueryTable(new(UserMFA)).Filter("UserID", userID).
+		Update(orm.Params{"Enabled": true, "EnabledAt": now, "FailedAttempts": 0})
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, errors.New("no pending enrollment")
+	}
+	return RegenerateRecoveryCodes(userID)
+}
+
+// DisableMFA removes the secret and recovery codes. The Required flag is
+// dropped too; callers must refuse this for users who are required to use MFA.
+func DisableMFA(userID string) error {
+	o := orm.NewOrm()
+	if _, err := o.QueryTable(new(MFARecoveryCode)).Filter("UserID", userID).Delete(); err != nil {
+		return err
+	}
+	_, err := o.QueryTable(new(UserMFA)).Filter("UserID", userID).Delete()
+	return err
+}
+
+// RequireMFAEnrollment forces the user to enroll before using the API.
+func RequireMFAEnrollment(userID string) error {
+	o := orm.NewOrm()
+	m, err := readOrInsertMFA(o, userID)
+	if err != nil {
+		return err
+	}
+	if m.Required {
+		return nil
+	}
+	m.Required = true
+	_, err = o.Update(m, "Required")
+	return err
+}
+
+// MarkMFAStep records the TOTP step that was just accepted. It returns false
+// if that step (or a later one) was already used, preventing code replay.
+func MarkMFAStep(userID string, step int64) (bool, error) {
+	n, err := orm.NewOrm().QueryTable(new(UserMFA)).
+		Filter("UserID", userID).
+		Filter("LastUsedStep__lt", step).
+		Update(orm.Params{"LastUsedStep": step, "FailedAttempts": 0})
+	return n == 1, err
+}
+
+// RecordMFAFailure counts a failed code and returns the consecutive failures.
+func RecordMFAFailure(userID string) (int, error) {
+	o := orm.NewOrm()
+	if _, err := o.QueryTable(new(UserMFA)).Filter("UserID", userID).
+		Update(orm.Params{"FailedAttempts": orm.ColValue(orm.ColAdd, 1)}); err != nil {
+		return 0, err
+	}
+	m, err := GetUserMFA(userID)
+	if err != nil || m == nil {
+		return 0, err
+	}
+	return m.FailedAttempts, nil
+}
+
+// ResetMFAFailures clears the consecutive failure counter.
+func ResetMFAFailures(userID string) error {
+	_, err := orm.NewOrm().QueryTable(new(UserMFA)).Filter("UserID", userID).
+		Update(orm.Params{"FailedAttempts": 0})
+	return err
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+func newRecoveryCode() (string, error) {
+	// 32 symbols without look-alikes (l, o, 0, 1), so b&31 is unbiased
+	const alphabet = "abcdefghijkmnpqrstuvwxyz23456789"
+	b := make([]byte, 10)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	for i := range b {
+		b[i] = alphabet[b[i]&31]
+	}
+	return string(b[:5]) + "-" + string(b[5:]), nil
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes. The plain
+// codes are returned once.
+func RegenerateRecoveryCodes(userID string) ([]string, error) {
+	o := orm.NewOrm()
+	if _, err := o.QueryTable(new(MFARecoveryCode)).Filter("UserID", userID).Delete(); err != nil {
+		return nil, err
+	}
+	codes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		code, err := newRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := o.Insert(&MFARecoveryCode{UserID: userID, CodeHash: hashToken(normalizeRecoveryCode(code))}); err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+// UseRecoveryCode consumes a matching unused recovery code.
+func UseRecoveryCode(userID, code string) (bool, error) {
+	n, err := orm.NewOrm().QueryTable(new(MFARecoveryCode)).
+		Filter("UserID", userID).
+		Filter("CodeHash", hashToken(normalizeRecoveryCode(code))).
+		Filter("UsedAt__isnull", true).
+		Update(orm.Params{"UsedAt": time.Now()})
+	return n == 1, err
+}
+
+// SetResourceMFA marks whether permission checks on resource require a
+// token obtained with a second factor.
+func SetResourceMFA(resource string, required bool) error {
+	o := orm.NewOrm()
+	p := ResourcePolicy{Resource: resource, RequireMFA: required}
+	if _, err := o.Insert(&p); err != nil {
+		_, err = o.Update(&p, "RequireMFA")
+		return err
+	}
+	return nil
+}
+
+func ResourceRequiresMFA(resource string) (bool, error) {
+	p := ResourcePolicy{Resource: resource}
+	if err := orm.NewOrm().Read(&p); err != nil {
+		if err == orm.ErrNoRows {
+			return false, nil
+		}
+		return false, err
+	}
+	return p.RequireMFA, nil
+}
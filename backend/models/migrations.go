@@ -0,0 +1,55 @@
+package models
+
+import (
+	"embed"
+	"fmt"
+
+	"github.com/mymi14s/goconda/utils/migrate"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// Migrations are the schema migrations of the core tables.
+var Migrations = append(migrate.MustLoad("core", migrationFiles, "migrations"),
+	// A database that still keys users by email has its user tables renamed
+	// before the baselines and copied back once every app's tables exist.
+	// Neither step has anything to undo.
+	migrate.Migration{
+		Version: "20261018085900",
+		Name:    "user_ids_prepare",
+		App:     "core",
+		Up:      userIDsPrepareUp,
+		Down:    noop,
+	},
+	migrate.Migration{
+		Version: "20261018090100",
+		Name:    "user_role_unique",
+		App:     "core",
+		Up:      userRoleUniqueUp,
+		Down: migrate.DialectSQL(map[string]string{
+			migrate.SQLite: "DROP INDEX IF EXISTS `idx_user_role_unique`;",
+			migrate.MySQL:  "DROP INDEX `idx_user_role_unique` ON `user_role`;",
+		}),
+	},
+	migrate.Migration{
+		Version: "20261018090900",
+		Name:    "user_ids",
+		App:     "core",
+		Up:      userIDsUp,
+		Down:    noop,
+	},
+)
+
+func noop(migrate.DB) error { return nil }
+
+// userRoleUniqueUp removes duplicate role assignments left by older
+// versions, then makes (user_id, role) unique.
+func userRoleUniqueUp(db migrate.DB) error {
+	// the derived table lets MySQL read the table it deletes from
+	if _, err := db.Raw(`DELETE FROM user_role WHERE id NOT IN (
+		SELECT id FROM (SELECT MIN(id) AS id FROM user_role GROUP BY user_id, role) AS keep_rows)`).Exec(); err != nil {
+		return fmt.Errorf("remove duplicate user roles: %w", err)
+	}
+	return db.Exec("CREATE UNIQUE INDEX `idx_user_role_unique` ON `user_role` (`user_id`, `role`);")
+}
@@ -0,0 +1,27 @@
+DROP TABLE IF EXISTS `audit_log`;
+DROP TABLE IF EXISTS `error_log`;
+DROP TABLE IF EXISTS `magic_link_token`;
+DROP TABLE IF EXISTS `password_reset_token`;
+DROP TABLE IF EXISTS `personal_access_token`;
+DROP TABLE IF EXISTS `org_invitation`;
+DROP TABLE IF EXISTS `org_membership`;
+DROP TABLE IF EXISTS `organization`;
+DROP TABLE IF EXISTS `permission`;
+DROP TABLE IF EXISTS `user_role`;
+DROP TABLE IF EXISTS `role`;
+DROP TABLE IF EXISTS `email_change_request`;
+DROP TABLE IF EXISTS `verified_user`;
+DROP TABLE IF EXISTS `email_verification_token`;
+DROP TABLE IF EXISTS `password_history`;
+DROP TABLE IF EXISTS `webauthn_credential`;
+DROP TABLE IF EXISTS `user_identity`;
+DROP TABLE IF EXISTS `login_lockout`;
+DROP TABLE IF EXISTS `login_attempt`;
+DROP TABLE IF EXISTS `resource_policy`;
+DROP TABLE IF EXISTS `mfa_recovery_code`;
+DROP TABLE IF EXISTS `user_mfa`;
+DROP TABLE IF EXISTS `user_session`;
+DROP TABLE IF EXISTS `refresh_token`;
+DROP TABLE IF EXISTS `revoked_token`;
+DROP TABLE IF EXISTS `user`;
+DROP TABLE IF EXISTS `site_setting`;
@@ -0,0 +1,316 @@
+-- Schema of the core tables as created by orm.RunSyncdb before
+-- migrations; existing tables and indexes are left alone.
+
+-- SiteSetting
+CREATE TABLE IF NOT EXISTS `site_setting` (
+    `id` integer NOT NULL PRIMARY KEY,
+    `title` varchar(128) NOT NULL DEFAULT '',
+    `site_name` varchar(128) NOT NULL DEFAULT '',
+    `base_url` varchar(255) NOT NULL DEFAULT '',
+    `email` varchar(128) NOT NULL DEFAULT '',
+    `tagline` varchar(256),
+    `header` varchar(5000),
+    `updated_at` datetime NOT NULL,
+    `version` integer NOT NULL DEFAULT 0,
+    `sentinel` varchar(16) NOT NULL DEFAULT '' UNIQUE
+);
+
+-- User
+CREATE TABLE IF NOT EXISTS `user` (
+    `id` varchar(36) NOT NULL PRIMARY KEY,
+    `email` varchar(191) NOT NULL DEFAULT '' UNIQUE,
+    `first_name` varchar(100) NOT NULL DEFAULT '',
+    `last_name` varchar(100) NOT NULL DEFAULT '',
+    `avatar` varchar(255) NOT NULL DEFAULT '',
+    `locale` varchar(35) NOT NULL DEFAULT '',
+    `timezone` varchar(64) NOT NULL DEFAULT '',
+    `password_hash` varchar(255) NOT NULL DEFAULT '',
+    `created_at` datetime NOT NULL,
+    `updated_at` datetime NOT NULL,
+    `is_superuser` bool NOT NULL DEFAULT false,
+    `disabled_at` datetime,
+    `password_reset_required` bool NOT NULL DEFAULT false,
+    `deleted_at` datetime,
+    `purged_at` datetime
+);
+CREATE INDEX `user_deleted_at` ON `user` (`deleted_at`);
+
+-- RevokedToken
+CREATE TABLE IF NOT EXISTS `revoked_token` (
+    `jti` varchar(191) NOT NULL PRIMARY KEY,
+    `expires_at` datetime NOT NULL,
+    `created_at` datetime NOT NULL
+);
+CREATE INDEX `revoked_token_expires_at` ON `revoked_token` (`expires_at`);
+
+-- RefreshToken
+CREATE TABLE IF NOT EXISTS `refresh_token` (
+    `token_hash` varchar(64) NOT NULL PRIMARY KEY,
+    `family` varchar(64) NOT NULL DEFAULT '',
+    `user_id` varchar(36) NOT NULL DEFAULT '',
+    `access_jti` varchar(191) NOT NULL DEFAULT '',
+    `access_expires_at` datetime NOT NULL,
+    `amr` varchar(100) NOT NULL DEFAULT '',
+    `org` integer NOT NULL DEFAULT 0,
+    `expires_at` datetime NOT NULL,
+    `used_at` datetime,
+    `revoked_at` datetime,
+    `created_at` datetime NOT NULL
+);
+CREATE INDEX `refresh_token_family` ON `refresh_token` (`family`);
+CREATE INDEX `refresh_token_user_id` ON `refresh_token` (`user_id`);
+
+-- UserSession
+CREATE TABLE IF NOT EXISTS `user_session` (
+    `jti` varchar(191) NOT NULL PRIMARY KEY,
+    `user_id` varchar(36) NOT NULL DEFAULT '',
+    `user_agent` varchar(512) NOT NULL DEFAULT '',
+    `ip` varchar(64) NOT NULL DEFAULT '',
+    `issued_at` datetime NOT NULL,
+    `last_seen_at` datetime NOT NULL,
+    `expires_at` datetime NOT NULL,
+    `revoked_at` datetime
+);
+CREATE INDEX `user_session_user_id` ON `user_session` (`user_id`);
+CREATE INDEX `user_session_expires_at` ON `user_session` (`expires_at`);
+
+-- UserMFA
+CREATE TABLE IF NOT EXISTS `user_mfa` (
+    `user_id` varchar(36) NOT NULL PRIMARY KEY,
+    `secret` varchar(64) NOT NULL DEFAULT '',
+    `enabled` bool NOT NULL DEFAULT false,
+    `required` bool NOT NULL DEFAULT false,
+    `last_used_step` integer NOT NULL DEFAULT 0,
+    `failed_attempts` integer NOT NULL DEFAULT 0,
+    `enabled_at` datetime,
+    `updated_at` datetime NOT NULL
+);
+
+-- MFARecoveryCode
+CREATE TABLE IF NOT EXISTS `mfa_recovery_code` (
+    `id` integer NOT NULL PRIMARY KEY AUTOINCREMENT,
+    `user_id` varchar(36) NOT NULL DEFAULT '',
+    `code_hash` varchar(64) NOT NULL DEFAULT '',
+    `used_at` datetime,
+    `created_at` datetime NOT NULL
+);
+CREATE INDEX `mfa_recovery_code_user_id` ON `mfa_recovery_code` (`user_id`);
+
+-- ResourcePolicy
+CREATE TABLE IF NOT EXISTS `resource_policy` (
+    `resource` varchar(191) NOT NULL PRIMARY KEY,
+    `require_mfa` bool NOT NULL DEFAULT false 
+);
+
+-- LoginAttempt
+CREATE TABLE IF NOT EXISTS `login_attempt` (
+    `id` integer NOT NULL PRIMARY KEY AUTOINCREMENT,
+    `email` varchar(191) NOT NULL DEFAULT '',
+    `ip` varchar(64) NOT NULL DEFAULT '',
+    `user_agent` varchar(512) NOT NULL DEFAULT '',
+    `success` bool NOT NULL DEFAULT false,
+    `created_at` datetime NOT NULL
+);
+CREATE INDEX `login_attempt_email` ON `login_attempt` (`email`);
+CREATE INDEX `login_attempt_ip` ON `login_attempt` (`ip`);
+CREATE INDEX `login_attempt_created_at` ON `login_attempt` (`created_at`);
+
+-- LoginLockout
+CREATE TABLE IF NOT EXISTS `login_lockout` (
+    `key` varchar(191) NOT NULL PRIMARY KEY,
+    `failures` integer NOT NULL DEFAULT 0,
+    `last_failure_at` datetime NOT NULL,
+    `locked_until` datetime
+);
+CREATE INDEX `login_lockout_locked_until` ON `login_lockout` (`locked_until`);
+
+-- UserIdentity
+CREATE TABLE IF NOT EXISTS `user_identity` (
+    `id` integer NOT NULL PRIMARY KEY AUTOINCREMENT,
+    `provider` varchar(64) NOT NULL DEFAULT '',
+    `subject` varchar(191) NOT NULL DEFAULT '',
+    `user_id` varchar(36) NOT NULL DEFAULT '',
+    `created_at` datetime NOT NULL,
+    `last_login_at` datetime NOT NULL,
+   UNIQUE (`provider`, `subject`)
+);
+CREATE INDEX `user_identity_user_id` ON `user_identity` (`user_id`);
+
+-- WebAuthnCredential
+CREATE TABLE IF NOT EXISTS `webauthn_credential` (
+    `id` varchar(255) NOT NULL PRIMARY KEY,
+    `user_id` varchar(36) NOT NULL DEFAULT '',
+    `user_handle` varchar(128) NOT NULL DEFAULT '',
+    `public_key` text NOT NULL,
+    `sign_count` integer NOT NULL DEFAULT 0,
+    `aaguid` varchar(64) NOT NULL DEFAULT '',
+    `name` varchar(100) NOT NULL DEFAULT '',
+    `backup_eligible` bool NOT NULL DEFAULT false,
+    `created_at` datetime NOT NULL,
+    `last_used_at` datetime
+);
+CREATE INDEX `webauthn_credential_user_id` ON `webauthn_credential` (`user_id`);
+
+-- PasswordHistory
+CREATE TABLE IF NOT EXISTS `password_history` (
+    `i_d` integer NOT NULL PRIMARY KEY AUTOINCREMENT,
+    `user_id` varchar(36) NOT NULL DEFAULT '',
+    `hash` varchar(255) NOT NULL DEFAULT '',
+    `created_at` datetime NOT NULL
+);
+CREATE INDEX `password_history_user_id` ON `password_history` (`user_id`);
+
+-- EmailVerificationToken
+CREATE TABLE IF NOT EXISTS `email_verification_token` (
+    `token` varchar(64) NOT NULL PRIMARY KEY,
+    `user_id` varchar(36) NOT NULL DEFAULT '',
+    `email` varchar(191) NOT NULL DEFAULT '',
+    `expires_at` datetime NOT NULL,
+    `used_at` datetime,
+    `created_at` datetime NOT NULL
+);
+CREATE INDEX `email_verification_token_user_id` ON `email_verification_token` (`user_id`);
+
+-- VerifiedUser
+CREATE TABLE IF NOT EXISTS `verified_user` (
+    `user_id` varchar(36) NOT NULL PRIMARY KEY,
+    `email` varchar(191) NOT NULL DEFAULT '',
+    `verified_at` datetime NOT NULL
+);
+
+-- EmailChangeRequest
+CREATE TABLE IF NOT EXISTS `email_change_request` (
+    `id` integer NOT NULL PRIMARY KEY AUTOINCREMENT,
+    `user_id` varchar(36) NOT NULL DEFAULT '',
+    `old_email` varchar(191) NOT NULL DEFAULT '',
+    `new_email` varchar(191) NOT NULL DEFAULT '',
+    `token_hash` varchar(64) NOT NULL DEFAULT '' UNIQUE,
+    `revert_token_hash` varchar(64) NOT NULL DEFAULT '' UNIQUE,
+    `expires_at` datetime NOT NULL,
+    `revert_until` datetime NOT NULL,
+    `confirmed_at` datetime,
+    `reverted_at` datetime,
+    `created_at` datetime NOT NULL
+);
+CREATE INDEX `email_change_request_user_id` ON `email_change_request` (`user_id`);
+CREATE INDEX `email_change_request_new_email` ON `email_change_request` (`new_email`);
+
+-- Role
+CREATE TABLE IF NOT EXISTS `role` (
+    `name` varchar(100) NOT NULL PRIMARY KEY,
+    `parent` varchar(100) NOT NULL DEFAULT '' 
+);
+
+-- UserRole
+CREATE TABLE IF NOT EXISTS `user_role` (
+    `id` integer NOT NULL PRIMARY KEY AUTOINCREMENT,
+    `user_id` varchar(36) NOT NULL DEFAULT '',
+    `role` varchar(100) NOT NULL DEFAULT '' 
+);
+
+-- Permission
+CREATE TABLE IF NOT EXISTS `permission` (
+    `id` integer NOT NULL PRIMARY KEY AUTOINCREMENT,
+    `role` varchar(100) NOT NULL DEFAULT '',
+    `resource` varchar(191) NOT NULL DEFAULT '',
+    `action` varchar(50) NOT NULL DEFAULT '',
+    `effect` varchar(10) NOT NULL DEFAULT 'allow' 
+);
+
+-- Organization
+CREATE TABLE IF NOT EXISTS `organization` (
+    `id` integer NOT NULL PRIMARY KEY AUTOINCREMENT,
+    `slug` varchar(64) NOT NULL DEFAULT '' UNIQUE,
+    `name` varchar(200) NOT NULL DEFAULT '',
+    `created_by` varchar(36) NOT NULL DEFAULT '',
+    `created_at` datetime NOT NULL
+);
+
+-- OrgMembership
+CREATE TABLE IF NOT EXISTS `org_membership` (
+    `id` integer NOT NULL PRIMARY KEY AUTOINCREMENT,
+    `org_id` integer NOT NULL DEFAULT 0,
+    `user_id` varchar(36) NOT NULL DEFAULT '',
+    `role` varchar(100) NOT NULL DEFAULT '',
+    `created_at` datetime NOT NULL,
+   UNIQUE (`org_id`, `user_id`)
+);
+CREATE INDEX `org_membership_org_id` ON `org_membership` (`org_id`);
+CREATE INDEX `org_membership_user_id` ON `org_membership` (`user_id`);
+
+-- OrgInvitation
+CREATE TABLE IF NOT EXISTS `org_invitation` (
+    `id` integer NOT NULL PRIMARY KEY AUTOINCREMENT,
+    `org_id` integer NOT NULL DEFAULT 0,
+    `email` varchar(191) NOT NULL DEFAULT '',
+    `role` varchar(100) NOT NULL DEFAULT '',
+    `token_hash` varchar(64) NOT NULL DEFAULT '' UNIQUE,
+    `invited_by` varchar(36) NOT NULL DEFAULT '',
+    `expires_at` datetime NOT NULL,
+    `accepted_at` datetime,
+    `created_at` datetime NOT NULL
+);
+CREATE INDEX `org_invitation_org_id` ON `org_invitation` (`org_id`);
+CREATE INDEX `org_invitation_email` ON `org_invitation` (`email`);
+
+-- PersonalAccessToken
+CREATE TABLE IF NOT EXISTS `personal_access_token` (
+    `id` integer NOT NULL PRIMARY KEY AUTOINCREMENT,
+    `user_id` varchar(36) NOT NULL DEFAULT '',
+    `name` varchar(100) NOT NULL DEFAULT '',
+    `hint` varchar(16) NOT NULL DEFAULT '',
+    `token_hash` varchar(64) NOT NULL DEFAULT '' UNIQUE,
+    `scopes` varchar(1000) NOT NULL DEFAULT '',
+    `org_id` integer NOT NULL DEFAULT 0,
+    `expires_at` datetime,
+    `last_used_at` datetime,
+    `last_used_ip` varchar(64) NOT NULL DEFAULT '',
+    `revoked_at` datetime,
+    `created_at` datetime NOT NULL
+);
+CREATE INDEX `personal_access_token_user_id` ON `personal_access_token` (`user_id`);
+
+-- PasswordResetToken
+CREATE TABLE IF NOT EXISTS `password_reset_token` (
+    `token` varchar(64) NOT NULL PRIMARY KEY,
+    `user_id` varchar(36) NOT NULL DEFAULT '',
+    `expires_at` datetime NOT NULL,
+    `used_at` datetime,
+    `created_at` datetime NOT NULL
+);
+CREATE INDEX `password_reset_token_user_id` ON `password_reset_token` (`user_id`);
+
+-- MagicLinkToken
+CREATE TABLE IF NOT EXISTS `magic_link_token` (
+    `token` varchar(64) NOT NULL PRIMARY KEY,
+    `user_id` varchar(36) NOT NULL DEFAULT '',
+    `nonce_hash` varchar(64) NOT NULL DEFAULT '',
+    `expires_at` datetime NOT NULL,
+    `used_at` datetime,
+    `created_at` datetime NOT NULL
+);
+CREATE INDEX `magic_link_token_user_id` ON `magic_link_token` (`user_id`);
+
+-- ErrorLog
+CREATE TABLE IF NOT EXISTS `error_log` (
+    `id` integer NOT NULL PRIMARY KEY AUTOINCREMENT,
+    `title` varchar(100) NOT NULL DEFAULT '',
+    `context` varchar(150) NOT NULL DEFAULT '',
+    `error` varchar(255) NOT NULL DEFAULT '',
+    `created_at` datetime NOT NULL
+);
+
+-- AuditLog
+CREATE TABLE IF NOT EXISTS `audit_log` (
+    `id` integer NOT NULL PRIMARY KEY AUTOINCREMENT,
+    `actor_id` varchar(36) NOT NULL DEFAULT '',
+    `action` varchar(64) NOT NULL DEFAULT '',
+    `target_id` varchar(36) NOT NULL DEFAULT '',
+    `ip` varchar(64) NOT NULL DEFAULT '',
+    `details` text NOT NULL,
+    `created_at` datetime NOT NULL
+);
+CREATE INDEX `audit_log_actor_id` ON `audit_log` (`actor_id`);
+CREATE INDEX `audit_log_action` ON `audit_log` (`action`);
+CREATE INDEX `audit_log_target_id` ON `audit_log` (`target_id`);
+CREATE INDEX `audit_log_created_at` ON `audit_log` (`created_at`);
@@ -0,0 +1,2 @@
+ALTER TABLE `permission` DROP COLUMN `effect`;
+ALTER TABLE `role` DROP COLUMN `parent`;
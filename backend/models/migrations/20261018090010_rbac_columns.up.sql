@@ -0,0 +1,4 @@
+-- Columns added to the role and permission tables of earlier releases;
+-- tables created by the baseline already have them.
+ALTER TABLE `role` ADD COLUMN `parent` varchar(100) NOT NULL DEFAULT '';
+ALTER TABLE `permission` ADD COLUMN `effect` varchar(10) NOT NULL DEFAULT 'allow';
@@ -0,0 +1 @@
+ALTER TABLE `role` DROP COLUMN `org_scoped`;
@@ -0,0 +1,2 @@
+-- Roles that can be given to organization members.
+ALTER TABLE `role` ADD COLUMN `org_scoped` bool NOT NULL DEFAULT false;
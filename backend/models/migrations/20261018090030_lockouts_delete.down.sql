@@ -0,0 +1 @@
+UPDATE `permission` SET `action` = 'write' WHERE `resource` = 'lockouts' AND `action` = 'delete';
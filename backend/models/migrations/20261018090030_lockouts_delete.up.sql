@@ -0,0 +1,2 @@
+-- Clearing lockouts needs lockouts:delete, like every other DELETE route.
+UPDATE `permission` SET `action` = 'delete' WHERE `resource` = 'lockouts' AND `action` = 'write';
@@ -0,0 +1,383 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/beego/beego/v2/client/orm"
+)
+
+// Organization roles with a built-in meaning: owners and admins manage the
+// organization, owners can also delete it. A member may instead hold any
+// RBAC role; its rules apply only while that organization is active.
+const (
+	OrgRoleOwner  = "owner"
+	OrgRoleAdmin  = "admin"
+	OrgRoleMember = "member"
+)
+
+var (
+	ErrOrgNotFound        = errors.New("organization not found")
+	ErrOrgExists          = errors.New("organization slug already taken")
+	ErrNotOrgMember       = errors.New("not a member of this organization")
+	ErrLastOrgOwner       = errors.New("organization must keep at least one owner")
+	ErrInvalidOrgRole     = errors.New("role must be owner, admin, member or an organization role")
+	ErrInvitationNotFound = errors.New("invitation not found")
+	ErrInvalidInvitation  = errors.New("invalid or expired invitation")
+)
+
+// Organization is a tenant: a workspace whose members share tenant-aware
+// data such as items.
+type Organization struct {
+	ID        int64     `orm:"auto;pk;column(id)" json:"id"`
+	Slug      string    `orm:"size(64);unique" json:"slug"`
+	Name      string    `orm:"size(200)" json:"name"`
+	CreatedBy string    `orm:"size(36)" json:"created_by"` // user ID
+	CreatedAt time.Time `orm:"auto_now_add;type(datetime)" json:"created_at"`
+}
+
+func (o *Organization) TableName() string { return "organization" }
+
+// OrgMembership gives a user a role in one organization.
+type OrgMembership struct {
+	ID        int64     `orm:"auto;pk;column(id)" json:"id"`
+	OrgID     int64     `orm:"column(org_id);index" json:"org_id"`
+	UserID    string    `orm:"size(36);column(user_id);index" json:"user_id"`
+	Role      string    `orm:"size(100)" json:"role"`
+	CreatedAt time.Time `orm:"auto_now_add;type(datetime)" json:"created_at"`
+}
+
+func (m *OrgMembership) TableName() string { return "org_membership" }
+
+func (m *OrgMembership) TableUnique() [][]string {
+	return [][]string{{"OrgID", "UserID"}}
+}
+
+// OrgInvitation is an emailed offer to join an organization with a role.
+// Only the SHA-256 of the token is stored.
+type OrgInvitation struct {
+	ID         int64      `orm:"auto;pk;column(id)" json:"id"`
+	OrgID      int64      `orm:"column(org_id);index" json:"org_id"`
+	Email      string     `orm:"size(191);index" json:"email"`
+	Role       string     `orm:"size(100)" json:"role"`
+	TokenHash  string     `orm:"size(64);unique" json:"-"`
+	InvitedBy  string     `orm:"size(36)" json:"invited_by"` // user ID
+	ExpiresAt  time.Time  `orm:"type(datetime)" json:"expires_at"`
+	AcceptedAt *time.Time `orm:"null;type(datetime)" json:"accepted_at"`
+	CreatedAt  time.Time  `orm:"auto_now_add;type(datetime)" json:"created_at"`
+}
+
+func (i *OrgInvitation) TableName() string { return "org_invitation" }
+
+// OrgMember is a membership with the member's current email address.
+type OrgMember struct {
+	OrgMembership
+	Email string `json:"email"`
+}
+
+// UserOrg is an organization as seen by one of its members.
+type UserOrg struct {
+	Organization
+	Role string `json:"role"`
+}
+
+// CheckOrgRole validates a role given to an organization member: owner,
+// admin, member, or an existing role marked OrgScoped. Superuser and its
+// descendants are refused: they would grant everything, everywhere.
+func CheckOrgRole(role string) error {
+	switch role {
+	case OrgRoleOwner, OrgRoleAdmin, OrgRoleMember:
+		return nil
+	case "":
+		return ErrInvalidOrgRole
+	}
+	r, err := GetRole(role)
+	if err != nil {
+		return err
+	}
+	if r == nil || !r.OrgScoped {
+		return ErrInvalidOrgRole
+	}
+	if inherits, err := InheritsSuperuser(role); err != nil {
+		return err
+	} else if inherits {
+		return ErrInvalidOrgRole
+	}
+	return nil
+}
+
+// CreateOrganization creates the organization with the user ID owner as its
+// first owner.
+func CreateOrganization(name, slug, owner string) (*Organization, error) {
+	o := orm.NewOrm()
+	if exists := o.QueryTable(new(Organization)).Filter("Slug", slug).Exist(); exists {
+		return nil, ErrOrgExists
+	}
+	org := &Organization{Slug: slug, Name: name, CreatedBy: owner}
+	err := o.DoTx(func(ctx context.Context, tx orm.TxOrmer) error {
+		if _, err := tx.Insert(org); err != nil {
+			return err
+		}
+		_, err := tx.Insert(&OrgMembership{OrgID: org.ID, UserID: owner, Role: OrgRoleOwner})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	invalidatePermissions()
+	return org, nil
+}
+
+func GetOrganization(id int64) (*Organization, error) {
+	org := Organization{ID: id}
+	if err := orm.NewOrm().Read(&org); err != nil {
+		if err == orm.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &org, nil
+}
+
+func RenameOrganization(id int64, name string) error {
+	_, err := orm.NewOrm().QueryTable(new(Organization)).Filter("ID", id).Update(orm.Params{"Name": name})
+	return err
+}
+
+// DeleteOrganization removes the organization, its memberships, its
+// invitations and the rows of every tenant-aware model that belong to it.
+func DeleteOrganization(id int64) error {
+	err := orm.NewOrm().DoTx(func(ctx context.Context, tx orm.TxOrmer) error {
+		n, err := tx.QueryTable(new(Organization)).Filter("ID", id).Delete()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return ErrOrgNotFound
+		}
+		if _, err := tx.QueryTable(new(OrgMembership)).Filter("OrgID", id).Delete(); err != nil {
+			return err
+		}
+		if _, err := tx.QueryTable(new(OrgInvitation)).Filter("OrgID", id).Delete(); err != nil {
+			return err
+		}
+		for _, md := range tenantModels() {
+			if _, err := tx.QueryTable(md).Filter(TenantField, id).Delete(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	invalidatePermissions()
+	return err
+}
+
+// ListUserOrganizations returns the organizations the user belongs to, with
+// the role held in each.
+func ListUserOrganizations(userID string) ([]UserOrg, error) {
+	o := orm.NewOrm()
+	var ms []OrgMembership
+	if _, err := o.QueryTable(new(OrgMembership)).Filter("UserID", userID).All(&ms); err != nil {
+		return nil, err
+	}
+	out := []UserOrg{}
+	if len(ms) == 0 {
+		return out, nil
+	}
+	roles := make(map[int64]string, len(ms))
+	ids := make([]int64, 0, len(ms))
+	for _, m := range ms {
+		roles[m.OrgID] = m.Role
+		ids = append(ids, m.OrgID)
+	}
+	var orgs []Organization
+	if _, err := o.QueryTable(new(Organization)).Filter("ID__in", ids).OrderBy("Name").All(&orgs); err != nil {
+		return nil, err
+	}
+	for _, org := range orgs {
+		out = append(out, UserOrg{Organization: org, Role: roles[org.ID]})
+	}
+	return out, nil
+}
+
+// OrgRole returns the user's role in the organization, "" for non-members.
+func OrgRole(org int64, userID string) (string, error) {
+	var m OrgMembership
+	err := orm.NewOrm().QueryTable(new(OrgMembership)).Filter("OrgID", org).Filter("UserID", userID).One(&m)
+	if err == orm.ErrNoRows {
+		return "", nil
+	}
+	return m.Role, err
+}
+
+func IsOrgMember(org int64, userID string) bool {
+	role, err := OrgRole(org, userID)
+	return err == nil && role != ""
+}
+
+// ListOrgMembers returns the organization's members ordered by email.
+func ListOrgMembers(org int64) ([]OrgMember, error) {
+	o := orm.NewOrm()
+	var ms []OrgMembership
+	if _, err := o.QueryTable(new(OrgMembership)).Filter("OrgID", org).All(&ms); err != nil {
+		return nil, err
+	}
+	out := []OrgMember{}
+	if len(ms) == 0 {
+		return out, nil
+	}
+	byID := make(map[string]OrgMembership, len(ms))
+	ids := make([]string, 0, len(ms))
+	for _, m := range ms {
+		byID[m.UserID] = m
+		ids = append(ids, m.UserID)
+	}
+	var users []User
+	if _, err := o.QueryTable(new(User)).Filter("ID__in", ids).OrderBy("Email").All(&users, "ID", "Email"); err != nil {
+		return nil, err
+	}
+	for _, u := range users {
+		out = append(out, OrgMember{OrgMembership: byID[u.ID], Email: u.Email})
+	}
+	return out, nil
+}
+
+// AddOrgMember makes the user a member with role. Existing members keep the
+// role they have.
+func AddOrgMember(org int64, userID, role string) error {
+	o := orm.NewOrm()
+	if _, err := o.Insert(&OrgMembership{OrgID: org, UserID: userID, Role: role}); err != nil {
+		// a concurrent or earlier join hit the unique key
+		if IsOrgMember(org, userID) {
+			return nil
+		}
+		return err
+	}
+	invalidatePermissions()
+	return nil
+}
+
+// ownerGuard refuses a change that would leave the organization without
+// an owner: demoting or removing userID when it is the last one.
+func ownerGuard(o orm.Ormer, org int64, userID string) error {
+	n, err := o.QueryTable(new(OrgMembership)).Filter("OrgID", org).Filter("Role", OrgRoleOwner).Exclude("UserID", userID).Count()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrLastOrgOwner
+	}
+	return nil
+}
+
+// SetOrgMemberRole changes a member's role.
+func SetOrgMemberRole(org int64, userID, role string) error {
+	o := orm.NewOrm()
+	current, err := OrgRole(org, userID)
+	if err != nil {
+		return err
+	}
+	if current == "" {
+		return ErrNotOrgMember
+	}
+	if current == OrgRoleOwner && role != OrgRoleOwner {
+		if err := ownerGuard(o, org, userID); err != nil {
+			return err
+		}
+	}
+	_, err = o.QueryTable(new(OrgMembership)).Filter("OrgID", org).Filter("UserID", userID).Update(orm.Params{"Role": role})
+	invalidatePermissions()
+	return err
+}
+
+// RemoveOrgMember removes the user from the organization.
+func RemoveOrgMember(org int64, userID string) error {
+	o := orm.NewOrm()
+	current, err := OrgRole(org, userID)
+	if err != nil {
+		return err
+	}
+	if current == "" {
+		return ErrNotOrgMember
+	}
+	if current == OrgRoleOwner {
+		if err := ownerGuard(o, org, userID); err != nil {
+			return err
+		}
+	}
+	_, err = o.QueryTable(new(OrgMembership)).Filter("OrgID", org).Filter("UserID", userID).Delete()
+	invalidatePermissions()
+	return err
+}
+
+// CreateOrgInvitation stores an invitation for email, sent by the user ID
+// by, and returns it with the raw token to mail.
+func CreateOrgInvitation(org int64, email, role, by string, ttl time.Duration) (*OrgInvitation, string, error) {
+	raw, err := randomToken(32)
+	if err != nil {
+		return nil, "", err
+	}
+	inv := &OrgInvitation{
+		OrgID:     org,
+		Email:     email,
+		Role:      role,
+		TokenHash: hashToken(raw),
+		InvitedBy: by,
+		ExpiresAt: time.Now().Add(ttl),
+	}
+	if _, err := orm.NewOrm().Insert(inv); err != nil {
+		return nil, "", err
+	}
+	return inv, raw, nil
+}
+
+// ListOrgInvitations returns the organization's pending invitations.
+func ListOrgInvitations(org int64) ([]OrgInvitation, error) {
+	var out []OrgInvitation
+	_, err := orm.NewOrm().QueryTable(new(OrgInvitation)).
+		Filter("OrgID", org).
+		Filter("AcceptedAt__isnull", true).
+		Filter("ExpiresAt__gt", time.Now()).
+		OrderBy("-ID").All(&out)
+	return out, err
+}
+
+func DeleteOrgInvitation(org, id int64) error {
+	n, err := orm.NewOrm().QueryTable(new(OrgInvitation)).Filter("ID", id).Filter("OrgID", org).Delete()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrInvitationNotFound
+	}
+	return nil
+}
+
+// AcceptOrgInvitation redeems the invitation for u, whose email must be the
+// address it was sent to, and adds the membership.
+func AcceptOrgInvitation(raw string, u *User) (*OrgInvitation, error) {
+	o := orm.NewOrm()
+	var inv OrgInvitation
+	if err := o.QueryTable(new(OrgInvitation)).Filter("TokenHash", hashToken(raw)).One(&inv); err != nil {
+		return nil, ErrInvalidInvitation
+	}
+	if inv.AcceptedAt != nil || time.Now().After(inv.ExpiresAt) || inv.Email != u.Email {
+		return nil, ErrInvalidInvitation
+	}
+	// a concurrent redemption must not win twice
+	n, err := o.QueryTable(new(OrgInvitation)).
+		Filter("ID", inv.ID).
+		Filter("AcceptedAt__isnull", true).
+		Update(orm.Params{"AcceptedAt": time.Now()})
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, ErrInvalidInvitation
+	}
+	if err := AddOrgMember(inv.OrgID, u.ID, inv.Role); err != nil {
+		return nil, err
+	}
+	return &inv, nil
+}
@@ -0,0 +1,55 @@
+package models
+
+import (
+	"time"
+
+	"github.com/beego/beego/v2/client/orm"
+)
+
+// PasswordHistory keeps hashes of a user's previous passwords so they are
+// not reused.
+type PasswordHistory struct {
+	ID        int64     `orm:"auto;pk" json:"id"`
+	UserID    string    `orm:"size(36);column(user_id);index" json:"user_id"`
+	Hash      string    `orm:"size(255)" json:"-"`
+	CreatedAt time.Time `orm:"auto_now_add;type(datetime)" json:"created_at"`
+}
+
+func (h *PasswordHistory) TableName() string { return "password_history" }
+
+// RecentPasswordHashes returns the user's last n password hashes, newest first.
+func RecentPasswordHashes(userID string, n int) ([]string, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+	var rows []PasswordHistory
+	_, err := orm.NewOrm().QueryTable(new(PasswordHistory)).Filter("UserID", userID).
+		OrderBy("-CreatedAt", "-ID").Limit(n).All(&rows, "Hash")
+	out := make([]string, 0, len(rows))
+	for _, r := range rows {
+		out = append(out, r.Hash)
+	}
+	return out, err
+}
+
+// AddPasswordHistory records hash and drops all but the newest keep entries.
+func AddPasswordHistory(userID, hash string, keep int) error {
+	if keep < 1 {
+		keep = 1
+	}
+	o := orm.NewOrm()
+	if _, err := o.Insert(&PasswordHistory{UserID: userID, Hash: hash}); err != nil {
+		return err
+	}
+	var old []PasswordHistory
+	if _, err := o.QueryTable(new(PasswordHistory)).Filter("UserID", userID).
+		OrderBy("-CreatedAt", "-ID").Limit(-1, keep).All(&old, "ID"); err != nil {
+		return err
+	}
+	for _, r := range old {
+		if _, err := o.Delete(&PasswordHistory{ID: r.ID}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -11,7 +11,7 @@ import (
 
 type PasswordResetToken struct {
 	Token     string     `orm:"size(64);pk" json:"token"`
-	Email     string     `orm:"size(191)" json:"email"`
+	UserID    string     `orm:"size(36);column(user_id);index" json:"user_id"`
 	ExpiresAt time.Time  `orm:"type(datetime)" json:"expires_at"`
 	UsedAt    *time.Time `orm:"null;type(datetime)" json:"used_at"`
 	CreatedAt time.Time  `orm:"auto_now_add;type(datetime)" json:"created_at"`
@@ -27,14 +27,14 @@ func randomResetToken(n int) (string, error) {
 	return hex.EncodeToString(b), nil
 }
 
-func CreatePasswordResetToken(email string, ttl time.Duration) (*PasswordResetToken, error) {
+func CreatePasswordResetToken(userID string, ttl time.Duration) (*PasswordResetToken, error) {
 	tok, err := randomResetToken(16)
 	if err != nil {
 		return nil, err
 	}
 	t := &PasswordResetToken{
 		Token:     tok,
-		Email:     email,
+		UserID:    userID,
 		ExpiresAt: time.Now().Add(ttl),
 	}
 	o := orm.NewOrm()
@@ -44,22 +44,39 @@ func CreatePasswordResetToken(email string, ttl time.Duration) (*PasswordResetTo
 	return t, nil
 }
 
-func ConsumePasswordResetToken(token string) (string, error) {
-	o := orm.NewOrm()
-	t := PasswordResetToken{Token: token}
-	if err := o.Read(&t); err != nil {
+// PeekPasswordResetToken returns the user of a valid, unused token without
+// using it up, so the new password can be checked first.
+func PeekPasswordResetToken(token string) (string, error) {
+	t, err := readPasswordResetToken(token)
+	if err != nil {
 		return "", err
 	}
+	return t.UserID, nil
+}
+
+func readPasswordResetToken(token string) (*PasswordResetToken, error) {
+	t := PasswordResetToken{Token: token}
+	if err := orm.NewOrm().Read(&t); err != nil {
+		return nil, err
+	}
 	if t.UsedAt != nil {
-		return "", errors.New("token already used")
+		return nil, errors.New("token already used")
 	}
 	if time.Now().After(t.ExpiresAt) {
-		return "", errors.New("token expired")
+		return nil, errors.New("token expired")
+	}
+	return &t, nil
+}
+
+func ConsumePasswordResetToken(token string) (string, error) {
+	t, err := readPasswordResetToken(token)
+	if err != nil {
+		return "", err
 	}
 	now := time.Now()
 	t.UsedAt = &now
-	if _, err := o.Update(&t, "UsedAt"); err != nil {
+	if _, err := orm.NewOrm().Update(t, "UsedAt"); err != nil {
 		return "", err
 	}
-	return t.Email, nil
+	return t.UserID, nil
 }
@@ -0,0 +1,161 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/beego/beego/v2/client/orm"
+)
+
+// PATPrefix starts every personal access token, so they are easy to tell
+// apart from JWTs and to spot in leaked text.
+const PATPrefix = "gcp_"
+
+var (
+	ErrTokenNotFound = errors.New("token not found")
+	errInvalidPAT    = errors.New("invalid, expired or revoked token")
+)
+
+// PersonalAccessToken is a long-lived bearer token for scripts and
+// integrations. It acts for its user in organization OrgID (0 = personal),
+// limited to Scopes. Only the SHA-256 of the token is stored.
+type PersonalAccessToken struct {
+	ID         int64      `orm:"auto;pk;column(id)" json:"id"`
+	UserID     string     `orm:"size(36);column(user_id);index" json:"-"`
+	Name       string     `orm:"size(100)" json:"name"`
+	Hint       string     `orm:"size(16)" json:"hint"` // first characters, to recognise the token
+	TokenHash  string     `orm:"size(64);unique" json:"-"`
+	Scopes     string     `orm:"size(1000)" json:"-"` // comma separated resource:action pairs
+	OrgID      int64      `orm:"column(org_id);default(0)" json:"org_id,omitempty"`
+	ExpiresAt  *time.Time `orm:"null;type(datetime)" json:"expires_at"`
+	LastUsedAt *time.Time `orm:"null;type(datetime)" json:"last_used_at"`
+	LastUsedIP string     `orm:"size(64);column(last_used_ip)" json:"last_used_ip"`
+	RevokedAt  *time.Time `orm:"null;type(datetime)" json:"-"`
+	CreatedAt  time.Time  `orm:"auto_now_add;type(datetime)" json:"created_at"`
+}
+
+func (t *PersonalAccessToken) TableName() string { return "personal_access_token" }
+
+// ScopeList returns the token's scopes.
+func (t *PersonalAccessToken) ScopeList() []string {
+	if t.Scopes == "" {
+		return []string{}
+	}
+	return strings.Split(t.Scopes, ",")
+}
+
+// Allows reports whether a scope covers resource/action. Scopes use the
+// same wildcards as permission rules: "*", "reports:*", "admin*:read".
+func (t *PersonalAccessToken) Allows(resource, action string) bool {
+	for _, s := range t.ScopeList() {
+		res, act, _ := strings.Cut(s, ":")
+		if matchResource(res, resource) && (act == "*" || act == action) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseScopes validates "resource:action" scopes and removes duplicates.
+func ParseScopes(scopes []string) ([]string, error) {
+	out := []string{}
+	seen := map[string]bool{}
+	for _, s := range scopes {
+		s = strings.TrimSpace(s)
+		res, act, ok := strings.Cut(s, ":")
+		if !ok || res == "" || act == "" || strings.ContainsAny(s, ", ") {
+			return nil, errors.New("scopes must look like resource:action")
+		}
+		if !seen[s] {
+			seen[s] = true
+			out = append(out, s)
+		}
+	}
+	if len(out) == 0 {
+		return nil, errors.New("at least one scope is required")
+	}
+	return out, nil
+}
+
+// CreatePersonalAccessToken stores a token for userID and returns it with
+// the raw value, which is shown once and never stored. A nil expiresAt
+// never expires.
+func CreatePersonalAccessToken(userID, name string, org int64, scopes []string, expiresAt *time.Time) (*PersonalAccessToken, string, error) {
+	secret, err := randomToken(20)
+	if err != nil {
+		return nil, "", err
+	}
+	raw := PATPrefix + secret
+	t := &PersonalAccessToken{
+		UserID:    userID,
+		Name:      name,
+		Hint:      raw[:len(PATPrefix)+4],
+		TokenHash: hashToken(raw),
+		Scopes:    strings.Join(scopes, ","),
+		OrgID:     org,
+		ExpiresAt: expiresAt,
+	}
+	if _, err := orm.NewOrm().Insert(t); err != nil {
+		return nil, "", err
+	}
+	return t, raw, nil
+}
+
+// ListPersonalAccessTokens returns the user's tokens that are not revoked,
+// newest first.
+func ListPersonalAccessTokens(userID string) ([]PersonalAccessToken, error) {
+	var out []PersonalAccessToken
+	_, err := orm.NewOrm().QueryTable(new(PersonalAccessToken)).
+		Filter("UserID", userID).
+		Filter("RevokedAt__isnull", true).
+		OrderBy("-ID").
+		All(&out)
+	return out, err
+}
+
+func RevokePersonalAccessToken(userID string, id int64) error {
+	n, err := orm.NewOrm().QueryTable(new(PersonalAccessToken)).
+		Filter("ID", id).
+		Filter("UserID", userID).
+		Filter("RevokedAt__isnull", true).
+		Update(orm.Params{"RevokedAt": time.Now()})
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrTokenNotFound
+	}
+	return nil
+}
+
+// RevokeAllPersonalAccessTokens revokes every live token of the user and
+// returns how many there were.
+func RevokeAllPersonalAccessTokens(userID string) (int64, error) {
+	return orm.NewOrm().QueryTable(new(PersonalAccessToken)).
+		Filter("UserID", userID).
+		Filter("RevokedAt__isnull", true).
+		Update(orm.Params{"RevokedAt": time.Now()})
+}
+
+// AuthenticatePersonalAccessToken looks up a live token and records its use
+// from ip, at most once per sessionTouchInterval.
+func AuthenticatePersonalAccessToken(raw, ip string) (*PersonalAccessToken, error) {
+	o := orm.NewOrm()
+	var t PersonalAccessToken
+	if err := o.QueryTable(new(PersonalAccessToken)).Filter("TokenHash", hashToken(raw)).One(&t); err != nil {
+		if err == orm.ErrNoRows {
+			return nil, errInvalidPAT
+		}
+		return nil, err
+	}
+	now := time.Now()
+	if t.RevokedAt != nil || (t.ExpiresAt != nil && now.After(*t.ExpiresAt)) {
+		return nil, errInvalidPAT
+	}
+	if t.LastUsedAt == nil || t.LastUsedAt.Before(now.Add(-sessionTouchInterval)) || t.LastUsedIP != ip {
+		_, _ = o.QueryTable(new(PersonalAccessToken)).Filter("ID", t.ID).Update(orm.Params{"LastUsedAt": now, "LastUsedIP": ip})
+		t.LastUsedAt, t.LastUsedIP = &now, ip
+	}
+	return &t, nil
+}
@@ -0,0 +1,209 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/beego/beego/v2/client/orm"
+	"github.com/beego/beego/v2/server/web"
+)
+
+var ErrRoleCycle = errors.New("role cannot inherit from itself")
+
+// PermissionSet is a user's effective roles and rules, with inheritance
+// already resolved. OrgRoles and OrgRules come from the user's membership in
+// the active organization.
+type PermissionSet struct {
+	Roles    []string     `json:"roles"`
+	Rules    []Permission `json:"rules"`
+	OrgRoles []string     `json:"org_roles,omitempty"`
+	OrgRules []Permission `json:"org_rules,omitempty"`
+}
+
+// GlobalResources are the resources of the site administration, which an
+// organization role never grants.
+var GlobalResources = []string{"rbac", "users", "lockouts", "audit"}
+
+// HasRole reports whether the user holds role directly or by inheritance,
+// outside any organization.
+func (s *PermissionSet) HasRole(role string) bool {
+	for _, r := range s.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Allows applies the rules: any matching deny refuses, otherwise any
+// matching allow grants. Allow rules of organization roles do not count
+// for GlobalResources.
+func (s *PermissionSet) Allows(resource, action string) bool {
+	if s.HasRole("Superuser") {
+		return true
+	}
+	global := slices.Contains(GlobalResources, resource)
+	allowed := false
+	for i, rules := range [][]Permission{s.Rules, s.OrgRules} {
+		for _, p := range rules {
+			if !matchResource(p.Resource, resource) || (p.Action != "*" && p.Action != action) {
+				continue
+			}
+			if p.Effect == EffectDeny {
+				return false
+			}
+			allowed = allowed || i == 0 || !global
+		}
+	}
+	return allowed
+}
+
+func matchResource(pattern, resource string) bool {
+	if pattern == "*" || pattern == resource {
+		return true
+	}
+	if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+		return strings.HasPrefix(resource, prefix)
+	}
+	return false
+}
+
+// effectiveSQL walks up the role tree from the user's roles, plus the role
+// held in the active organization, and joins their rules, in one round
+// trip. The org column marks roles reached only through the membership;
+// that walk starts from and follows OrgScoped roles alone, so it cannot
+// reach Superuser. UNION (not UNION ALL) stops on cycles.
+const effectiveSQL = `WITH RECURSIVE roles(name, org) AS (
+	SELECT role, 0 FROM user_role WHERE user_id = ?
+	UNION
+	SELECT m.role, 1 FROM org_membership m JOIN role r ON r.name = m.role
+	WHERE m.user_id = ? AND m.org_id = ? AND r.org_scoped
+	UNION
+	SELECT r.parent, roles.org FROM role r JOIN roles ON r.name = roles.name
+	WHERE r.parent <> '' AND (roles.org = 0 OR r.parent IN (SELECT name FROM role WHERE org_scoped))
+)
+SELECT held.name, held.org, p.resource, p.action, p.effect
+FROM (SELECT name, MIN(org) AS org FROM roles GROUP BY name) held
+LEFT JOIN permission p ON p.role = held.name`
+
+func loadPermissions(userID string, org int64) (*PermissionSet, error) {
+	var rows []orm.ParamsList
+	if _, err := orm.NewOrm().Raw(effectiveSQL, userID, userID, org).ValuesList(&rows); err != nil {
+		return nil, err
+	}
+	set := &PermissionSet{}
+	seen := map[string]bool{}
+	str := func(v any) string { s, _ := v.(string); return s }
+	for _, r := range rows {
+		role := str(r[0])
+		inOrg := fmt.Sprint(r[1]) == "1"
+		if !seen[role] {
+			seen[role] = true
+			if inOrg {
+				set.OrgRoles = append(set.OrgRoles, role)
+			} else {
+				set.Roles = append(set.Roles, role)
+			}
+		}
+		if r[2] == nil {
+			continue
+		}
+		effect := str(r[4])
+		if effect == "" {
+			effect = EffectAllow
+		}
+		p := Permission{Role: role, Resource: str(r[2]), Action: str(r[3]), Effect: effect}
+		if inOrg {
+			set.OrgRules = append(set.OrgRules, p)
+		} else {
+			set.Rules = append(set.Rules, p)
+		}
+	}
+	return set, nil
+}
+
+// permCache holds PermissionSets per user and organization. Role and rule
+// changes made through this package invalidate it; rbac::cache_seconds
+// bounds how long changes made elsewhere (e.g. another instance) can go
+// unnoticed.
+var permCache = struct {
+	sync.Mutex
+	gen     uint64
+	entries map[string]permEntry
+}{entries: map[string]permEntry{}}
+
+type permEntry struct {
+	set     *PermissionSet
+	gen     uint64
+	expires time.Time
+}
+
+// invalidatePermissions drops every cached set. Role changes are rare, so
+// this is simpler than tracking which users a change affects.
+func invalidatePermissions() {
+	permCache.Lock()
+	defer permCache.Unlock()
+	permCache.gen++
+	permCache.entries = map[string]permEntry{}
+}
+
+// EffectivePermissions returns the user's resolved roles and rules.
+func EffectivePermissions(userID string) (*PermissionSet, error) {
+	return EffectivePermissionsIn(userID, 0)
+}
+
+// EffectivePermissionsIn is EffectivePermissions while acting in
+// organization org, whose membership role then counts as one of the
+// user's roles.
+func EffectivePermissionsIn(userID string, org int64) (*PermissionSet, error) {
+	key := fmt.Sprintf("%s|%d", userID, org)
+	now := time.Now()
+	permCache.Lock()
+	e, ok := permCache.entries[key]
+	gen := permCache.gen
+	permCache.Unlock()
+	if ok && e.gen == gen && now.Before(e.expires) {
+		return e.set, nil
+	}
+
+	set, err := loadPermissions(userID, org)
+	if err != nil {
+		return nil, err
+	}
+	ttl := time.Duration(web.AppConfig.DefaultInt("rbac::cache_seconds", 60)) * time.Second
+	if ttl > 0 {
+		permCache.Lock()
+		// skip storing if a change happened while loading
+		if permCache.gen == gen {
+			permCache.entries[key] = permEntry{set: set, gen: gen, expires: now.Add(ttl)}
+		}
+		permCache.Unlock()
+	}
+	return set, nil
+}
+
+// roleAncestors returns role's parent chain, nearest first.
+func roleAncestors(role string) ([]string, error) {
+	o := orm.NewOrm()
+	var out []string
+	seen := map[string]bool{role: true}
+	for {
+		r := Role{Name: role}
+		if err := o.Read(&r); err != nil {
+			if err == orm.ErrNoRows {
+				return out, nil
+			}
+			return nil, err
+		}
+		if r.Parent == "" || seen[r.Parent] {
+			return out, nil
+		}
+		seen[r.Parent] = true
+		out = append(out, r.Parent)
+		role = r.Parent
+	}
+}
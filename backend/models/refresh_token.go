@@ -0,0 +1,136 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/beego/beego/v2/client/orm"
+)
+
+// ErrRefreshTokenReused is returned when a refresh token that was already
+// rotated is presented again. The whole family is revoked when this happens.
+var ErrRefreshTokenReused = errors.New("refresh token reuse detected")
+
+// RefreshToken is one link in a rotation chain. Every login starts a new
+// family; every refresh marks the presented token used and issues the next
+// one in the same family. Only the SHA-256 of the token is stored.
+type RefreshToken struct {
+	TokenHash       string     `orm:"size(64);pk" json:"-"`
+	Family          string     `orm:"size(64);index" json:"family"`
+	UserID          string     `orm:"size(36);column(user_id);index" json:"user_id"`
+	AccessJTI       string     `orm:"size(191);column(access_jti)" json:"access_jti"`
+	AccessExpiresAt time.Time  `orm:"type(datetime)" json:"access_expires_at"`
+	AMR             string     `orm:"size(100);column(amr)" json:"amr"` // comma separated, carried over on refresh
+	Org             int64      `orm:"default(0)" json:"org,omitempty"`  // active organization, carried over on refresh
+	ExpiresAt       time.Time  `orm:"type(datetime)" json:"expires_at"`
+	UsedAt          *time.Time `orm:"null;type(datetime)" json:"used_at"`
+	RevokedAt       *time.Time `orm:"null;type(datetime)" json:"revoked_at"`
+	CreatedAt       time.Time  `orm:"auto_now_add;type(datetime)" json:"created_at"`
+}
+
+func (t *RefreshToken) TableName() string { return "refresh_token" }
+
+func hashToken(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateRefreshToken stores t (UserID, AccessJTI, AccessExpiresAt and
+// optionally Family/AMR filled by the caller) as a new refresh token valid
+// for ttl. An empty Family starts a new rotation chain. The raw token is
+// returned once and never stored.
+func CreateRefreshToken(t *RefreshToken, ttl time.Duration) (string, error) {
+	raw, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+	if t.Family == "" {
+		if t.Family, err = randomToken(16); err != nil {
+			return "", err
+		}
+	}
+	t.TokenHash = hashToken(raw)
+	t.ExpiresAt = time.Now().Add(ttl)
+	if _, err := orm.NewOrm().Insert(t); err != nil {
+		return "", err
+	}
+	return raw, nil
+}
+
+// ConsumeRefreshToken marks the token as used and returns it so the caller
+// can issue the next token in the same family. Presenting a token that was
+// already used revokes the family and returns ErrRefreshTokenReused.
+func ConsumeRefreshToken(raw string) (*RefreshToken, error) {
+	o := orm.NewOrm()
+	t := RefreshToken{TokenHash: hashToken(raw)}
+	if err := o.Read(&t); err != nil {
+		if err == orm.ErrNoRows {
+			return nil, errors.New("invalid refresh token")
+		}
+		return nil, err
+	}
+	if t.RevokedAt != nil {
+		return nil, errors.New("refresh token revoked")
+	}
+	if t.UsedAt != nil {
+		_ = RevokeRefreshFamily(t.Family)
+		return nil, ErrRefreshTokenReused
+	}
+	if time.Now().After(t.ExpiresAt) {
+		return nil, errors.New("refresh token expired")
+	}
+	// Only one concurrent caller may win the rotation.
+	now := time.Now()
+	n, err := o.QueryTable(new(RefreshToken)).
+		Filter("TokenHash", t.TokenHash).
+		Filter("UsedAt__isnull", true).
+		Update(orm.Params{"UsedAt": now})
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		_ = RevokeRefreshFamily(t.Family)
+		return nil, ErrRefreshTokenReused
+	}
+	t.UsedAt = &now
+	return &t, nil
+}
+
+// RevokeRefreshFamily revokes every refresh token in the family together
+// with the access tokens that were issued alongside them.
+func RevokeRefreshFamily(family string) error {
+	if family == "" {
+		return nil
+	}
+	o := orm.NewOrm()
+	var tokens []RefreshToken
+	if _, err := o.QueryTable(new(RefreshToken)).Filter("Family", family).All(&tokens); err != nil {
+		return err
+	}
+	for _, t := range tokens {
+		_ = RevokeToken(t.AccessJTI, t.AccessExpiresAt)
+	}
+	_, err := o.QueryTable(new(RefreshToken)).
+		Filter("Family", family).
+		Filter("RevokedAt__isnull", true).
+		Update(orm.Params{"RevokedAt": time.Now()})
+	return err
+}
+
+// RevokeRefreshToken revokes the family the raw token belongs to. Unknown
+// tokens are ignored.
+func RevokeRefreshToken(raw string) error {
+	if raw == "" {
+		return nil
+	}
+	t := RefreshToken{TokenHash: hashToken(raw)}
+	if err := orm.NewOrm().Read(&t); err != nil {
+		if err == orm.ErrNoRows {
+			return nil
+		}
+		return err
+	}
+	return RevokeRefreshFamily(t.Family)
+}
@@ -1,32 +1,48 @@
 package models
 
 import (
+	"context"
 	"errors"
+	"strings"
 
 	"github.com/beego/beego/v2/client/orm"
 )
 
+// Role is a named set of permissions. A role inherits every rule of its
+// Parent (and the parent's parent, and so on). Only OrgScoped roles can be
+// given to organization members, and they never inherit from Superuser.
 type Role struct {
-	Name string `orm:"size(100);pk" json:"name"`
+	Name      string `orm:"size(100);pk" json:"name"`
+	Parent    string `orm:"size(100);default()" json:"parent"`
+	OrgScoped bool   `orm:"default(false)" json:"org_scoped"`
 }
 
 func (r *Role) TableName() string { return "role" }
 
 type UserRole struct {
-	ID    int64  `orm:"auto;column(id)" json:"id"`
-	Email string `orm:"size(191)" json:"email"`
-	Role  string `orm:"size(100)" json:"role"`
+	ID     int64  `orm:"auto;column(id)" json:"id"`
+	UserID string `orm:"size(36);column(user_id)" json:"user_id"`
+	Role   string `orm:"size(100)" json:"role"`
 }
 
 func (ur *UserRole) TableName() string { return "user_role" }
 
+// Permission is an allow or deny rule. Resource and Action may be "*", and
+// a resource ending in "*" matches by prefix (e.g. "reports/*"). Deny rules
+// win over allow rules.
 type Permission struct {
 	ID       int64  `orm:"auto;column(id)" json:"id"`
 	Role     string `orm:"size(100)" json:"role"`
 	Resource string `orm:"size(191)" json:"resource"`
 	Action   string `orm:"size(50)" json:"action"` // read, create, update, delete
+	Effect   string `orm:"size(10);default(allow)" json:"effect"`
 }
 
+const (
+	EffectAllow = "allow"
+	EffectDeny  = "deny"
+)
+
 func (p *Permission) TableName() string { return "permission" }
 
 func EnsureRole(name string) error {
@@ -38,68 +54,330 @@ func EnsureRole(name string) error {
 	return nil
 }
 
-func AssignRole(email, role string) error {
+var (
+	ErrRoleNotFound       = errors.New("role not found")
+	ErrRoleExists         = errors.New("role already exists")
+	ErrRoleProtected      = errors.New("the Superuser role cannot be deleted")
+	ErrPermissionNotFound = errors.New("permission not found")
+	ErrOrgRoleSuperuser   = errors.New("an organization role cannot be or inherit from Superuser")
+)
+
+// GetRole returns the role, or (nil, nil).
+func GetRole(name string) (*Role, error) {
+	r := Role{Name: name}
+	if err := orm.NewOrm().Read(&r); err != nil {
+		if err == orm.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &r, nil
+}
+
+func ListRoles() ([]Role, error) {
+	var out []Role
+	_, err := orm.NewOrm().QueryTable(new(Role)).OrderBy("Name").All(&out)
+	return out, err
+}
+
+// CreateRole adds a new role, optionally inheriting from parent.
+func CreateRole(name, parent string, orgScoped bool) (*Role, error) {
+	if r, err := GetRole(name); err != nil {
+		return nil, err
+	} else if r != nil {
+		return nil, ErrRoleExists
+	}
+	if parent != "" {
+		if p, err := GetRole(parent); err != nil {
+			return nil, err
+		} else if p == nil {
+			return nil, ErrRoleNotFound
+		}
+	}
+	if orgScoped {
+		if isSuperuser(name) {
+			return nil, ErrOrgRoleSuperuser
+		}
+		if inherits, err := InheritsSuperuser(parent); err != nil {
+			return nil, err
+		} else if inherits {
+			return nil, ErrOrgRoleSuperuser
+		}
+	}
+	r := &Role{Name: name, Parent: parent, OrgScoped: orgScoped}
+	if _, err := orm.NewOrm().Insert(r); err != nil {
+		return nil, err
+	}
+	invalidatePermissions()
+	return r, nil
+}
+
+// DeleteRole removes the role with its rules and assignments. Roles that
+// inherited from it lose their parent.
+func DeleteRole(name string) error {
+	if strings.EqualFold(name, "Superuser") {
+		return ErrRoleProtected
+	}
+	err := orm.NewOrm().DoTx(func(ctx context.Context, tx orm.TxOrmer) error {
+		n, err := tx.QueryTable(new(Role)).Filter("Name", name).Delete()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return ErrRoleNotFound
+		}
+		if _, err := tx.QueryTable(new(Permission)).Filter("Role", name).Delete(); err != nil {
+			return err
+		}
+		if _, err := tx.QueryTable(new(UserRole)).Filter("Role", name).Delete(); err != nil {
+			return err
+		}
+		_, err = tx.QueryTable(new(Role)).Filter("Parent", name).Update(orm.Params{"Parent": ""})
+		return err
+	})
+	invalidatePermissions()
+	return err
+}
+
+func AssignRole(userID, role string) error {
 	o := orm.NewOrm()
-	exists := o.QueryTable(new(UserRole)).Filter("Email", email).Filter("Role", role).Exist()
+	exists := o.QueryTable(new(UserRole)).Filter("UserID", userID).Filter("Role", role).Exist()
 	if exists {
 		return nil
 	}
-	_, err := o.Insert(&UserRole{Email: email, Role: role})
+	_, err := o.Insert(&UserRole{UserID: userID, Role: role})
+	invalidatePermissions()
+	// lost a race with a concurrent assignment: the unique index kept one row
+	if err != nil && o.QueryTable(new(UserRole)).Filter("UserID", userID).Filter("Role", role).Exist() {
+		return nil
+	}
 	return err
 }
 
+// UnassignRole removes role from the user; it reports whether it was held.
+func UnassignRole(userID, role string) (bool, error) {
+	n, err := orm.NewOrm().QueryTable(new(UserRole)).Filter("UserID", userID).Filter("Role", role).Delete()
+	invalidatePermissions()
+	return n > 0, err
+}
+
+// ListUserRoles returns the roles assigned directly to the user.
+func ListUserRoles(userID string) ([]string, error) {
+	var rows []UserRole
+	_, err := orm.NewOrm().QueryTable(new(UserRole)).Filter("UserID", userID).OrderBy("Role").All(&rows)
+	out := make([]string, 0, len(rows))
+	for _, r := range rows {
+		out = append(out, r.Role)
+	}
+	return out, err
+}
+
 func Grant(role, resource, action string) error {
-	o := orm.NewOrm()
-	_, err := o.Insert(&Permission{Role: role, Resource: resource, Action: action})
-	return err
+	return addRule(role, resource, action, EffectAllow)
 }
 
-func HasRole(email, role string) (bool, error) {
-	o := orm.NewOrm()
-	cnt, err := o.QueryTable(new(UserRole)).Filter("Email", email).Filter("Role", role).Count()
-	return cnt > 0, err
+// EnsurePermission creates the role if needed and grants it resource and
+// action, unless the role already has a rule for them. It seeds defaults
+// without undoing an administrator's deny rule or adding duplicates.
+func EnsurePermission(role, resource, action string) error {
+	if err := EnsureRole(role); err != nil {
+		return err
+	}
+	if orm.NewOrm().QueryTable(new(Permission)).Filter("Role", role).
+		Filter("Resource", resource).Filter("Action", action).Exist() {
+		return nil
+	}
+	return Grant(role, resource, action)
 }
 
-func HasPermission(email, resource, action string) (bool, error) {
-	o := orm.NewOrm()
-	// superuser role bypass happens at controller level via user flag/role
-	var roles []UserRole
-	_, err := o.QueryTable(new(UserRole)).Filter("Email", email).All(&roles)
+// Revoke deletes the role's rules for resource and action, allow or deny.
+func Revoke(role, resource, action string) (int64, error) {
+	n, err := orm.NewOrm().QueryTable(new(Permission)).Filter("Role", role).
+		Filter("Resource", resource).Filter("Action", action).Delete()
+	invalidatePermissions()
+	return n, err
+}
+
+func ListPermissions(role string) ([]Permission, error) {
+	var out []Permission
+	_, err := orm.NewOrm().QueryTable(new(Permission)).Filter("Role", role).OrderBy("Resource", "Action", "ID").All(&out)
+	return out, err
+}
+
+// RoleRules returns the rules of role and of every role it inherits from.
+func RoleRules(role string) ([]Permission, error) {
+	ancestors, err := roleAncestors(role)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
-	if len(roles) == 0 {
-		return false, nil
-	}
-	for _, ur := range roles {
-		cnt, err := o.QueryTable(new(Permission)).Filter("Role", ur.Role).Filter("Resource", resource).Filter("Action", action).Count()
+	var out []Permission
+	for _, r := range append([]string{role}, ancestors...) {
+		rules, err := ListPermissions(r)
 		if err != nil {
-			return false, err
-		}
-		if cnt > 0 {
-			return true, nil
+			return nil, err
 		}
+		out = append(out, rules...)
 	}
-	return false, nil
+	return out, nil
+}
+
+// UpdatePermission replaces one of the role's rules.
+func UpdatePermission(role string, id int64, resource, action, effect string) (*Permission, error) {
+	p := &Permission{ID: id, Role: role, Resource: resource, Action: action, Effect: effect}
+	n, err := orm.NewOrm().QueryTable(new(Permission)).Filter("ID", id).Filter("Role", role).
+		Update(orm.Params{"Resource": resource, "Action": action, "Effect": effect})
+	invalidatePermissions()
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, ErrPermissionNotFound
+	}
+	return p, nil
 }
 
-func RequirePermission(email, resource, action string) error {
-	ok, err := HasPermission(email, resource, action)
+// DeletePermission removes one of the role's rules by id.
+func DeletePermission(role string, id int64) error {
+	n, err := orm.NewOrm().QueryTable(new(Permission)).Filter("ID", id).Filter("Role", role).Delete()
+	invalidatePermissions()
 	if err != nil {
 		return err
 	}
-	if !ok {
-		return errors.New("forbidden")
+	if n == 0 {
+		return ErrPermissionNotFound
 	}
 	return nil
 }
 
-func MigrateUserEmail(oldEmail, newEmail string) error {
+// Deny adds a rule that overrides any allow for the same resource and action.
+func Deny(role, resource, action string) error {
+	return addRule(role, resource, action, EffectDeny)
+}
+
+func addRule(role, resource, action, effect string) error {
+	_, err := AddPermission(role, resource, action, effect)
+	return err
+}
+
+// AddPermission stores a rule for role and returns it.
+func AddPermission(role, resource, action, effect string) (*Permission, error) {
+	p := &Permission{Role: role, Resource: resource, Action: action, Effect: effect}
+	_, err := orm.NewOrm().Insert(p)
+	invalidatePermissions()
+	return p, err
+}
+
+// SetRoleParent makes role inherit from parent ("" removes the parent).
+// Both roles are created if needed; cycles are rejected, and so is a
+// Superuser ancestor for an organization role.
+func SetRoleParent(role, parent string) error {
+	if parent != "" {
+		if parent == role {
+			return ErrRoleCycle
+		}
+		ancestors, err := roleAncestors(parent)
+		if err != nil {
+			return err
+		}
+		for _, a := range ancestors {
+			if a == role {
+				return ErrRoleCycle
+			}
+		}
+		if r, err := GetRole(role); err != nil {
+			return err
+		} else if r != nil && r.OrgScoped {
+			if inherits, err := InheritsSuperuser(parent); err != nil {
+				return err
+			} else if inherits {
+				return ErrOrgRoleSuperuser
+			}
+		}
+		_ = EnsureRole(parent)
+	}
+	_ = EnsureRole(role)
+	_, err := orm.NewOrm().QueryTable(new(Role)).Filter("Name", role).Update(orm.Params{"Parent": parent})
+	invalidatePermissions()
+	return err
+}
+
+// SetRoleOrgScoped marks the role as one that can be given to organization
+// members, or not. Superuser and the roles inheriting from it cannot be.
+func SetRoleOrgScoped(role string, scoped bool) error {
+	if r, err := GetRole(role); err != nil {
+		return err
+	} else if r == nil {
+		return ErrRoleNotFound
+	}
+	if scoped {
+		if inherits, err := InheritsSuperuser(role); err != nil {
+			return err
+		} else if inherits {
+			return ErrOrgRoleSuperuser
+		}
+	}
+	_, err := orm.NewOrm().QueryTable(new(Role)).Filter("Name", role).Update(orm.Params{"OrgScoped": scoped})
+	invalidatePermissions()
+	return err
+}
+
+// InheritsSuperuser reports whether role is Superuser or inherits from it.
+func InheritsSuperuser(role string) (bool, error) {
+	if role == "" {
+		return false, nil
+	}
+	if isSuperuser(role) {
+		return true, nil
+	}
+	ancestors, err := roleAncestors(role)
+	if err != nil {
+		return false, err
+	}
+	for _, a := range ancestors {
+		if isSuperuser(a) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func isSuperuser(role string) bool {
+	return strings.EqualFold(role, "Superuser")
+}
+
+func HasRole(userID, role string) (bool, error) {
 	o := orm.NewOrm()
-	// update user_roles
-	_, err := o.QueryTable(new(UserRole)).Filter("Email", oldEmail).Update(orm.Params{"Email": newEmail})
+	cnt, err := o.QueryTable(new(UserRole)).Filter("UserID", userID).Filter("Role", role).Count()
+	return cnt > 0, err
+}
+
+// HasPermission evaluates the user's effective rules, including inherited
+// roles and wildcards. Roles that inherit from "Superuser" are allowed
+// everything, like the role itself.
+func HasPermission(userID, resource, action string) (bool, error) {
+	return HasPermissionIn(userID, 0, resource, action)
+}
+
+// HasPermissionIn is HasPermission while acting in organization org.
+func HasPermissionIn(userID string, org int64, resource, action string) (bool, error) {
+	set, err := EffectivePermissionsIn(userID, org)
+	if err != nil {
+		return false, err
+	}
+	return set.Allows(resource, action), nil
+}
+
+func RequirePermission(userID, resource, action string) error {
+	return RequirePermissionIn(userID, 0, resource, action)
+}
+
+func RequirePermissionIn(userID string, org int64, resource, action string) error {
+	ok, err := HasPermissionIn(userID, org, resource, action)
 	if err != nil {
 		return err
 	}
+	if !ok {
+		return errors.New("forbidden")
+	}
 	return nil
 }
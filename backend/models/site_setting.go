@@ -32,6 +32,17 @@ func (s *SiteSetting) Get() (*SiteSetting, error) {
 	return &ss, nil
 }
 
+// MailerSite is the mailer.SiteSettings of the site settings.
+type MailerSite struct{}
+
+func (MailerSite) Site() (name, baseURL string) {
+	s, err := (&SiteSetting{}).Get()
+	if err != nil || s == nil {
+		return "", ""
+	}
+	return s.SiteName, s.BaseURL
+}
+
 func Update(apply func(*SiteSetting) error) error {
 	o := orm.NewOrm()
 	return o.DoTx(func(ctx context.Context, txOrm orm.TxOrmer) error {
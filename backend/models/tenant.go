@@ -0,0 +1,112 @@
+package models
+
+import (
+	"reflect"
+	"sync"
+
+	"github.com/beego/beego/v2/client/orm"
+)
+
+// TenantField is the field every tenant-aware model stores its
+// organization in. 0 is the owner's personal space.
+const TenantField = "OrgID"
+
+// TenantAware is implemented by models whose rows belong to an
+// organization. Register them with RegisterTenantModel.
+type TenantAware interface {
+	TenantID() int64
+	SetTenantID(org int64)
+}
+
+var tenantRegistry struct {
+	sync.Mutex
+	models []TenantAware
+}
+
+// RegisterTenantModel records md so deleting an organization also deletes
+// its rows. Call it next to orm.RegisterModel.
+func RegisterTenantModel(md TenantAware) {
+	tenantRegistry.Lock()
+	defer tenantRegistry.Unlock()
+	tenantRegistry.models = append(tenantRegistry.models, md)
+}
+
+func tenantModels() []TenantAware {
+	tenantRegistry.Lock()
+	defer tenantRegistry.Unlock()
+	return append([]TenantAware(nil), tenantRegistry.models...)
+}
+
+// TenantOrmer is an orm.Ormer confined to one organization. For
+// tenant-aware models, QueryTable only sees the organization's rows,
+// Insert stamps new rows with it, and Read, Update and Delete treat rows of
+// other organizations as missing (orm.ErrNoRows). Other models pass
+// through untouched.
+type TenantOrmer struct {
+	orm.Ormer
+	Org int64
+}
+
+// ForOrg returns an Ormer scoped to org, typically the token's active
+// organization (jwt Claims.Org).
+func ForOrg(org int64) *TenantOrmer {
+	return &TenantOrmer{Ormer: orm.NewOrm(), Org: org}
+}
+
+func (t *TenantOrmer) QueryTable(ptrStructOrTableName interface{}) orm.QuerySeter {
+	qs := t.Ormer.QueryTable(ptrStructOrTableName)
+	if _, ok := ptrStructOrTableName.(TenantAware); ok {
+		qs = qs.Filter(TenantField, t.Org)
+	}
+	return qs
+}
+
+func (t *TenantOrmer) Read(md interface{}, cols ...string) error {
+	if err := t.Ormer.Read(md, cols...); err != nil {
+		return err
+	}
+	if ta, ok := md.(TenantAware); ok && ta.TenantID() != t.Org {
+		return orm.ErrNoRows
+	}
+	return nil
+}
+
+func (t *TenantOrmer) Insert(md interface{}) (int64, error) {
+	if ta, ok := md.(TenantAware); ok {
+		ta.SetTenantID(t.Org)
+	}
+	return t.Ormer.Insert(md)
+}
+
+// owns checks that md's stored row, not the in-memory value, belongs to
+// the organization. Tenant-aware models keep their primary key in ID.
+func (t *TenantOrmer) owns(md interface{}) error {
+	if _, ok := md.(TenantAware); !ok {
+		return nil
+	}
+	v := reflect.Indirect(reflect.ValueOf(md)).FieldByName("ID")
+	if !v.IsValid() || v.IsZero() {
+		return orm.ErrMissPK
+	}
+	if !t.QueryTable(md).Filter("ID", v.Interface()).Exist() {
+		return orm.ErrNoRows
+	}
+	return nil
+}
+
+func (t *TenantOrmer) Update(md interface{}, cols ...string) (int64, error) {
+	if err := t.owns(md); err != nil {
+		return 0, err
+	}
+	if ta, ok := md.(TenantAware); ok {
+		ta.SetTenantID(t.Org)
+	}
+	return t.Ormer.Update(md, cols...)
+}
+
+func (t *TenantOrmer) Delete(md interface{}, cols ...string) (int64, error) {
+	if err := t.owns(md); err != nil {
+		return 0, err
+	}
+	return t.Ormer.Delete(md, cols...)
+}
@@ -4,24 +4,74 @@ import (
 	"time"
 
 	"github.com/beego/beego/v2/client/orm"
+	"github.com/google/uuid"
 )
 
+// User is an account. ID is a random UUID that never changes; Email is
+// unique but may change. Other tables refer to users by ID.
+//
+// A deleted account keeps its row: DeletedAt starts the grace period, and
+// PurgeDeletedUsers later removes its data and anonymizes the row.
 type User struct {
-	Email        string    `orm:"size(191);pk" json:"email"`
-	FirstName    string    `orm:"size(100)" json:"first_name"`
-	LastName     string    `orm:"size(100)" json:"last_name"`
-	PasswordHash string    `orm:"size(255)" json:"-"`
-	CreatedAt    time.Time `orm:"auto_now_add;type(datetime)" json:"created_at"`
-	UpdatedAt    time.Time `orm:"auto_now;type(datetime)" json:"updated_at"`
-	IsSuperuser  bool      `orm:"default(false)" json:"is_superuser"`
+	ID                    string     `orm:"size(36);pk;column(id)" json:"id"`
+	Email                 string     `orm:"size(191);unique" json:"email"`
+	FirstName             string     `orm:"size(100)" json:"first_name"`
+	LastName              string     `orm:"size(100)" json:"last_name"`
+	Avatar                string     `orm:"size(255)" json:"avatar"`  // path of the uploaded image
+	Locale                string     `orm:"size(35)" json:"locale"`   // BCP 47 tag, e.g. "en-GB"
+	Timezone              string     `orm:"size(64)" json:"timezone"` // IANA name, e.g. "Europe/London"
+	PasswordHash          string     `orm:"size(255)" json:"-"`
+	CreatedAt             time.Time  `orm:"auto_now_add;type(datetime)" json:"created_at"`
+	UpdatedAt             time.Time  `orm:"auto_now;type(datetime)" json:"updated_at"`
+	IsSuperuser           bool       `orm:"default(false)" json:"is_superuser"`
+	DisabledAt            *time.Time `orm:"null;type(datetime)" json:"disabled_at,omitempty"` // set by an administrator
+	PasswordResetRequired bool       `orm:"default(false)" json:"password_reset_required"`    // blocks password sign-in until reset
+	DeletedAt             *time.Time `orm:"null;type(datetime);index" json:"deleted_at,omitempty"`
+	PurgedAt              *time.Time `orm:"null;type(datetime)" json:"purged_at,omitempty"`
 }
 
 func (u *User) TableName() string { return "user" }
 
+// CreateUser inserts u, giving it a new ID unless one is set.
+func CreateUser(u *User) error {
+	if u.ID == "" {
+		u.ID = uuid.NewString()
+	}
+	_, err := orm.NewOrm().Insert(u)
+	return err
+}
+
+// GetUserByID returns the user, or nil if there is none. Deleted and
+// disabled accounts count as missing, so they cannot sign in or be found.
+func GetUserByID(id string) (*User, error) {
+	return getActiveUser("ID", id)
+}
+
+// FindUser is GetUserByID including disabled accounts and those pending
+// deletion, for administration.
+func FindUser(id string) (*User, error) {
+	u := User{ID: id}
+	err := orm.NewOrm().Read(&u)
+	if err == orm.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// GetUserByEmail is GetUserByID by address.
 func GetUserByEmail(email string) (*User, error) {
-	o := orm.NewOrm()
-	u := User{Email: email}
-	err := o.Read(&u)
+	return getActiveUser("Email", email)
+}
+
+func getActiveUser(field, value string) (*User, error) {
+	var u User
+	err := orm.NewOrm().QueryTable(new(User)).Filter(field, value).
+		Filter("DeletedAt__isnull", true).
+		Filter("DisabledAt__isnull", true).
+		One(&u)
 	if err == orm.ErrNoRows {
 		return nil, nil
 	}
@@ -30,3 +80,9 @@ func GetUserByEmail(email string) (*User, error) {
 	}
 	return &u, nil
 }
+
+// EmailInUse reports whether any account, including one pending deletion,
+// holds email.
+func EmailInUse(email string) bool {
+	return orm.NewOrm().QueryTable(new(User)).Filter("Email", email).Exist()
+}
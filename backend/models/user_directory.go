@@ -0,0 +1,133 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"github.com/beego/beego/v2/client/orm"
+)
+
+// UserFilter narrows SearchUsers. Query is split into words, each of which
+// must appear in the email, first or last name; nil flags match both ways.
+type UserFilter struct {
+	Query     string
+	Verified  *bool
+	Superuser *bool
+	Disabled  *bool
+	Role      string // directly assigned role
+}
+
+// UserSummary is a user as listed in the admin directory.
+type UserSummary struct {
+	User
+	Verified bool     `json:"verified"`
+	Roles    []string `json:"roles"`
+}
+
+// likeEscaper escapes LIKE wildcards for ESCAPE '!'.
+var likeEscaper = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+
+// SearchUsers returns a page of the accounts matching f, newest first, with
+// the total number of matches. Accounts pending deletion are left out.
+func SearchUsers(f UserFilter, limit, offset int64) ([]UserSummary, int64, error) {
+	where := []string{"u.`deleted_at` IS NULL"}
+	var args []any
+	for _, word := range strings.Fields(strings.ToLower(f.Query)) {
+		like := "%" + likeEscaper.Replace(word) + "%"
+		where = append(where, "(LOWER(u.`email`) LIKE ? ESCAPE '!' OR LOWER(u.`first_name`) LIKE ? ESCAPE '!' OR LOWER(u.`last_name`) LIKE ? ESCAPE '!')")
+		args = append(args, like, like, like)
+	}
+	if f.Verified != nil {
+		cond := "EXISTS (SELECT 1 FROM `verified_user` v WHERE v.`user_id` = u.`id` AND v.`email` = u.`email`)"
+		if !*f.Verified {
+			cond = "NOT " + cond
+		}
+		where = append(where, cond)
+	}
+	if f.Superuser != nil {
+		where = append(where, "u.`is_superuser` = ?")
+		args = append(args, *f.Superuser)
+	}
+	if f.Disabled != nil {
+		if *f.Disabled {
+			where = append(where, "u.`disabled_at` IS NOT NULL")
+		} else {
+			where = append(where, "u.`disabled_at` IS NULL")
+		}
+	}
+	if f.Role != "" {
+		where = append(where, "EXISTS (SELECT 1 FROM `user_role` r WHERE r.`user_id` = u.`id` AND r.`role` = ?)")
+		args = append(args, f.Role)
+	}
+	cond := strings.Join(where, " AND ")
+
+	o := orm.NewOrm()
+	var total int64
+	if err := o.Raw("SELECT COUNT(*) FROM `user` u WHERE "+cond, args...).QueryRow(&total); err != nil {
+		return nil, 0, err
+	}
+	var users []User
+	_, err := o.Raw("SELECT u.* FROM `user` u WHERE "+cond+" ORDER BY u.`created_at` DESC, u.`id` LIMIT ? OFFSET ?",
+		append(args, limit, offset)...).QueryRows(&users)
+	if err != nil {
+		return nil, 0, err
+	}
+	out := make([]UserSummary, len(users))
+	if len(users) == 0 {
+		return out, total, nil
+	}
+	ids := make([]string, len(users))
+	for i, u := range users {
+		ids[i] = u.ID
+	}
+	var verified []VerifiedUser
+	if _, err := o.QueryTable(new(VerifiedUser)).Filter("UserID__in", ids).All(&verified); err != nil {
+		return nil, 0, err
+	}
+	verifiedEmail := map[string]string{}
+	for _, v := range verified {
+		verifiedEmail[v.UserID] = v.Email
+	}
+	var assigned []UserRole
+	if _, err := o.QueryTable(new(UserRole)).Filter("UserID__in", ids).OrderBy("Role").All(&assigned); err != nil {
+		return nil, 0, err
+	}
+	roles := map[string][]string{}
+	for _, r := range assigned {
+		roles[r.UserID] = append(roles[r.UserID], r.Role)
+	}
+	for i, u := range users {
+		out[i] = UserSummary{User: u, Verified: verifiedEmail[u.ID] == u.Email, Roles: roles[u.ID]}
+		if out[i].Roles == nil {
+			out[i].Roles = []string{}
+		}
+	}
+	return out, total, nil
+}
+
+// SetUserDisabled disables or re-enables the account. Disabling signs it
+// out everywhere.
+func SetUserDisabled(userID string, disabled bool) error {
+	var at any
+	if disabled {
+		at = time.Now()
+	}
+	if _, err := orm.NewOrm().QueryTable(new(User)).Filter("ID", userID).Update(orm.Params{"DisabledAt": at}); err != nil {
+		return err
+	}
+	if disabled {
+		_, err := RevokeOtherSessions(userID, "")
+		return err
+	}
+	return nil
+}
+
+// RequirePasswordReset blocks password sign-in for the user until the
+// password is reset, and signs the account out everywhere.
+func RequirePasswordReset(userID string) error {
+	if _, err := orm.NewOrm().QueryTable(new(User)).Filter("ID", userID).Update(orm.Params{"PasswordResetRequired": true}); err != nil {
+		return err
+	}
+	_, err := RevokeOtherSessions(userID, "")
+	return err
+}
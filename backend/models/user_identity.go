@@ -0,0 +1,60 @@
+package models
+
+import (
+	"time"
+
+	"github.com/beego/beego/v2/client/orm"
+)
+
+// UserIdentity links an external (OAuth/OIDC) account to a user. A provider
+// subject maps to exactly one user; a user may link several providers.
+type UserIdentity struct {
+	ID          int64     `orm:"auto;column(id)" json:"id"`
+	Provider    string    `orm:"size(64)" json:"provider"`
+	Subject     string    `orm:"size(191)" json:"subject"`
+	UserID      string    `orm:"size(36);column(user_id);index" json:"user_id"`
+	CreatedAt   time.Time `orm:"auto_now_add;type(datetime)" json:"created_at"`
+	LastLoginAt time.Time `orm:"type(datetime)" json:"last_login_at"`
+}
+
+func (i *UserIdentity) TableName() string { return "user_identity" }
+
+func (i *UserIdentity) TableUnique() [][]string {
+	return [][]string{{"Provider", "Subject"}}
+}
+
+// GetUserIdentity returns the link for a provider subject, or (nil, nil).
+func GetUserIdentity(provider, subject string) (*UserIdentity, error) {
+	var i UserIdentity
+	err := orm.NewOrm().QueryTable(new(UserIdentity)).
+		Filter("Provider", provider).Filter("Subject", subject).One(&i)
+	if err == orm.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &i, nil
+}
+
+// LinkUserIdentity attaches a provider subject to the user.
+func LinkUserIdentity(userID, provider, subject string) (*UserIdentity, error) {
+	i := &UserIdentity{Provider: provider, Subject: subject, UserID: userID, LastLoginAt: time.Now()}
+	if _, err := orm.NewOrm().Insert(i); err != nil {
+		return nil, err
+	}
+	return i, nil
+}
+
+// TouchUserIdentity records a login through the identity.
+func TouchUserIdentity(i *UserIdentity) error {
+	i.LastLoginAt = time.Now()
+	_, err := orm.NewOrm().Update(i, "LastLoginAt")
+	return err
+}
+
+func ListUserIdentities(userID string) ([]UserIdentity, error) {
+	var out []UserIdentity
+	_, err := orm.NewOrm().QueryTable(new(UserIdentity)).Filter("UserID", userID).OrderBy("Provider").All(&out)
+	return out, err
+}
@@ -0,0 +1,253 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/beego/beego/v2/client/orm"
+	"github.com/google/uuid"
+
+	"github.com/mymi14s/goconda/utils/migrate"
+)
+
+// legacySuffix names the copies of the email-keyed tables kept while their
+// rows are moved to the new schema.
+const legacySuffix = "_legacy"
+
+// UserRelation describes a table that referred to users by email before
+// users had IDs, for the migration to rewrite.
+type UserRelation struct {
+	Table string
+	// Columns maps each user ID column to the email column it replaces
+	// (which may have the same name).
+	Columns map[string]string
+	// Optional lists columns whose user may no longer exist. Such rows are
+	// kept with an empty ID; otherwise they are dropped.
+	Optional []string
+}
+
+var userRelations = []UserRelation{
+	{Table: "refresh_token", Columns: map[string]string{"user_id": "email"}},
+	{Table: "user_session", Columns: map[string]string{"user_id": "email"}},
+	{Table: "user_mfa", Columns: map[string]string{"user_id": "email"}},
+	{Table: "mfa_recovery_code", Columns: map[string]string{"user_id": "email"}},
+	{Table: "user_identity", Columns: map[string]string{"user_id": "email"}},
+	{Table: "webauthn_credential", Columns: map[string]string{"user_id": "email"}},
+	{Table: "password_history", Columns: map[string]string{"user_id": "email"}},
+	{Table: "email_verification_token", Columns: map[string]string{"user_id": "email"}},
+	{Table: "verified_user", Columns: map[string]string{"user_id": "email"}},
+	{Table: "password_reset_token", Columns: map[string]string{"user_id": "email"}},
+	{Table: "magic_link_token", Columns: map[string]string{"user_id": "email"}},
+	{Table: "user_role", Columns: map[string]string{"user_id": "email"}},
+	{Table: "organization", Columns: map[string]string{"created_by": "created_by"}, Optional: []string{"created_by"}},
+	{Table: "org_membership", Columns: map[string]string{"user_id": "email"}},
+	{Table: "org_invitation", Columns: map[string]string{"invited_by": "invited_by"}, Optional: []string{"invited_by"}},
+	{Table: "personal_access_token", Columns: map[string]string{"user_id": "email"}},
+}
+
+// RegisterUserRelation adds an app table to the user ID migration. Register
+// parent tables before the tables that reference them.
+func RegisterUserRelation(r UserRelation) {
+	userRelations = append(userRelations, r)
+}
+
+// userIDsPrepareUp runs before the baselines. When the database still keys
+// users by email, it renames the user table and every table that refers to
+// users out of the way, so the baselines create them afresh. A run that
+// stopped halfway (MySQL has no transactional DDL) is resumed: tables
+// already renamed are skipped.
+func userIDsPrepareUp(db migrate.DB) error {
+	cols, err := tableColumns(db, "user")
+	if err != nil {
+		return err
+	}
+	legacy, err := tableColumns(db, "user"+legacySuffix)
+	if err != nil {
+		return err
+	}
+	if len(legacy) == 0 && (len(cols) == 0 || cols["id"]) {
+		return nil
+	}
+	for _, table := range migrationTables() {
+		if cols, err := tableColumns(db, table+legacySuffix); err != nil {
+			return err
+		} else if len(cols) > 0 {
+			continue
+		}
+		if cols, err := tableColumns(db, table); err != nil {
+			return err
+		} else if len(cols) == 0 {
+			continue
+		}
+		if err := dropIndexes(db, table); err != nil {
+			return err
+		}
+		if _, err := db.Raw(fmt.Sprintf("ALTER TABLE `%s` RENAME TO `%s%s`", table, table, legacySuffix)).Exec(); err != nil {
+			return fmt.Errorf("rename %s: %w", table, err)
+		}
+	}
+	return nil
+}
+
+// userIDsUp runs after the baselines of every app. It gives every user from
+// the renamed tables a new ID, copies the other rows with their email
+// columns replaced by user IDs, and drops the renamed tables. On SQLite it
+// runs in the migration's transaction; elsewhere a second run skips the
+// rows already copied, and user_legacy is dropped last so that it does run.
+func userIDsUp(db migrate.DB) error {
+	if cols, err := tableColumns(db, "user"+legacySuffix); err != nil || len(cols) == 0 {
+		return err
+	}
+	if err := copyLegacyUsers(db); err != nil {
+		return err
+	}
+	for _, r := range userRelations {
+		if err := copyLegacyRelation(db, r); err != nil {
+			return fmt.Errorf("migrate %s: %w", r.Table, err)
+		}
+	}
+	// children first, so nothing references a dropped table
+	tables := migrationTables()
+	for i := len(tables) - 1; i >= 0; i-- {
+		if cols, err := tableColumns(db, tables[i]+legacySuffix); err != nil {
+			return err
+		} else if len(cols) == 0 {
+			continue
+		}
+		if _, err := db.Raw(fmt.Sprintf("DROP TABLE `%s%s`", tables[i], legacySuffix)).Exec(); err != nil {
+			return fmt.Errorf("drop %s%s: %w", tables[i], legacySuffix, err)
+		}
+	}
+	return nil
+}
+
+// migrationTables lists the user table followed by its relations.
+func migrationTables() []string {
+	out := []string{"user"}
+	for _, r := range userRelations {
+		out = append(out, r.Table)
+	}
+	return out
+}
+
+// copyLegacyUsers gives each legacy user not copied yet a new ID.
+func copyLegacyUsers(o orm.QueryExecutor) error {
+	shared, err := sharedColumns(o, "user", nil)
+	if err != nil {
+		return err
+	}
+	var emails orm.ParamsList
+	if _, err := o.Raw("SELECT l.`email` FROM `user" + legacySuffix + "` l WHERE NOT EXISTS (SELECT 1 FROM `user` u WHERE u.`email` = l.`email`)").ValuesFlat(&emails); err != nil {
+		return err
+	}
+	list := "`" + strings.Join(shared, "`, `") + "`"
+	stmt := fmt.Sprintf("INSERT INTO `user` (`id`, %s) SELECT ?, %s FROM `user%s` WHERE `email` = ?", list, list, legacySuffix)
+	for _, email := range emails {
+		if _, err := o.Raw(stmt, uuid.NewString(), email).Exec(); err != nil {
+			return fmt.Errorf("migrate user %v: %w", email, err)
+		}
+	}
+	return nil
+}
+
+// copyLegacyRelation copies r's legacy rows, looking up each user ID by the
+// old email. An empty email (e.g. a share made to a role) stays empty. Rows
+// whose key was already copied are skipped.
+func copyLegacyRelation(o orm.QueryExecutor, r UserRelation) error {
+	legacy := r.Table + legacySuffix
+	if cols, err := tableColumns(o, legacy); err != nil || len(cols) == 0 {
+		return err
+	}
+	shared, err := sharedColumns(o, r.Table, r.Columns)
+	if err != nil {
+		return err
+	}
+	targets := append([]string{}, shared...)
+	sources := make([]string, 0, len(shared)+len(r.Columns))
+	for _, c := range shared {
+		sources = append(sources, "l.`"+c+"`")
+	}
+	optional := map[string]bool{}
+	for _, c := range r.Optional {
+		optional[c] = true
+	}
+	var where []string
+	for to, from := range r.Columns {
+		lookup := fmt.Sprintf("(SELECT u.`id` FROM `user` u WHERE u.`email` = l.`%s`)", from)
+		targets = append(targets, to)
+		sources = append(sources, fmt.Sprintf("COALESCE(%s, '')", lookup))
+		if !optional[to] {
+			where = append(where, fmt.Sprintf("(l.`%s` = '' OR %s IS NOT NULL)", from, lookup))
+		}
+	}
+	insert := "INSERT OR IGNORE"
+	if o.Driver().Type() == orm.DRMySQL {
+		insert = "INSERT IGNORE"
+	}
+	stmt := fmt.Sprintf("%s INTO `%s` (`%s`) SELECT %s FROM `%s` l",
+		insert, r.Table, strings.Join(targets, "`, `"), strings.Join(sources, ", "), legacy)
+	if len(where) > 0 {
+		stmt += " WHERE " + strings.Join(where, " AND ")
+	}
+	_, err = o.Raw(stmt).Exec()
+	return err
+}
+
+// sharedColumns returns the columns table and its legacy copy have in
+// common, except the user ID columns in mapped.
+func sharedColumns(o orm.QueryExecutor, table string, mapped map[string]string) ([]string, error) {
+	now, err := tableColumns(o, table)
+	if err != nil {
+		return nil, err
+	}
+	old, err := tableColumns(o, table+legacySuffix)
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for c := range now {
+		if _, skip := mapped[c]; !skip && old[c] {
+			out = append(out, c)
+		}
+	}
+	return out, nil
+}
+
+// tableColumns returns the table's column names, or none if it does not
+// exist.
+func tableColumns(o orm.QueryExecutor, table string) (map[string]bool, error) {
+	var names orm.ParamsList
+	var err error
+	if o.Driver().Type() == orm.DRMySQL {
+		_, err = o.Raw("SELECT column_name FROM information_schema.columns WHERE table_schema = DATABASE() AND table_name = ?", table).ValuesFlat(&names)
+	} else {
+		_, err = o.Raw("SELECT name FROM pragma_table_info(?)", table).ValuesFlat(&names)
+	}
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]bool, len(names))
+	for _, n := range names {
+		out[strings.ToLower(fmt.Sprint(n))] = true
+	}
+	return out, nil
+}
+
+// dropIndexes removes the table's named indexes on SQLite, where index
+// names are global and would clash with those the baselines create for the
+// new table. MySQL index names are per table.
+func dropIndexes(o orm.QueryExecutor, table string) error {
+	if o.Driver().Type() == orm.DRMySQL {
+		return nil
+	}
+	var names orm.ParamsList
+	if _, err := o.Raw("SELECT name FROM sqlite_master WHERE type = 'index' AND tbl_name = ? AND sql IS NOT NULL", table).ValuesFlat(&names); err != nil {
+		return err
+	}
+	for _, n := range names {
+		if _, err := o.Raw(fmt.Sprintf("DROP INDEX `%v`", n)).Exec(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
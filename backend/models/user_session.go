@@ -0,0 +1,148 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/beego/beego/v2/client/orm"
+)
+
+// ErrSessionNotFound is returned when a JTI does not belong to the user.
+var ErrSessionNotFound = errors.New("session not found")
+
+// sessionTouchInterval throttles last-seen writes to one per interval.
+const sessionTouchInterval = time.Minute
+
+// UserSession records an issued access token so users can see and revoke
+// where they are signed in.
+type UserSession struct {
+	JTI        string     `orm:"pk;size(191);column(jti)" json:"jti"`
+	UserID     string     `orm:"size(36);column(user_id);index" json:"-"`
+	UserAgent  string     `orm:"size(512)" json:"user_agent"`
+	IP         string     `orm:"size(64);column(ip)" json:"ip"`
+	IssuedAt   time.Time  `orm:"type(datetime)" json:"issued_at"`
+	LastSeenAt time.Time  `orm:"type(datetime)" json:"last_seen_at"`
+	ExpiresAt  time.Time  `orm:"type(datetime);index" json:"expires_at"`
+	RevokedAt  *time.Time `orm:"null;type(datetime)" json:"-"`
+}
+
+func (s *UserSession) TableName() string { return "user_session" }
+
+func RecordSession(jti, userID, userAgent, ip string, issuedAt, expiresAt time.Time) error {
+	if jti == "" {
+		return nil
+	}
+	if len(userAgent) > 512 {
+		userAgent = userAgent[:512]
+	}
+	_, err := orm.NewOrm().Insert(&UserSession{
+		JTI:        jti,
+		UserID:     userID,
+		UserAgent:  userAgent,
+		IP:         ip,
+		IssuedAt:   issuedAt,
+		LastSeenAt: issuedAt,
+		ExpiresAt:  expiresAt,
+	})
+	return err
+}
+
+// TouchSession bumps last-seen, at most once per sessionTouchInterval.
+func TouchSession(jti string) error {
+	if jti == "" {
+		return nil
+	}
+	now := time.Now()
+	_, err := orm.NewOrm().QueryTable(new(UserSession)).
+		Filter("JTI", jti).
+		Filter("LastSeenAt__lt", now.Add(-sessionTouchInterval)).
+		Update(orm.Params{"LastSeenAt": now})
+	return err
+}
+
+// ReplaceSession carries a session over to the access token issued by a
+// refresh: the new row keeps the original sign-in time and the old row is
+// dropped. The old access token stays revocable through its refresh family.
+func ReplaceSession(oldJTI, newJTI string) error {
+	if oldJTI == "" || newJTI == "" {
+		return nil
+	}
+	o := orm.NewOrm()
+	old := UserSession{JTI: oldJTI}
+	if err := o.Read(&old); err != nil {
+		if err == orm.ErrNoRows {
+			return nil
+		}
+		return err
+	}
+	if _, err := o.QueryTable(new(UserSession)).Filter("JTI", newJTI).Update(orm.Params{"IssuedAt": old.IssuedAt}); err != nil {
+		return err
+	}
+	_, err := o.Delete(&old)
+	return err
+}
+
+// ListSessions returns the user's live sessions, most recently used first.
+func ListSessions(userID string) ([]UserSession, error) {
+	var out []UserSession
+	_, err := orm.NewOrm().QueryTable(new(UserSession)).
+		Filter("UserID", userID).
+		Filter("RevokedAt__isnull", true).
+		Filter("ExpiresAt__gt", time.Now()).
+		OrderBy("-LastSeenAt").
+		All(&out)
+	return out, err
+}
+
+// RevokeSession revokes one of the user's sessions: its access token, the
+// refresh family it belongs to, and the session row itself.
+func RevokeSession(userID, jti string) error {
+	o := orm.NewOrm()
+	s := UserSession{JTI: jti}
+	if err := o.Read(&s); err != nil {
+		if err == orm.ErrNoRows {
+			return ErrSessionNotFound
+		}
+		return err
+	}
+	if s.UserID != userID {
+		return ErrSessionNotFound
+	}
+	return revokeSession(o, &s)
+}
+
+// RevokeOtherSessions revokes every session of the user except keepJTI
+// (pass "" to revoke all of them) and returns how many were revoked.
+func RevokeOtherSessions(userID, keepJTI string) (int, error) {
+	o := orm.NewOrm()
+	var sessions []UserSession
+	qs := o.QueryTable(new(UserSession)).Filter("UserID", userID).Filter("RevokedAt__isnull", true)
+	if keepJTI != "" {
+		qs = qs.Exclude("JTI", keepJTI)
+	}
+	if _, err := qs.All(&sessions); err != nil {
+		return 0, err
+	}
+	for i := range sessions {
+		if err := revokeSession(o, &sessions[i]); err != nil {
+			return i, err
+		}
+	}
+	return len(sessions), nil
+}
+
+func revokeSession(o orm.Ormer, s *UserSession) error {
+	if err := RevokeToken(s.JTI, s.ExpiresAt); err != nil {
+		return err
+	}
+	var rt RefreshToken
+	if err := o.QueryTable(new(RefreshToken)).Filter("AccessJTI", s.JTI).One(&rt); err == nil {
+		if err := RevokeRefreshFamily(rt.Family); err != nil {
+			return err
+		}
+	}
+	now := time.Now()
+	s.RevokedAt = &now
+	_, err := o.Update(s, "RevokedAt")
+	return err
+}
@@ -9,8 +9,11 @@ import (
 	"github.com/beego/beego/v2/client/orm"
 )
 
+// EmailVerificationToken proves the user controls Email, the address it
+// was sent to.
 type EmailVerificationToken struct {
 	Token     string     `orm:"size(64);pk" json:"token"`
+	UserID    string     `orm:"size(36);column(user_id);index" json:"user_id"`
 	Email     string     `orm:"size(191)" json:"email"`
 	ExpiresAt time.Time  `orm:"type(datetime)" json:"expires_at"`
 	UsedAt    *time.Time `orm:"null;type(datetime)" json:"used_at"`
@@ -19,8 +22,11 @@ type EmailVerificationToken struct {
 
 func (t *EmailVerificationToken) TableName() string { return "email_verification_token" }
 
+// VerifiedUser records the address a user verified. Changing the address
+// makes the user unverified again.
 type VerifiedUser struct {
-	Email      string    `orm:"size(191);pk" json:"email"`
+	UserID     string    `orm:"size(36);pk;column(user_id)" json:"user_id"`
+	Email      string    `orm:"size(191)" json:"email"`
 	VerifiedAt time.Time `orm:"type(datetime)" json:"verified_at"`
 }
 
@@ -34,14 +40,16 @@ func randomToken(n int) (string, error) {
 	return hex.EncodeToString(b), nil
 }
 
-func CreateVerificationToken(email string, ttl time.Duration) (*EmailVerificationToken, error) {
+// CreateVerificationToken issues a token for u's current address.
+func CreateVerificationToken(u *User, ttl time.Duration) (*EmailVerificationToken, error) {
 	tok, err := randomToken(16)
 	if err != nil {
 		return nil, err
 	}
 	t := &EmailVerificationToken{
 		Token:     tok,
-		Email:     email,
+		UserID:    u.ID,
+		Email:     u.Email,
 		ExpiresAt: time.Now().Add(ttl),
 	}
 	o := orm.NewOrm()
@@ -51,47 +59,51 @@ func CreateVerificationToken(email string, ttl time.Duration) (*EmailVerificatio
 	return t, nil
 }
 
-func ConsumeVerificationToken(token string) (string, error) {
+// ConsumeVerificationToken uses up token and returns it, so the caller can
+// mark its address verified.
+func ConsumeVerificationToken(token string) (*EmailVerificationToken, error) {
 	o := orm.NewOrm()
 	t := EmailVerificationToken{Token: token}
 	if err := o.Read(&t); err != nil {
-		return "", err
+		return nil, err
 	}
 	if t.UsedAt != nil {
-		return "", errors.New("token already used")
+		return nil, errors.New("token already used")
 	}
 	if time.Now().After(t.ExpiresAt) {
-		return "", errors.New("token expired")
+		return nil, errors.New("token expired")
 	}
 	now := time.Now()
 	t.UsedAt = &now
 	if _, err := o.Update(&t, "UsedAt"); err != nil {
-		return "", err
+		return nil, err
 	}
-	return t.Email, nil
+	return &t, nil
 }
 
-func MarkUserVerified(email string) error {
+// MarkUserVerified records that the user controls email.
+func MarkUserVerified(userID, email string) error {
 	o := orm.NewOrm()
-	v := &VerifiedUser{Email: email, VerifiedAt: time.Now()}
+	v := &VerifiedUser{UserID: userID, Email: email, VerifiedAt: time.Now()}
 	// Upsert behavior: try insert; if exists, update time
 	if _, err := o.Insert(v); err != nil {
 		// try update
-		if _, err2 := o.Update(v, "VerifiedAt"); err2 != nil {
+		if _, err2 := o.Update(v, "Email", "VerifiedAt"); err2 != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func IsUserVerified(email string) (bool, error) {
+// IsUserVerified reports whether u verified its current address.
+func IsUserVerified(u *User) (bool, error) {
 	o := orm.NewOrm()
-	v := VerifiedUser{Email: email}
+	v := VerifiedUser{UserID: u.ID}
 	if err := o.Read(&v); err != nil {
 		if err == orm.ErrNoRows {
 			return false, nil
 		}
 		return false, err
 	}
-	return true, nil
+	return v.Email == u.Email, nil
 }
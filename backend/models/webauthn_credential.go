@@ -0,0 +1,89 @@
+package models
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"time"
+
+	"github.com/beego/beego/v2/client/orm"
+)
+
+var ErrWebAuthnCredentialNotFound = errors.New("passkey not found")
+
+// WebAuthnCredential is a registered passkey. ID, UserHandle and PublicKey
+// (a COSE key) are stored base64url encoded.
+type WebAuthnCredential struct {
+	ID             string     `orm:"size(255);pk;column(id)" json:"id"`
+	UserID         string     `orm:"size(36);column(user_id);index" json:"user_id"`
+	UserHandle     string     `orm:"size(128)" json:"-"`
+	PublicKey      string     `orm:"type(text)" json:"-"`
+	SignCount      int64      `orm:"default(0)" json:"-"`
+	AAGUID         string     `orm:"size(64);column(aaguid)" json:"aaguid"`
+	Name           string     `orm:"size(100)" json:"name"`
+	BackupEligible bool       `orm:"default(false)" json:"backup_eligible"`
+	CreatedAt      time.Time  `orm:"auto_now_add;type(datetime)" json:"created_at"`
+	LastUsedAt     *time.Time `orm:"null;type(datetime)" json:"last_used_at"`
+}
+
+func (c *WebAuthnCredential) TableName() string { return "webauthn_credential" }
+
+// WebAuthnUserHandle returns the opaque WebAuthn user id for the user: the
+// one its passkeys already use, or a new random one.
+func WebAuthnUserHandle(userID string) (string, error) {
+	var c WebAuthnCredential
+	err := orm.NewOrm().QueryTable(new(WebAuthnCredential)).Filter("UserID", userID).Limit(1).One(&c, "UserHandle")
+	if err == nil && c.UserHandle != "" {
+		return c.UserHandle, nil
+	}
+	if err != nil && err != orm.ErrNoRows {
+		return "", err
+	}
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func CreateWebAuthnCredential(c *WebAuthnCredential) error {
+	_, err := orm.NewOrm().Insert(c)
+	return err
+}
+
+// GetWebAuthnCredential returns the credential with id, or (nil, nil).
+func GetWebAuthnCredential(id string) (*WebAuthnCredential, error) {
+	c := WebAuthnCredential{ID: id}
+	if err := orm.NewOrm().Read(&c); err != nil {
+		if err == orm.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &c, nil
+}
+
+func ListWebAuthnCredentials(userID string) ([]WebAuthnCredential, error) {
+	var out []WebAuthnCredential
+	_, err := orm.NewOrm().QueryTable(new(WebAuthnCredential)).Filter("UserID", userID).OrderBy("CreatedAt").All(&out)
+	return out, err
+}
+
+// MarkWebAuthnCredentialUsed stores the new signature counter after a login.
+func MarkWebAuthnCredentialUsed(id string, signCount int64) error {
+	_, err := orm.NewOrm().QueryTable(new(WebAuthnCredential)).Filter("ID", id).
+		Update(orm.Params{"SignCount": signCount, "LastUsedAt": time.Now()})
+	return err
+}
+
+// DeleteWebAuthnCredential removes one of the user's passkeys.
+func DeleteWebAuthnCredential(userID, id string) error {
+	n, err := orm.NewOrm().QueryTable(new(WebAuthnCredential)).Filter("ID", id).Filter("UserID", userID).Delete()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrWebAuthnCredentialNotFound
+	}
+	return nil
+}
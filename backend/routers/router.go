@@ -3,43 +3,146 @@ package routers
 import (
 	"github.com/beego/beego/v2/server/web"
 
-	frontend "github.com/mymi14s/goconda/apps/frontend/controllers"
-	items "github.com/mymi14s/goconda/apps/items/controllers"
 	"github.com/mymi14s/goconda/controllers"
 	"github.com/mymi14s/goconda/middleware"
 )
 
 func init() {
+	// Routes capture SessionOn when registered, so enable it before any
+	// Router call; WebAuthn keeps its ceremony challenge in the session.
+	web.BConfig.WebConfig.Session.SessionOn = true
 
 	// Bridge cookie -> Authorization for BFF sessions
 	middleware.SetupCookieAuthBridge()
 
 	middleware.ProtectMany(
+		"/api/v1/auth/logout",
+		"/api/v1/auth/change-password",
+		"/api/v1/auth/change-email",
+		"/api/v1/auth/mfa/enroll",
+		"/api/v1/auth/mfa/enroll/verify",
+		"/api/v1/auth/mfa/disable",
+		"/api/v1/auth/mfa/recovery-codes",
 		"/api/v1/users/me",
-		"/api/v1/items",
-		"/api/v1/items/*", // covers /items/:id paths
 		"/api/v1/upload",
+		"/api/v1/auth/sessions",
+		"/api/v1/auth/sessions/*",
+		"/api/v1/auth/tokens",
+		"/api/v1/auth/tokens/*",
+		"/api/v1/auth/webauthn/register/*",
+		"/api/v1/auth/webauthn/credentials",
+		"/api/v1/auth/webauthn/credentials/*",
+		"/api/v1/admin",
+		"/api/v1/admin/*",
+		"/api/v1/orgs",
+		"/api/v1/orgs/*",
 	)
 
-	web.Router("/", &frontend.FrontendController{}, "get:Index")
-	web.Router("/frontend/api/get-info", &frontend.FrontendController{}, "get:GetInfo")
-	web.Router("/frontend/api/contact-form", &frontend.FrontendController{}, "post:ContactForm")
+	// reachable without an access token; they authenticate by other means
+	// (password, emailed token, OAuth state, passkey) or only start a flow
+	middleware.Public(
+		"/api/v1/auth/register",
+		"/api/v1/auth/login",
+		"/api/v1/auth/refresh",
+		"/api/v1/auth/forgot-password",
+		"/api/v1/auth/reset-password",
+		"/api/v1/auth/send-verification",
+		"/api/v1/auth/verify",
+		"/api/v1/auth/change-email/confirm",
+		"/api/v1/auth/change-email/revert",
+		"/api/v1/auth/magic-link",
+		"/api/v1/auth/magic-link/consume",
+		"/api/v1/auth/mfa/verify",
+		"/api/v1/auth/oauth/*",
+		"/api/v1/auth/webauthn/login/*",
+	)
+
+	// RBAC checked before the controller runs
+	middleware.Require("/api/v1/admin/lockouts", "GET", "lockouts", "read")
+	middleware.Require("/api/v1/admin/lockouts", "DELETE", "lockouts", "delete")
+	for _, p := range []string{"/api/v1/admin/roles", "/api/v1/admin/roles/*"} {
+		middleware.Require(p, "GET", "rbac", "read")
+		middleware.Require(p, "POST", "rbac", "create")
+		middleware.Require(p, "PUT", "rbac", "update")
+		middleware.Require(p, "DELETE", "rbac", "delete")
+	}
+	for _, p := range []string{"/api/v1/admin/users/:user_id/roles", "/api/v1/admin/users/:user_id/roles/*"} {
+		middleware.Require(p, "GET", "rbac", "read")
+		middleware.Require(p, "POST,DELETE", "rbac", "update")
+	}
+	middleware.Require("/api/v1/admin/users", "GET", "users", "read")
+	middleware.Require("/api/v1/admin/users/:user_id", "GET", "users", "read")
+	for _, p := range []string{"/api/v1/admin/users/:user_id/disable", "/api/v1/admin/users/:user_id/enable", "/api/v1/admin/users/:user_id/force-password-reset"} {
+		middleware.Require(p, "POST", "users", "update")
+	}
+	middleware.Require("/api/v1/admin/users/:user_id/impersonate", "POST", "users", "impersonate")
+	middleware.Require("/api/v1/admin/audit-logs", "GET", "audit", "read")
+
+	web.Router("/.well-known/jwks.json", &controllers.JWKSController{}, "get:Keys")
 
 	ns := web.NewNamespace("/api/v1",
 		web.NSNamespace("/auth",
 			web.NSRouter("/register", &controllers.AuthController{}, "post:Register"),
 			web.NSRouter("/login", &controllers.AuthController{}, "post:Login"),
+			web.NSRouter("/refresh", &controllers.AuthController{}, "post:Refresh"),
 			web.NSRouter("/logout", &controllers.LogoutController{}, "post:Logout"),
 			web.NSRouter("/forgot-password", &controllers.AuthController{}, "post:ForgotPassword"),
 			web.NSRouter("/reset-password", &controllers.AuthController{}, "post:ResetPassword"),
 			web.NSRouter("/change-password", &controllers.AuthController{}, "post:ChangePassword"),
 			web.NSRouter("/change-email", &controllers.AuthController{}, "post:ChangeEmail"),
+			web.NSRouter("/change-email/confirm", &controllers.AuthController{}, "get:ConfirmEmailChange"),
+			web.NSRouter("/change-email/revert", &controllers.AuthController{}, "get:RevertEmailChange"),
 			web.NSRouter("/send-verification", &controllers.AuthController{}, "post:SendVerification"),
 			web.NSRouter("/verify", &controllers.AuthController{}, "get:VerifyEmail"),
+			web.NSRouter("/magic-link", &controllers.MagicLinkController{}, "post:Request"),
+			web.NSRouter("/magic-link/consume", &controllers.MagicLinkController{}, "get:Consume"),
+			web.NSRouter("/mfa/enroll", &controllers.MFAController{}, "post:Enroll"),
+			web.NSRouter("/mfa/enroll/verify", &controllers.MFAController{}, "post:ConfirmEnroll"),
+			web.NSRouter("/mfa/disable", &controllers.MFAController{}, "post:Disable"),
+			web.NSRouter("/mfa/recovery-codes", &controllers.MFAController{}, "post:RecoveryCodes"),
+			web.NSRouter("/mfa/verify", &controllers.MFAController{}, "post:Verify"),
+			web.NSRouter("/oauth/providers", &controllers.OAuthController{}, "get:Providers"),
+			web.NSRouter("/oauth/:provider/start", &controllers.OAuthController{}, "get:Start"),
+			web.NSRouter("/oauth/:provider/callback", &controllers.OAuthController{}, "get:Callback"),
+			web.NSRouter("/webauthn/register/begin", &controllers.WebAuthnController{}, "post:RegisterBegin"),
+			web.NSRouter("/webauthn/register/finish", &controllers.WebAuthnController{}, "post:RegisterFinish"),
+			web.NSRouter("/webauthn/login/begin", &controllers.WebAuthnController{}, "post:LoginBegin"),
+			web.NSRouter("/webauthn/login/finish", &controllers.WebAuthnController{}, "post:LoginFinish"),
+			web.NSRouter("/webauthn/credentials", &controllers.WebAuthnController{}, "get:Credentials"),
+			web.NSRouter("/webauthn/credentials/:id", &controllers.WebAuthnController{}, "delete:DeleteCredential"),
+			web.NSRouter("/sessions", &controllers.SessionController{}, "get:List"),
+			web.NSRouter("/sessions/revoke-others", &controllers.SessionController{}, "post:RevokeOthers"),
+			web.NSRouter("/sessions/:jti", &controllers.SessionController{}, "delete:Revoke"),
+			web.NSRouter("/tokens", &controllers.TokenController{}, "get:List;post:Create"),
+			web.NSRouter("/tokens/:id", &controllers.TokenController{}, "delete:Revoke"),
+		),
+		web.NSNamespace("/admin",
+			web.NSRouter("/lockouts", &controllers.LockoutController{}, "get:List;delete:Clear"),
+			web.NSRouter("/roles", &controllers.RBACController{}, "get:ListRoles;post:CreateRole"),
+			web.NSRouter("/roles/:name", &controllers.RBACController{}, "get:GetRole;put:UpdateRole;delete:DeleteRole"),
+			web.NSRouter("/roles/:name/permissions", &controllers.RBACController{}, "get:ListPermissions;post:AddPermission;delete:RevokePermission"),
+			web.NSRouter("/roles/:name/permissions/:id", &controllers.RBACController{}, "put:UpdatePermission;delete:DeletePermission"),
+			web.NSRouter("/users", &controllers.AdminUserController{}, "get:List"),
+			web.NSRouter("/users/:user_id", &controllers.AdminUserController{}, "get:Get"),
+			web.NSRouter("/users/:user_id/disable", &controllers.AdminUserController{}, "post:Disable"),
+			web.NSRouter("/users/:user_id/enable", &controllers.AdminUserController{}, "post:Enable"),
+			web.NSRouter("/users/:user_id/force-password-reset", &controllers.AdminUserController{}, "post:ForcePasswordReset"),
+			web.NSRouter("/users/:user_id/impersonate", &controllers.AdminUserController{}, "post:Impersonate"),
+			web.NSRouter("/audit-logs", &controllers.AdminUserController{}, "get:AuditLogs"),
+			web.NSRouter("/users/:user_id/roles", &controllers.RBACController{}, "get:UserRoles;post:AssignRole"),
+			web.NSRouter("/users/:user_id/roles/:role", &controllers.RBACController{}, "delete:UnassignRole"),
+		),
+		web.NSNamespace("/orgs",
+			web.NSRouter("/", &controllers.OrgController{}, "get:List;post:Create"),
+			web.NSRouter("/switch", &controllers.OrgController{}, "post:Switch"),
+			web.NSRouter("/invitations/accept", &controllers.OrgController{}, "post:Accept"),
+			web.NSRouter("/:id", &controllers.OrgController{}, "get:Get;put:Update;delete:Delete"),
+			web.NSRouter("/:id/members", &controllers.OrgController{}, "get:Members"),
+			web.NSRouter("/:id/members/:user_id", &controllers.OrgController{}, "put:UpdateMember;delete:RemoveMember"),
+			web.NSRouter("/:id/invitations", &controllers.OrgController{}, "get:Invitations;post:Invite"),
+			web.NSRouter("/:id/invitations/:invite_id", &controllers.OrgController{}, "delete:RevokeInvitation"),
 		),
-		web.NSRouter("/users/me", &controllers.UserController{}, "get:Me"),
-		web.NSRouter("/items", &items.ItemController{}, "get:List;post:Create"),
-		web.NSRouter("/items/:id", &items.ItemController{}, "get:GetOne;put:Update;delete:Delete"),
+		web.NSRouter("/users/me", &controllers.UserController{}, "get:Me;patch:UpdateMe;delete:DeleteMe"),
 		web.NSRouter("/upload", &controllers.UploadController{}, "post:Upload"),
 	)
 	web.AddNamespace(ns)
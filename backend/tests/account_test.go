@@ -0,0 +1,167 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/beego/beego/v2/client/orm"
+	"github.com/beego/beego/v2/server/web"
+
+	itemmodels "github.com/mymi14s/goconda/apps/items/models"
+	"github.com/mymi14s/goconda/models"
+	"github.com/mymi14s/goconda/utils/hash"
+	jwtutil "github.com/mymi14s/goconda/utils/jwt"
+)
+
+// pngHeader is enough of a PNG file for content sniffing.
+var pngHeader = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR\x00\x00\x00\x01\x00\x00\x00\x01\x08\x06\x00\x00\x00")
+
+// uploadAvatar sends PATCH /users/me as multipart/form-data with fields and
+// an avatar file.
+func uploadAvatar(t *testing.T, token string, fields map[string]string, name string, content []byte) (int, json.RawMessage) {
+	t.Helper()
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	for k, v := range fields {
+		_ = w.WriteField(k, v)
+	}
+	fw, _ := w.CreateFormFile("avatar", name)
+	_, _ = fw.Write(content)
+	_ = w.Close()
+	req := httptest.NewRequest("PATCH", "/api/v1/users/me", &buf)
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	web.BeeApp.Handlers.ServeHTTP(rec, req)
+	var out struct {
+		Data json.RawMessage `json:"data"`
+	}
+	_ = json.Unmarshal(rec.Body.Bytes(), &out)
+	return rec.Code, out.Data
+}
+
+func TestProfileUpdate(t *testing.T) {
+	if err := setJWTConfig(t, map[string]string{"jwt::secret": "profile-secret"}); err != nil {
+		t.Fatal(err)
+	}
+	_ = web.AppConfig.Set("upload::dir", t.TempDir())
+	u := newUser(t, "profile@account.test")
+	token, _ := jwtutil.Generate(u.ID)
+
+	code, data := apiCall(t, token, "PATCH", "/api/v1/users/me", map[string]string{
+		"first_name": "Grace", "locale": "en-GB", "timezone": "Europe/London",
+	})
+	var me models.User
+	_ = json.Unmarshal(data, &me)
+	if code != 200 || me.FirstName != "Grace" || me.LastName != "User" || me.Locale != "en-GB" || me.Timezone != "Europe/London" {
+		t.Fatalf("update: %d %s", code, data)
+	}
+	for _, bad := range []map[string]string{{"locale": "not a locale"}, {"timezone": "Mars/Olympus"}, {"first_name": " "}} {
+		if code, _ := apiCall(t, token, "PATCH", "/api/v1/users/me", bad); code != 400 {
+			t.Fatalf("expected %v to be refused, got %d", bad, code)
+		}
+	}
+
+	if code, _ := uploadAvatar(t, token, nil, "me.txt", []byte("not an image")); code != 400 {
+		t.Fatalf("expected a non-image avatar to be refused, got %d", code)
+	}
+	code, data = uploadAvatar(t, token, map[string]string{"last_name": "Hopper"}, "me.png", pngHeader)
+	me = models.User{}
+	_ = json.Unmarshal(data, &me)
+	if code != 200 || me.Avatar == "" || me.LastName != "Hopper" {
+		t.Fatalf("avatar upload: %d %s", code, data)
+	}
+	if _, err := os.Stat(me.Avatar); err != nil {
+		t.Fatalf("avatar not stored: %v", err)
+	}
+	avatar := me.Avatar
+
+	code, data = apiCall(t, token, "PATCH", "/api/v1/users/me", map[string]string{"avatar": ""})
+	me = models.User{}
+	_ = json.Unmarshal(data, &me)
+	if code != 200 || me.Avatar != "" {
+		t.Fatalf("remove avatar: %d %s", code, data)
+	}
+	if _, err := os.Stat(avatar); !os.IsNotExist(err) {
+		t.Fatalf("expected the old avatar file to be removed, got %v", err)
+	}
+
+	code, data = apiCall(t, token, "GET", "/api/v1/users/me", nil)
+	me = models.User{}
+	_ = json.Unmarshal(data, &me)
+	if code != 200 || me.ID != u.ID || me.FirstName != "Grace" || me.Timezone != "Europe/London" {
+		t.Fatalf("me: %d %s", code, data)
+	}
+}
+
+func TestAccountDeletion(t *testing.T) {
+	if err := setJWTConfig(t, map[string]string{"jwt::secret": "delete-secret"}); err != nil {
+		t.Fatal(err)
+	}
+	pw, _ := hash.Make("delete-me-please")
+	u := &models.User{Email: "leaver@account.test", FirstName: "Leaver", PasswordHash: pw}
+	if err := models.CreateUser(u); err != nil {
+		t.Fatal(err)
+	}
+	token, _ := jwtutil.Generate(u.ID)
+	_ = models.EnsureRole("Leavers")
+	_ = models.AssignRole(u.ID, "Leavers")
+	if _, _, err := models.CreatePersonalAccessToken(u.ID, "ci", 0, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	code, data := apiCall(t, token, "POST", "/api/v1/items", map[string]string{"name": "Diary"})
+	var it itemmodels.Item
+	_ = json.Unmarshal(data, &it)
+	if code != 200 {
+		t.Fatalf("create item: %d %s", code, data)
+	}
+
+	if code, _ := apiCall(t, token, "DELETE", "/api/v1/users/me", map[string]string{"password": "wrong"}); code != 400 {
+		t.Fatalf("expected a wrong password to be refused, got %d", code)
+	}
+	if code, data := apiCall(t, token, "DELETE", "/api/v1/users/me", map[string]string{"password": "delete-me-please"}); code != 200 {
+		t.Fatalf("delete: %d %s", code, data)
+	}
+	if code, _ := apiCall(t, token, "GET", "/api/v1/users/me", nil); code != 401 {
+		t.Fatalf("expected a deleted account to be signed out, got %d", code)
+	}
+	if got, _ := models.GetUserByEmail(u.Email); got != nil {
+		t.Fatalf("expected a deleted account to be hidden")
+	}
+	if !models.EmailInUse(u.Email) {
+		t.Fatalf("expected the address to stay taken during the grace period")
+	}
+
+	// pretend the account was deleted two days ago
+	o := orm.NewOrm()
+	if _, err := o.QueryTable(new(models.User)).Filter("ID", u.ID).Update(orm.Params{"DeletedAt": time.Now().Add(-48 * time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := models.PurgeDeletedUsers(72 * time.Hour); err != nil || n != 0 {
+		t.Fatalf("expected nothing to purge within the grace period, got %d %v", n, err)
+	}
+	if _, err := models.PurgeDeletedUsers(24 * time.Hour); err != nil {
+		t.Fatalf("purge: %v", err)
+	}
+	row := models.User{ID: u.ID}
+	if err := o.Read(&row); err != nil || row.PurgedAt == nil || row.Email == u.Email || row.FirstName != "" || row.PasswordHash != "" {
+		t.Fatalf("expected the user row to be anonymized: %v %+v", err, row)
+	}
+	if models.EmailInUse(u.Email) {
+		t.Fatalf("expected the address to be free after the purge")
+	}
+	if roles, _ := models.ListUserRoles(u.ID); len(roles) != 0 {
+		t.Fatalf("expected roles to be purged, got %v", roles)
+	}
+	if tokens, _ := models.ListPersonalAccessTokens(u.ID); len(tokens) != 0 {
+		t.Fatalf("expected tokens to be purged, got %v", tokens)
+	}
+	if o.QueryTable(new(itemmodels.Item)).Filter("ID", it.ID).Exist() {
+		t.Fatalf("expected personal items to be purged")
+	}
+}
@@ -0,0 +1,145 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/mymi14s/goconda/models"
+	jwtutil "github.com/mymi14s/goconda/utils/jwt"
+)
+
+type userPage struct {
+	Total int64                `json:"total"`
+	Users []models.UserSummary `json:"users"`
+}
+
+func TestAdminUserDirectory(t *testing.T) {
+	if err := setJWTConfig(t, map[string]string{"jwt::secret": "admin-users-secret"}); err != nil {
+		t.Fatal(err)
+	}
+	captureMail(t)
+	admin := newUser(t, "directory.admin@dir.test")
+	_ = models.EnsureRole("UserAdmins")
+	_ = models.Grant("UserAdmins", "users", "*")
+	_ = models.Grant("UserAdmins", "audit", "read")
+	_ = models.AssignRole(admin.ID, "UserAdmins")
+	adminTok, _ := jwtutil.Generate(admin.ID)
+
+	ada := newUser(t, "ada.lovelace@dir.test")
+	ada.FirstName, ada.LastName = "Ada", "Lovelace"
+	_ = models.MarkUserVerified(ada.ID, ada.Email)
+	_ = models.EnsureRole("Analysts")
+	_ = models.AssignRole(ada.ID, "Analysts")
+	bob := newUser(t, "bob@dir.test")
+	adaTok, _ := jwtutil.Generate(ada.ID)
+	if code, _ := apiCall(t, adaTok, "GET", "/api/v1/admin/users", nil); code != 403 {
+		t.Fatalf("expected 403 without the users permission, got %d", code)
+	}
+
+	list := func(query string) userPage {
+		t.Helper()
+		code, data := apiCall(t, adminTok, "GET", "/api/v1/admin/users?"+query, nil)
+		var page userPage
+		_ = json.Unmarshal(data, &page)
+		if code != 200 {
+			t.Fatalf("list %s: %d %s", query, code, data)
+		}
+		return page
+	}
+	if page := list("q=dir.test+ADA"); page.Total != 1 || page.Users[0].ID != ada.ID || !page.Users[0].Verified || page.Users[0].Roles[0] != "Analysts" {
+		t.Fatalf("search: %+v", page)
+	}
+	if page := list("q=dir.test&verified=false&limit=1"); page.Total != 2 || len(page.Users) != 1 {
+		t.Fatalf("unverified: %+v", page)
+	}
+	if page := list("role=Analysts"); page.Total != 1 || page.Users[0].ID != ada.ID {
+		t.Fatalf("role filter: %+v", page)
+	}
+	if page := list("q=%25"); page.Total != 0 {
+		t.Fatalf("expected wildcards to be matched literally, got %+v", page)
+	}
+	if code, _ := apiCall(t, adminTok, "GET", "/api/v1/admin/users?superuser=maybe", nil); code != 400 {
+		t.Fatalf("expected a bad flag to be refused, got %d", code)
+	}
+
+	// disable and enable
+	bobPath := fmt.Sprintf("/api/v1/admin/users/%s", bob.ID)
+	bobTok, _ := jwtutil.Generate(bob.ID)
+	if code, _ := apiCall(t, adminTok, "POST", bobPath+"/disable", nil); code != 200 {
+		t.Fatalf("disable: %d", code)
+	}
+	if code, _ := apiCall(t, bobTok, "GET", "/api/v1/users/me", nil); code != 401 {
+		t.Fatalf("expected a disabled account to be refused, got %d", code)
+	}
+	if page := list("disabled=true&q=dir.test"); page.Total != 1 || page.Users[0].ID != bob.ID {
+		t.Fatalf("disabled filter: %+v", page)
+	}
+	if code, _ := apiCall(t, adminTok, "POST", bobPath+"/enable", nil); code != 200 {
+		t.Fatalf("enable: %d", code)
+	}
+	bobTok, _ = jwtutil.Generate(bob.ID)
+	if code, _ := apiCall(t, bobTok, "GET", "/api/v1/users/me", nil); code != 200 {
+		t.Fatalf("expected an enabled account to work, got %d", code)
+	}
+	if code, _ := apiCall(t, adminTok, "POST", fmt.Sprintf("/api/v1/admin/users/%s/disable", admin.ID), nil); code != 400 {
+		t.Fatalf("expected admins not to disable themselves, got %d", code)
+	}
+
+	if code, _ := apiCall(t, adminTok, "POST", bobPath+"/force-password-reset", nil); code != 200 {
+		t.Fatalf("force reset: %d", code)
+	}
+	if u, _ := models.GetUserByID(bob.ID); u == nil || !u.PasswordResetRequired {
+		t.Fatalf("expected a reset to be required")
+	}
+
+	// impersonation
+	adaPath := fmt.Sprintf("/api/v1/admin/users/%s/impersonate", ada.ID)
+	if code, _ := apiCall(t, adminTok, "POST", adaPath, map[string]string{}); code != 400 {
+		t.Fatalf("expected a reason to be required, got %d", code)
+	}
+	code, data := apiCall(t, adminTok, "POST", adaPath, map[string]string{"reason": "support ticket 42"})
+	var imp struct {
+		Token string `json:"token"`
+	}
+	_ = json.Unmarshal(data, &imp)
+	if code != 200 || imp.Token == "" {
+		t.Fatalf("impersonate: %d %s", code, data)
+	}
+	claims, err := jwtutil.Parse(imp.Token)
+	if err != nil || claims.Subject != ada.ID || claims.Act == nil || claims.Act.Subject != admin.ID {
+		t.Fatalf("expected an act claim naming the admin: %v %+v", err, claims)
+	}
+	if code, data := apiCall(t, imp.Token, "GET", "/api/v1/users/me", nil); code != 200 || !json.Valid(data) {
+		t.Fatalf("impersonated request: %d", code)
+	}
+	if code, _ := apiCall(t, imp.Token, "PATCH", "/api/v1/users/me", map[string]string{"first_name": "Augusta"}); code != 200 {
+		t.Fatalf("impersonated write: %d", code)
+	}
+	if code, _ := apiCall(t, imp.Token, "POST", "/api/v1/auth/change-password", nil); code != 403 {
+		t.Fatalf("expected account management to be refused while impersonating, got %d", code)
+	}
+	if code, _ := apiCall(t, imp.Token, "GET", "/api/v1/admin/users", nil); code != 403 {
+		t.Fatalf("expected the admin API to be refused while impersonating, got %d", code)
+	}
+	if code, _ := apiCall(t, imp.Token, "POST", "/api/v1/orgs/switch", map[string]int64{"org_id": 0}); code != 403 {
+		t.Fatalf("expected switching organization to be refused while impersonating, got %d", code)
+	}
+
+	code, data = apiCall(t, adminTok, "GET", "/api/v1/admin/audit-logs?target_id="+ada.ID, nil)
+	var audit struct {
+		Total   int64             `json:"total"`
+		Entries []models.AuditLog `json:"entries"`
+	}
+	_ = json.Unmarshal(data, &audit)
+	if code != 200 || audit.Total != 2 || audit.Entries[0].Action != models.AuditImpersonatedRequest ||
+		audit.Entries[1].Action != models.AuditImpersonationStart || audit.Entries[1].Details != "support ticket 42" {
+		t.Fatalf("audit log: %d %s", code, data)
+	}
+
+	// losing the permission ends the impersonation
+	_, _ = models.UnassignRole(admin.ID, "UserAdmins")
+	if code, _ := apiCall(t, imp.Token, "GET", "/api/v1/users/me", nil); code != 401 {
+		t.Fatalf("expected the act claim to be rechecked, got %d", code)
+	}
+}
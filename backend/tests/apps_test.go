@@ -0,0 +1,56 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/mymi14s/goconda/apps"
+	"github.com/mymi14s/goconda/models"
+)
+
+func TestAppRegistry(t *testing.T) {
+	var names []string
+	for _, a := range apps.Enabled() {
+		names = append(names, a.Name)
+	}
+	if len(names) != 3 || names[0] != "frontend" || names[1] != "items" || names[2] != "notes" {
+		t.Fatalf("enabled apps: %v", names)
+	}
+	if code, _ := apiCall(t, "", "GET", "/api/v1/notes", nil); code != 401 {
+		t.Fatalf("expected app routes to be mounted and protected, got %d", code)
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatalf("expected a second registration to panic")
+			}
+		}()
+		apps.Register(apps.App{Name: "items"})
+	}()
+	if err := apps.Load(); err == nil {
+		t.Fatalf("expected apps to load only once")
+	}
+}
+
+func TestEnsurePermission(t *testing.T) {
+	for i := 0; i < 2; i++ {
+		if err := models.EnsurePermission("Seeded", "reports", "read"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if rules, _ := models.ListPermissions("Seeded"); len(rules) != 1 {
+		t.Fatalf("expected one rule, got %+v", rules)
+	}
+	if _, err := models.AddPermission("Seeded", "reports", "delete", models.EffectDeny); err != nil {
+		t.Fatal(err)
+	}
+	_ = models.EnsurePermission("Seeded", "reports", "delete")
+	rules, _ := models.ListPermissions("Seeded")
+	for _, r := range rules {
+		if r.Action == "delete" && r.Effect != models.EffectDeny {
+			t.Fatalf("expected a deny rule to be kept, got %+v", rules)
+		}
+	}
+	if len(rules) != 2 {
+		t.Fatalf("expected two rules, got %+v", rules)
+	}
+}
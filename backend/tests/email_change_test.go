@@ -0,0 +1,133 @@
+package tests
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/beego/beego/v2/server/web"
+
+	"github.com/mymi14s/goconda/models"
+	"github.com/mymi14s/goconda/utils/hash"
+	jwtutil "github.com/mymi14s/goconda/utils/jwt"
+)
+
+// requestEmailChange posts to change-email and returns the status code.
+func requestEmailChange(t *testing.T, token, password, newEmail string) int {
+	t.Helper()
+	form := url.Values{"password": {password}, "new_email": {newEmail}}
+	req := httptest.NewRequest("POST", "/api/v1/auth/change-email", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	web.BeeApp.Handlers.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+// emailChangeTokens reads the confirm and revert tokens from the two
+// emails, by recipient.
+func emailChangeTokens(t *testing.T, sent <-chan string, newEmail, oldEmail string) (string, string) {
+	t.Helper()
+	var confirm, revert string
+	for i := 0; i < 2; i++ {
+		select {
+		case msg := <-sent:
+			body := strings.NewReplacer("=\r\n", "", "=\n", "").Replace(msg)
+			m := inviteTokenRe.FindStringSubmatch(body)
+			if m == nil {
+				t.Fatalf("no token in email:\n%s", msg)
+			}
+			switch {
+			case strings.HasPrefix(msg, newEmail):
+				confirm = m[1]
+			case strings.HasPrefix(msg, oldEmail):
+				revert = m[1]
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("expected two emails")
+		}
+	}
+	if confirm == "" || revert == "" {
+		t.Fatalf("expected one email to each address")
+	}
+	return confirm, revert
+}
+
+func TestEmailChangeFlow(t *testing.T) {
+	if err := setJWTConfig(t, map[string]string{"jwt::secret": "email-change-secret"}); err != nil {
+		t.Fatal(err)
+	}
+	sent := captureMail(t)
+	pw, _ := hash.Make("old-password-1")
+	u := &models.User{Email: "mover@change.test", FirstName: "Mover", PasswordHash: pw}
+	if err := models.CreateUser(u); err != nil {
+		t.Fatal(err)
+	}
+	_ = models.CreateUser(&models.User{Email: "taken@change.test"})
+	token, _ := jwtutil.Generate(u.ID)
+
+	if code := requestEmailChange(t, token, "wrong", "new@change.test"); code != 400 {
+		t.Fatalf("expected a wrong password to be refused, got %d", code)
+	}
+	if code := requestEmailChange(t, token, "old-password-1", "taken@change.test"); code != 400 {
+		t.Fatalf("expected a used address to be refused, got %d", code)
+	}
+	if code := requestEmailChange(t, token, "old-password-1", "new@change.test"); code != 202 {
+		t.Fatalf("change-email: %d", code)
+	}
+	confirm, revert := emailChangeTokens(t, sent, "new@change.test", "mover@change.test")
+	if cur, _ := models.GetUserByID(u.ID); cur.Email != "mover@change.test" {
+		t.Fatalf("expected the address to stay until confirmed, got %s", cur.Email)
+	}
+
+	if code, data := apiCall(t, "", "GET", "/api/v1/auth/change-email/confirm?token="+confirm, nil); code != 200 {
+		t.Fatalf("confirm: %d %s", code, data)
+	}
+	cur, _ := models.GetUserByID(u.ID)
+	if cur.Email != "new@change.test" {
+		t.Fatalf("expected the new address, got %s", cur.Email)
+	}
+	if ok, _ := models.IsUserVerified(cur); !ok {
+		t.Fatalf("expected the new address to be verified")
+	}
+	if code, _ := apiCall(t, "", "GET", "/api/v1/auth/change-email/confirm?token="+confirm, nil); code != 400 {
+		t.Fatalf("expected a used confirm link to be refused, got %d", code)
+	}
+
+	// "this wasn't me" within the rollback window
+	if code, data := apiCall(t, "", "GET", "/api/v1/auth/change-email/revert?token="+revert, nil); code != 200 {
+		t.Fatalf("revert: %d %s", code, data)
+	}
+	cur, _ = models.GetUserByID(u.ID)
+	if cur.Email != "mover@change.test" {
+		t.Fatalf("expected the old address back, got %s", cur.Email)
+	}
+	if ok, _ := models.IsUserVerified(cur); !ok {
+		t.Fatalf("expected the restored address to be verified")
+	}
+	if code, _ := apiCall(t, "", "GET", "/api/v1/auth/change-email/revert?token="+revert, nil); code != 400 {
+		t.Fatalf("expected a used revert link to be refused, got %d", code)
+	}
+
+	if code, _ := apiCall(t, token, "GET", "/api/v1/users/me", nil); code != 401 {
+		t.Fatalf("expected the revert to sign out existing sessions, got %d", code)
+	}
+
+	// reverting a pending change cancels it
+	token, _ = jwtutil.Generate(u.ID)
+	if code := requestEmailChange(t, token, "old-password-1", "other@change.test"); code != 202 {
+		t.Fatalf("second change-email: %d", code)
+	}
+	confirm, revert = emailChangeTokens(t, sent, "other@change.test", "mover@change.test")
+	if code, _ := apiCall(t, "", "GET", "/api/v1/auth/change-email/revert?token="+revert, nil); code != 200 {
+		t.Fatalf("cancel: %d", code)
+	}
+	if code, _ := apiCall(t, "", "GET", "/api/v1/auth/change-email/confirm?token="+confirm, nil); code != 400 {
+		t.Fatalf("expected a cancelled change to be refused, got %d", code)
+	}
+	if cur, _ := models.GetUserByID(u.ID); cur.Email != "mover@change.test" {
+		t.Fatalf("expected the address to be unchanged, got %s", cur.Email)
+	}
+}
@@ -6,26 +6,35 @@ import (
 
     "github.com/beego/beego/v2/client/orm"
     "github.com/beego/beego/v2/server/web"
+    _ "github.com/mattn/go-sqlite3"
 
+    "github.com/mymi14s/goconda/apps"
+    _ "github.com/mymi14s/goconda/apps/installed"
     "github.com/mymi14s/goconda/models"
+    "github.com/mymi14s/goconda/utils/mailer"
+    "github.com/mymi14s/goconda/utils/migrate"
 )
 
 func init() {
-    web.AppConfigPath = "conf/app.dev.conf"
+    // links in emails are built from it, never from the request's Host
+    _ = web.AppConfig.Set("mailer::base_url", "https://app.example.test")
+    mailer.Site = models.MailerSite{}
+    _ = apps.Load()
     _ = models.InitDB()
     orm.RunSyncdb("default", true, true)
+    // syncdb leaves the migration history alone
+    _, _ = orm.NewOrm().Raw("DROP TABLE IF EXISTS " + migrate.Table).Exec()
 }
 
 func TestEmailVerificationFlow(t *testing.T) {
     // create user
-    o := orm.NewOrm()
     u := &models.User{Email: "alice@example.com", FirstName: "Alice", LastName: "Liddell", PasswordHash: "x"}
-    if _, err := o.Insert(u); err != nil {
+    if err := models.CreateUser(u); err != nil {
         t.Fatalf("insert user: %v", err)
     }
 
     // initially not verified
-    ok, err := models.IsUserVerified(u.Email)
+    ok, err := models.IsUserVerified(u)
     if err != nil {
         t.Fatalf("IsUserVerified error: %v", err)
     }
@@ -34,34 +43,43 @@ func TestEmailVerificationFlow(t *testing.T) {
     }
 
     // create token
-    tok, err := models.CreateVerificationToken(u.Email, time.Hour)
+    tok, err := models.CreateVerificationToken(u, time.Hour)
     if err != nil {
         t.Fatalf("CreateVerificationToken: %v", err)
     }
-    if tok.Email != u.Email || tok.Token == "" {
+    if tok.UserID != u.ID || tok.Email != u.Email || tok.Token == "" {
         t.Fatalf("invalid token %+v", tok)
     }
 
     // consume
-    email, err := models.ConsumeVerificationToken(tok.Token)
+    used, err := models.ConsumeVerificationToken(tok.Token)
     if err != nil {
         t.Fatalf("ConsumeVerificationToken: %v", err)
     }
-    if email != u.Email {
-        t.Fatalf("expected email match")
+    if used.UserID != u.ID || used.Email != u.Email {
+        t.Fatalf("expected user and email match")
     }
 
     // mark verified
-    if err := models.MarkUserVerified(email); err != nil {
+    if err := models.MarkUserVerified(used.UserID, used.Email); err != nil {
         t.Fatalf("MarkUserVerified: %v", err)
     }
 
     // now verified
-    ok, err = models.IsUserVerified(u.Email)
+    ok, err = models.IsUserVerified(u)
     if err != nil {
         t.Fatalf("IsUserVerified 2: %v", err)
     }
     if !ok {
         t.Fatalf("expected verified true")
     }
+
+    // a new address has to be verified again
+    u.Email = "alice@wonderland.example"
+    if _, err := orm.NewOrm().Update(u, "Email"); err != nil {
+        t.Fatalf("update email: %v", err)
+    }
+    if ok, _ = models.IsUserVerified(u); ok {
+        t.Fatalf("expected a changed email to be unverified")
+    }
 }
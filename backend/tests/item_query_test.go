@@ -0,0 +1,133 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/beego/beego/v2/client/orm"
+
+	itemmodels "github.com/mymi14s/goconda/apps/items/models"
+	jwtutil "github.com/mymi14s/goconda/utils/jwt"
+)
+
+type itemPage struct {
+	Total int64             `json:"total"`
+	Items []itemmodels.Item `json:"items"`
+	Next  string            `json:"next"`
+	Prev  string            `json:"prev"`
+}
+
+func TestItemQuery(t *testing.T) {
+	if err := setJWTConfig(t, map[string]string{"jwt::secret": "item-query-secret"}); err != nil {
+		t.Fatal(err)
+	}
+	u := newUser(t, "lister@query.test")
+	token, _ := jwtutil.Generate(u.ID)
+	ids := map[string]int64{}
+	for _, it := range []struct{ name, desc string }{
+		{"Apple", "red fruit"}, {"banana", "yellow fruit"}, {"Cherry", "small red"},
+		{"Apple", "green fruit"}, {"Date", "sweet"}, {"Elderberry", "dark"},
+	} {
+		code, data := apiCall(t, token, "POST", "/api/v1/items", map[string]string{"name": it.name, "description": it.desc})
+		var item itemmodels.Item
+		_ = json.Unmarshal(data, &item)
+		if code != 200 {
+			t.Fatalf("create: %d %s", code, data)
+		}
+		ids[it.name+"/"+it.desc] = item.ID
+	}
+	// the first two were made a day ago; the rest share a second
+	old := time.Now().Add(-24 * time.Hour)
+	if _, err := orm.NewOrm().QueryTable(new(itemmodels.Item)).Filter("ID__in", ids["Apple/red fruit"], ids["banana/yellow fruit"]).
+		Update(orm.Params{"CreatedAt": old}); err != nil {
+		t.Fatal(err)
+	}
+
+	list := func(path string) itemPage {
+		t.Helper()
+		code, data := apiCall(t, token, "GET", path, nil)
+		var page itemPage
+		_ = json.Unmarshal(data, &page)
+		if code != 200 {
+			t.Fatalf("GET %s: %d %s", path, code, data)
+		}
+		return page
+	}
+	names := func(p itemPage) []string {
+		out := make([]string, len(p.Items))
+		for i, it := range p.Items {
+			out[i] = it.Name
+		}
+		return out
+	}
+	same := func(got, want []string) bool {
+		if len(got) != len(want) {
+			return false
+		}
+		for i := range got {
+			if got[i] != want[i] {
+				return false
+			}
+		}
+		return true
+	}
+
+	if p := list("/api/v1/items?name__icontains=APP"); p.Total != 2 {
+		t.Fatalf("name__icontains: %+v", p)
+	}
+	since := url.QueryEscape(time.Now().Add(-time.Hour).UTC().Format(time.RFC3339))
+	if p := list("/api/v1/items?created_at__gte=" + since); p.Total != 4 {
+		t.Fatalf("created_at__gte: %+v", p)
+	}
+	if p := list("/api/v1/items?q=red+fruit"); p.Total != 1 || p.Items[0].ID != ids["Apple/red fruit"] {
+		t.Fatalf("q: %+v", p)
+	}
+	if p := list("/api/v1/items?sort=name,created_at"); !same(names(p), []string{"Apple", "Apple", "Cherry", "Date", "Elderberry", "banana"}) ||
+		p.Items[0].Description != "red fruit" {
+		t.Fatalf("sort: %v", names(p))
+	}
+	for _, bad := range []string{"sort=owner", "name__gt=a", "created_at__gte=yesterday", "cursor=bogus", "limit=0"} {
+		if code, _ := apiCall(t, token, "GET", "/api/v1/items?"+bad, nil); code != 400 {
+			t.Fatalf("expected %s to be refused, got %d", bad, code)
+		}
+	}
+
+	// walk every page forwards, then back again
+	var forward []string
+	var pages []itemPage
+	p := list("/api/v1/items?sort=-created_at&limit=2")
+	for {
+		pages = append(pages, p)
+		forward = append(forward, names(p)...)
+		if p.Next == "" {
+			break
+		}
+		p = list(p.Next)
+	}
+	if len(pages) != 3 || len(forward) != 6 || !same(forward[4:], []string{"banana", "Apple"}) || pages[0].Prev != "" {
+		t.Fatalf("forward paging: %v", forward)
+	}
+	var backward []string
+	for p.Prev != "" {
+		p = list(p.Prev)
+		backward = append(names(p), backward...)
+	}
+	if !same(backward, forward[:4]) || p.Next == "" {
+		t.Fatalf("backward paging: %v, want %v", backward, forward[:4])
+	}
+
+	// offset still works, and hands over to cursors
+	p = list("/api/v1/items?sort=name&limit=2&offset=2")
+	if !same(names(p), []string{"Cherry", "Date"}) || p.Prev == "" {
+		t.Fatalf("offset: %v", names(p))
+	}
+	if prev := list(p.Prev); !same(names(prev), []string{"Apple", "Apple"}) {
+		t.Fatalf("prev after offset: %v", names(prev))
+	}
+	if code, _ := apiCall(t, token, "GET", strings.Replace(p.Next, "sort=name", "sort=-name", 1), nil); code != 400 {
+		t.Fatalf("expected a cursor to be tied to its sort, got %d", code)
+	}
+}
@@ -0,0 +1,98 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	itemmodels "github.com/mymi14s/goconda/apps/items/models"
+	"github.com/mymi14s/goconda/models"
+	jwtutil "github.com/mymi14s/goconda/utils/jwt"
+)
+
+func TestItemSharing(t *testing.T) {
+	if err := setJWTConfig(t, map[string]string{"jwt::secret": "share-test-secret"}); err != nil {
+		t.Fatal(err)
+	}
+	tokens := map[string]string{}
+	users := map[string]*models.User{}
+	for _, e := range []string{"owner@share.test", "viewer@share.test", "editor@share.test", "stranger@share.test"} {
+		users[e] = newUser(t, e)
+		tokens[e], _ = jwtutil.Generate(users[e].ID)
+	}
+	owner, viewer, editor, stranger := tokens["owner@share.test"], tokens["viewer@share.test"], tokens["editor@share.test"], tokens["stranger@share.test"]
+	_ = models.EnsureRole("ShareEditors")
+	_ = models.AssignRole(users["editor@share.test"].ID, "ShareEditors")
+
+	code, data := apiCall(t, owner, "POST", "/api/v1/items", map[string]string{"name": "Plan"})
+	if code != 200 {
+		t.Fatalf("create: %d %s", code, data)
+	}
+	var it itemmodels.Item
+	_ = json.Unmarshal(data, &it)
+	path := fmt.Sprintf("/api/v1/items/%d", it.ID)
+
+	if code, _ := apiCall(t, viewer, "GET", path, nil); code != 403 {
+		t.Fatalf("expected 403 before sharing, got %d", code)
+	}
+	if code, data := apiCall(t, owner, "POST", path+"/shares", map[string]string{"email": "viewer@share.test"}); code != 200 {
+		t.Fatalf("share with user: %d %s", code, data)
+	}
+	if code, _ := apiCall(t, owner, "POST", path+"/shares", map[string]string{"role": "ShareEditors", "permission": "editor"}); code != 200 {
+		t.Fatalf("share with role: %d", code)
+	}
+
+	if code, _ := apiCall(t, viewer, "GET", path, nil); code != 200 {
+		t.Fatalf("viewer read: %d", code)
+	}
+	if code, _ := apiCall(t, viewer, "PUT", path, map[string]string{"name": "Hacked"}); code != 403 {
+		t.Fatalf("expected viewer update to be forbidden, got %d", code)
+	}
+	if code, _ := apiCall(t, editor, "PUT", path, map[string]string{"name": "Plan v2"}); code != 200 {
+		t.Fatalf("editor update: %d", code)
+	}
+	if code, _ := apiCall(t, editor, "DELETE", path, nil); code != 403 {
+		t.Fatalf("expected editor delete to be forbidden, got %d", code)
+	}
+	if code, _ := apiCall(t, editor, "POST", path+"/shares", map[string]string{"email": "stranger@share.test"}); code != 403 {
+		t.Fatalf("expected only the owner to share, got %d", code)
+	}
+	if code, _ := apiCall(t, stranger, "GET", path, nil); code != 403 {
+		t.Fatalf("expected stranger to be forbidden, got %d", code)
+	}
+
+	code, data = apiCall(t, viewer, "GET", "/api/v1/items?scope=shared", nil)
+	var list struct {
+		Total int64             `json:"total"`
+		Items []itemmodels.Item `json:"items"`
+	}
+	_ = json.Unmarshal(data, &list)
+	if code != 200 || list.Total != 1 || len(list.Items) != 1 || list.Items[0].Name != "Plan v2" {
+		t.Fatalf("shared list: %d %s", code, data)
+	}
+
+	// revoke the user share
+	code, data = apiCall(t, owner, "GET", path+"/shares", nil)
+	var shares []itemmodels.ItemShare
+	_ = json.Unmarshal(data, &shares)
+	if code != 200 || len(shares) != 2 {
+		t.Fatalf("list shares: %d %s", code, data)
+	}
+	for _, s := range shares {
+		if s.UserID == users["viewer@share.test"].ID {
+			if code, _ := apiCall(t, owner, "DELETE", fmt.Sprintf("%s/shares/%d", path, s.ID), nil); code != 200 {
+				t.Fatalf("unshare: %d", code)
+			}
+		}
+	}
+	if code, _ := apiCall(t, viewer, "GET", path, nil); code != 403 {
+		t.Fatalf("expected access to end after unsharing, got %d", code)
+	}
+
+	if code, _ := apiCall(t, owner, "DELETE", path, nil); code != 200 {
+		t.Fatalf("owner delete: %d", code)
+	}
+	if left, _ := itemmodels.ListItemShares(it.ID); len(left) != 0 {
+		t.Fatalf("expected shares to be deleted with the item, got %v", left)
+	}
+}
@@ -0,0 +1,136 @@
+package tests
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/beego/beego/v2/server/web"
+	"github.com/golang-jwt/jwt/v5"
+
+	jwtutil "github.com/mymi14s/goconda/utils/jwt"
+)
+
+func writePKCS8(t *testing.T, dir, name string, key any) string {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("write key: %v", err)
+	}
+	return path
+}
+
+// setJWTConfig applies [jwt] settings and reloads the key set, restoring
+// the HS256 defaults when the test ends.
+func setJWTConfig(t *testing.T, kv map[string]string) error {
+	t.Helper()
+	t.Cleanup(func() {
+		for k := range kv {
+			_ = web.AppConfig.Set(k, "")
+		}
+		_ = jwtutil.Load()
+	})
+	for k, v := range kv {
+		_ = web.AppConfig.Set(k, v)
+	}
+	return jwtutil.Load()
+}
+
+func tokenKID(t *testing.T, token string) string {
+	t.Helper()
+	parsed, _, err := jwt.NewParser().ParseUnverified(token, &jwtutil.Claims{})
+	if err != nil {
+		t.Fatalf("parse unverified: %v", err)
+	}
+	kid, _ := parsed.Header["kid"].(string)
+	return kid
+}
+
+func TestJWTAsymmetricRotation(t *testing.T) {
+	dir := t.TempDir()
+	oldKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	oldPath := writePKCS8(t, dir, "old.pem", oldKey)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	newPath := writePKCS8(t, dir, "new.pem", edKey)
+
+	// sign with the RSA key
+	if err := setJWTConfig(t, map[string]string{
+		"jwt::algorithm":        "RS256",
+		"jwt::private_key_file": oldPath,
+		"jwt::key_id":           "2025-01",
+	}); err != nil {
+		t.Fatalf("load RS256: %v", err)
+	}
+	oldToken, err := jwtutil.Generate("rotate-user")
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	if kid := tokenKID(t, oldToken); kid != "2025-01" {
+		t.Fatalf("expected kid 2025-01, got %q", kid)
+	}
+
+	// rotate to EdDSA, keeping the RSA key for verification only
+	if err := setJWTConfig(t, map[string]string{
+		"jwt::algorithm":        "EdDSA",
+		"jwt::private_key_file": newPath,
+		"jwt::key_id":           "",
+		"jwt::verify_keys":      "2025-01=" + oldPath,
+	}); err != nil {
+		t.Fatalf("load EdDSA: %v", err)
+	}
+	newToken, err := jwtutil.Generate("rotate-user")
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	for _, tok := range []string{oldToken, newToken} {
+		if c, err := jwtutil.Parse(tok); err != nil || c.Subject != "rotate-user" {
+			t.Fatalf("parse after rotation: %v %+v", err, c)
+		}
+	}
+
+	set, err := jwtutil.JWKS()
+	if err != nil {
+		t.Fatalf("jwks: %v", err)
+	}
+	keys := set["keys"].([]map[string]string)
+	if len(keys) != 2 || keys[0]["kty"] != "OKP" || keys[0]["kid"] != tokenKID(t, newToken) || keys[1]["kid"] != "2025-01" {
+		t.Fatalf("unexpected jwks: %+v", keys)
+	}
+	for _, k := range keys {
+		if _, leaked := k["d"]; leaked {
+			t.Fatalf("private member in jwks: %+v", k)
+		}
+	}
+
+	// once the old key is dropped its tokens stop verifying
+	if err := setJWTConfig(t, map[string]string{"jwt::verify_keys": ""}); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if _, err := jwtutil.Parse(oldToken); err == nil {
+		t.Fatalf("expected token signed by a removed key to be rejected")
+	}
+}
+
+func TestJWTRefusesDevSecretInProd(t *testing.T) {
+	if os.Getenv("JWT_SECRET") != "" {
+		t.Skip("JWT_SECRET is set")
+	}
+	mode := web.BConfig.RunMode
+	web.BConfig.RunMode = "prod"
+	t.Cleanup(func() {
+		web.BConfig.RunMode = mode
+		_ = jwtutil.Load()
+	})
+	if err := jwtutil.Load(); err == nil {
+		t.Fatalf("expected prod to refuse the development secret")
+	}
+}
@@ -7,7 +7,7 @@ import (
 )
 
 func TestJWTRoundTrip(t *testing.T) {
-	token, err := jwtutil.Generate("user@example.com")
+	token, _, err := jwtutil.Issue("user-id", jwtutil.IssueOptions{Email: "user@example.com"})
 	if err != nil {
 		t.Fatalf("generate: %v", err)
 	}
@@ -15,7 +15,7 @@ func TestJWTRoundTrip(t *testing.T) {
 	if err != nil {
 		t.Fatalf("parse: %v", err)
 	}
-	if claims.Email != "user@example.com" {
+	if claims.Subject != "user-id" || claims.Email != "user@example.com" {
 		t.Fatalf("claims mismatch: %+v", claims)
 	}
 }
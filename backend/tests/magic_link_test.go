@@ -0,0 +1,85 @@
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/beego/beego/v2/server/web"
+
+	"github.com/mymi14s/goconda/models"
+)
+
+func TestMagicLinkTokenIsBoundAndSingleUse(t *testing.T) {
+	userID := "magic-user"
+	tok, nonce, err := models.CreateMagicLinkToken(userID, time.Minute, "")
+	if err != nil {
+		t.Fatalf("CreateMagicLinkToken: %v", err)
+	}
+	if tok.NonceHash == nonce {
+		t.Fatalf("raw nonce must not be stored")
+	}
+	if _, err := models.ConsumeMagicLinkToken(tok.Token, "other-browser"); err == nil {
+		t.Fatalf("expected a different browser nonce to be rejected")
+	}
+	got, err := models.ConsumeMagicLinkToken(tok.Token, nonce)
+	if err != nil || got != userID {
+		t.Fatalf("ConsumeMagicLinkToken: %q %v", got, err)
+	}
+	if _, err := models.ConsumeMagicLinkToken(tok.Token, nonce); err == nil {
+		t.Fatalf("expected second redemption to fail")
+	}
+
+	expired, nonce, _ := models.CreateMagicLinkToken(userID, -time.Minute, "")
+	if _, err := models.ConsumeMagicLinkToken(expired.Token, nonce); err == nil {
+		t.Fatalf("expected expired link to be rejected")
+	}
+}
+
+func TestMagicLinkRequestsShareTheBrowserNonce(t *testing.T) {
+	sent := captureMail(t)
+	u := newUser(t, "magic.twice@example.com")
+	request := func(cookie *http.Cookie) *http.Cookie {
+		t.Helper()
+		req := httptest.NewRequest("POST", "/api/v1/auth/magic-link", strings.NewReader(`{"email":"magic.twice@example.com"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.RemoteAddr = "198.51.100.9:1234"
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		rec := httptest.NewRecorder()
+		web.BeeApp.Handlers.ServeHTTP(rec, req)
+		if rec.Code != 202 {
+			t.Fatalf("request: %d %s", rec.Code, rec.Body)
+		}
+		for _, c := range rec.Result().Cookies() {
+			if c.Name == "goconda_magic" {
+				return c
+			}
+		}
+		t.Fatal("no nonce cookie")
+		return nil
+	}
+	first := request(nil)
+	if second := request(first); second.Value != first.Value {
+		t.Fatalf("expected a second request to keep the nonce of the first")
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-sent:
+		case <-time.After(2 * time.Second):
+			t.Fatal("no email sent")
+		}
+	}
+
+	// so both links work in that browser
+	a, _, _ := models.CreateMagicLinkToken(u.ID, time.Minute, first.Value)
+	b, _, _ := models.CreateMagicLinkToken(u.ID, time.Minute, first.Value)
+	for _, tok := range []*models.MagicLinkToken{a, b} {
+		if got, err := models.ConsumeMagicLinkToken(tok.Token, first.Value); err != nil || got != u.ID {
+			t.Fatalf("expected every link to work: %q %v", got, err)
+		}
+	}
+}
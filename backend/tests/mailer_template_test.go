@@ -0,0 +1,105 @@
+package tests
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/beego/beego/v2/server/web"
+
+	"github.com/mymi14s/goconda/models"
+	"github.com/mymi14s/goconda/utils/mailer"
+)
+
+// captureMail replaces the mail transport for the test and returns the
+// channel sent messages arrive on.
+func captureMail(t *testing.T) <-chan string {
+	t.Helper()
+	sent := make(chan string, 4)
+	prev := mailer.Transport
+	mailer.Transport = func(from string, rcpts []string, msg []byte) error {
+		sent <- strings.Join(rcpts, ",") + "\n" + string(msg)
+		return nil
+	}
+	t.Cleanup(func() { mailer.Transport = prev })
+	_ = web.AppConfig.Set("mailer::template_dir", "../views/email")
+	t.Cleanup(func() { _ = web.AppConfig.Set("mailer::template_dir", "") })
+	return sent
+}
+
+func TestRenderEmailTemplate(t *testing.T) {
+	captureMail(t)
+	m, err := mailer.Render("reset_password", map[string]any{
+		"FirstName": "<Ada>", "Link": "https://example.com/reset?token=abc", "ExpiresIn": "1 hour",
+	})
+	if err != nil {
+		t.Fatalf("Render: %v", err)
+	}
+	if !strings.Contains(m.Subject, "password") || strings.Contains(m.Subject, "\n") {
+		t.Fatalf("unexpected subject %q", m.Subject)
+	}
+	if !strings.Contains(m.Text, "https://example.com/reset?token=abc") || !strings.Contains(m.Text, "Hi <Ada>") {
+		t.Fatalf("unexpected text body %q", m.Text)
+	}
+	if !strings.Contains(m.HTML, "Hi &lt;Ada&gt;") {
+		t.Fatalf("expected HTML body to be escaped, got %q", m.HTML)
+	}
+	if _, err := mailer.Render("no_such_template", nil); err == nil {
+		t.Fatalf("expected error for a missing template")
+	}
+}
+
+func TestForgotPasswordSendsEmailNotToken(t *testing.T) {
+	sent := captureMail(t)
+	email := "mailed-reset@example.com"
+	_ = models.CreateUser(&models.User{Email: email, FirstName: "Reset"})
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/api/v1/auth/forgot-password", strings.NewReader(url.Values{"email": {email}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Host = "evil.example"
+	web.BeeApp.Handlers.ServeHTTP(rec, req)
+	if rec.Code != 202 {
+		t.Fatalf("expected 202, got %d %s", rec.Code, rec.Body)
+	}
+	if strings.Contains(rec.Body.String(), "token") {
+		t.Fatalf("token must not be in the response: %s", rec.Body)
+	}
+
+	select {
+	case msg := <-sent:
+		if !strings.HasPrefix(msg, email) || !strings.Contains(msg, "multipart/alternative") ||
+			!strings.Contains(msg, "https://app.example.test/reset-password?token=3D") {
+			t.Fatalf("unexpected email:\n%s", msg)
+		}
+		if strings.Contains(msg, "evil.example") {
+			t.Fatalf("expected links not to use the request's Host:\n%s", msg)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("no email sent")
+	}
+
+	// unknown accounts get the same answer and no email
+	rec = httptest.NewRecorder()
+	req = httptest.NewRequest("POST", "/api/v1/auth/forgot-password", strings.NewReader("email=nobody@example.com"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	web.BeeApp.Handlers.ServeHTTP(rec, req)
+	if rec.Code != 202 {
+		t.Fatalf("expected 202 for unknown account, got %d", rec.Code)
+	}
+
+	// outside dev, no base URL means no link rather than one to the Host
+	_ = web.AppConfig.Set("mailer::base_url", "")
+	t.Cleanup(func() { _ = web.AppConfig.Set("mailer::base_url", "https://app.example.test") })
+	_ = models.CreateUser(&models.User{Email: "unlinked-reset@example.com", FirstName: "Reset"})
+	rec = httptest.NewRecorder()
+	req = httptest.NewRequest("POST", "/api/v1/auth/forgot-password", strings.NewReader("email=unlinked-reset@example.com"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.RemoteAddr = "198.51.100.8:1234" // keep the shared mail limit for other tests
+	web.BeeApp.Handlers.ServeHTTP(rec, req)
+	if rec.Code != 500 || len(sent) != 0 {
+		t.Fatalf("expected no email without a base URL, got %d", rec.Code)
+	}
+}
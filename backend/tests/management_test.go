@@ -0,0 +1,70 @@
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mymi14s/goconda/controllers"
+	"github.com/mymi14s/goconda/models"
+	"github.com/mymi14s/goconda/utils/hash"
+	"github.com/mymi14s/goconda/utils/scheduler"
+)
+
+func TestSetUserPassword(t *testing.T) {
+	u := newUser(t, "ops.password@example.com")
+	now := time.Now()
+	if err := models.RecordSession("ops-jti", u.ID, "test-agent", "127.0.0.1", now, now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := controllers.SetUserPassword(u, "short"); err == nil {
+		t.Fatalf("expected the password policy to apply")
+	}
+	if err := controllers.SetUserPassword(u, "Correct-Horse-Battery-9"); err != nil {
+		t.Fatal(err)
+	}
+	if fresh, _ := models.GetUserByID(u.ID); fresh == nil || !hash.Check("Correct-Horse-Battery-9", fresh.PasswordHash) {
+		t.Fatalf("password not stored")
+	}
+	if revoked, _ := models.IsTokenRevoked("ops-jti"); !revoked {
+		t.Fatalf("expected the user to be signed out")
+	}
+	if err := controllers.SetUserPassword(u, "Correct-Horse-Battery-9"); err == nil {
+		t.Fatalf("expected the current password to be refused")
+	}
+}
+
+func TestRevokeAllPersonalAccessTokens(t *testing.T) {
+	u := newUser(t, "ops.tokens@example.com")
+	for _, name := range []string{"ci", "backup"} {
+		if _, _, err := models.CreatePersonalAccessToken(u.ID, name, 0, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n, err := models.RevokeAllPersonalAccessTokens(u.ID); err != nil || n != 2 {
+		t.Fatalf("revoke: %v %d", err, n)
+	}
+	if left, _ := models.ListPersonalAccessTokens(u.ID); len(left) != 0 {
+		t.Fatalf("expected no live tokens, got %d", len(left))
+	}
+}
+
+func TestSchedulerRun(t *testing.T) {
+	defer scheduler.Stop()
+	ran := 0
+	if _, err := scheduler.Register("ops-job", "0 0 0 1 1 *", func() { ran++ }); err != nil {
+		t.Fatal(err)
+	}
+	if err := scheduler.Run("ops-job"); err != nil || ran != 1 {
+		t.Fatalf("run: %v %d", err, ran)
+	}
+	if err := scheduler.Run("missing"); err == nil {
+		t.Fatalf("expected an unknown job to fail")
+	}
+	found := false
+	for _, name := range scheduler.Names() {
+		found = found || name == "ops-job"
+	}
+	if !found {
+		t.Fatalf("job not listed: %v", scheduler.Names())
+	}
+}
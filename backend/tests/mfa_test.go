@@ -0,0 +1,136 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/beego/beego/v2/server/web"
+
+	"github.com/mymi14s/goconda/models"
+	"github.com/mymi14s/goconda/utils/hash"
+	"github.com/mymi14s/goconda/utils/totp"
+)
+
+// RFC 6238 appendix B vectors, truncated to 6 digits.
+func TestTOTPVectors(t *testing.T) {
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ" // "12345678901234567890"
+	cases := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		2000000000: "279037",
+	}
+	for ts, want := range cases {
+		got, err := totp.Code(secret, time.Unix(ts, 0))
+		if err != nil {
+			t.Fatalf("code: %v", err)
+		}
+		if got != want {
+			t.Fatalf("T=%d: want %s got %s", ts, want, got)
+		}
+	}
+	if _, ok := totp.Validate(secret, "287082", time.Unix(59+30, 0), 1); !ok {
+		t.Fatalf("expected previous step to validate with skew 1")
+	}
+	if _, ok := totp.Validate(secret, "287082", time.Unix(59+90, 0), 1); ok {
+		t.Fatalf("expected code outside skew to fail")
+	}
+	if uri := totp.URI("goconda", "a@example.com", secret); !strings.HasPrefix(uri, "otpauth://totp/goconda:a@example.com?") {
+		t.Fatalf("unexpected uri %s", uri)
+	}
+}
+
+func TestMFAEnrollmentAndRecoveryCodes(t *testing.T) {
+	userID := "mfa-user"
+	secret, _ := totp.GenerateSecret()
+	if err := models.StartMFAEnrollment(userID, secret); err != nil {
+		t.Fatalf("StartMFAEnrollment: %v", err)
+	}
+	codes, err := models.EnableMFA(userID)
+	if err != nil || len(codes) != 10 {
+		t.Fatalf("EnableMFA: %v %v", codes, err)
+	}
+	if err := models.StartMFAEnrollment(userID, secret); err != models.ErrMFAAlreadyEnabled {
+		t.Fatalf("expected already enabled, got %v", err)
+	}
+
+	// a TOTP step is accepted only once
+	step := totp.Step(time.Now())
+	if ok, _ := models.MarkMFAStep(userID, step); !ok {
+		t.Fatalf("expected fresh step to be accepted")
+	}
+	if ok, _ := models.MarkMFAStep(userID, step); ok {
+		t.Fatalf("expected replayed step to be rejected")
+	}
+
+	// recovery codes are single use and tolerate formatting
+	if ok, _ := models.UseRecoveryCode(userID, strings.ToUpper(strings.ReplaceAll(codes[0], "-", ""))); !ok {
+		t.Fatalf("expected recovery code to be accepted")
+	}
+	if ok, _ := models.UseRecoveryCode(userID, codes[0]); ok {
+		t.Fatalf("expected used recovery code to be rejected")
+	}
+
+	if err := models.SetResourceMFA("billing", true); err != nil {
+		t.Fatalf("SetResourceMFA: %v", err)
+	}
+	if need, _ := models.ResourceRequiresMFA("billing"); !need {
+		t.Fatalf("expected billing to require MFA")
+	}
+	if need, _ := models.ResourceRequiresMFA("items"); need {
+		t.Fatalf("expected items not to require MFA")
+	}
+}
+
+func TestMFAFailuresAreNotForgiven(t *testing.T) {
+	if err := setJWTConfig(t, map[string]string{"jwt::secret": "mfa-guess-secret"}); err != nil {
+		t.Fatal(err)
+	}
+	email := "mfa.guess@example.com"
+	pw, _ := hash.Make("correct horse battery")
+	u := &models.User{Email: email, FirstName: "Guess", PasswordHash: pw}
+	if err := models.CreateUser(u); err != nil {
+		t.Fatal(err)
+	}
+	secret, _ := totp.GenerateSecret()
+	_ = models.StartMFAEnrollment(u.ID, secret)
+	_, _ = models.EnableMFA(u.ID)
+
+	post := func(path string, body any) (int, map[string]any) {
+		t.Helper()
+		raw, _ := json.Marshal(body)
+		req := httptest.NewRequest("POST", path, bytes.NewReader(raw))
+		req.Header.Set("Content-Type", "application/json")
+		req.RemoteAddr = "198.51.100.30:1234"
+		rec := httptest.NewRecorder()
+		web.BeeApp.Handlers.ServeHTTP(rec, req)
+		var out struct {
+			Data map[string]any `json:"data"`
+		}
+		_ = json.Unmarshal(rec.Body.Bytes(), &out)
+		return rec.Code, out.Data
+	}
+	login := map[string]string{"email": email, "password": "correct horse battery"}
+	code, data := post("/api/v1/auth/login", login)
+	if code != 200 || data["mfa_required"] != true {
+		t.Fatalf("login: %d %v", code, data)
+	}
+
+	// the last allowed guess revokes the token but keeps the count
+	for i := 0; i < 4; i++ {
+		_, _ = models.RecordMFAFailure(u.ID)
+	}
+	if code, _ := post("/api/v1/auth/mfa/verify", map[string]string{"mfa_token": data["mfa_token"].(string), "code": "000000"}); code != 401 {
+		t.Fatalf("expected the bad code to be refused, got %d", code)
+	}
+	if m, _ := models.GetUserMFA(u.ID); m == nil || m.FailedAttempts != 5 {
+		t.Fatalf("expected the failures to survive the lockout, got %+v", m)
+	}
+	// and counts against the login limiter, even with the right password
+	if code, _ := post("/api/v1/auth/login", login); code != 429 {
+		t.Fatalf("expected a bad code to throttle the next login, got %d", code)
+	}
+}
@@ -0,0 +1,204 @@
+package tests
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/beego/beego/v2/client/orm"
+
+	"github.com/mymi14s/goconda/apps"
+	"github.com/mymi14s/goconda/utils/migrate"
+)
+
+// schema describes every table of a SQLite database: its columns with type,
+// nullability and default, and its indexes.
+func schema(t *testing.T, alias string) map[string][]string {
+	t.Helper()
+	o := orm.NewOrmUsingDB(alias)
+	var tables orm.ParamsList
+	if _, err := o.Raw("SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' AND name != ?", migrate.Table).ValuesFlat(&tables); err != nil {
+		t.Fatal(err)
+	}
+	out := map[string][]string{}
+	for _, table := range tables {
+		var cols []orm.Params
+		if _, err := o.Raw("SELECT name, type, \"notnull\", dflt_value, pk FROM pragma_table_info(?) ORDER BY name", table).Values(&cols); err != nil {
+			t.Fatal(err)
+		}
+		for _, c := range cols {
+			out[fmt.Sprint(table)] = append(out[fmt.Sprint(table)], fmt.Sprintf("%v %v notnull=%v default=%v pk=%v", c["name"], c["type"], c["notnull"], c["dflt_value"], c["pk"]))
+		}
+		var indexes orm.ParamsList
+		if _, err := o.Raw("SELECT name FROM sqlite_master WHERE type = 'index' AND tbl_name = ? AND sql IS NOT NULL ORDER BY name", table).ValuesFlat(&indexes); err != nil {
+			t.Fatal(err)
+		}
+		for _, i := range indexes {
+			out[fmt.Sprint(table)] = append(out[fmt.Sprint(table)], fmt.Sprint("index ", i))
+		}
+	}
+	return out
+}
+
+func TestMigrationsMatchModels(t *testing.T) {
+	dir := t.TempDir()
+	for _, alias := range []string{"migrated", "synced"} {
+		if err := orm.RegisterDataBase(alias, "sqlite3", "file:"+filepath.Join(dir, alias+".db")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := orm.RunSyncdb("synced", false, false); err != nil {
+		t.Fatal(err)
+	}
+	m, err := apps.Migrator("migrated")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done, err := m.Up(0)
+	if err != nil {
+		t.Fatalf("up: %v", err)
+	}
+	if len(done) != len(apps.Migrations()) {
+		t.Fatalf("applied %d of %d migrations", len(done), len(apps.Migrations()))
+	}
+
+	got, want := schema(t, "migrated"), schema(t, "synced")
+	// the one index syncdb cannot express
+	want["user_role"] = append(want["user_role"], "index idx_user_role_unique")
+	for table, cols := range want {
+		if fmt.Sprint(got[table]) != fmt.Sprint(cols) {
+			t.Errorf("%s drifted from its model:\n migrated %v\n model    %v", table, got[table], cols)
+		}
+	}
+	for table := range got {
+		if _, ok := want[table]; !ok {
+			t.Errorf("table %s has no model", table)
+		}
+	}
+
+	status, err := m.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range status {
+		if !s.Applied || s.Unknown || s.AppliedAt.IsZero() {
+			t.Fatalf("unexpected status %+v", s)
+		}
+	}
+	if done, err := m.Up(0); err != nil || len(done) != 0 {
+		t.Fatalf("expected nothing to apply: %v %v", err, done)
+	}
+
+	if done, err := m.Down(1); err != nil || len(done) != 1 || done[0].Version != status[len(status)-1].Version {
+		t.Fatalf("down 1: %v %v", err, done)
+	}
+	if _, err := m.Down(len(status)); err != nil {
+		t.Fatalf("down all: %v", err)
+	}
+	if left := schema(t, "migrated"); len(left) != 0 {
+		t.Fatalf("expected every table to be dropped, got %v", left)
+	}
+	if pending, _ := m.Pending(); len(pending) != len(status) {
+		t.Fatalf("expected everything pending, got %d", len(pending))
+	}
+}
+
+func TestMigrateLoad(t *testing.T) {
+	fsys := fstest.MapFS{
+		"m/20260101000000_tags.up.sql":         {Data: []byte("-- tags\nCREATE TABLE tag (id integer);\nCREATE INDEX tag_id ON tag (id);\n")},
+		"m/20260101000000_tags.mysql.up.sql":   {Data: []byte("CREATE TABLE tag (id bigint);")},
+		"m/20260101000000_tags.down.sql":       {Data: []byte("DROP TABLE tag;")},
+		"m/20260102000000_seed.sqlite3.up.sql": {Data: []byte("INSERT INTO tag VALUES (1);\nINSERT INTO tag VALUES (2);")},
+		"m/README.md":                          {Data: []byte("ignored")},
+	}
+	migs, err := migrate.Load("tags", fsys, "m")
+	if err != nil || len(migs) != 2 || migs[0].Name != "tags" || migs[1].Down != nil {
+		t.Fatalf("load: %v %+v", err, migs)
+	}
+	if err := orm.RegisterDataBase("tags", "sqlite3", "file:"+filepath.Join(t.TempDir(), "tags.db")); err != nil {
+		t.Fatal(err)
+	}
+	m, err := migrate.New("tags", migs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Up(0); err != nil {
+		t.Fatalf("up: %v", err)
+	}
+	db := migrate.DB{QueryExecutor: orm.NewOrmUsingDB("tags"), Dialect: migrate.SQLite}
+	// an existing index is skipped rather than failing
+	if err := db.Exec("CREATE INDEX tag_id ON tag (id);"); err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	if err := db.Raw("SELECT COUNT(*) FROM tag").QueryRow(&n); err != nil || n != 2 {
+		t.Fatalf("seed: %v %d", err, n)
+	}
+	if _, err := m.Down(1); err == nil {
+		t.Fatalf("expected a migration without a down script to be irreversible")
+	}
+
+	// a failed migration is rolled back with its history row
+	bad := append(migs, migrate.Migration{Version: "20260103000000", Name: "bad", App: "tags",
+		Up: migrate.SQL("INSERT INTO tag VALUES (3);\nINSERT INTO missing VALUES (1);")})
+	if m, err = migrate.New("tags", bad); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Up(0); err == nil {
+		t.Fatalf("expected the migration to fail")
+	}
+	if err := db.Raw("SELECT COUNT(*) FROM tag").QueryRow(&n); err != nil || n != 2 {
+		t.Fatalf("expected the failed migration to be rolled back: %v %d", err, n)
+	}
+	if pending, _ := m.Pending(); len(pending) != 1 {
+		t.Fatalf("expected the failed migration to stay pending, got %v", pending)
+	}
+	if _, err := migrate.New("tags", append(bad, bad[0])); err == nil {
+		t.Fatalf("expected a duplicate version to be refused")
+	}
+}
+
+// preSeriesTables are tables as the first release created them, before
+// role inheritance and deny rules.
+var preSeriesTables = []string{
+	"CREATE TABLE `role` (`name` varchar(100) NOT NULL PRIMARY KEY)",
+	"CREATE TABLE `permission` (`id` integer NOT NULL PRIMARY KEY AUTOINCREMENT, `role` varchar(100) NOT NULL DEFAULT '', `resource` varchar(191) NOT NULL DEFAULT '', `action` varchar(50) NOT NULL DEFAULT '')",
+	"INSERT INTO `role` VALUES ('Reader')",
+	"INSERT INTO `permission` (`role`, `resource`, `action`) VALUES ('Reader', 'items', 'read')",
+}
+
+func TestMigrateAddsColumnsToOldTables(t *testing.T) {
+	dir := t.TempDir()
+	for _, alias := range []string{"old_rbac", "synced_rbac"} {
+		if err := orm.RegisterDataBase(alias, "sqlite3", "file:"+filepath.Join(dir, alias+".db")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := orm.RunSyncdb("synced_rbac", false, false); err != nil {
+		t.Fatal(err)
+	}
+	o := orm.NewOrmUsingDB("old_rbac")
+	for _, stmt := range preSeriesTables {
+		if _, err := o.Raw(stmt).Exec(); err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+	m, err := apps.Migrator("old_rbac")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Up(0); err != nil {
+		t.Fatalf("up: %v", err)
+	}
+	got, want := schema(t, "old_rbac"), schema(t, "synced_rbac")
+	for _, table := range []string{"role", "permission"} {
+		if fmt.Sprint(got[table]) != fmt.Sprint(want[table]) {
+			t.Errorf("%s was not upgraded:\n migrated %v\n model    %v", table, got[table], want[table])
+		}
+	}
+	var effect string
+	if err := o.Raw("SELECT `effect` FROM `permission` WHERE `role` = 'Reader'").QueryRow(&effect); err != nil || effect != "allow" {
+		t.Fatalf("expected old rules to become allow rules: %v %q", err, effect)
+	}
+}
@@ -0,0 +1,211 @@
+package tests
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/beego/beego/v2/server/web"
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/mymi14s/goconda/models"
+	"github.com/mymi14s/goconda/utils/oidc"
+
+	_ "github.com/mymi14s/goconda/routers"
+)
+
+// stubOIDC is a minimal OpenID provider: discovery, JWKS and a token
+// endpoint that checks the PKCE verifier and returns a signed ID token.
+type stubOIDC struct {
+	srv       *httptest.Server
+	key       *rsa.PrivateKey
+	challenge string
+	nonce     string
+	email     string
+	verified  bool
+}
+
+func newStubOIDC(t *testing.T) *stubOIDC {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &stubOIDC{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 s.srv.URL,
+			"authorization_endpoint": s.srv.URL + "/authorize",
+			"token_endpoint":         s.srv.URL + "/token",
+			"jwks_uri":               s.srv.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		b64 := base64.RawURLEncoding.EncodeToString
+		_ = json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA", "kid": "stub", "use": "sig",
+			"n": b64(key.N.Bytes()), "e": b64(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		if r.Form.Get("code") != "good-code" || oidc.PKCEChallenge(r.Form.Get("code_verifier")) != s.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		tok := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+			"iss": s.srv.URL, "aud": "stub-client", "sub": "subject-" + s.email,
+			"exp": time.Now().Add(time.Minute).Unix(), "iat": time.Now().Unix(),
+			"nonce": s.nonce, "email": s.email, "email_verified": s.verified,
+			"given_name": "Ada", "family_name": "Lovelace",
+		})
+		tok.Header["kid"] = "stub"
+		signed, _ := tok.SignedString(key)
+		_ = json.NewEncoder(w).Encode(map[string]string{"access_token": "at", "id_token": signed})
+	})
+	s.srv = httptest.NewServer(mux)
+	t.Cleanup(s.srv.Close)
+	return s
+}
+
+// signIn runs start + callback against the app and returns the callback response.
+func (s *stubOIDC) signIn(t *testing.T) *httptest.ResponseRecorder {
+	t.Helper()
+	start := httptest.NewRecorder()
+	web.BeeApp.Handlers.ServeHTTP(start, httptest.NewRequest("GET", "/api/v1/auth/oauth/stub/start?format=json", nil))
+	if start.Code != 200 {
+		t.Fatalf("start: %d %s", start.Code, start.Body)
+	}
+	var body struct {
+		Data struct {
+			AuthURL string `json:"auth_url"`
+		} `json:"data"`
+	}
+	_ = json.Unmarshal(start.Body.Bytes(), &body)
+	u, err := url.Parse(body.Data.AuthURL)
+	if err != nil || !strings.HasPrefix(body.Data.AuthURL, s.srv.URL+"/authorize") {
+		t.Fatalf("unexpected auth url %q", body.Data.AuthURL)
+	}
+	q := u.Query()
+	if q.Get("code_challenge_method") != "S256" || q.Get("state") == "" {
+		t.Fatalf("missing PKCE/state in %q", body.Data.AuthURL)
+	}
+	s.challenge, s.nonce = q.Get("code_challenge"), q.Get("nonce")
+
+	req := httptest.NewRequest("GET", "/api/v1/auth/oauth/stub/callback?code=good-code&state="+url.QueryEscape(q.Get("state")), nil)
+	for _, ck := range start.Result().Cookies() {
+		req.AddCookie(ck)
+	}
+	rec := httptest.NewRecorder()
+	web.BeeApp.Handlers.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestOAuthLoginCreatesAndLinksUser(t *testing.T) {
+	s := newStubOIDC(t)
+	oidc.Reset()
+	t.Cleanup(oidc.Reset)
+	if err := setJWTConfig(t, map[string]string{
+		"oauth::providers":      "stub",
+		"oauth_stub::issuer":    s.srv.URL,
+		"oauth_stub::client_id": "stub-client",
+		"jwt::secret":           "oauth-test-secret",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	s.email, s.verified = "oidc-user@example.com", true
+	rec := s.signIn(t)
+	if rec.Code != 200 || !strings.Contains(rec.Body.String(), `"token"`) {
+		t.Fatalf("callback: %d %s", rec.Code, rec.Body)
+	}
+	// login responses name the field last_name; lastname is a deprecated alias
+	if body := rec.Body.String(); !strings.Contains(body, `"last_name":"Lovelace"`) || !strings.Contains(body, `"lastname":"Lovelace"`) {
+		t.Fatalf("expected last_name in the login response: %s", body)
+	}
+	u, _ := models.GetUserByEmail(s.email)
+	if u == nil || u.FirstName != "Ada" {
+		t.Fatalf("expected user to be created, got %+v", u)
+	}
+	if ids, _ := models.ListUserIdentities(u.ID); len(ids) != 1 || ids[0].Provider != "stub" {
+		t.Fatalf("expected one linked identity, got %+v", ids)
+	}
+	if ok, _ := models.IsUserVerified(u); !ok {
+		t.Fatalf("expected provider-verified email to be marked verified")
+	}
+
+	// signing in again reuses the link
+	if rec := s.signIn(t); rec.Code != 200 {
+		t.Fatalf("second sign-in: %d %s", rec.Code, rec.Body)
+	}
+
+	// an unverified email must not create or claim an account
+	s.email, s.verified = "unverified@example.com", false
+	if rec := s.signIn(t); rec.Code != 403 {
+		t.Fatalf("expected 403 for unverified email, got %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestOAuthDoesNotClaimUnverifiedAccount(t *testing.T) {
+	s := newStubOIDC(t)
+	oidc.Reset()
+	t.Cleanup(oidc.Reset)
+	if err := setJWTConfig(t, map[string]string{
+		"oauth::providers":      "stub",
+		"oauth_stub::issuer":    s.srv.URL,
+		"oauth_stub::client_id": "stub-client",
+		"jwt::secret":           "oauth-test-secret",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// someone registered the address first and never verified it
+	squatter := newUser(t, "preclaimed@example.com")
+	s.email, s.verified = squatter.Email, true
+	if rec := s.signIn(t); rec.Code != 403 {
+		t.Fatalf("expected 403 for an unverified local account, got %d %s", rec.Code, rec.Body)
+	}
+	if ids, _ := models.ListUserIdentities(squatter.ID); len(ids) != 0 {
+		t.Fatalf("expected no identity to be linked, got %+v", ids)
+	}
+	if ok, _ := models.IsUserVerified(squatter); ok {
+		t.Fatalf("expected the account to stay unverified")
+	}
+
+	// a verified account is linked
+	_ = models.MarkUserVerified(squatter.ID, squatter.Email)
+	if rec := s.signIn(t); rec.Code != 200 {
+		t.Fatalf("expected a verified account to be linked, got %d %s", rec.Code, rec.Body)
+	}
+	if ids, _ := models.ListUserIdentities(squatter.ID); len(ids) != 1 {
+		t.Fatalf("expected one linked identity, got %+v", ids)
+	}
+}
+
+func TestOAuthCallbackRejectsBadState(t *testing.T) {
+	s := newStubOIDC(t)
+	oidc.Reset()
+	t.Cleanup(oidc.Reset)
+	if err := setJWTConfig(t, map[string]string{
+		"oauth::providers":      "stub",
+		"oauth_stub::issuer":    s.srv.URL,
+		"oauth_stub::client_id": "stub-client",
+		"jwt::secret":           "oauth-test-secret",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	web.BeeApp.Handlers.ServeHTTP(rec, httptest.NewRequest("GET", "/api/v1/auth/oauth/stub/callback?code=good-code&state=forged", nil))
+	if rec.Code != 400 {
+		t.Fatalf("expected 400 without state cookie, got %d %s", rec.Code, rec.Body)
+	}
+}
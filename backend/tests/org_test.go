@@ -0,0 +1,173 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/beego/beego/v2/client/orm"
+
+	itemmodels "github.com/mymi14s/goconda/apps/items/models"
+	"github.com/mymi14s/goconda/models"
+	jwtutil "github.com/mymi14s/goconda/utils/jwt"
+)
+
+// inviteTokenRe finds the token in a quoted-printable email body.
+var inviteTokenRe = regexp.MustCompile(`token=3D([0-9a-f]+)`)
+
+// switchOrg exchanges token for one acting in org and returns it.
+func switchOrg(t *testing.T, token string, org int64) string {
+	t.Helper()
+	code, data := apiCall(t, token, "POST", "/api/v1/orgs/switch", map[string]int64{"org_id": org})
+	var resp struct {
+		Token string `json:"token"`
+	}
+	_ = json.Unmarshal(data, &resp)
+	if code != 200 || resp.Token == "" {
+		t.Fatalf("switch to %d: %d %s", org, code, data)
+	}
+	claims, err := jwtutil.Parse(resp.Token)
+	if err != nil || claims.Org != org {
+		t.Fatalf("expected org claim %d, got %+v (%v)", org, claims, err)
+	}
+	return resp.Token
+}
+
+func TestOrganizations(t *testing.T) {
+	if err := setJWTConfig(t, map[string]string{"jwt::secret": "org-test-secret"}); err != nil {
+		t.Fatal(err)
+	}
+	sent := captureMail(t)
+	o := orm.NewOrm()
+	owner, member, outsider := newUser(t, "owner@org.test").ID, newUser(t, "member@org.test").ID, newUser(t, "outsider@org.test").ID
+	tokens := map[string]string{}
+	for _, id := range []string{owner, member, outsider} {
+		tokens[id], _ = jwtutil.Generate(id)
+	}
+
+	code, data := apiCall(t, tokens[owner], "POST", "/api/v1/orgs", map[string]string{"name": "Acme, Inc."})
+	var org models.UserOrg
+	_ = json.Unmarshal(data, &org)
+	if code != 200 || org.Slug != "acme-inc" || org.Role != models.OrgRoleOwner {
+		t.Fatalf("create org: %d %s", code, data)
+	}
+	if code, _ := apiCall(t, tokens[outsider], "POST", "/api/v1/orgs", map[string]string{"name": "Other", "slug": "acme-inc"}); code != 409 {
+		t.Fatalf("expected duplicate slug to conflict, got %d", code)
+	}
+	orgPath := fmt.Sprintf("/api/v1/orgs/%d", org.ID)
+	if code, _ := apiCall(t, tokens[outsider], "GET", orgPath, nil); code != 404 {
+		t.Fatalf("expected outsiders to get 404, got %d", code)
+	}
+	if code, _ := apiCall(t, tokens[outsider], "POST", "/api/v1/orgs/switch", map[string]int64{"org_id": org.ID}); code != 404 {
+		t.Fatalf("expected outsiders not to switch in, got %d", code)
+	}
+
+	// items are scoped to the active organization
+	ownerOrg := switchOrg(t, tokens[owner], org.ID)
+	if code, _ := apiCall(t, tokens[owner], "GET", "/api/v1/items", nil); code != 401 {
+		t.Fatalf("expected switching to end the previous session, got %d", code)
+	}
+	tokens[owner], _ = jwtutil.Generate(owner)
+	code, data = apiCall(t, ownerOrg, "POST", "/api/v1/items", map[string]string{"name": "Roadmap"})
+	var it itemmodels.Item
+	_ = json.Unmarshal(data, &it)
+	if code != 200 || it.OrgID != org.ID {
+		t.Fatalf("create org item: %d %s", code, data)
+	}
+	if _, data := apiCall(t, tokens[owner], "POST", "/api/v1/items", map[string]string{"name": "Personal"}); len(data) == 0 {
+		t.Fatal("create personal item failed")
+	}
+	itemPath := fmt.Sprintf("/api/v1/items/%d", it.ID)
+	if code, _ := apiCall(t, tokens[owner], "GET", itemPath, nil); code != 404 {
+		t.Fatalf("expected org item to be invisible outside the org, got %d", code)
+	}
+	var list struct {
+		Total int64 `json:"total"`
+	}
+	_, data = apiCall(t, ownerOrg, "GET", "/api/v1/items", nil)
+	_ = json.Unmarshal(data, &list)
+	if list.Total != 1 {
+		t.Fatalf("org item list: %s", data)
+	}
+	if _, err := models.ForOrg(0).Delete(&itemmodels.Item{ID: it.ID}); err != orm.ErrNoRows {
+		t.Fatalf("expected scoped delete to miss another org's row, got %v", err)
+	}
+
+	// invitations
+	invPath := orgPath + "/invitations"
+	if code, _ := apiCall(t, tokens[owner], "POST", invPath, map[string]string{"email": "member@org.test", "role": "Superuser"}); code != 400 {
+		t.Fatalf("expected Superuser to be refused as an org role, got %d", code)
+	}
+	_ = models.EnsureRole("OrgReporter")
+	_ = models.Grant("OrgReporter", "reports", "read")
+	_ = models.Grant("OrgReporter", "rbac", "update")
+	if code, _ := apiCall(t, tokens[owner], "POST", invPath, map[string]string{"email": "member@org.test", "role": "OrgReporter"}); code != 400 {
+		t.Fatalf("expected a role not marked org_scoped to be refused, got %d", code)
+	}
+	if err := models.SetRoleOrgScoped("OrgReporter", true); err != nil {
+		t.Fatal(err)
+	}
+	if code, data := apiCall(t, tokens[owner], "POST", invPath, map[string]string{"email": "member@org.test", "role": "OrgReporter"}); code != 202 {
+		t.Fatalf("invite: %d %s", code, data)
+	}
+	body := strings.NewReplacer("=\r\n", "", "=\n", "").Replace(<-sent)
+	m := inviteTokenRe.FindStringSubmatch(body)
+	if m == nil {
+		t.Fatal("no token in invitation email")
+	}
+	accept := map[string]string{"token": m[1]}
+	if code, _ := apiCall(t, tokens[outsider], "POST", "/api/v1/orgs/invitations/accept", accept); code != 400 {
+		t.Fatalf("expected someone else's invitation to be refused, got %d", code)
+	}
+	if code, data := apiCall(t, tokens[member], "POST", "/api/v1/orgs/invitations/accept", accept); code != 200 {
+		t.Fatalf("accept: %d %s", code, data)
+	}
+	if code, _ := apiCall(t, tokens[member], "POST", "/api/v1/orgs/invitations/accept", accept); code != 400 {
+		t.Fatalf("expected an invitation to work once, got %d", code)
+	}
+
+	// the org role only applies inside the org
+	if ok, _ := models.HasPermissionIn(member, org.ID, "reports", "read"); !ok {
+		t.Fatal("expected the org role to grant reports:read in the org")
+	}
+	if ok, _ := models.HasPermission(member, "reports", "read"); ok {
+		t.Fatal("expected no reports:read outside the org")
+	}
+	if ok, _ := models.HasPermissionIn(member, org.ID, "rbac", "update"); ok {
+		t.Fatal("expected an org role never to grant site administration")
+	}
+	_ = models.Grant("Billing", "billing", "read")
+	if err := models.SetRoleParent("OrgReporter", "Billing"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := models.HasPermissionIn(member, org.ID, "billing", "read"); ok {
+		t.Fatal("expected an org role to inherit from org roles only")
+	}
+	memberOrg := switchOrg(t, tokens[member], org.ID)
+	if code, _ := apiCall(t, memberOrg, "PUT", orgPath, map[string]string{"name": "Renamed"}); code != 403 {
+		t.Fatalf("expected members not to manage the org, got %d", code)
+	}
+
+	// owners cannot all leave
+	if code, _ := apiCall(t, tokens[owner], "DELETE", orgPath+"/members/"+owner, nil); code != 400 {
+		t.Fatalf("expected the last owner to stay, got %d", code)
+	}
+
+	// removal takes effect on live org tokens
+	if code, _ := apiCall(t, tokens[owner], "DELETE", orgPath+"/members/"+member, nil); code != 200 {
+		t.Fatalf("remove member: %d", code)
+	}
+	if code, _ := apiCall(t, memberOrg, "GET", "/api/v1/items", nil); code != 403 {
+		t.Fatalf("expected a removed member's org token to be refused, got %d", code)
+	}
+
+	// deleting the org deletes its items
+	if code, _ := apiCall(t, tokens[owner], "DELETE", orgPath, nil); code != 200 {
+		t.Fatalf("delete org: %d", code)
+	}
+	if n, _ := o.QueryTable(new(itemmodels.Item)).Filter("OrgID", org.ID).Count(); n != 0 {
+		t.Fatalf("expected org items to be deleted, %d left", n)
+	}
+}
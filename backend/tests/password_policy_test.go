@@ -0,0 +1,115 @@
+package tests
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/beego/beego/v2/server/web"
+
+	"github.com/mymi14s/goconda/models"
+	"github.com/mymi14s/goconda/utils/hash"
+	"github.com/mymi14s/goconda/utils/validators"
+)
+
+func TestPasswordPolicy(t *testing.T) {
+	dir := t.TempDir()
+	bannedFile := filepath.Join(dir, "banned.txt")
+	if err := os.WriteFile(bannedFile, []byte("# comment\nCorrectHorse1\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// offline breach list entry for "Tr0ub4dor&3"
+	sum := sha1.Sum([]byte("Tr0ub4dor&3"))
+	h := strings.ToUpper(hex.EncodeToString(sum[:]))
+	if err := os.WriteFile(filepath.Join(dir, h[:5]+".txt"), []byte("0000000000000000000000000000000000A:1\n"+h[5:]+":42\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	p := validators.PasswordPolicy{MinLength: 8, MaxLength: 64, RequireDigit: true, RequireSymbol: true,
+		BannedFile: bannedFile, BreachDir: dir}
+
+	if err := p.Validate("long-enough-1", "someone@example.com"); err != nil {
+		t.Fatalf("expected valid password, got %v", err)
+	}
+	var perr *validators.PasswordError
+	if err := p.Validate("short"); !errors.As(err, &perr) || len(perr.Problems) != 3 {
+		t.Fatalf("expected length, digit and symbol problems, got %v", err)
+	}
+	p.RequireSymbol = false
+	if err := p.Validate("CORRECTHORSE1"); err == nil || !strings.Contains(err.Error(), "too common") {
+		t.Fatalf("expected banned password to be rejected, got %v", err)
+	}
+	if err := p.Validate("Tr0ub4dor&3"); err == nil || !strings.Contains(err.Error(), "breach") {
+		t.Fatalf("expected breached password to be rejected, got %v", err)
+	}
+	if err := p.Validate("alice-rocks-99", "alice@example.com"); err == nil {
+		t.Fatalf("expected password containing the email name to be rejected")
+	}
+}
+
+func TestPasswordPolicyFitsBcrypt(t *testing.T) {
+	t.Cleanup(func() { _ = web.AppConfig.Set("password::algorithm", "") })
+	long := strings.Repeat("long-pass-", 10) // 100 characters, 100 bytes
+	_ = web.AppConfig.Set("password::algorithm", "bcrypt")
+	if err := validators.ValidatePassword(long); err == nil || !strings.Contains(err.Error(), "at most 72 bytes") {
+		t.Fatalf("expected bcrypt's 72-byte limit to apply, got %v", err)
+	}
+	if err := validators.ValidatePassword(strings.Repeat("é", 40)); err == nil {
+		t.Fatalf("expected the limit to count bytes, not characters")
+	}
+	_ = web.AppConfig.Set("password::algorithm", "argon2id")
+	if err := validators.ValidatePassword(long); err != nil {
+		t.Fatalf("expected argon2id to take long passwords, got %v", err)
+	}
+	if _, err := hash.Make(long); err != nil {
+		t.Fatalf("hash: %v", err)
+	}
+}
+
+func TestPasswordRehashAndArgon2(t *testing.T) {
+	t.Cleanup(func() {
+		_ = web.AppConfig.Set("password::algorithm", "")
+		_ = web.AppConfig.Set("password::bcrypt_cost", "")
+	})
+	_ = web.AppConfig.Set("password::bcrypt_cost", "4")
+	old, _ := hash.Make("s3cret-pass")
+	if hash.NeedsRehash(old) {
+		t.Fatalf("fresh hash should not need a rehash")
+	}
+	_ = web.AppConfig.Set("password::bcrypt_cost", "5")
+	if !hash.NeedsRehash(old) {
+		t.Fatalf("expected cost change to require a rehash")
+	}
+
+	_ = web.AppConfig.Set("password::algorithm", "argon2id")
+	if !hash.NeedsRehash(old) {
+		t.Fatalf("expected algorithm change to require a rehash")
+	}
+	a2, err := hash.Make("s3cret-pass")
+	if err != nil || !strings.HasPrefix(a2, "$argon2id$") {
+		t.Fatalf("argon2id hash: %q %v", a2, err)
+	}
+	if !hash.Check("s3cret-pass", a2) || hash.Check("wrong", a2) {
+		t.Fatalf("argon2id check failed")
+	}
+	// bcrypt hashes still verify after switching
+	if !hash.Check("s3cret-pass", old) || hash.NeedsRehash(a2) {
+		t.Fatalf("expected old hashes to keep working")
+	}
+}
+
+func TestPasswordHistoryKeepsNewest(t *testing.T) {
+	userID := "history-user"
+	for _, h := range []string{"h1", "h2", "h3", "h4"} {
+		if err := models.AddPasswordHistory(userID, h, 2); err != nil {
+			t.Fatalf("AddPasswordHistory: %v", err)
+		}
+	}
+	got, err := models.RecentPasswordHashes(userID, 10)
+	if err != nil || len(got) != 2 || got[0] != "h4" || got[1] != "h3" {
+		t.Fatalf("expected [h4 h3], got %v %v", got, err)
+	}
+}
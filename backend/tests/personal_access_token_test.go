@@ -0,0 +1,97 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mymi14s/goconda/models"
+	jwtutil "github.com/mymi14s/goconda/utils/jwt"
+)
+
+func TestPersonalAccessTokens(t *testing.T) {
+	if err := setJWTConfig(t, map[string]string{"jwt::secret": "pat-test-secret"}); err != nil {
+		t.Fatal(err)
+	}
+	u := newUser(t, "pat.user@example.com")
+	_ = models.EnsureRole("PatAuditor")
+	_ = models.Grant("PatAuditor", "rbac", "read")
+	_ = models.AssignRole(u.ID, "PatAuditor")
+	jwt, _ := jwtutil.Generate(u.ID)
+
+	create := func(scopes ...string) (int64, string) {
+		t.Helper()
+		code, data := apiCall(t, jwt, "POST", "/api/v1/auth/tokens", map[string]any{"name": "ci", "scopes": scopes, "expires_in_days": 30})
+		var out struct {
+			ID    int64  `json:"id"`
+			Token string `json:"token"`
+		}
+		_ = json.Unmarshal(data, &out)
+		if code != 200 || !strings.HasPrefix(out.Token, models.PATPrefix) {
+			t.Fatalf("create token: %d %s", code, data)
+		}
+		return out.ID, out.Token
+	}
+	if code, _ := apiCall(t, jwt, "POST", "/api/v1/auth/tokens", map[string]any{"name": "bad", "scopes": []string{"items"}}); code != 400 {
+		t.Fatalf("expected a malformed scope to be refused, got %d", code)
+	}
+	id, pat := create("items:read")
+
+	if code, _ := apiCall(t, pat, "GET", "/api/v1/items", nil); code != 200 {
+		t.Fatalf("expected items:read to list items, got %d", code)
+	}
+	if code, _ := apiCall(t, pat, "POST", "/api/v1/items", map[string]string{"name": "x"}); code != 403 {
+		t.Fatalf("expected items:read not to create, got %d", code)
+	}
+	if code, _ := apiCall(t, pat, "GET", "/api/v1/admin/roles", nil); code != 403 {
+		t.Fatalf("expected the scope to limit RBAC permissions, got %d", code)
+	}
+	if code, _ := apiCall(t, pat, "GET", "/api/v1/auth/tokens", nil); code != 403 {
+		t.Fatalf("expected tokens to be refused on auth endpoints, got %d", code)
+	}
+	if code, _ := apiCall(t, pat, "GET", "/api/v1/users/me", nil); code != 403 {
+		t.Fatalf("expected the profile to need the users scope, got %d", code)
+	}
+	_, profile := create("users:read")
+	if code, _ := apiCall(t, profile, "GET", "/api/v1/users/me", nil); code != 200 {
+		t.Fatalf("expected users:read to read the profile, got %d", code)
+	}
+	if code, _ := apiCall(t, profile, "PATCH", "/api/v1/users/me", map[string]string{"first_name": "Scoped"}); code != 403 {
+		t.Fatalf("expected users:read not to update the profile, got %d", code)
+	}
+	if code, _ := apiCall(t, profile, "DELETE", "/api/v1/users/me", nil); code != 403 {
+		t.Fatalf("expected users:read not to delete the account, got %d", code)
+	}
+
+	// scopes never add to the user's own permissions
+	_, wide := create("rbac:*")
+	if code, _ := apiCall(t, wide, "GET", "/api/v1/admin/roles", nil); code != 200 {
+		t.Fatalf("expected rbac:* to allow rbac read, got %d", code)
+	}
+	if code, _ := apiCall(t, wide, "POST", "/api/v1/admin/roles", map[string]string{"name": "PatNope"}); code != 403 {
+		t.Fatalf("expected rbac create to need the user's permission too, got %d", code)
+	}
+
+	code, data := apiCall(t, jwt, "GET", "/api/v1/auth/tokens", nil)
+	if code != 200 || strings.Contains(string(data), pat) || !strings.Contains(string(data), `"last_used_at":"`) {
+		t.Fatalf("list tokens: %d %s", code, data)
+	}
+
+	if code, _ := apiCall(t, jwt, "DELETE", fmt.Sprintf("/api/v1/auth/tokens/%d", id), nil); code != 200 {
+		t.Fatalf("revoke: %d", code)
+	}
+	if code, _ := apiCall(t, pat, "GET", "/api/v1/items", nil); code != 401 {
+		t.Fatalf("expected a revoked token to be refused, got %d", code)
+	}
+
+	past := time.Now().Add(-time.Minute)
+	_, expired, err := models.CreatePersonalAccessToken(u.ID, "old", 0, []string{"items:read"}, &past)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code, _ := apiCall(t, expired, "GET", "/api/v1/items", nil); code != 401 {
+		t.Fatalf("expected an expired token to be refused, got %d", code)
+	}
+}
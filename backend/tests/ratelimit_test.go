@@ -0,0 +1,129 @@
+package tests
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/beego/beego/v2/server/web"
+
+	"github.com/mymi14s/goconda/models"
+	jwtutil "github.com/mymi14s/goconda/utils/jwt"
+	"github.com/mymi14s/goconda/utils/ratelimit"
+)
+
+func TestLimiterProgressiveDelay(t *testing.T) {
+	l := ratelimit.New(ratelimit.NewMemoryStore(), ratelimit.Policy{
+		MaxFailures: 10, Window: time.Hour, Lockout: 24 * time.Hour, BaseDelay: time.Minute,
+	})
+	if w := l.Check("ip:1.2.3.4"); w != 0 {
+		t.Fatalf("expected no wait before failures, got %v", w)
+	}
+	first := l.Fail("ip:1.2.3.4")
+	second := l.Fail("ip:1.2.3.4")
+	if first <= 0 || first > time.Minute {
+		t.Fatalf("expected ~1m after first failure, got %v", first)
+	}
+	if second <= time.Minute || second > 2*time.Minute {
+		t.Fatalf("expected ~2m after second failure, got %v", second)
+	}
+	if w := l.Check("ip:5.6.7.8", "ip:1.2.3.4"); w <= time.Minute {
+		t.Fatalf("expected the longest wait across keys, got %v", w)
+	}
+}
+
+func TestLimiterLockoutDBStore(t *testing.T) {
+	l := ratelimit.New(models.LockoutStore{}, ratelimit.Policy{
+		MaxFailures: 3, Window: time.Hour, Lockout: 15 * time.Minute,
+	})
+	key := "login:email:locked@example.com"
+	_ = l.Reset(key)
+	for i := 0; i < 2; i++ {
+		if w := l.Fail(key); w != 0 {
+			t.Fatalf("failure %d: expected no wait without base delay, got %v", i+1, w)
+		}
+	}
+	if w := l.Fail(key); w < 14*time.Minute {
+		t.Fatalf("expected lockout after third failure, got %v", w)
+	}
+	locked, err := l.Locked()
+	if err != nil {
+		t.Fatalf("Locked: %v", err)
+	}
+	found := false
+	for _, s := range locked {
+		found = found || (s.Key == key && s.Failures == 3)
+	}
+	if !found {
+		t.Fatalf("expected %s in locked list, got %+v", key, locked)
+	}
+	if err := l.Reset(key); err != nil {
+		t.Fatalf("Reset: %v", err)
+	}
+	if w := l.Check(key); w != 0 {
+		t.Fatalf("expected no wait after reset, got %v", w)
+	}
+}
+
+func TestLockoutAdminAPI(t *testing.T) {
+	if err := setJWTConfig(t, map[string]string{"jwt::secret": "lockout-test-secret"}); err != nil {
+		t.Fatal(err)
+	}
+	writer, deleter := newUser(t, "lockout.writer@example.com").ID, newUser(t, "lockout.deleter@example.com").ID
+	_ = models.Grant("LockoutWriter", "lockouts", "write")
+	_ = models.AssignRole(writer, "LockoutWriter")
+	_ = models.Grant("LockoutDeleter", "lockouts", "delete")
+	_ = models.AssignRole(deleter, "LockoutDeleter")
+	writerTok, _ := jwtutil.Generate(writer)
+	deleterTok, _ := jwtutil.Generate(deleter)
+
+	clear := map[string]string{"key": "login:email:cleared@example.com"}
+	if code, _ := apiCall(t, writerTok, "DELETE", "/api/v1/admin/lockouts", clear); code != 403 {
+		t.Fatalf("expected lockouts:write not to clear lockouts, got %d", code)
+	}
+	if code, data := apiCall(t, deleterTok, "DELETE", "/api/v1/admin/lockouts", clear); code != 200 {
+		t.Fatalf("expected lockouts:delete to clear lockouts, got %d %s", code, data)
+	}
+}
+
+func TestLoginLimiterIgnoresSpoofedForwardedFor(t *testing.T) {
+	// login returns the IP recorded for the attempt, or "" when the limiter
+	// turned it away before the password was checked
+	login := func(email, remote, forwarded string) string {
+		t.Helper()
+		body := fmt.Sprintf(`{"email":%q,"password":"wrong password"}`, email)
+		req := httptest.NewRequest("POST", "/api/v1/auth/login", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Forwarded-For", forwarded)
+		req.RemoteAddr = remote
+		web.BeeApp.Handlers.ServeHTTP(httptest.NewRecorder(), req)
+		attempts, _ := models.RecentLoginAttempts(email, 1)
+		if len(attempts) == 0 {
+			return ""
+		}
+		return attempts[0].IP
+	}
+
+	// a new X-Forwarded-For per attempt does not escape the per-IP key
+	if ip := login("spoof.one@example.com", "203.0.113.50:1234", "192.0.2.1"); ip != "203.0.113.50" {
+		t.Fatalf("expected the peer address to be recorded, got %q", ip)
+	}
+	if ip := login("spoof.two@example.com", "203.0.113.50:1234", "192.0.2.2"); ip != "" {
+		t.Fatalf("expected the spoofed header to be ignored, got an attempt from %q", ip)
+	}
+
+	// behind a trusted proxy the forwarded client is the key
+	_ = web.AppConfig.Set("ratelimit::trusted_proxies", "203.0.113.0/24")
+	t.Cleanup(func() { _ = web.AppConfig.Set("ratelimit::trusted_proxies", "") })
+	if ip := login("proxied.one@example.com", "203.0.113.60:1234", "192.0.2.10"); ip != "192.0.2.10" {
+		t.Fatalf("expected the forwarded client to be recorded, got %q", ip)
+	}
+	if ip := login("proxied.two@example.com", "203.0.113.60:1234", "192.0.2.11"); ip != "192.0.2.11" {
+		t.Fatalf("expected another forwarded client not to be throttled, got %q", ip)
+	}
+	if ip := login("proxied.three@example.com", "203.0.113.60:1234", "198.51.100.1, 192.0.2.10"); ip != "" {
+		t.Fatalf("expected the right-most untrusted hop to be throttled, got an attempt from %q", ip)
+	}
+}
@@ -0,0 +1,184 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/beego/beego/v2/client/orm"
+	"github.com/beego/beego/v2/server/web"
+
+	"github.com/mymi14s/goconda/apps"
+	"github.com/mymi14s/goconda/models"
+	jwtutil "github.com/mymi14s/goconda/utils/jwt"
+)
+
+// apiCall sends a JSON request as the holder of token and decodes "data".
+func apiCall(t *testing.T, token, method, path string, body any) (int, json.RawMessage) {
+	t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		_ = json.NewEncoder(&buf).Encode(body)
+	}
+	req := httptest.NewRequest(method, path, &buf)
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	web.BeeApp.Handlers.ServeHTTP(rec, req)
+	var out struct {
+		Data json.RawMessage `json:"data"`
+	}
+	_ = json.Unmarshal(rec.Body.Bytes(), &out)
+	return rec.Code, out.Data
+}
+
+// newUser creates a user with email and returns it.
+func newUser(t *testing.T, email string) *models.User {
+	t.Helper()
+	u := &models.User{Email: email, FirstName: "Test", LastName: "User", PasswordHash: "x"}
+	if err := models.CreateUser(u); err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func TestRBACAdminAPI(t *testing.T) {
+	if err := setJWTConfig(t, map[string]string{"jwt::secret": "rbac-test-secret"}); err != nil {
+		t.Fatal(err)
+	}
+	admin, member := newUser(t, "rbac.admin@example.com").ID, newUser(t, "rbac.member@example.com").ID
+	_ = models.EnsureRole("RBACAdmin")
+	_ = models.Grant("RBACAdmin", "rbac", "*")
+	_ = models.Grant("RBACAdmin", "reports", "read")
+	_ = models.AssignRole(admin, "RBACAdmin")
+	adminTok, _ := jwtutil.Generate(admin)
+	memberTok, _ := jwtutil.Generate(member)
+
+	if code, _ := apiCall(t, memberTok, "GET", "/api/v1/admin/roles", nil); code != 403 {
+		t.Fatalf("expected 403 without rbac permission, got %d", code)
+	}
+
+	if code, _ := apiCall(t, adminTok, "POST", "/api/v1/admin/roles", map[string]string{"name": "Auditor"}); code != 200 {
+		t.Fatalf("create role: %d", code)
+	}
+	if code, _ := apiCall(t, adminTok, "POST", "/api/v1/admin/roles", map[string]string{"name": "Auditor"}); code != 409 {
+		t.Fatalf("expected duplicate role to conflict, got %d", code)
+	}
+	code, data := apiCall(t, adminTok, "POST", "/api/v1/admin/roles/Auditor/permissions",
+		map[string]string{"resource": "reports", "action": "read"})
+	if code != 200 {
+		t.Fatalf("add permission: %d %s", code, data)
+	}
+	var perm models.Permission
+	_ = json.Unmarshal(data, &perm)
+
+	// administrators only hand out what they hold; Superuser and wildcards
+	// are for superusers
+	for _, rule := range []map[string]string{
+		{"resource": "billing", "action": "read"},
+		{"resource": "*", "action": "*"},
+		{"resource": "reports", "action": "*"},
+		{"resource": "rep*", "action": "read"},
+	} {
+		if code, _ := apiCall(t, adminTok, "POST", "/api/v1/admin/roles/RBACAdmin/permissions", rule); code != 403 {
+			t.Fatalf("expected %v to be refused, got %d", rule, code)
+		}
+	}
+	_ = models.EnsureRole("Superuser")
+	if code, _ := apiCall(t, adminTok, "POST", fmt.Sprintf("/api/v1/admin/users/%s/roles", admin), map[string]string{"name": "Superuser"}); code != 403 {
+		t.Fatalf("expected assigning Superuser to be refused, got %d", code)
+	}
+	if code, _ := apiCall(t, adminTok, "POST", "/api/v1/admin/roles", map[string]string{"name": "Root", "parent": "Superuser"}); code != 403 {
+		t.Fatalf("expected a Superuser child role to be refused, got %d", code)
+	}
+	boss := newUser(t, "rbac.boss@example.com").ID
+	_ = models.AssignRole(boss, "Superuser")
+	bossTok, _ := jwtutil.Generate(boss)
+	if code, _ := apiCall(t, bossTok, "POST", "/api/v1/admin/roles/RBACAdmin/permissions", map[string]string{"resource": "*", "action": "*", "effect": "deny"}); code != 200 {
+		t.Fatalf("expected a superuser to add wildcard rules, got %d", code)
+	}
+	if code, _ := apiCall(t, adminTok, "GET", "/api/v1/admin/roles", nil); code != 403 {
+		t.Fatalf("expected the deny to apply, got %d", code)
+	}
+	if code, _ := apiCall(t, bossTok, "POST", "/api/v1/admin/roles/RBACAdmin/permissions", map[string]string{"resource": "billing", "action": "read"}); code != 200 {
+		t.Fatalf("expected a superuser to grant anything, got %d", code)
+	}
+	if code, _ := apiCall(t, bossTok, "DELETE", "/api/v1/admin/roles/RBACAdmin/permissions", map[string]string{"resource": "*", "action": "*"}); code != 200 {
+		t.Fatalf("revoke: %d", code)
+	}
+	// lifting a deny grants, too
+	_ = models.Deny("Auditor", "billing", "delete")
+	if code, _ := apiCall(t, adminTok, "DELETE", "/api/v1/admin/roles/Auditor/permissions", map[string]string{"resource": "billing", "action": "delete"}); code != 403 {
+		t.Fatalf("expected lifting a deny on a permission not held to be refused, got %d", code)
+	}
+
+	userRoles := fmt.Sprintf("/api/v1/admin/users/%s/roles", member)
+	if code, data := apiCall(t, adminTok, "POST", userRoles, map[string]string{"name": "Auditor"}); code != 200 {
+		t.Fatalf("assign role: %d %s", code, data)
+	}
+	if ok, _ := models.HasPermission(member, "reports", "read"); !ok {
+		t.Fatalf("expected member to read reports after assignment")
+	}
+	if code, _ := apiCall(t, adminTok, "POST", userRoles, map[string]string{"name": "Auditor"}); code != 200 {
+		t.Fatalf("re-assigning must be a no-op, got %d", code)
+	}
+	if roles, _ := models.ListUserRoles(member); len(roles) != 1 {
+		t.Fatalf("expected one assignment, got %v", roles)
+	}
+
+	// switch the rule to deny
+	path := fmt.Sprintf("/api/v1/admin/roles/Auditor/permissions/%d", perm.ID)
+	if code, _ := apiCall(t, adminTok, "PUT", path, map[string]string{"resource": "reports", "action": "read", "effect": "deny"}); code != 200 {
+		t.Fatalf("update permission: %d", code)
+	}
+	if ok, _ := models.HasPermission(member, "reports", "read"); ok {
+		t.Fatalf("expected deny after update")
+	}
+	if code, _ := apiCall(t, adminTok, "DELETE", path, nil); code != 200 {
+		t.Fatalf("delete permission: %d", code)
+	}
+
+	if code, _ := apiCall(t, adminTok, "DELETE", userRoles+"/Auditor", nil); code != 200 {
+		t.Fatalf("unassign: %d", code)
+	}
+	if code, _ := apiCall(t, adminTok, "DELETE", userRoles+"/Auditor", nil); code != 404 {
+		t.Fatalf("expected 404 for a role not held, got %d", code)
+	}
+	if code, _ := apiCall(t, adminTok, "DELETE", "/api/v1/admin/roles/Auditor", nil); code != 200 {
+		t.Fatalf("delete role: %d", code)
+	}
+	if code, _ := apiCall(t, adminTok, "DELETE", "/api/v1/admin/roles/Superuser", nil); code != 400 {
+		t.Fatalf("expected Superuser role to be protected, got %d", code)
+	}
+}
+
+func TestUserRoleUniqueIndex(t *testing.T) {
+	o := orm.NewOrm()
+	userID := "dupe-roles-user"
+	for i := 0; i < 2; i++ {
+		if _, err := o.Insert(&models.UserRole{UserID: userID, Role: "Dupe"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the test schema comes from syncdb, so this also adopts it
+	m, err := apps.Migrator("default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Up(0); err != nil {
+		t.Fatalf("migrate up: %v", err)
+	}
+	if roles, _ := models.ListUserRoles(userID); len(roles) != 1 {
+		t.Fatalf("expected duplicates to be removed, got %v", roles)
+	}
+	if _, err := o.Insert(&models.UserRole{UserID: userID, Role: "Dupe"}); err == nil {
+		t.Fatalf("expected the unique index to reject a duplicate")
+	}
+	if pending, err := m.Pending(); err != nil || len(pending) != 0 {
+		t.Fatalf("expected nothing pending: %v %v", err, pending)
+	}
+}
@@ -0,0 +1,84 @@
+package tests
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/mymi14s/goconda/models"
+)
+
+func TestRBACInheritanceWildcardsAndDeny(t *testing.T) {
+	userID := "carol-user"
+	must := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	allowed := func(resource, action string) bool {
+		t.Helper()
+		ok, err := models.HasPermission(userID, resource, action)
+		must(err)
+		return ok
+	}
+
+	must(models.SetRoleParent("Editor", "Viewer"))
+	must(models.Grant("Viewer", "*", "read"))
+	must(models.Grant("Editor", "items", "*"))
+	must(models.AssignRole(userID, "Editor"))
+
+	if !allowed("reports", "read") {
+		t.Fatalf("expected *:read inherited from Viewer")
+	}
+	if !allowed("items", "delete") || allowed("reports", "delete") {
+		t.Fatalf("expected items:* only")
+	}
+
+	// a cached result must see new rules
+	if !allowed("secrets", "read") {
+		t.Fatalf("expected read on secrets before deny")
+	}
+	must(models.Deny("Editor", "secrets", "*"))
+	if allowed("secrets", "read") {
+		t.Fatalf("expected deny to override the inherited allow")
+	}
+
+	// prefix wildcards
+	must(models.Grant("Viewer", "admin/*", "update"))
+	if !allowed("admin/users", "update") || allowed("administrators", "update") {
+		t.Fatalf("expected admin/* to match by prefix")
+	}
+
+	if err := models.SetRoleParent("Viewer", "Editor"); !errors.Is(err, models.ErrRoleCycle) {
+		t.Fatalf("expected cycle to be rejected, got %v", err)
+	}
+
+	set, err := models.EffectivePermissions(userID)
+	must(err)
+	if !set.HasRole("Viewer") || !set.HasRole("Editor") {
+		t.Fatalf("expected inherited roles, got %v", set.Roles)
+	}
+
+	// inheriting from Superuser grants everything
+	must(models.SetRoleParent("Viewer", "Superuser"))
+	if !allowed("anything", "delete") {
+		t.Fatalf("expected Superuser ancestor to allow everything")
+	}
+
+	// and is never possible for an organization role
+	if err := models.SetRoleOrgScoped("Editor", true); !errors.Is(err, models.ErrOrgRoleSuperuser) {
+		t.Fatalf("expected a Superuser descendant not to become an org role, got %v", err)
+	}
+	if _, err := models.CreateRole("OrgAdmins", "Viewer", true); !errors.Is(err, models.ErrOrgRoleSuperuser) {
+		t.Fatalf("expected an org role under Superuser to be refused, got %v", err)
+	}
+	_, err = models.CreateRole("OrgEditors", "", true)
+	must(err)
+	if err := models.SetRoleParent("OrgEditors", "Editor"); !errors.Is(err, models.ErrOrgRoleSuperuser) {
+		t.Fatalf("expected an org role not to inherit from Superuser, got %v", err)
+	}
+	if err := models.CheckOrgRole("Editor"); !errors.Is(err, models.ErrInvalidOrgRole) {
+		t.Fatalf("expected a global role to be refused for members, got %v", err)
+	}
+	must(models.CheckOrgRole("OrgEditors"))
+}
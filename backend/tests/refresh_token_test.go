@@ -0,0 +1,67 @@
+package tests
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mymi14s/goconda/models"
+)
+
+func TestRefreshTokenRotationAndReuse(t *testing.T) {
+	userID := "refresh-user"
+	accessExp := time.Now().Add(time.Hour)
+
+	rt1 := &models.RefreshToken{UserID: userID, AccessJTI: "jti-1", AccessExpiresAt: accessExp}
+	first, err := models.CreateRefreshToken(rt1, time.Hour)
+	if err != nil {
+		t.Fatalf("CreateRefreshToken: %v", err)
+	}
+	if first == "" || rt1.Family == "" {
+		t.Fatalf("expected token and family, got %q %+v", first, rt1)
+	}
+	if rt1.TokenHash == first {
+		t.Fatalf("raw token must not be stored")
+	}
+
+	// rotate: consume the first token and issue the next in the same family
+	used, err := models.ConsumeRefreshToken(first)
+	if err != nil {
+		t.Fatalf("ConsumeRefreshToken: %v", err)
+	}
+	if used.UserID != userID || used.Family != rt1.Family {
+		t.Fatalf("unexpected consumed token %+v", used)
+	}
+	second, err := models.CreateRefreshToken(&models.RefreshToken{
+		UserID: userID, Family: used.Family, AccessJTI: "jti-2", AccessExpiresAt: accessExp,
+	}, time.Hour)
+	if err != nil {
+		t.Fatalf("CreateRefreshToken (rotation): %v", err)
+	}
+
+	// replaying the rotated token revokes the whole family
+	if _, err := models.ConsumeRefreshToken(first); !errors.Is(err, models.ErrRefreshTokenReused) {
+		t.Fatalf("expected reuse detection, got %v", err)
+	}
+	for _, jti := range []string{"jti-1", "jti-2"} {
+		revoked, err := models.IsTokenRevoked(jti)
+		if err != nil || !revoked {
+			t.Fatalf("expected access token %s revoked, got %v %v", jti, revoked, err)
+		}
+	}
+	if _, err := models.ConsumeRefreshToken(second); err == nil {
+		t.Fatalf("expected latest token in revoked family to be rejected")
+	}
+}
+
+func TestRefreshTokenExpired(t *testing.T) {
+	raw, err := models.CreateRefreshToken(&models.RefreshToken{
+		UserID: "expired-user", AccessJTI: "jti-exp", AccessExpiresAt: time.Now(),
+	}, -time.Minute)
+	if err != nil {
+		t.Fatalf("CreateRefreshToken: %v", err)
+	}
+	if _, err := models.ConsumeRefreshToken(raw); err == nil {
+		t.Fatalf("expected expired token to be rejected")
+	}
+}
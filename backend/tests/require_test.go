@@ -0,0 +1,71 @@
+package tests
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/beego/beego/v2/server/web"
+
+	"github.com/mymi14s/goconda/controllers"
+	"github.com/mymi14s/goconda/middleware"
+	"github.com/mymi14s/goconda/models"
+	jwtutil "github.com/mymi14s/goconda/utils/jwt"
+)
+
+type probeController struct {
+	controllers.BaseController
+}
+
+var probeHits int
+
+func (c *probeController) Get() {
+	probeHits++
+	c.JSONOK(map[string]any{"ok": true})
+}
+
+func TestRequireRunsBeforeController(t *testing.T) {
+	if err := setJWTConfig(t, map[string]string{"jwt::secret": "require-test-secret"}); err != nil {
+		t.Fatal(err)
+	}
+	web.Router("/internal/probe", &probeController{}, "get:Get;post:Get")
+	middleware.Require("/internal/probe", "GET", "probe", "read")
+
+	email := "probe@example.com"
+	u := &models.User{Email: email, FirstName: "P", LastName: "R", PasswordHash: "x"}
+	if err := models.CreateUser(u); err != nil {
+		t.Fatal(err)
+	}
+	tok, _ := jwtutil.Generate(u.ID)
+	get := func(method, token string) int {
+		req := httptest.NewRequest(method, "/internal/probe", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		web.BeeApp.Handlers.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := get("GET", ""); code != 401 || probeHits != 0 {
+		t.Fatalf("expected 401 before the controller, got %d (hits %d)", code, probeHits)
+	}
+	if code := get("GET", tok); code != 403 || probeHits != 0 {
+		t.Fatalf("expected 403 before the controller, got %d (hits %d)", code, probeHits)
+	}
+	// the rule only covers GET
+	if code := get("POST", tok); code != 200 || probeHits != 1 {
+		t.Fatalf("expected POST to pass, got %d", code)
+	}
+	_ = models.EnsureRole("Prober")
+	_ = models.Grant("Prober", "probe", "read")
+	_ = models.AssignRole(u.ID, "Prober")
+	if code := get("GET", tok); code != 200 || probeHits != 2 {
+		t.Fatalf("expected GET with permission to pass, got %d", code)
+	}
+}
+
+func TestEveryAPIRouteHasAnAuthRule(t *testing.T) {
+	if missing := middleware.UnprotectedRoutes("/api/v1"); len(missing) > 0 {
+		t.Fatalf("routes without Protect, Require or Public: %v", missing)
+	}
+}
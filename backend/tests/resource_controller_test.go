@@ -0,0 +1,95 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	notemodels "github.com/mymi14s/goconda/apps/notes/models"
+	"github.com/mymi14s/goconda/models"
+	jwtutil "github.com/mymi14s/goconda/utils/jwt"
+)
+
+func TestResourceController(t *testing.T) {
+	if err := setJWTConfig(t, map[string]string{"jwt::secret": "resource-secret"}); err != nil {
+		t.Fatal(err)
+	}
+	u, other := newUser(t, "writer@notes.test"), newUser(t, "reader@notes.test")
+	token, _ := jwtutil.Generate(u.ID)
+	otherTok, _ := jwtutil.Generate(other.ID)
+
+	note := func(data json.RawMessage) notemodels.Note {
+		var n notemodels.Note
+		_ = json.Unmarshal(data, &n)
+		return n
+	}
+	code, data := apiCall(t, token, "POST", "/api/v1/notes", map[string]any{"title": " Groceries ", "body": "milk", "pinned": "true"})
+	n := note(data)
+	if code != 200 || n.ID == 0 || n.Title != "Groceries" || !n.Pinned || n.OwnerID != u.ID {
+		t.Fatalf("create: %d %s", code, data)
+	}
+	path := fmt.Sprintf("/api/v1/notes/%d", n.ID)
+	for _, bad := range []map[string]any{{"body": "no title"}, {"title": "x", "owner_id": other.ID}, {"title": "   "}} {
+		if code, _ := apiCall(t, token, "POST", "/api/v1/notes", bad); code != 400 {
+			t.Fatalf("expected %v to be refused, got %d", bad, code)
+		}
+	}
+	_, _ = apiCall(t, token, "POST", "/api/v1/notes", map[string]any{"title": "Ideas"})
+
+	if code, data := apiCall(t, token, "PATCH", path, map[string]any{"body": "milk, eggs"}); code != 200 || note(data).Title != "Groceries" || note(data).Body != "milk, eggs" {
+		t.Fatalf("patch: %d %s", code, data)
+	}
+	if code, _ := apiCall(t, token, "PATCH", path, map[string]any{"title": ""}); code != 400 {
+		t.Fatalf("expected a required field not to be cleared, got %d", code)
+	}
+	code, data = apiCall(t, token, "PUT", path, map[string]any{"title": "Shopping"})
+	if n := note(data); code != 200 || n.Title != "Shopping" || n.Body != "" || n.Pinned {
+		t.Fatalf("expected PUT to replace every writable field: %d %s", code, data)
+	}
+	if code, data := apiCall(t, token, "GET", path, nil); code != 200 || note(data).Title != "Shopping" {
+		t.Fatalf("get: %d %s", code, data)
+	}
+
+	code, data = apiCall(t, token, "GET", "/api/v1/notes?sort=title&q=shop", nil)
+	var list struct {
+		Total int64             `json:"total"`
+		Items []notemodels.Note `json:"items"`
+	}
+	_ = json.Unmarshal(data, &list)
+	if code != 200 || list.Total != 1 || list.Items[0].ID != n.ID {
+		t.Fatalf("list: %d %s", code, data)
+	}
+
+	// other users cannot see the note
+	if code, _ := apiCall(t, otherTok, "GET", path, nil); code != 404 {
+		t.Fatalf("expected another user's note to be hidden, got %d", code)
+	}
+	code, data = apiCall(t, otherTok, "GET", "/api/v1/notes", nil)
+	list.Total = -1
+	_ = json.Unmarshal(data, &list)
+	if code != 200 || list.Total != 0 {
+		t.Fatalf("expected an empty list: %d %s", code, data)
+	}
+	if code, _ := apiCall(t, otherTok, "DELETE", path, nil); code != 404 {
+		t.Fatalf("expected another user's delete to fail, got %d", code)
+	}
+
+	// personal access tokens need the notes scope
+	_, pat, err := models.CreatePersonalAccessToken(u.ID, "read-only", 0, []string{"notes:read"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code, _ := apiCall(t, pat, "GET", path, nil); code != 200 {
+		t.Fatalf("scoped read: %d", code)
+	}
+	if code, _ := apiCall(t, pat, "DELETE", path, nil); code != 403 {
+		t.Fatalf("expected the scope to block deletes, got %d", code)
+	}
+
+	if code, _ := apiCall(t, token, "DELETE", path, nil); code != 200 {
+		t.Fatalf("delete: %d", code)
+	}
+	if code, _ := apiCall(t, token, "GET", path, nil); code != 404 {
+		t.Fatalf("expected the note to be gone, got %d", code)
+	}
+}
@@ -0,0 +1,161 @@
+package tests
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/beego/beego/v2/client/orm"
+
+	"github.com/mymi14s/goconda/apps"
+	itemmodels "github.com/mymi14s/goconda/apps/items/models"
+	"github.com/mymi14s/goconda/models"
+)
+
+// legacySchema is the email-keyed layout from before users had IDs.
+var legacySchema = []string{
+	"CREATE TABLE `user` (`email` varchar(191) NOT NULL PRIMARY KEY, `first_name` varchar(100) NOT NULL DEFAULT '', `last_name` varchar(100) NOT NULL DEFAULT '', `password_hash` varchar(255) NOT NULL DEFAULT '', `created_at` datetime NOT NULL, `updated_at` datetime NOT NULL, `is_superuser` bool NOT NULL DEFAULT false)",
+	"CREATE TABLE `user_role` (`id` integer NOT NULL PRIMARY KEY AUTOINCREMENT, `email` varchar(191) NOT NULL DEFAULT '', `role` varchar(100) NOT NULL DEFAULT '')",
+	"CREATE UNIQUE INDEX idx_user_role_unique ON user_role (email, role)",
+	"CREATE TABLE `item` (`id` integer NOT NULL PRIMARY KEY AUTOINCREMENT, `name` varchar(200) NOT NULL DEFAULT '', `description` text NOT NULL DEFAULT '', `owner_email` varchar(191) NOT NULL, `org_id` integer NOT NULL DEFAULT 0, `created_at` datetime NOT NULL, `updated_at` datetime NOT NULL)",
+	"CREATE INDEX `item_org_id` ON `item` (`org_id`)",
+	"CREATE TABLE `item_share` (`id` integer NOT NULL PRIMARY KEY AUTOINCREMENT, `item_id` integer NOT NULL, `email` varchar(191) NOT NULL DEFAULT '', `role` varchar(100) NOT NULL DEFAULT '', `permission` varchar(10) NOT NULL DEFAULT '', `created_by` varchar(191) NOT NULL DEFAULT '', `created_at` datetime NOT NULL)",
+	"INSERT INTO `user` VALUES ('ann@legacy.test', 'Ann', 'A', 'x', '2024-01-01 00:00:00', '2024-01-01 00:00:00', false)",
+	"INSERT INTO `user` VALUES ('ben@legacy.test', 'Ben', 'B', 'x', '2024-01-01 00:00:00', '2024-01-01 00:00:00', true)",
+	"INSERT INTO `user_role` (`email`, `role`) VALUES ('ann@legacy.test', 'Editor'), ('gone@legacy.test', 'Editor')",
+	"INSERT INTO `item` VALUES (1, 'Notes', '', 'ann@legacy.test', 0, '2024-01-01 00:00:00', '2024-01-01 00:00:00')",
+	"INSERT INTO `item_share` (`item_id`, `email`, `role`, `permission`, `created_by`, `created_at`) VALUES (1, 'ben@legacy.test', '', 'viewer', 'ann@legacy.test', '2024-01-01 00:00:00'), (1, '', 'Editor', 'editor', 'gone@legacy.test', '2024-01-01 00:00:00')",
+}
+
+func TestUserIDMigration(t *testing.T) {
+	dir := t.TempDir()
+	if err := orm.RegisterDataBase("legacy", "sqlite3", "file:"+filepath.Join(dir, "legacy.db")+"?_fk=1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := orm.RegisterDataBase("legacy_synced", "sqlite3", "file:"+filepath.Join(dir, "synced.db")); err != nil {
+		t.Fatal(err)
+	}
+	if err := orm.RunSyncdb("legacy_synced", false, false); err != nil {
+		t.Fatal(err)
+	}
+	o := orm.NewOrmUsingDB("legacy")
+	for _, stmt := range append(legacySchema, preSeriesTables...) {
+		if _, err := o.Raw(stmt).Exec(); err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+
+	// one migration at a time, as `goconda migrate up 1` would
+	m, err := apps.Migrator("legacy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for {
+		done, err := m.Up(1)
+		if err != nil {
+			t.Fatalf("up: %v", err)
+		}
+		if len(done) == 0 {
+			break
+		}
+	}
+
+	got, want := schema(t, "legacy"), schema(t, "legacy_synced")
+	want["user_role"] = append(want["user_role"], "index idx_user_role_unique")
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		for table, cols := range want {
+			if fmt.Sprint(got[table]) != fmt.Sprint(cols) {
+				t.Errorf("%s:\n migrated %v\n model    %v", table, got[table], cols)
+			}
+		}
+		t.Fatalf("migrated legacy schema differs from the models")
+	}
+
+	var users []models.User
+	if _, err := o.QueryTable(new(models.User)).OrderBy("Email").All(&users); err != nil || len(users) != 2 {
+		t.Fatalf("users: %v %+v", err, users)
+	}
+	ann, ben := users[0], users[1]
+	if len(ann.ID) != 36 || ann.FirstName != "Ann" || !ben.IsSuperuser {
+		t.Fatalf("users not copied: %+v", users)
+	}
+
+	var roles []models.UserRole
+	if _, err := o.QueryTable(new(models.UserRole)).All(&roles); err != nil || len(roles) != 1 || roles[0].UserID != ann.ID {
+		t.Fatalf("expected only ann's role to survive, got %v %+v", err, roles)
+	}
+
+	it := itemmodels.Item{ID: 1}
+	if err := o.Read(&it); err != nil || it.Owner == nil || it.Owner.ID != ann.ID {
+		t.Fatalf("item owner: %v %+v", err, it.Owner)
+	}
+	var shares []itemmodels.ItemShare
+	if _, err := o.QueryTable(new(itemmodels.ItemShare)).OrderBy("ID").All(&shares); err != nil || len(shares) != 2 {
+		t.Fatalf("shares: %v %+v", err, shares)
+	}
+	if shares[0].UserID != ben.ID || shares[0].CreatedBy != ann.ID {
+		t.Fatalf("user share: %+v", shares[0])
+	}
+	if shares[1].UserID != "" || shares[1].Role != "Editor" || shares[1].CreatedBy != "" {
+		t.Fatalf("role share: %+v", shares[1])
+	}
+
+	var left int
+	if err := o.Raw("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name LIKE '%_legacy'").QueryRow(&left); err != nil || left != 0 {
+		t.Fatalf("expected legacy tables to be dropped, %d left (%v)", left, err)
+	}
+}
+
+// TestUserIDMigrationResumes starts from a conversion that stopped halfway,
+// as it can on MySQL: the user table is already renamed and one user and
+// one of her roles are already copied.
+func TestUserIDMigrationResumes(t *testing.T) {
+	if err := orm.RegisterDataBase("legacy_resumed", "sqlite3", "file:"+filepath.Join(t.TempDir(), "resumed.db")); err != nil {
+		t.Fatal(err)
+	}
+	o := orm.NewOrmUsingDB("legacy_resumed")
+	for _, stmt := range append(legacySchema, "ALTER TABLE `user` RENAME TO `user_legacy`") {
+		if _, err := o.Raw(stmt).Exec(); err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+	m, err := apps.Migrator("legacy_resumed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pending, err := m.Pending()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pending[len(pending)-1].Name != "user_ids" {
+		t.Fatalf("expected user_ids to run last, got %s", pending[len(pending)-1])
+	}
+	if _, err := m.Up(len(pending) - 1); err != nil {
+		t.Fatalf("up: %v", err)
+	}
+	const annID = "00000000-0000-0000-0000-00000000a000"
+	for _, stmt := range []string{
+		"INSERT INTO `user` (`id`, `email`, `first_name`, `last_name`, `password_hash`, `created_at`, `updated_at`, `is_superuser`) VALUES ('" + annID + "', 'ann@legacy.test', 'Ann', 'A', 'x', '2024-01-01 00:00:00', '2024-01-01 00:00:00', false)",
+		"INSERT INTO `user_role` (`id`, `user_id`, `role`) VALUES (1, '" + annID + "', 'Editor')",
+	} {
+		if _, err := o.Raw(stmt).Exec(); err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+	if _, err := m.Up(0); err != nil {
+		t.Fatalf("resume: %v", err)
+	}
+
+	var users []models.User
+	if _, err := o.QueryTable(new(models.User)).OrderBy("Email").All(&users); err != nil || len(users) != 2 || users[0].ID != annID {
+		t.Fatalf("expected ann to keep her ID and ben to be copied: %v %+v", err, users)
+	}
+	var roles []models.UserRole
+	if _, err := o.QueryTable(new(models.UserRole)).All(&roles); err != nil || len(roles) != 1 || roles[0].UserID != annID {
+		t.Fatalf("expected ann's role once, got %v %+v", err, roles)
+	}
+	var shares int
+	if err := o.Raw("SELECT COUNT(*) FROM `item_share`").QueryRow(&shares); err != nil || shares != 2 {
+		t.Fatalf("shares: %v %d", err, shares)
+	}
+}
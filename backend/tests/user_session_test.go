@@ -0,0 +1,55 @@
+package tests
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mymi14s/goconda/models"
+)
+
+func TestUserSessions(t *testing.T) {
+	userID := "sessions-user"
+	now := time.Now()
+	exp := now.Add(time.Hour)
+	for _, jti := range []string{"sess-a", "sess-b", "sess-c"} {
+		if err := models.RecordSession(jti, userID, "test-agent", "127.0.0.1", now, exp); err != nil {
+			t.Fatalf("RecordSession %s: %v", jti, err)
+		}
+	}
+	// sess-b has a refresh family that must be revoked with it
+	refresh, err := models.CreateRefreshToken(&models.RefreshToken{UserID: userID, AccessJTI: "sess-b", AccessExpiresAt: exp}, time.Hour)
+	if err != nil {
+		t.Fatalf("CreateRefreshToken: %v", err)
+	}
+
+	list, err := models.ListSessions(userID)
+	if err != nil || len(list) != 3 {
+		t.Fatalf("expected 3 sessions, got %d (%v)", len(list), err)
+	}
+
+	if err := models.RevokeSession("someone-else@example.com", "sess-a"); !errors.Is(err, models.ErrSessionNotFound) {
+		t.Fatalf("expected not found for foreign session, got %v", err)
+	}
+
+	n, err := models.RevokeOtherSessions(userID, "sess-a")
+	if err != nil || n != 2 {
+		t.Fatalf("RevokeOtherSessions: n=%d err=%v", n, err)
+	}
+	for _, jti := range []string{"sess-b", "sess-c"} {
+		if revoked, _ := models.IsTokenRevoked(jti); !revoked {
+			t.Fatalf("expected %s revoked", jti)
+		}
+	}
+	if revoked, _ := models.IsTokenRevoked("sess-a"); revoked {
+		t.Fatalf("current session must stay valid")
+	}
+	if _, err := models.ConsumeRefreshToken(refresh); err == nil {
+		t.Fatalf("expected refresh token of revoked session to be rejected")
+	}
+
+	list, _ = models.ListSessions(userID)
+	if len(list) != 1 || list[0].JTI != "sess-a" {
+		t.Fatalf("expected only sess-a left, got %+v", list)
+	}
+}
@@ -0,0 +1,241 @@
+package tests
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+
+	"github.com/beego/beego/v2/server/web"
+	"github.com/beego/beego/v2/server/web/session"
+
+	"github.com/mymi14s/goconda/models"
+	jwtutil "github.com/mymi14s/goconda/utils/jwt"
+)
+
+func init() {
+	// web.Run normally creates the session manager.
+	if web.GlobalSessions == nil {
+		web.GlobalSessions, _ = session.NewManager("memory", &session.ManagerConfig{
+			CookieName: "bffsid", EnableSetCookie: true, Gclifetime: 3600, Maxlifetime: 3600,
+		})
+	}
+}
+
+// cborEnc encodes the few CBOR shapes a software authenticator needs.
+func cborEnc(v any) []byte {
+	head := func(major byte, n uint64) []byte {
+		switch {
+		case n < 24:
+			return []byte{major<<5 | byte(n)}
+		case n < 256:
+			return []byte{major<<5 | 24, byte(n)}
+		default:
+			b := []byte{major<<5 | 25, 0, 0}
+			binary.BigEndian.PutUint16(b[1:], uint16(n))
+			return b
+		}
+	}
+	switch x := v.(type) {
+	case int:
+		if x < 0 {
+			return head(1, uint64(-1-x))
+		}
+		return head(0, uint64(x))
+	case []byte:
+		return append(head(2, uint64(len(x))), x...)
+	case string:
+		return append(head(3, uint64(len(x))), x...)
+	case map[any]any:
+		keys := make([]any, 0, len(x))
+		for k := range x {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool { return string(cborEnc(keys[i])) < string(cborEnc(keys[j])) })
+		out := head(5, uint64(len(x)))
+		for _, k := range keys {
+			out = append(out, cborEnc(k)...)
+			out = append(out, cborEnc(x[k])...)
+		}
+		return out
+	}
+	panic("cborEnc: unsupported type")
+}
+
+// softAuthenticator is an in-memory ES256 passkey.
+type softAuthenticator struct {
+	key    *ecdsa.PrivateKey
+	id     []byte
+	rpID   string
+	origin string
+	count  uint32
+}
+
+func newSoftAuthenticator(t *testing.T, rpID, origin string) *softAuthenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+	return &softAuthenticator{key: key, id: id, rpID: rpID, origin: origin}
+}
+
+func (a *softAuthenticator) authData(attested bool) []byte {
+	rpHash := sha256.Sum256([]byte(a.rpID))
+	flags := byte(0x01 | 0x04) // UP | UV
+	if attested {
+		flags |= 0x40
+	}
+	b := append(rpHash[:], flags, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(b[33:], a.count)
+	if attested {
+		b = append(b, make([]byte, 16)...) // AAGUID
+		b = append(b, byte(len(a.id)>>8), byte(len(a.id)))
+		b = append(b, a.id...)
+		b = append(b, cborEnc(map[any]any{
+			1: 2, 3: -7, -1: 1,
+			-2: a.key.X.FillBytes(make([]byte, 32)),
+			-3: a.key.Y.FillBytes(make([]byte, 32)),
+		})...)
+	}
+	return b
+}
+
+func (a *softAuthenticator) clientData(typ, challenge string) []byte {
+	b, _ := json.Marshal(map[string]string{"type": typ, "challenge": challenge, "origin": a.origin})
+	return b
+}
+
+func (a *softAuthenticator) create(challenge string) map[string]any {
+	att := cborEnc(map[any]any{"fmt": "none", "attStmt": map[any]any{}, "authData": a.authData(true)})
+	id := base64.RawURLEncoding.EncodeToString(a.id)
+	return map[string]any{"id": id, "rawId": id, "type": "public-key", "name": "Test key", "response": map[string]string{
+		"clientDataJSON":    base64.RawURLEncoding.EncodeToString(a.clientData("webauthn.create", challenge)),
+		"attestationObject": base64.RawURLEncoding.EncodeToString(att),
+	}}
+}
+
+func (a *softAuthenticator) get(t *testing.T, challenge string) map[string]any {
+	a.count++
+	ad := a.authData(false)
+	cd := a.clientData("webauthn.get", challenge)
+	sum := sha256.Sum256(cd)
+	digest := sha256.Sum256(append(append([]byte(nil), ad...), sum[:]...))
+	sig, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := base64.RawURLEncoding.EncodeToString(a.id)
+	return map[string]any{"id": id, "rawId": id, "type": "public-key", "response": map[string]string{
+		"clientDataJSON":    base64.RawURLEncoding.EncodeToString(cd),
+		"authenticatorData": base64.RawURLEncoding.EncodeToString(ad),
+		"signature":         base64.RawURLEncoding.EncodeToString(sig),
+	}}
+}
+
+// webauthnClient keeps the session cookie between ceremony requests.
+type webauthnClient struct {
+	token   string
+	cookies []*http.Cookie
+}
+
+func (c *webauthnClient) post(t *testing.T, path string, body any) (int, map[string]any) {
+	t.Helper()
+	raw, _ := json.Marshal(body)
+	req := httptest.NewRequest("POST", "http://example.com"+path, bytes.NewReader(raw))
+	req.Header.Set("Content-Type", "application/json")
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	for _, ck := range c.cookies {
+		req.AddCookie(ck)
+	}
+	rec := httptest.NewRecorder()
+	web.BeeApp.Handlers.ServeHTTP(rec, req)
+	for _, ck := range rec.Result().Cookies() {
+		if ck.Name == "bffsid" {
+			c.cookies = []*http.Cookie{ck}
+		}
+	}
+	var out struct {
+		Data map[string]any `json:"data"`
+	}
+	_ = json.Unmarshal(rec.Body.Bytes(), &out)
+	return rec.Code, out.Data
+}
+
+func challengeOf(t *testing.T, data map[string]any) string {
+	t.Helper()
+	pk, _ := data["publicKey"].(map[string]any)
+	ch, _ := pk["challenge"].(string)
+	if ch == "" {
+		t.Fatalf("no challenge in %v", data)
+	}
+	return ch
+}
+
+func TestWebAuthnRegisterAndLogin(t *testing.T) {
+	if err := setJWTConfig(t, map[string]string{"jwt::secret": "webauthn-test-secret"}); err != nil {
+		t.Fatal(err)
+	}
+	email := "passkey@example.com"
+	u := &models.User{Email: email, FirstName: "Pass", LastName: "Key", PasswordHash: "x"}
+	if err := models.CreateUser(u); err != nil {
+		t.Fatalf("insert user: %v", err)
+	}
+	token, err := jwtutil.Generate(u.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := newSoftAuthenticator(t, "example.com", "http://example.com")
+
+	// registration
+	client := &webauthnClient{token: token}
+	code, data := client.post(t, "/api/v1/auth/webauthn/register/begin", nil)
+	if code != 200 {
+		t.Fatalf("register/begin: %d %v", code, data)
+	}
+	if code, data = client.post(t, "/api/v1/auth/webauthn/register/finish", auth.create(challengeOf(t, data))); code != 200 {
+		t.Fatalf("register/finish: %d %v", code, data)
+	}
+	if creds, _ := models.ListWebAuthnCredentials(u.ID); len(creds) != 1 || creds[0].Name != "Test key" {
+		t.Fatalf("expected one stored passkey, got %+v", creds)
+	}
+
+	// login from a fresh, unauthenticated browser
+	anon := &webauthnClient{}
+	code, data = anon.post(t, "/api/v1/auth/webauthn/login/begin", map[string]string{"email": email})
+	if code != 200 {
+		t.Fatalf("login/begin: %d %v", code, data)
+	}
+	challenge := challengeOf(t, data)
+	assertion := auth.get(t, challenge)
+	code, data = anon.post(t, "/api/v1/auth/webauthn/login/finish", assertion)
+	if code != 200 || data["token"] == nil || data["email"] != email {
+		t.Fatalf("login/finish: %d %v", code, data)
+	}
+
+	// the challenge is single-use
+	if code, _ = anon.post(t, "/api/v1/auth/webauthn/login/finish", assertion); code != 400 {
+		t.Fatalf("expected replayed assertion to be rejected, got %d", code)
+	}
+
+	// a wrong origin fails even with a fresh challenge
+	code, data = anon.post(t, "/api/v1/auth/webauthn/login/begin", nil)
+	if code != 200 {
+		t.Fatalf("login/begin: %d %v", code, data)
+	}
+	auth.origin = "http://evil.example"
+	if code, _ = anon.post(t, "/api/v1/auth/webauthn/login/finish", auth.get(t, challengeOf(t, data))); code != 401 {
+		t.Fatalf("expected origin mismatch to be rejected, got %d", code)
+	}
+}
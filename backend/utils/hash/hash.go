@@ -1,16 +1,147 @@
 package hash
 
-import "golang.org/x/crypto/bcrypt"
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/beego/beego/v2/server/web"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Params selects the algorithm new hashes use. It is read from [password]:
+//
+//	algorithm = bcrypt | argon2id
+//	bcrypt_cost = 10
+//	argon2_time = 3
+//	argon2_memory = 65536   ; KiB
+//	argon2_threads = 2
+type Params struct {
+	Algorithm     string
+	BcryptCost    int
+	Argon2Time    uint32
+	Argon2Memory  uint32
+	Argon2Threads uint8
+}
+
+// CurrentParams returns the configured hashing parameters.
+func CurrentParams() Params {
+	p := Params{
+		Algorithm:     strings.ToLower(web.AppConfig.DefaultString("password::algorithm", "bcrypt")),
+		BcryptCost:    web.AppConfig.DefaultInt("password::bcrypt_cost", bcrypt.DefaultCost),
+		Argon2Time:    uint32(web.AppConfig.DefaultInt("password::argon2_time", 3)),
+		Argon2Memory:  uint32(web.AppConfig.DefaultInt("password::argon2_memory", 64*1024)),
+		Argon2Threads: uint8(web.AppConfig.DefaultInt("password::argon2_threads", 2)),
+	}
+	if p.BcryptCost < bcrypt.MinCost || p.BcryptCost > bcrypt.MaxCost {
+		p.BcryptCost = bcrypt.DefaultCost
+	}
+	if p.Argon2Time == 0 {
+		p.Argon2Time = 1
+	}
+	if p.Argon2Memory < 8*uint32(p.Argon2Threads) || p.Argon2Threads == 0 {
+		p.Argon2Memory, p.Argon2Threads = 64*1024, 2
+	}
+	return p
+}
+
+// BcryptMaxBytes is the longest password bcrypt accepts.
+const BcryptMaxBytes = 72
+
+// MaxBytes is the longest password, in bytes, that p can hash; 0 means no
+// limit.
+func (p Params) MaxBytes() int {
+	if p.Algorithm == "argon2id" {
+		return 0
+	}
+	return BcryptMaxBytes
+}
 
 func HashPassword(pw string) (string, error) {
-	b, err := bcrypt.GenerateFromPassword([]byte(pw), bcrypt.DefaultCost)
+	return HashWith(pw, CurrentParams())
+}
+
+// HashWith hashes pw with explicit parameters.
+func HashWith(pw string, p Params) (string, error) {
+	if p.Algorithm == "argon2id" {
+		salt := make([]byte, 16)
+		if _, err := rand.Read(salt); err != nil {
+			return "", err
+		}
+		key := argon2.IDKey([]byte(pw), salt, p.Argon2Time, p.Argon2Memory, p.Argon2Threads, 32)
+		return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version,
+			p.Argon2Memory, p.Argon2Time, p.Argon2Threads,
+			base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+	}
+	b, err := bcrypt.GenerateFromPassword([]byte(pw), p.BcryptCost)
 	return string(b), err
 }
 
+// CheckPassword verifies pw against a bcrypt or argon2id hash.
 func CheckPassword(pw, hashed string) bool {
+	if strings.HasPrefix(hashed, "$argon2id$") {
+		a, err := parseArgon2(hashed)
+		if err != nil {
+			return false
+		}
+		key := argon2.IDKey([]byte(pw), a.salt, a.time, a.memory, a.threads, uint32(len(a.key)))
+		return subtle.ConstantTimeCompare(key, a.key) == 1
+	}
 	return bcrypt.CompareHashAndPassword([]byte(hashed), []byte(pw)) == nil
 }
 
+// NeedsRehash reports whether hashed was made with another algorithm or
+// weaker parameters than the configured ones, so it should be replaced the
+// next time the plain password is known (e.g. at login).
+func NeedsRehash(hashed string) bool {
+	p := CurrentParams()
+	if strings.HasPrefix(hashed, "$argon2id$") {
+		if p.Algorithm != "argon2id" {
+			return true
+		}
+		a, err := parseArgon2(hashed)
+		return err != nil || a.time != p.Argon2Time || a.memory != p.Argon2Memory || a.threads != p.Argon2Threads
+	}
+	if p.Algorithm == "argon2id" {
+		return true
+	}
+	cost, err := bcrypt.Cost([]byte(hashed))
+	return err != nil || cost != p.BcryptCost
+}
+
+type argon2Hash struct {
+	time, memory uint32
+	threads      uint8
+	salt, key    []byte
+}
+
+// parseArgon2 reads "$argon2id$v=19$m=..,t=..,p=..$salt$key".
+func parseArgon2(s string) (*argon2Hash, error) {
+	parts := strings.Split(s, "$")
+	if len(parts) != 6 {
+		return nil, fmt.Errorf("hash: malformed argon2id hash")
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, fmt.Errorf("hash: unsupported argon2 version")
+	}
+	a := &argon2Hash{}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &a.memory, &a.time, &a.threads); err != nil {
+		return nil, fmt.Errorf("hash: malformed argon2id parameters")
+	}
+	var err error
+	if a.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return nil, err
+	}
+	if a.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(a.key) == 0 {
+		return nil, fmt.Errorf("hash: malformed argon2id key")
+	}
+	return a, nil
+}
+
 func Make(pw string) (string, error) {
 	return HashPassword(pw)
 }
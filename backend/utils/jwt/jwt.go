@@ -4,36 +4,194 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"errors"
+	"fmt"
 	"os"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/beego/beego/v2/server/web"
 	"github.com/golang-jwt/jwt/v5"
 )
 
+const devSecret = "dev-secret-please-change"
+
+// Claims are the token's claims. Subject is the user ID; Email is
+// informational only, as a user's address can change.
 type Claims struct {
-	Email string `json:"email"`
+	Email string `json:"email,omitempty"`
+	// AMR lists the authentication methods used, e.g. ["pwd", "mfa"].
+	AMR []string `json:"amr,omitempty"`
+	// Purpose marks restricted tokens (e.g. "mfa_pending") that must not be
+	// accepted as access tokens.
+	Purpose string `json:"purpose,omitempty"`
+	// Org is the organization the token acts in; 0 means the user's
+	// personal space.
+	Org int64 `json:"org,omitempty"`
+	// Act names the administrator acting as the subject in an
+	// impersonation session (RFC 8693 "act" claim).
+	Act *Actor `json:"act,omitempty"`
 	jwt.RegisteredClaims
 }
 
-func secretAndIssuer() ([]byte, string, time.Duration) {
-	secret := os.Getenv("JWT_SECRET")
-	if secret == "" {
-		// try config
-		secret = web.AppConfig.DefaultString("jwtsecret", "")
+// Actor identifies who really holds a token issued on someone else's
+// behalf.
+type Actor struct {
+	Subject string `json:"sub"`
+	Email   string `json:"email,omitempty"`
+}
+
+// HasAMR reports whether method is among the token's authentication methods.
+func (c *Claims) HasAMR(method string) bool {
+	for _, m := range c.AMR {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// keySet holds the active signing key plus every key accepted for verification.
+type keySet struct {
+	active *signingKey
+	byKID  map[string]*signingKey
+	issuer string
+	ttl    time.Duration
+}
+
+var (
+	mu      sync.RWMutex
+	current *keySet
+
+	hooksMu sync.RWMutex
+	hooks   []IssueHook
+)
+
+// IssueOptions customizes an issued token. UserAgent and IP are not encoded
+// in the token; they are passed to issue hooks.
+type IssueOptions struct {
+	Email     string
+	UserAgent string
+	IP        string
+	AMR       []string
+	Purpose   string
+	Org       int64
+	Act       *Actor
+	// TTL overrides the configured lifetime when non-zero.
+	TTL time.Duration
+}
+
+// IssueHook is called after every token issued by Generate or Issue.
+type IssueHook func(claims *Claims, opts IssueOptions)
+
+// OnIssue registers a hook, e.g. to record the token as a session.
+func OnIssue(h IssueHook) {
+	hooksMu.Lock()
+	defer hooksMu.Unlock()
+	hooks = append(hooks, h)
+}
+
+// Load (re)reads the signing configuration:
+//
+//	[jwt]
+//	algorithm = HS256 | RS256 | ES256 | EdDSA
+//	secret = ...                  ; HS256 only (JWT_SECRET env wins)
+//	private_key_file = key.pem    ; asymmetric algorithms
+//	key_id = 2025-01              ; optional, defaults to the RFC 7638 thumbprint
+//	verify_keys = old=old.pub.pem ; retired keys still accepted, "kid=path" or "path"
+//
+// In prod it refuses to fall back to the built-in development secret.
+func Load() error {
+	ks, err := loadKeySet()
+	if err != nil {
+		return err
+	}
+	mu.Lock()
+	current = ks
+	mu.Unlock()
+	return nil
+}
+
+func keys() (*keySet, error) {
+	mu.RLock()
+	ks := current
+	mu.RUnlock()
+	if ks != nil {
+		return ks, nil
 	}
-	if secret == "" {
-		secret = "dev-secret-please-change"
+	if err := Load(); err != nil {
+		return nil, err
 	}
-	issuer := web.AppConfig.DefaultString("jwtissuer", "goconda")
-	ttlStr := web.AppConfig.DefaultString("jwtexp", "")
-	ttl := 24 * time.Hour
-	if ttlStr != "" {
+	mu.RLock()
+	defer mu.RUnlock()
+	return current, nil
+}
+
+func loadKeySet() (*keySet, error) {
+	ks := &keySet{
+		byKID:  map[string]*signingKey{},
+		issuer: web.AppConfig.DefaultString("jwt::issuer", web.AppConfig.DefaultString("jwtissuer", "goconda")),
+		ttl:    24 * time.Hour,
+	}
+	if ttlStr := web.AppConfig.DefaultString("jwtexp", ""); ttlStr != "" {
 		if d, err := time.ParseDuration(ttlStr); err == nil {
-			ttl = d
+			ks.ttl = d
+		}
+	}
+
+	alg := strings.TrimSpace(web.AppConfig.DefaultString("jwt::algorithm", "HS256"))
+	if strings.EqualFold(alg, "HS256") {
+		secret := os.Getenv("JWT_SECRET")
+		if secret == "" {
+			secret = web.AppConfig.DefaultString("jwt::secret", web.AppConfig.DefaultString("jwtsecret", ""))
+		}
+		if secret == "" {
+			if web.BConfig.RunMode == "prod" {
+				return nil, errors.New("jwt: no secret configured (JWT_SECRET or [jwt] secret); refusing to use the development secret in prod")
+			}
+			secret = devSecret
+		}
+		ks.active = &signingKey{method: jwt.SigningMethodHS256, sign: []byte(secret), verify: []byte(secret)}
+		return ks, nil
+	}
+
+	path := web.AppConfig.DefaultString("jwt::private_key_file", "")
+	if path == "" {
+		return nil, fmt.Errorf("jwt: algorithm %s requires [jwt] private_key_file", alg)
+	}
+	active, err := loadKeyFile(path, web.AppConfig.DefaultString("jwt::key_id", ""))
+	if err != nil {
+		return nil, err
+	}
+	if active.sign == nil {
+		return nil, fmt.Errorf("jwt: %s does not contain a private key", path)
+	}
+	if !strings.EqualFold(active.method.Alg(), alg) {
+		return nil, fmt.Errorf("jwt: key in %s is for %s, not %s", path, active.method.Alg(), alg)
+	}
+	ks.active = active
+	ks.byKID[active.kid] = active
+
+	for _, entry := range web.AppConfig.DefaultStrings("jwt::verify_keys", nil) {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		kid, file := "", entry
+		if i := strings.Index(entry, "="); i > 0 {
+			kid, file = strings.TrimSpace(entry[:i]), strings.TrimSpace(entry[i+1:])
+		}
+		k, err := loadKeyFile(file, kid)
+		if err != nil {
+			return nil, err
+		}
+		// retired keys only verify
+		k.sign = nil
+		if _, dup := ks.byKID[k.kid]; !dup {
+			ks.byKID[k.kid] = k
 		}
 	}
-	return []byte(secret), issuer, ttl
+	return ks, nil
 }
 
 func randomJTI() string {
@@ -42,28 +200,77 @@ func randomJTI() string {
 	return hex.EncodeToString(b)
 }
 
-func Generate(email string) (string, error) {
-	sec, iss, ttl := secretAndIssuer()
+// Generate signs an access token for the user ID subject.
+func Generate(subject string) (string, error) {
+	token, _, err := Issue(subject, IssueOptions{})
+	return token, err
+}
+
+// Issue signs an access token for the user ID subject and also returns its
+// claims, so callers can link the JTI and expiry to other records (e.g.
+// refresh tokens).
+func Issue(subject string, opts IssueOptions) (string, *Claims, error) {
+	ks, err := keys()
+	if err != nil {
+		return "", nil, err
+	}
+	ttl := ks.ttl
+	if opts.TTL > 0 {
+		ttl = opts.TTL
+	}
 	now := time.Now()
 	claims := Claims{
-		Email: email,
+		Email:   opts.Email,
+		AMR:     opts.AMR,
+		Purpose: opts.Purpose,
+		Org:     opts.Org,
+		Act:     opts.Act,
 		RegisteredClaims: jwt.RegisteredClaims{
-			Issuer:    iss,
-			Subject:   email,
+			Issuer:    ks.issuer,
+			Subject:   subject,
 			IssuedAt:  jwt.NewNumericDate(now),
 			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
 			ID:        randomJTI(),
 		},
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(sec)
+	token := jwt.NewWithClaims(ks.active.method, claims)
+	if ks.active.kid != "" {
+		token.Header["kid"] = ks.active.kid
+	}
+	signed, err := token.SignedString(ks.active.sign)
+	if err != nil {
+		return "", nil, err
+	}
+	hooksMu.RLock()
+	for _, h := range hooks {
+		h(&claims, opts)
+	}
+	hooksMu.RUnlock()
+	return signed, &claims, nil
 }
 
+// Parse verifies tokenStr with the key named by its kid header (or the
+// active key when there is none) and returns its claims.
 func Parse(tokenStr string) (*Claims, error) {
-	sec, _, _ := secretAndIssuer()
+	ks, err := keys()
+	if err != nil {
+		return nil, err
+	}
 	token, err := jwt.ParseWithClaims(tokenStr, &Claims{}, func(t *jwt.Token) (interface{}, error) {
-		return sec, nil
-	})
+		k := ks.active
+		if kid, _ := t.Header["kid"].(string); kid != "" {
+			found, ok := ks.byKID[kid]
+			if !ok {
+				return nil, fmt.Errorf("unknown key id %q", kid)
+			}
+			k = found
+		}
+		// never let the token pick a different algorithm than the key's
+		if t.Method.Alg() != k.method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method %s", t.Method.Alg())
+		}
+		return k.verify, nil
+	}, jwt.WithValidMethods(ks.methods()))
 	if err != nil {
 		return nil, err
 	}
@@ -72,3 +279,15 @@ func Parse(tokenStr string) (*Claims, error) {
 	}
 	return nil, errors.New("invalid token")
 }
+
+func (ks *keySet) methods() []string {
+	seen := map[string]bool{ks.active.method.Alg(): true}
+	out := []string{ks.active.method.Alg()}
+	for _, k := range ks.byKID {
+		if alg := k.method.Alg(); !seen[alg] {
+			seen[alg] = true
+			out = append(out, alg)
+		}
+	}
+	return out
+}
@@ -0,0 +1,167 @@
+package jwtutil
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// signingKey is one entry of the key set. sign is nil for verify-only keys.
+type signingKey struct {
+	kid    string
+	method jwt.SigningMethod
+	sign   any
+	verify any
+}
+
+// loadKeyFile reads a PEM private or public key. When kid is empty the
+// RFC 7638 thumbprint of the public key is used.
+func loadKeyFile(path, kid string) (*signingKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("jwt: read key: %w", err)
+	}
+	priv, pub, err := parsePEMKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("jwt: %s: %w", path, err)
+	}
+	method, err := methodFor(pub)
+	if err != nil {
+		return nil, fmt.Errorf("jwt: %s: %w", path, err)
+	}
+	if kid == "" {
+		if kid, err = thumbprint(pub); err != nil {
+			return nil, err
+		}
+	}
+	return &signingKey{kid: kid, method: method, sign: priv, verify: pub}, nil
+}
+
+// parsePEMKey accepts PKCS#8/PKCS#1/SEC1 private keys and PKIX/PKCS#1 public keys.
+func parsePEMKey(data []byte) (crypto.Signer, crypto.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, nil, errors.New("no PEM block found")
+	}
+	if k, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		if s, ok := k.(crypto.Signer); ok {
+			return s, s.Public(), nil
+		}
+	}
+	if k, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return k, k.Public(), nil
+	}
+	if k, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return k, k.Public(), nil
+	}
+	if k, err := x509.ParsePKIXPublicKey(block.Bytes); err == nil {
+		return nil, k, nil
+	}
+	if k, err := x509.ParsePKCS1PublicKey(block.Bytes); err == nil {
+		return nil, k, nil
+	}
+	return nil, nil, errors.New("unsupported key format")
+}
+
+func methodFor(pub crypto.PublicKey) (jwt.SigningMethod, error) {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return jwt.SigningMethodRS256, nil
+	case *ecdsa.PublicKey:
+		if k.Curve != elliptic.P256() {
+			return nil, errors.New("only P-256 EC keys (ES256) are supported")
+		}
+		return jwt.SigningMethodES256, nil
+	case ed25519.PublicKey:
+		return jwt.SigningMethodEdDSA, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %T", pub)
+}
+
+func b64(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+
+// publicJWK returns the required public members of a JWK (RFC 7517/7518/8037).
+func publicJWK(pub crypto.PublicKey) (map[string]string, error) {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return map[string]string{
+			"kty": "RSA",
+			"n":   b64(k.N.Bytes()),
+			"e":   b64(big.NewInt(int64(k.E)).Bytes()),
+		}, nil
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		return map[string]string{
+			"kty": "EC",
+			"crv": k.Curve.Params().Name,
+			"x":   b64(k.X.FillBytes(make([]byte, size))),
+			"y":   b64(k.Y.FillBytes(make([]byte, size))),
+		}, nil
+	case ed25519.PublicKey:
+		return map[string]string{"kty": "OKP", "crv": "Ed25519", "x": b64(k)}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %T", pub)
+}
+
+// thumbprint computes the RFC 7638 JWK thumbprint (json.Marshal sorts map
+// keys, which gives the required lexicographic member order).
+func thumbprint(pub crypto.PublicKey) (string, error) {
+	jwk, err := publicJWK(pub)
+	if err != nil {
+		return "", err
+	}
+	raw, err := json.Marshal(jwk)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(raw)
+	return b64(sum[:]), nil
+}
+
+// JWKS returns the public verification keys as a JSON Web Key Set. It is
+// empty when tokens are signed with a shared HS256 secret.
+func JWKS() (map[string]any, error) {
+	ks, err := keys()
+	if err != nil {
+		return nil, err
+	}
+	out := []map[string]string{}
+	add := func(k *signingKey) {
+		jwk, err := publicJWK(k.verify)
+		if err != nil {
+			return
+		}
+		jwk["kid"] = k.kid
+		jwk["alg"] = k.method.Alg()
+		jwk["use"] = "sig"
+		out = append(out, jwk)
+	}
+	if _, symmetric := ks.active.verify.([]byte); !symmetric {
+		add(ks.active)
+		kids := make([]string, 0, len(ks.byKID))
+		for kid := range ks.byKID {
+			if kid != ks.active.kid {
+				kids = append(kids, kid)
+			}
+		}
+		sort.Strings(kids)
+		for _, kid := range kids {
+			add(ks.byKID[kid])
+		}
+	}
+	return map[string]any{"keys": out}, nil
+}
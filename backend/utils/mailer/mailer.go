@@ -5,6 +5,7 @@ import (
 	"crypto/tls"
 	"fmt"
 	"log"
+	"mime"
 	"net"
 	"net/smtp"
 	"strings"
@@ -49,38 +50,94 @@ func SendEmail(msg string, recipients []string, subject string) error {
 
 // sendEmailSync contains the original sending logic (blocking).
 func sendEmailSync(msg string, recipients []string, subject string) error {
-	host := web.AppConfig.DefaultString("smtp::host", "")
-	port := web.AppConfig.DefaultInt("smtp::port", 587)
-	user := web.AppConfig.DefaultString("smtp::username", "")
-	pass := web.AppConfig.DefaultString("smtp::password", "")
-	from := web.AppConfig.DefaultString("smtp::from", user)
-
-	if host == "" || user == "" || pass == "" || from == "" {
-		return logErr(fmt.Errorf("smtp not configured (host/user/pass/from)"), "config")
-	}
-
-	addr := fmt.Sprintf("%s:%d", host, port)
-	hello := host
-	if i := strings.Index(host, ":"); i >= 0 {
-		hello = host[:i]
-	}
-
 	// Basic heuristic for HTML
 	contentType := "text/plain; charset=UTF-8"
 	if strings.Contains(strings.ToLower(msg), "<html") || strings.Contains(msg, "<") {
 		contentType = "text/html; charset=UTF-8"
 	}
+	return deliver(recipients, subject, contentType, []byte(msg))
+}
+
+// Transport hands a complete RFC 5322 message to the mail server. Tests may
+// replace it to capture outgoing mail.
+var Transport = smtpTransport
+
+// deliver adds the standard headers to body and sends it through Transport.
+func deliver(recipients []string, subject, contentType string, body []byte) error {
+	from := web.AppConfig.DefaultString("smtp::from", web.AppConfig.DefaultString("smtp::username", ""))
 
 	// Build message
 	var buf bytes.Buffer
 	writeHeader := func(k, v string) { buf.WriteString(fmt.Sprintf("%s: %s\r\n", k, v)) }
 	writeHeader("From", from)
 	writeHeader("To", strings.Join(recipients, ", "))
-	writeHeader("Subject", subject)
+	writeHeader("Subject", mime.QEncoding.Encode("utf-8", subject))
 	writeHeader("MIME-Version", "1.0")
 	writeHeader("Content-Type", contentType)
 	buf.WriteString("\r\n")
-	buf.WriteString(msg)
+	buf.Write(body)
+
+	return Transport(from, recipients, buf.Bytes())
+}
+
+// smtpTransport sends over SMTP using the [smtp] config section.
+func smtpTransport(from string, recipients []string, msg []byte) error {
+	if from == "" {
+		return logErr(fmt.Errorf("smtp not configured (host/user/pass/from)"), "config")
+	}
+	c, err := dial()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if err = c.Mail(from); err != nil {
+		return logErr(err, "mail")
+	}
+	for _, rcpt := range recipients {
+		if err = c.Rcpt(rcpt); err != nil {
+			return logErr(err, "rcpt")
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return logErr(err, "data")
+	}
+	if _, err = w.Write(msg); err != nil {
+		return logErr(err, "write")
+	}
+	return logErr(w.Close(), "closeWriter")
+}
+
+// Check connects and signs in to the SMTP server without sending anything.
+func Check() error {
+	if web.AppConfig.DefaultString("smtp::from", web.AppConfig.DefaultString("smtp::username", "")) == "" {
+		return fmt.Errorf("smtp not configured (host/user/pass/from)")
+	}
+	c, err := dial()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	return c.Quit()
+}
+
+// dial opens an authenticated connection to the [smtp] server.
+func dial() (*smtp.Client, error) {
+	host := web.AppConfig.DefaultString("smtp::host", "")
+	port := web.AppConfig.DefaultInt("smtp::port", 587)
+	user := web.AppConfig.DefaultString("smtp::username", "")
+	pass := web.AppConfig.DefaultString("smtp::password", "")
+
+	if host == "" || user == "" || pass == "" {
+		return nil, logErr(fmt.Errorf("smtp not configured (host/user/pass/from)"), "config")
+	}
+
+	addr := fmt.Sprintf("%s:%d", host, port)
+	hello := host
+	if i := strings.Index(host, ":"); i >= 0 {
+		hello = host[:i]
+	}
 
 	auth := smtp.PlainAuth("", user, pass, host)
 
@@ -91,67 +148,37 @@ func sendEmailSync(msg string, recipients []string, subject string) error {
 		c, err2 := smtp.Dial(addr)
 		if err2 != nil {
 			// return the original TLS error for better signal; include context
-			return logErr(err, "tlsDial+Dial")
+			return nil, logErr(err, "tlsDial+Dial")
 		}
-		defer c.Close()
-
 		if err = c.Hello(hello); err != nil {
-			return logErr(err, "hello")
+			c.Close()
+			return nil, logErr(err, "hello")
 		}
 		if ok, _ := c.Extension("STARTTLS"); ok {
 			if err = c.StartTLS(&tls.Config{ServerName: host}); err != nil {
-				return logErr(err, "starttls")
+				c.Close()
+				return nil, logErr(err, "starttls")
 			}
 		}
 		if ok, _ := c.Extension("AUTH"); ok {
 			if err = c.Auth(auth); err != nil {
-				return logErr(err, "auth(starttls path)")
-			}
-		}
-		if err = c.Mail(from); err != nil {
-			return logErr(err, "mail")
-		}
-		for _, rcpt := range recipients {
-			if err = c.Rcpt(rcpt); err != nil {
-				return logErr(err, "rcpt")
+				c.Close()
+				return nil, logErr(err, "auth(starttls path)")
 			}
 		}
-		w, err := c.Data()
-		if err != nil {
-			return logErr(err, "data")
-		}
-		if _, err = w.Write(buf.Bytes()); err != nil {
-			return logErr(err, "write")
-		}
-		return logErr(w.Close(), "closeWriter")
+		return c, nil
 	}
-	defer conn.Close()
 
 	client, err := smtp.NewClient(conn, host)
 	if err != nil {
-		return logErr(err, "newClient")
+		conn.Close()
+		return nil, logErr(err, "newClient")
 	}
-	defer client.Close()
-
 	if err = client.Auth(auth); err != nil {
-		return logErr(err, "auth(implicit tls)")
-	}
-	if err = client.Mail(from); err != nil {
-		return logErr(err, "mail(implicit tls)")
-	}
-	for _, rcpt := range recipients {
-		if err = client.Rcpt(rcpt); err != nil {
-			return logErr(err, "rcpt(implicit tls)")
-		}
-	}
-	w, err := client.Data()
-	if err != nil {
-		return logErr(err, "data(implicit tls)")
-	}
-	if _, err = w.Write(buf.Bytes()); err != nil {
-		return logErr(err, "write(implicit tls)")
+		client.Close()
+		return nil, logErr(err, "auth(implicit tls)")
 	}
-	return logErr(w.Close(), "closeWriter(implicit tls)")
+	return client, nil
 }
 
 // tlsDial makes a TLS connection to addr without doing a prior plaintext SMTP handshake.
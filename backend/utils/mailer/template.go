@@ -0,0 +1,182 @@
+package mailer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"log"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	texttemplate "text/template"
+
+	"github.com/beego/beego/v2/server/web"
+)
+
+// Message is a rendered email template.
+type Message struct {
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// templateDir holds <name>.html and <name>.txt; mailer::template_dir overrides it.
+func templateDir() string {
+	return web.AppConfig.DefaultString("mailer::template_dir", "views/email")
+}
+
+// SiteSettings gives the site name and public base URL set at runtime,
+// either "" when unset.
+type SiteSettings interface {
+	Site() (name, baseURL string)
+}
+
+// Site is read for every email; the application sets it to its site
+// settings. Nil, or "" values, fall back to appname and mailer::base_url.
+var Site SiteSettings
+
+// siteData returns SiteName and BaseURL from Site, falling back to appname
+// and mailer::base_url.
+func siteData() map[string]any {
+	name := web.AppConfig.DefaultString("appname", "goconda")
+	base := web.AppConfig.DefaultString("mailer::base_url", "")
+	if Site != nil {
+		siteName, siteBase := Site.Site()
+		if siteName != "" {
+			name = siteName
+		}
+		if siteBase != "" {
+			base = siteBase
+		}
+	}
+	return map[string]any{"SiteName": name, "BaseURL": strings.TrimRight(base, "/")}
+}
+
+// SiteBaseURL is the public URL links in emails should point to ("" if unset).
+func SiteBaseURL() string {
+	base, _ := siteData()["BaseURL"].(string)
+	return base
+}
+
+// Render executes views/email/<name>.txt and <name>.html (at least one must
+// exist) with data plus SiteName and BaseURL. The subject comes from a
+// {{define "subject"}} block in either file.
+func Render(name string, data map[string]any) (*Message, error) {
+	all := siteData()
+	for k, v := range data {
+		all[k] = v
+	}
+	dir := templateDir()
+	m := &Message{}
+	found := false
+
+	if src, err := os.ReadFile(filepath.Join(dir, name+".txt")); err == nil {
+		found = true
+		t, err := texttemplate.New(name).Parse(string(src))
+		if err != nil {
+			return nil, fmt.Errorf("mailer: %s.txt: %w", name, err)
+		}
+		if m.Text, err = execText(t, name, all); err != nil {
+			return nil, err
+		}
+		if t.Lookup("subject") != nil {
+			m.Subject, _ = execText(t, "subject", all)
+		}
+	}
+	if src, err := os.ReadFile(filepath.Join(dir, name+".html")); err == nil {
+		found = true
+		t, err := htmltemplate.New(name).Parse(string(src))
+		if err != nil {
+			return nil, fmt.Errorf("mailer: %s.html: %w", name, err)
+		}
+		var buf bytes.Buffer
+		if err := t.ExecuteTemplate(&buf, name, all); err != nil {
+			return nil, fmt.Errorf("mailer: %s.html: %w", name, err)
+		}
+		m.HTML = buf.String()
+		if m.Subject == "" && t.Lookup("subject") != nil {
+			buf.Reset()
+			_ = t.ExecuteTemplate(&buf, "subject", all)
+			m.Subject = buf.String()
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("mailer: no template %q in %s", name, dir)
+	}
+	m.Subject = strings.TrimSpace(m.Subject)
+	return m, nil
+}
+
+func execText(t *texttemplate.Template, name string, data any) (string, error) {
+	var buf bytes.Buffer
+	if err := t.ExecuteTemplate(&buf, name, data); err != nil {
+		return "", fmt.Errorf("mailer: %s: %w", name, err)
+	}
+	return buf.String(), nil
+}
+
+// SendTemplate renders the named template and sends it in the background
+// like SendEmail. Rendering errors are returned immediately.
+func SendTemplate(name string, recipients []string, data map[string]any) error {
+	m, err := Render(name, data)
+	if err != nil {
+		return logErr(err, "render "+name)
+	}
+	if len(recipients) == 0 {
+		return errors.New("mailer: no recipients")
+	}
+	if m.Subject == "" {
+		m.Subject = web.AppConfig.DefaultString("smtp::default_subject", "Notification")
+	}
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Printf("mailer: recovered from panic in SendTemplate: %v", r)
+			}
+		}()
+		if err := SendMessageSync(m, recipients); err != nil {
+			log.Printf("mailer: failed to send %s email: %v", name, err)
+		}
+	}()
+	return nil
+}
+
+// SendMessageSync sends m as multipart/alternative (text and HTML) and blocks
+// until the server accepts it.
+func SendMessageSync(m *Message, recipients []string) error {
+	if m.HTML == "" {
+		return deliver(recipients, m.Subject, "text/plain; charset=UTF-8", []byte(m.Text))
+	}
+	if m.Text == "" {
+		return deliver(recipients, m.Subject, "text/html; charset=UTF-8", []byte(m.HTML))
+	}
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for _, part := range []struct{ ctype, content string }{
+		{"text/plain; charset=UTF-8", m.Text},
+		{"text/html; charset=UTF-8", m.HTML},
+	} {
+		w, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.ctype},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return err
+		}
+		qp := quotedprintable.NewWriter(w)
+		if _, err := qp.Write([]byte(part.content)); err != nil {
+			return err
+		}
+		if err := qp.Close(); err != nil {
+			return err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return err
+	}
+	return deliver(recipients, m.Subject, "multipart/alternative; boundary="+mw.Boundary(), body.Bytes())
+}
@@ -0,0 +1,269 @@
+// Package migrate applies versioned schema migrations and records them in
+// the schema_migrations table.
+//
+// A migration is an Up step and an optional Down step, written in Go or
+// loaded from .sql files (see Load). Migrations of every app share one
+// history and run in version order; versions are timestamps such as
+// 20261018090000, so they sort across apps. On SQLite each migration runs in
+// a transaction together with its history row; MySQL commits DDL
+// implicitly, so a failed migration there may be left half applied.
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/beego/beego/v2/client/orm"
+)
+
+// Dialects, named like the db::driver setting.
+const (
+	SQLite = "sqlite3"
+	MySQL  = "mysql"
+)
+
+// Table holds the applied migrations.
+const Table = "schema_migrations"
+
+// ErrIrreversible is returned when rolling back a migration with no Down
+// step.
+var ErrIrreversible = errors.New("migrate: migration cannot be rolled back")
+
+// Step changes the schema (or data) of db.
+type Step func(db DB) error
+
+// Migration is one versioned schema change.
+type Migration struct {
+	Version string
+	Name    string
+	// App is the app the migration belongs to ("core" for the models
+	// package).
+	App  string
+	Up   Step
+	Down Step
+}
+
+func (m Migration) String() string {
+	return m.Version + "_" + m.Name + " (" + m.App + ")"
+}
+
+// DB is what a Step runs against: the transaction on SQLite, the plain
+// connection on MySQL.
+type DB struct {
+	orm.QueryExecutor
+	Dialect string
+}
+
+// Status is a migration and whether it has been applied. Unknown marks a
+// version recorded in the database that no registered migration has.
+type Status struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+	Unknown   bool
+}
+
+// Migrator applies a set of migrations to one database.
+type Migrator struct {
+	o          orm.Ormer
+	dialect    string
+	migrations []Migration
+}
+
+// New returns a migrator for the database alias. Versions must be unique
+// and every migration needs an Up step.
+func New(alias string, migrations []Migration) (*Migrator, error) {
+	o := orm.NewOrmUsingDB(alias)
+	m := &Migrator{o: o, migrations: append([]Migration(nil), migrations...)}
+	switch o.Driver().Type() {
+	case orm.DRSqlite:
+		m.dialect = SQLite
+	case orm.DRMySQL:
+		m.dialect = MySQL
+	default:
+		return nil, fmt.Errorf("migrate: unsupported driver %s", o.Driver().Name())
+	}
+	sort.SliceStable(m.migrations, func(i, j int) bool {
+		return m.migrations[i].Version < m.migrations[j].Version
+	})
+	for i, mig := range m.migrations {
+		switch {
+		case mig.Version == "":
+			return nil, fmt.Errorf("migrate: %s has no version", mig)
+		case mig.Up == nil:
+			return nil, fmt.Errorf("migrate: %s has no up step", mig)
+		case i > 0 && m.migrations[i-1].Version == mig.Version:
+			return nil, fmt.Errorf("migrate: version %s used by %s and %s", mig.Version, m.migrations[i-1], mig)
+		}
+	}
+	return m, nil
+}
+
+// Dialect returns the database dialect, SQLite or MySQL.
+func (m *Migrator) Dialect() string { return m.dialect }
+
+// Status lists every migration in version order, followed by any unknown
+// versions found in the database.
+func (m *Migrator) Status() ([]Status, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Status, 0, len(m.migrations))
+	for _, mig := range m.migrations {
+		s := Status{Migration: mig}
+		if a, ok := applied[mig.Version]; ok {
+			s.Applied, s.AppliedAt = true, a.AppliedAt
+			delete(applied, mig.Version)
+		}
+		out = append(out, s)
+	}
+	var unknown []Status
+	for _, a := range applied {
+		unknown = append(unknown, a)
+	}
+	sort.Slice(unknown, func(i, j int) bool { return unknown[i].Version < unknown[j].Version })
+	return append(out, unknown...), nil
+}
+
+// Pending returns the migrations not applied yet, in version order.
+func (m *Migrator) Pending() ([]Migration, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+	var out []Migration
+	for _, mig := range m.migrations {
+		if _, ok := applied[mig.Version]; !ok {
+			out = append(out, mig)
+		}
+	}
+	return out, nil
+}
+
+// Up applies up to n pending migrations (all of them if n <= 0) and returns
+// those it applied.
+func (m *Migrator) Up(n int) ([]Migration, error) {
+	pending, err := m.Pending()
+	if err != nil {
+		return nil, err
+	}
+	if n > 0 && n < len(pending) {
+		pending = pending[:n]
+	}
+	var done []Migration
+	for _, mig := range pending {
+		err := m.run(mig.Up, func(db DB) error {
+			_, err := db.Raw("INSERT INTO `"+Table+"` (`version`, `app`, `name`, `applied_at`) VALUES (?, ?, ?, ?)",
+				mig.Version, mig.App, mig.Name, time.Now().UTC().Format(time.DateTime)).Exec()
+			return err
+		})
+		if err != nil {
+			return done, fmt.Errorf("migrate: up %s: %w", mig, err)
+		}
+		done = append(done, mig)
+	}
+	return done, nil
+}
+
+// Down rolls back the n most recently applied migrations (one if n <= 0)
+// and returns those it rolled back.
+func (m *Migrator) Down(n int) ([]Migration, error) {
+	if n <= 0 {
+		n = 1
+	}
+	status, err := m.Status()
+	if err != nil {
+		return nil, err
+	}
+	var applied []Status
+	for _, s := range status {
+		if s.Applied {
+			applied = append(applied, s)
+		}
+	}
+	sort.Slice(applied, func(i, j int) bool { return applied[i].Version > applied[j].Version })
+	if n < len(applied) {
+		applied = applied[:n]
+	}
+	var done []Migration
+	for _, s := range applied {
+		mig := s.Migration
+		switch {
+		case s.Unknown:
+			return done, fmt.Errorf("migrate: down %s: no such migration", mig.Version)
+		case mig.Down == nil:
+			return done, fmt.Errorf("migrate: down %s: %w", mig, ErrIrreversible)
+		}
+		err := m.run(mig.Down, func(db DB) error {
+			_, err := db.Raw("DELETE FROM `"+Table+"` WHERE `version` = ?", mig.Version).Exec()
+			return err
+		})
+		if err != nil {
+			return done, fmt.Errorf("migrate: down %s: %w", mig, err)
+		}
+		done = append(done, mig)
+	}
+	return done, nil
+}
+
+// run applies step and record, in one transaction where the dialect has
+// transactional DDL.
+func (m *Migrator) run(step, record Step) error {
+	if m.dialect == SQLite {
+		return m.o.DoTx(func(_ context.Context, tx orm.TxOrmer) error {
+			db := DB{QueryExecutor: tx, Dialect: m.dialect}
+			if err := step(db); err != nil {
+				return err
+			}
+			return record(db)
+		})
+	}
+	db := DB{QueryExecutor: m.o, Dialect: m.dialect}
+	if err := step(db); err != nil {
+		return err
+	}
+	return record(db)
+}
+
+// applied reads the history table, creating it first if needed.
+func (m *Migrator) applied() (map[string]Status, error) {
+	create := "CREATE TABLE IF NOT EXISTS `" + Table + "` (\n" +
+		"    `version` varchar(32) NOT NULL PRIMARY KEY,\n" +
+		"    `app` varchar(64) NOT NULL DEFAULT '',\n" +
+		"    `name` varchar(191) NOT NULL DEFAULT '',\n" +
+		"    `applied_at` datetime NOT NULL\n)"
+	if _, err := m.o.Raw(create).Exec(); err != nil {
+		return nil, fmt.Errorf("migrate: create %s: %w", Table, err)
+	}
+	var rows []orm.Params
+	if _, err := m.o.Raw("SELECT `version`, `app`, `name`, `applied_at` FROM `" + Table + "`").Values(&rows); err != nil {
+		return nil, fmt.Errorf("migrate: read %s: %w", Table, err)
+	}
+	known := make(map[string]bool, len(m.migrations))
+	for _, mig := range m.migrations {
+		known[mig.Version] = true
+	}
+	out := make(map[string]Status, len(rows))
+	for _, r := range rows {
+		s := Status{Applied: true}
+		s.Version, s.App, s.Name = fmt.Sprint(r["version"]), fmt.Sprint(r["app"]), fmt.Sprint(r["name"])
+		s.AppliedAt = parseTime(fmt.Sprint(r["applied_at"]))
+		s.Unknown = !known[s.Version]
+		out[s.Version] = s
+	}
+	return out, nil
+}
+
+// parseTime reads a datetime as either driver returns it.
+func parseTime(v string) time.Time {
+	for _, layout := range []string{time.DateTime, time.RFC3339Nano, "2006-01-02 15:04:05Z07:00"} {
+		if t, err := time.Parse(layout, v); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,222 @@
+package migrate
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// createIndex matches CREATE [UNIQUE] INDEX statements, capturing the index
+// and table names.
+var createIndex = regexp.MustCompile("(?is)^CREATE\\s+(?:UNIQUE\\s+)?INDEX\\s+(?:IF\\s+NOT\\s+EXISTS\\s+)?`?(\\w+)`?\\s+ON\\s+`?(\\w+)`?")
+
+// addColumn matches ALTER TABLE ... ADD [COLUMN] statements, capturing the
+// table and column names.
+var addColumn = regexp.MustCompile("(?is)^ALTER\\s+TABLE\\s+`?(\\w+)`?\\s+ADD\\s+(?:COLUMN\\s+)?`?(\\w+)`?")
+
+// Exec runs a script of statements, each ending with a semicolon at the end
+// of a line. A CREATE INDEX is skipped when the index already exists, as
+// MySQL has no IF NOT EXISTS for indexes, and so is an ADD COLUMN when the
+// column exists; both let a migration adopt tables an earlier release
+// created.
+func (db DB) Exec(script string) error {
+	for _, stmt := range statements(script) {
+		var exists bool
+		var err error
+		if m := createIndex.FindStringSubmatch(stmt); m != nil {
+			exists, err = db.HasIndex(m[2], m[1])
+		} else if m := addColumn.FindStringSubmatch(stmt); m != nil {
+			exists, err = db.HasColumn(m[1], m[2])
+		}
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+		if _, err := db.Raw(stmt).Exec(); err != nil {
+			return fmt.Errorf("%s: %w", firstLine(stmt), err)
+		}
+	}
+	return nil
+}
+
+// HasIndex reports whether table has the named index.
+func (db DB) HasIndex(table, index string) (bool, error) {
+	var n int
+	var err error
+	if db.Dialect == MySQL {
+		err = db.Raw("SELECT COUNT(*) FROM information_schema.statistics WHERE table_schema = DATABASE() AND table_name = ? AND index_name = ?", table, index).QueryRow(&n)
+	} else {
+		err = db.Raw("SELECT COUNT(*) FROM sqlite_master WHERE type = 'index' AND tbl_name = ? AND name = ?", table, index).QueryRow(&n)
+	}
+	return n > 0, err
+}
+
+// HasColumn reports whether table has the named column.
+func (db DB) HasColumn(table, column string) (bool, error) {
+	var n int
+	var err error
+	if db.Dialect == MySQL {
+		err = db.Raw("SELECT COUNT(*) FROM information_schema.columns WHERE table_schema = DATABASE() AND table_name = ? AND column_name = ?", table, column).QueryRow(&n)
+	} else {
+		err = db.Raw("SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?", table, column).QueryRow(&n)
+	}
+	return n > 0, err
+}
+
+// statements splits a script on semicolons at line ends, dropping comment
+// lines.
+func statements(script string) []string {
+	var out []string
+	var cur []string
+	for _, line := range strings.Split(script, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+		if strings.HasSuffix(trimmed, ";") {
+			cur = append(cur, strings.TrimSuffix(strings.TrimRight(line, " \t\r"), ";"))
+			out = append(out, strings.Join(cur, "\n"))
+			cur = nil
+			continue
+		}
+		cur = append(cur, line)
+	}
+	if len(cur) > 0 {
+		out = append(out, strings.Join(cur, "\n"))
+	}
+	return out
+}
+
+func firstLine(stmt string) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(stmt), "\n")
+	return line
+}
+
+// SQL returns a step that runs script on every dialect.
+func SQL(script string) Step {
+	return func(db DB) error { return db.Exec(script) }
+}
+
+// DialectSQL returns a step that runs the script for the database's
+// dialect, or the one under "" if there is none.
+func DialectSQL(scripts map[string]string) Step {
+	return func(db DB) error {
+		script, ok := scripts[db.Dialect]
+		if !ok {
+			if script, ok = scripts[""]; !ok {
+				return fmt.Errorf("no SQL for %s", db.Dialect)
+			}
+		}
+		return db.Exec(script)
+	}
+}
+
+// fileName matches VERSION_name[.dialect].up.sql and .down.sql.
+var fileName = regexp.MustCompile(`^(\d+)_(\w+?)(?:\.(sqlite3|mysql))?\.(up|down)\.sql$`)
+
+// Load reads the .sql migrations in dir of fsys, usually an embed.FS:
+//
+//	20261018090000_baseline.up.sql
+//	20261018090000_baseline.down.sql
+//	20261018093000_add_tags.mysql.up.sql
+//	20261018093000_add_tags.sqlite3.up.sql
+//
+// A file with a dialect suffix is used for that dialect only, one without
+// for the others. Other files are ignored.
+func Load(app string, fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	type scripts struct {
+		name     string
+		up, down map[string]string
+	}
+	byVersion := map[string]*scripts{}
+	for _, e := range entries {
+		m := fileName.FindStringSubmatch(e.Name())
+		if e.IsDir() || m == nil {
+			continue
+		}
+		version, name, dialect, direction := m[1], m[2], m[3], m[4]
+		s := byVersion[version]
+		if s == nil {
+			s = &scripts{name: name, up: map[string]string{}, down: map[string]string{}}
+			byVersion[version] = s
+		} else if s.name != name {
+			return nil, fmt.Errorf("migrate: %s: version %s is also %s", e.Name(), version, s.name)
+		}
+		data, err := fs.ReadFile(fsys, path.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		if direction == "up" {
+			s.up[dialect] = string(data)
+		} else {
+			s.down[dialect] = string(data)
+		}
+	}
+	out := make([]Migration, 0, len(byVersion))
+	for version, s := range byVersion {
+		if len(s.up) == 0 {
+			return nil, fmt.Errorf("migrate: %s_%s has no up script", version, s.name)
+		}
+		mig := Migration{Version: version, Name: s.name, App: app, Up: DialectSQL(s.up)}
+		if len(s.down) > 0 {
+			mig.Down = DialectSQL(s.down)
+		}
+		out = append(out, mig)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+	return out, nil
+}
+
+// MustLoad is Load for package variables; it panics on error.
+func MustLoad(app string, fsys fs.FS, dir string) []Migration {
+	out, err := Load(app, fsys, dir)
+	if err != nil {
+		panic(err)
+	}
+	return out
+}
+
+var nonWord = regexp.MustCompile(`\W+`)
+
+// Create writes an empty up and down script for a new migration to dir and
+// returns their paths. The version is the current UTC time.
+func Create(dir, name string) ([]string, error) {
+	name = strings.Trim(nonWord.ReplaceAllString(strings.ToLower(name), "_"), "_")
+	if name == "" {
+		return nil, fmt.Errorf("migrate: empty migration name")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	base := time.Now().UTC().Format("20060102150405") + "_" + name
+	var paths []string
+	for _, direction := range []string{"up", "down"} {
+		p := filepath.Join(dir, base+"."+direction+".sql")
+		body := fmt.Sprintf("-- %s: %s\n-- Add .sqlite3 or .mysql before .%s.sql for dialect-specific SQL.\n", name, direction, direction)
+		f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if err != nil {
+			return paths, err
+		}
+		_, err = f.WriteString(body)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return paths, err
+		}
+		paths = append(paths, p)
+	}
+	return paths, nil
+}
@@ -0,0 +1,153 @@
+package oidc
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// jwksRefetchInterval limits how often an unknown kid triggers a JWKS reload.
+const jwksRefetchInterval = time.Minute
+
+type keyCache struct {
+	keys    map[string]any
+	fetched time.Time
+}
+
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// VerifyIDToken checks the signature, issuer, audience, expiry and nonce of
+// an ID token and returns the identity it asserts.
+func (p *Provider) VerifyIDToken(raw, nonce string) (*Identity, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(raw, claims, p.keyFunc,
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "ES256", "ES384", "EdDSA"}),
+		jwt.WithIssuer(p.Issuer),
+		jwt.WithAudience(p.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: id_token: %w", err)
+	}
+	if got, _ := claims["nonce"].(string); nonce == "" || got != nonce {
+		return nil, errors.New("oidc: id_token: nonce mismatch")
+	}
+	if aud, _ := claims.GetAudience(); len(aud) > 1 {
+		if azp, _ := claims["azp"].(string); azp != p.ClientID {
+			return nil, errors.New("oidc: id_token: azp mismatch")
+		}
+	}
+	id := identityFromClaims(claims)
+	if id.Subject == "" {
+		return nil, errors.New("oidc: id_token: no subject")
+	}
+	return id, nil
+}
+
+func (p *Provider) keyFunc(t *jwt.Token) (any, error) {
+	kid, _ := t.Header["kid"].(string)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.jwks != nil {
+		if k := lookupKey(p.jwks.keys, kid); k != nil {
+			return k, nil
+		}
+		if time.Since(p.jwks.fetched) < jwksRefetchInterval {
+			return nil, fmt.Errorf("unknown key id %q", kid)
+		}
+	}
+	// first use, or the provider may have rotated its keys
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := getJSON(p.JWKSURL, "", &set); err != nil {
+		return nil, fmt.Errorf("fetch jwks: %w", err)
+	}
+	keys := map[string]any{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if pub, err := k.publicKey(); err == nil {
+			keys[k.Kid] = pub
+		}
+	}
+	p.jwks = &keyCache{keys: keys, fetched: time.Now()}
+	if k := lookupKey(keys, kid); k != nil {
+		return k, nil
+	}
+	return nil, fmt.Errorf("unknown key id %q", kid)
+}
+
+// lookupKey finds kid, or the only key when the token has no kid.
+func lookupKey(keys map[string]any, kid string) any {
+	if k, ok := keys[kid]; ok {
+		return k
+	}
+	if kid == "" && len(keys) == 1 {
+		for _, k := range keys {
+			return k
+		}
+	}
+	return nil
+}
+
+func (k jwk) publicKey() (any, error) {
+	dec := base64.RawURLEncoding.DecodeString
+	switch k.Kty {
+	case "RSA":
+		n, err := dec(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := dec(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := dec(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := dec(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	case "OKP":
+		x, err := dec(k.X)
+		if err != nil || k.Crv != "Ed25519" || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
@@ -0,0 +1,373 @@
+// Package oidc is a small OAuth 2.0 / OpenID Connect client for social
+// login: authorization-code flow with PKCE, ID token verification against
+// the provider's JWKS, and a userinfo fallback for plain OAuth 2.0
+// providers such as GitHub.
+//
+// Providers are configured in app.conf:
+//
+//	[oauth]
+//	providers = google,github
+//
+//	[oauth_google]
+//	issuer = https://accounts.google.com
+//	client_id = ...
+//	client_secret = ...
+//
+//	[oauth_github]
+//	auth_url = https://github.com/login/oauth/authorize
+//	token_url = https://github.com/login/oauth/access_token
+//	userinfo_url = https://api.github.com/user
+//	emails_url = https://api.github.com/user/emails
+//	scopes = read:user,user:email
+//	client_id = ...
+//	client_secret = ...
+//
+// When issuer is set, missing endpoints are read from its discovery document.
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/beego/beego/v2/server/web"
+)
+
+// HTTPClient is used for every call to a provider.
+var HTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+var ErrUnknownProvider = errors.New("unknown oauth provider")
+
+// Provider is one configured identity provider.
+type Provider struct {
+	Name         string
+	ClientID     string
+	ClientSecret string
+	Issuer       string
+	AuthURL      string
+	TokenURL     string
+	UserInfoURL  string
+	JWKSURL      string
+	// EmailsURL lists the account's addresses when userinfo has no verified
+	// email (GitHub's /user/emails).
+	EmailsURL   string
+	RedirectURL string
+	Scopes      []string
+
+	mu   sync.Mutex
+	jwks *keyCache
+}
+
+// Identity is what the provider tells us about the user.
+type Identity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	GivenName     string
+	FamilyName    string
+	Name          string
+}
+
+// Token is the token endpoint response.
+type Token struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	IDToken     string `json:"id_token"`
+	Error       string `json:"error"`
+	ErrorDesc   string `json:"error_description"`
+}
+
+var (
+	providersMu sync.Mutex
+	providers   = map[string]*Provider{}
+)
+
+// Enabled returns the provider names listed in oauth::providers.
+func Enabled() []string {
+	var out []string
+	for _, n := range strings.Split(web.AppConfig.DefaultString("oauth::providers", ""), ",") {
+		if n = strings.ToLower(strings.TrimSpace(n)); n != "" {
+			out = append(out, n)
+		}
+	}
+	return out
+}
+
+// Get returns the named provider from config, running discovery on first use.
+func Get(name string) (*Provider, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	enabled := false
+	for _, n := range Enabled() {
+		enabled = enabled || n == name
+	}
+	if !enabled {
+		return nil, ErrUnknownProvider
+	}
+	providersMu.Lock()
+	defer providersMu.Unlock()
+	if p, ok := providers[name]; ok {
+		return p, nil
+	}
+	p := FromConfig(name)
+	if err := p.Discover(); err != nil {
+		return nil, err
+	}
+	providers[name] = p
+	return p, nil
+}
+
+// Reset drops cached providers so the next Get re-reads config.
+func Reset() {
+	providersMu.Lock()
+	providers = map[string]*Provider{}
+	providersMu.Unlock()
+}
+
+// FromConfig reads the [oauth_<name>] section.
+func FromConfig(name string) *Provider {
+	get := func(k string) string {
+		return strings.TrimSpace(web.AppConfig.DefaultString("oauth_"+name+"::"+k, ""))
+	}
+	p := &Provider{
+		Name:         name,
+		ClientID:     get("client_id"),
+		ClientSecret: get("client_secret"),
+		Issuer:       strings.TrimRight(get("issuer"), "/"),
+		AuthURL:      get("auth_url"),
+		TokenURL:     get("token_url"),
+		UserInfoURL:  get("userinfo_url"),
+		JWKSURL:      get("jwks_url"),
+		EmailsURL:    get("emails_url"),
+		RedirectURL:  get("redirect_url"),
+	}
+	for _, s := range strings.Split(get("scopes"), ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			p.Scopes = append(p.Scopes, s)
+		}
+	}
+	if len(p.Scopes) == 0 && p.Issuer != "" {
+		p.Scopes = []string{"openid", "email", "profile"}
+	}
+	return p
+}
+
+// Discover fills unset endpoints from the issuer's discovery document.
+func (p *Provider) Discover() error {
+	if p.Issuer != "" && (p.AuthURL == "" || p.TokenURL == "" || p.JWKSURL == "") {
+		var doc struct {
+			Issuer   string `json:"issuer"`
+			Auth     string `json:"authorization_endpoint"`
+			Token    string `json:"token_endpoint"`
+			UserInfo string `json:"userinfo_endpoint"`
+			JWKS     string `json:"jwks_uri"`
+		}
+		if err := getJSON(p.Issuer+"/.well-known/openid-configuration", "", &doc); err != nil {
+			return fmt.Errorf("oidc: discovery for %s: %w", p.Name, err)
+		}
+		if strings.TrimRight(doc.Issuer, "/") != p.Issuer {
+			return fmt.Errorf("oidc: discovery for %s: issuer mismatch %q", p.Name, doc.Issuer)
+		}
+		p.AuthURL = firstNonEmpty(p.AuthURL, doc.Auth)
+		p.TokenURL = firstNonEmpty(p.TokenURL, doc.Token)
+		p.UserInfoURL = firstNonEmpty(p.UserInfoURL, doc.UserInfo)
+		p.JWKSURL = firstNonEmpty(p.JWKSURL, doc.JWKS)
+	}
+	if p.ClientID == "" || p.AuthURL == "" || p.TokenURL == "" {
+		return fmt.Errorf("oidc: provider %s needs client_id, auth_url and token_url (or issuer)", p.Name)
+	}
+	if p.JWKSURL == "" && p.UserInfoURL == "" {
+		return fmt.Errorf("oidc: provider %s needs an issuer or userinfo_url", p.Name)
+	}
+	return nil
+}
+
+// RandomString returns n random bytes, base64url encoded.
+func RandomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// PKCEChallenge is the S256 code challenge for verifier (RFC 7636).
+func PKCEChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthCodeURL builds the authorization request URL.
+func (p *Provider) AuthCodeURL(redirectURL, state, nonce, verifier string) string {
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.ClientID},
+		"redirect_uri":          {redirectURL},
+		"scope":                 {strings.Join(p.Scopes, " ")},
+		"state":                 {state},
+		"code_challenge":        {PKCEChallenge(verifier)},
+		"code_challenge_method": {"S256"},
+	}
+	if p.JWKSURL != "" {
+		q.Set("nonce", nonce)
+	}
+	sep := "?"
+	if strings.Contains(p.AuthURL, "?") {
+		sep = "&"
+	}
+	return p.AuthURL + sep + q.Encode()
+}
+
+// Exchange trades the authorization code for tokens.
+func (p *Provider) Exchange(code, redirectURL, verifier string) (*Token, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURL},
+		"client_id":     {p.ClientID},
+		"code_verifier": {verifier},
+	}
+	if p.ClientSecret != "" {
+		form.Set("client_secret", p.ClientSecret)
+	}
+	req, err := http.NewRequest(http.MethodPost, p.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	var t Token
+	if err := doJSON(req, &t); err != nil {
+		return nil, fmt.Errorf("oidc: token exchange: %w", err)
+	}
+	if t.Error != "" {
+		return nil, fmt.Errorf("oidc: token exchange: %s %s", t.Error, t.ErrorDesc)
+	}
+	if t.AccessToken == "" && t.IDToken == "" {
+		return nil, errors.New("oidc: token exchange: empty response")
+	}
+	return &t, nil
+}
+
+// Identify resolves the user behind t: from the verified ID token when the
+// provider is OpenID Connect, otherwise from the userinfo endpoint.
+func (p *Provider) Identify(t *Token, nonce string) (*Identity, error) {
+	if p.JWKSURL != "" {
+		if t.IDToken == "" {
+			return nil, errors.New("oidc: provider returned no id_token")
+		}
+		id, err := p.VerifyIDToken(t.IDToken, nonce)
+		if err != nil {
+			return nil, err
+		}
+		// some providers leave the email out of the ID token
+		if id.Email == "" && p.UserInfoURL != "" && t.AccessToken != "" {
+			if ui, err := p.UserInfo(t.AccessToken); err == nil && ui.Subject == id.Subject {
+				id.Email, id.EmailVerified = ui.Email, ui.EmailVerified
+			}
+		}
+		return id, nil
+	}
+	return p.UserInfo(t.AccessToken)
+}
+
+// UserInfo fetches the profile with the access token.
+func (p *Provider) UserInfo(accessToken string) (*Identity, error) {
+	if p.UserInfoURL == "" {
+		return nil, errors.New("oidc: no userinfo endpoint")
+	}
+	var raw map[string]any
+	if err := getJSON(p.UserInfoURL, accessToken, &raw); err != nil {
+		return nil, fmt.Errorf("oidc: userinfo: %w", err)
+	}
+	id := identityFromClaims(raw)
+	if id.Subject == "" {
+		// GitHub returns a numeric "id" instead of "sub"
+		switch v := raw["id"].(type) {
+		case float64:
+			id.Subject = strconv.FormatFloat(v, 'f', -1, 64)
+		case string:
+			id.Subject = v
+		}
+	}
+	if id.Subject == "" {
+		return nil, errors.New("oidc: userinfo has no subject")
+	}
+	if !id.EmailVerified && p.EmailsURL != "" {
+		var emails []struct {
+			Email    string `json:"email"`
+			Primary  bool   `json:"primary"`
+			Verified bool   `json:"verified"`
+		}
+		if err := getJSON(p.EmailsURL, accessToken, &emails); err == nil {
+			for _, e := range emails {
+				if e.Primary && e.Verified {
+					id.Email, id.EmailVerified = e.Email, true
+				}
+			}
+		}
+	}
+	return id, nil
+}
+
+func identityFromClaims(m map[string]any) *Identity {
+	str := func(k string) string { s, _ := m[k].(string); return s }
+	id := &Identity{
+		Subject:    str("sub"),
+		Email:      strings.ToLower(strings.TrimSpace(str("email"))),
+		GivenName:  str("given_name"),
+		FamilyName: str("family_name"),
+		Name:       str("name"),
+	}
+	switch v := m["email_verified"].(type) {
+	case bool:
+		id.EmailVerified = v
+	case string:
+		id.EmailVerified = v == "true"
+	}
+	return id
+}
+
+func getJSON(u, bearer string, v any) error {
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+	return doJSON(req, v)
+}
+
+func doJSON(req *http.Request, v any) error {
+	resp, err := HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode/100 != 2 && resp.StatusCode != http.StatusBadRequest {
+		return fmt.Errorf("%s: %s", req.URL.Host, resp.Status)
+	}
+	return json.Unmarshal(body, v)
+}
+
+func firstNonEmpty(a, b string) string {
+	if a != "" {
+		return a
+	}
+	return b
+}
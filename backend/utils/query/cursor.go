@@ -0,0 +1,223 @@
+package query
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/beego/beego/v2/client/orm"
+	"github.com/beego/beego/v2/client/orm/clauses/order_clause"
+)
+
+// cursor marks a row by its sort values. Prev pages backwards from it.
+type cursor struct {
+	Sort   string   `json:"s"`
+	Values []string `json:"v"`
+	Prev   bool     `json:"p,omitempty"`
+}
+
+// signature identifies a sort order, so a cursor is only used with the sort
+// it was made for.
+func signature(keys []SortKey) string {
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = k.Column
+		if k.Desc {
+			parts[i] = "-" + k.Column
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+func (c cursor) encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(raw string, keys []SortKey) (*cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var c cursor
+	if err := json.Unmarshal(b, &c); err != nil || c.Sort != signature(keys) || len(c.Values) != len(keys) {
+		return nil, ErrInvalidCursor
+	}
+	for i, k := range keys {
+		if _, err := cursorValue(k.Field.Kind, c.Values[i]); err != nil {
+			return nil, ErrInvalidCursor
+		}
+	}
+	return &c, nil
+}
+
+// cursorValue decodes one cursor value. Times are kept to the second, the
+// precision the ORM compares them at.
+func cursorValue(k Kind, raw string) (any, error) {
+	switch k {
+	case Time:
+		sec, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		return time.Unix(sec, 0), nil
+	case String:
+		return raw, nil
+	}
+	return parseValue(k, raw)
+}
+
+// rowCursor builds the cursor for a loaded row.
+func (q *Query) rowCursor(row reflect.Value, prev bool) string {
+	row = reflect.Indirect(row)
+	vals := make([]string, len(q.Sort))
+	for i, k := range q.Sort {
+		v := row.FieldByName(k.Field.Name)
+		switch k.Field.Kind {
+		case Int:
+			vals[i] = strconv.FormatInt(v.Int(), 10)
+		case Time:
+			vals[i] = strconv.FormatInt(v.Interface().(time.Time).Unix(), 10)
+		case Bool:
+			vals[i] = strconv.FormatBool(v.Bool())
+		default:
+			vals[i] = v.String()
+		}
+	}
+	return cursor{Sort: signature(q.Sort), Values: vals, Prev: prev}.encode()
+}
+
+// order returns the ORDER BY clauses, reversed when paging backwards. Times
+// are ordered by the second and then by the following keys, matching how
+// the keyset compares them.
+func (q *Query) order(reverse bool) []*order_clause.Order {
+	out := make([]*order_clause.Order, len(q.Sort))
+	for i, k := range q.Sort {
+		dir := order_clause.SortAscending()
+		if k.Desc != reverse {
+			dir = order_clause.SortDescending()
+		}
+		if k.Field.Kind == Time {
+			out[i] = order_clause.Clause(order_clause.Column("substr(T0.`"+k.Column+"`,1,19)"), order_clause.Raw(), dir)
+		} else {
+			out[i] = order_clause.Clause(order_clause.Column(k.Field.Name), dir)
+		}
+	}
+	return out
+}
+
+// keyset matches the rows that come after the cursor in the sort order, or
+// before it when reverse.
+func (q *Query) keyset(c *cursor, reverse bool) *orm.Condition {
+	vals := make([]any, len(q.Sort))
+	for i, k := range q.Sort {
+		vals[i], _ = cursorValue(k.Field.Kind, c.Values[i])
+	}
+	cond := orm.NewCondition()
+	for i, k := range q.Sort {
+		branch := orm.NewCondition()
+		for j, prev := range q.Sort[:i] {
+			branch = equal(branch, prev.Field, vals[j])
+		}
+		cond = cond.OrCond(beyond(branch, k.Field, vals[i], k.Desc != reverse))
+	}
+	return cond
+}
+
+func equal(c *orm.Condition, f Field, v any) *orm.Condition {
+	if t, ok := v.(time.Time); ok {
+		return c.And(f.Name+"__gte", t).And(f.Name+"__lt", t.Add(time.Second))
+	}
+	return c.And(f.Name, v)
+}
+
+func beyond(c *orm.Condition, f Field, v any, desc bool) *orm.Condition {
+	if t, ok := v.(time.Time); ok {
+		if desc {
+			return c.And(f.Name+"__lt", t)
+		}
+		return c.And(f.Name+"__gte", t.Add(time.Second))
+	}
+	if desc {
+		return c.And(f.Name+"__lt", v)
+	}
+	return c.And(f.Name+"__gt", v)
+}
+
+// Page describes where a loaded page sits in the result. Next and Prev are
+// cursors, empty at either end.
+type Page struct {
+	Total int64
+	Next  string
+	Prev  string
+}
+
+// Find applies q to qs and loads one page into out, a pointer to a slice of
+// models, like QuerySeter.All. Total counts every match, regardless of the
+// cursor.
+func (q *Query) Find(qs orm.QuerySeter, out any) (*Page, error) {
+	qs = q.Apply(qs)
+	total, err := qs.Count()
+	if err != nil {
+		return nil, err
+	}
+	back := q.cursor != nil && q.cursor.Prev
+	if q.cursor != nil {
+		qs = and(qs, q.keyset(q.cursor, back))
+	}
+	// one extra row tells whether there is another page
+	if _, err := qs.OrderClauses(q.order(back)...).Limit(q.Limit+1, q.Offset).All(out); err != nil && err != orm.ErrNoRows {
+		return nil, err
+	}
+	rows := reflect.ValueOf(out).Elem()
+	more := int64(rows.Len()) > q.Limit
+	if more {
+		rows.Set(rows.Slice(0, int(q.Limit)))
+	}
+	if back {
+		swap := reflect.Swapper(rows.Interface())
+		for i, j := 0, rows.Len()-1; i < j; i, j = i+1, j-1 {
+			swap(i, j)
+		}
+	}
+
+	page := &Page{Total: total}
+	hasNext, hasPrev := more, q.cursor != nil || q.Offset > 0
+	if back {
+		hasNext, hasPrev = true, more
+	}
+	if n := rows.Len(); n > 0 {
+		if hasNext {
+			page.Next = q.rowCursor(rows.Index(n-1), false)
+		}
+		if hasPrev {
+			page.Prev = q.rowCursor(rows.Index(0), true)
+		}
+	} else if q.cursor != nil {
+		// past either end: point back at the cursor's row
+		c := *q.cursor
+		c.Prev = !back
+		if back {
+			page.Next = c.encode()
+		} else {
+			page.Prev = c.encode()
+		}
+	}
+	return page, nil
+}
+
+// Link returns the request path with the cursor in place of any cursor or
+// offset, or "" if cursor is empty.
+func Link(u *url.URL, cursor string) string {
+	if cursor == "" {
+		return ""
+	}
+	vals := u.Query()
+	vals.Del(ParamOffset)
+	vals.Set(ParamCursor, cursor)
+	return u.Path + "?" + vals.Encode()
+}
@@ -0,0 +1,299 @@
+// Package query turns list-endpoint parameters into ORM queries: field
+// filters (name__icontains=foo, created_at__gte=2024-01-01), a multi-field
+// sort (sort=-created_at,name), a full-text q and opaque cursor paging.
+//
+// Each endpoint describes what it accepts with a Spec:
+//
+//	var ItemQuery = query.Spec{
+//		Fields: map[string]query.Field{
+//			"id":   {Name: "ID", Kind: query.Int, Sort: true},
+//			"name": {Name: "Name", Kind: query.String, Sort: true},
+//		},
+//		Search:      []string{"Name"},
+//		DefaultSort: "-id",
+//	}
+//
+// then parses the request with Parse and loads a page with Query.Find.
+package query
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/beego/beego/v2/client/orm"
+)
+
+// Kind is the type of a field's values.
+type Kind int
+
+const (
+	String Kind = iota
+	Int
+	Time
+	Bool
+)
+
+// lookups lists the operators each kind accepts after "__"; "exact" is also
+// what a bare field name means.
+var lookups = map[Kind][]string{
+	String: {"exact", "iexact", "contains", "icontains", "startswith", "istartswith", "endswith", "iendswith", "in"},
+	Int:    {"exact", "gt", "gte", "lt", "lte", "in"},
+	Time:   {"gt", "gte", "lt", "lte"},
+	Bool:   {"exact"},
+}
+
+// Field is a column exposed to clients under its Spec.Fields key, which must
+// also be the column name.
+type Field struct {
+	Name string // ORM field name, e.g. "CreatedAt"
+	Kind Kind
+	Sort bool // may be used in sort; the column must not be nullable
+}
+
+// Spec describes what a list endpoint accepts.
+type Spec struct {
+	Fields map[string]Field
+	// Search holds the ORM fields q is matched against.
+	Search []string
+	// DefaultSort applies when the request has no sort.
+	DefaultSort string
+	// Key is a unique, sortable field that breaks ties; "id" if empty.
+	Key string
+	// DefaultLimit and MaxLimit bound limit; 20 and 100 if zero.
+	DefaultLimit int64
+	MaxLimit     int64
+}
+
+func (s Spec) key() string {
+	if s.Key == "" {
+		return "id"
+	}
+	return s.Key
+}
+
+// Reserved parameters; anything else that is not a field is ignored, so
+// endpoints can add their own.
+const (
+	ParamSort   = "sort"
+	ParamSearch = "q"
+	ParamLimit  = "limit"
+	ParamOffset = "offset"
+	ParamCursor = "cursor"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+type filter struct {
+	field Field
+	op    string
+	value any
+}
+
+// SortKey is one field of the sort order.
+type SortKey struct {
+	Column string
+	Field  Field
+	Desc   bool
+}
+
+// Query is a parsed list request.
+type Query struct {
+	spec    Spec
+	filters []filter
+	Search  []string // lower-cased words of q
+	Sort    []SortKey
+	Limit   int64
+	Offset  int64 // only without a cursor
+	cursor  *cursor
+}
+
+// Parse reads the request parameters against spec. Its errors are meant for
+// the client.
+func Parse(values url.Values, spec Spec) (*Query, error) {
+	q := &Query{spec: spec}
+	for param, vals := range values {
+		name, op, _ := strings.Cut(param, "__")
+		f, ok := spec.Fields[name]
+		if !ok || len(vals) == 0 {
+			continue
+		}
+		if op == "" {
+			op = "exact"
+		}
+		if !allowed(f.Kind, op) {
+			return nil, fmt.Errorf("%s: unsupported filter %q", name, op)
+		}
+		v, err := parseFilter(f.Kind, op, vals[len(vals)-1])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", param, err)
+		}
+		q.filters = append(q.filters, filter{field: f, op: op, value: v})
+	}
+
+	q.Search = strings.Fields(strings.ToLower(values.Get(ParamSearch)))
+	if len(q.Search) > 0 && len(spec.Search) == 0 {
+		return nil, errors.New("q is not supported here")
+	}
+
+	sort := values.Get(ParamSort)
+	if sort == "" {
+		sort = spec.DefaultSort
+	}
+	keys, err := parseSort(sort, spec)
+	if err != nil {
+		return nil, err
+	}
+	q.Sort = keys
+
+	q.Limit = spec.DefaultLimit
+	if q.Limit <= 0 {
+		q.Limit = 20
+	}
+	max := spec.MaxLimit
+	if max <= 0 {
+		max = 100
+	}
+	if raw := values.Get(ParamLimit); raw != "" {
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || n <= 0 {
+			return nil, errors.New("limit must be a positive number")
+		}
+		q.Limit = min(n, max)
+	}
+
+	if raw := values.Get(ParamCursor); raw != "" {
+		c, err := decodeCursor(raw, q.Sort)
+		if err != nil {
+			return nil, err
+		}
+		q.cursor = c
+	} else if raw := values.Get(ParamOffset); raw != "" {
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || n < 0 {
+			return nil, errors.New("offset must not be negative")
+		}
+		q.Offset = n
+	}
+	return q, nil
+}
+
+func allowed(k Kind, op string) bool {
+	for _, l := range lookups[k] {
+		if l == op {
+			return true
+		}
+	}
+	return false
+}
+
+func parseFilter(k Kind, op, raw string) (any, error) {
+	if op == "in" {
+		var out []any
+		for _, part := range strings.Split(raw, ",") {
+			v, err := parseValue(k, strings.TrimSpace(part))
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, v)
+		}
+		return out, nil
+	}
+	return parseValue(k, raw)
+}
+
+func parseValue(k Kind, raw string) (any, error) {
+	switch k {
+	case Int:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return nil, errors.New("must be a whole number")
+		}
+		return n, nil
+	case Time:
+		for _, layout := range []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02"} {
+			if t, err := time.Parse(layout, raw); err == nil {
+				return t, nil
+			}
+		}
+		return nil, errors.New("must be a date (2006-01-02) or an RFC 3339 time")
+	case Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, errors.New("must be true or false")
+		}
+		return b, nil
+	}
+	return raw, nil
+}
+
+func parseSort(sort string, spec Spec) ([]SortKey, error) {
+	var keys []SortKey
+	seen := map[string]bool{}
+	for _, part := range strings.Split(sort, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		desc := strings.HasPrefix(part, "-")
+		col := strings.TrimPrefix(part, "-")
+		f, ok := spec.Fields[col]
+		if !ok || !f.Sort {
+			return nil, fmt.Errorf("cannot sort by %q", col)
+		}
+		if seen[col] {
+			continue
+		}
+		seen[col] = true
+		keys = append(keys, SortKey{Column: col, Field: f, Desc: desc})
+	}
+	// the key makes the order total, so cursors never skip or repeat rows
+	key := spec.key()
+	if !seen[key] {
+		f, ok := spec.Fields[key]
+		if !ok {
+			return nil, fmt.Errorf("query spec has no %q field", key)
+		}
+		desc := len(keys) > 0 && keys[len(keys)-1].Desc
+		keys = append(keys, SortKey{Column: key, Field: f, Desc: desc})
+	}
+	return keys, nil
+}
+
+// Apply adds the filters and the search to qs.
+func (q *Query) Apply(qs orm.QuerySeter) orm.QuerySeter {
+	for _, f := range q.filters {
+		expr := f.field.Name
+		if f.op != "exact" {
+			expr += "__" + f.op
+		}
+		if vals, ok := f.value.([]any); ok {
+			qs = qs.Filter(expr, vals...)
+		} else {
+			qs = qs.Filter(expr, f.value)
+		}
+	}
+	// every word must appear in one of the search fields
+	for _, word := range q.Search {
+		cond := orm.NewCondition()
+		for _, name := range q.spec.Search {
+			cond = cond.Or(name+"__icontains", word)
+		}
+		qs = and(qs, cond)
+	}
+	return qs
+}
+
+// and adds cond to the conditions already on qs.
+func and(qs orm.QuerySeter, cond *orm.Condition) orm.QuerySeter {
+	if cond.IsEmpty() {
+		return qs
+	}
+	if cur := qs.GetCond(); cur != nil && !cur.IsEmpty() {
+		return qs.SetCond(cur.AndCond(cond))
+	}
+	return qs.SetCond(orm.NewCondition().AndCond(cond))
+}
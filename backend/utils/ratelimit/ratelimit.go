@@ -0,0 +1,183 @@
+// Package ratelimit throttles repeated failures per key (an IP, an email,
+// ...) with progressive delays and a temporary lockout.
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Policy configures a Limiter.
+type Policy struct {
+	// MaxFailures within Window locks the key for Lockout.
+	MaxFailures int
+	Window      time.Duration
+	Lockout     time.Duration
+	// BaseDelay is the wait after the first failure; it doubles with each
+	// further failure (capped at Lockout). Zero disables delays.
+	BaseDelay time.Duration
+}
+
+// State is the failure record of one key.
+type State struct {
+	Key         string    `json:"key"`
+	Failures    int       `json:"failures"`
+	LastFailure time.Time `json:"last_failure"`
+	LockedUntil time.Time `json:"locked_until"`
+}
+
+// Store persists failure records. Implementations must be safe for
+// concurrent use.
+type Store interface {
+	// Get returns the record for key, or a zero State with Key set.
+	Get(key string) (State, error)
+	Put(s State) error
+	Delete(key string) error
+	// Locked lists the keys locked at now.
+	Locked(now time.Time) ([]State, error)
+}
+
+type Limiter struct {
+	store  Store
+	policy Policy
+	now    func() time.Time
+}
+
+func New(store Store, p Policy) *Limiter {
+	return &Limiter{store: store, policy: p, now: time.Now}
+}
+
+// Check returns how long the caller must wait before another attempt on any
+// of keys; zero means the attempt may proceed. Store errors fail open.
+func (l *Limiter) Check(keys ...string) time.Duration {
+	now := l.now()
+	var wait time.Duration
+	for _, k := range keys {
+		s, err := l.store.Get(k)
+		if err != nil {
+			continue
+		}
+		if w := l.waitFor(s, now); w > wait {
+			wait = w
+		}
+	}
+	return wait
+}
+
+// Fail records a failed attempt on each key and returns the resulting wait.
+func (l *Limiter) Fail(keys ...string) time.Duration {
+	now := l.now()
+	var wait time.Duration
+	for _, k := range keys {
+		s, err := l.store.Get(k)
+		if err != nil {
+			continue
+		}
+		if now.Sub(s.LastFailure) > l.policy.Window {
+			s.Failures = 0
+		}
+		s.Key = k
+		s.Failures++
+		s.LastFailure = now
+		if l.policy.MaxFailures > 0 && s.Failures >= l.policy.MaxFailures {
+			s.LockedUntil = now.Add(l.policy.Lockout)
+		}
+		_ = l.store.Put(s)
+		if w := l.waitFor(s, now); w > wait {
+			wait = w
+		}
+	}
+	return wait
+}
+
+// Reset forgets the failures of keys, e.g. after a successful login.
+func (l *Limiter) Reset(keys ...string) error {
+	for _, k := range keys {
+		if err := l.store.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Locked lists currently locked keys.
+func (l *Limiter) Locked() ([]State, error) {
+	return l.store.Locked(l.now())
+}
+
+func (l *Limiter) waitFor(s State, now time.Time) time.Duration {
+	if s.LockedUntil.After(now) {
+		return s.LockedUntil.Sub(now)
+	}
+	if s.Failures == 0 || l.policy.BaseDelay <= 0 || now.Sub(s.LastFailure) > l.policy.Window {
+		return 0
+	}
+	delay := l.policy.Lockout
+	if shift := s.Failures - 1; shift < 30 {
+		if d := l.policy.BaseDelay << shift; d < delay || delay <= 0 {
+			delay = d
+		}
+	}
+	if w := s.LastFailure.Add(delay).Sub(now); w > 0 {
+		return w
+	}
+	return 0
+}
+
+// MemoryStore keeps records in process memory. It suits a single instance;
+// use a shared store when running several.
+type MemoryStore struct {
+	mu sync.Mutex
+	m  map[string]State
+}
+
+// memoryPruneSize triggers dropping stale records once the map grows past it.
+const memoryPruneSize = 10000
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{m: map[string]State{}}
+}
+
+func (s *MemoryStore) Get(key string) (State, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st, ok := s.m[key]
+	if !ok {
+		st.Key = key
+	}
+	return st, nil
+}
+
+func (s *MemoryStore) Put(st State) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.m) >= memoryPruneSize {
+		cutoff := time.Now().Add(-24 * time.Hour)
+		for k, v := range s.m {
+			if v.LastFailure.Before(cutoff) && v.LockedUntil.Before(time.Now()) {
+				delete(s.m, k)
+			}
+		}
+	}
+	s.m[st.Key] = st
+	return nil
+}
+
+func (s *MemoryStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.m, key)
+	return nil
+}
+
+func (s *MemoryStore) Locked(now time.Time) ([]State, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []State
+	for _, v := range s.m {
+		if v.LockedUntil.After(now) {
+			out = append(out, v)
+		}
+	}
+	return out, nil
+}
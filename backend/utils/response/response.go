@@ -19,3 +19,12 @@ func JSONError(ctx *context.Context, code int, msg string) {
         "error":   msg,
     }, false, false)
 }
+
+// JSONAccepted answers 202 for requests whose effect happens later (e.g. an email).
+func JSONAccepted(ctx *context.Context, data interface{}) {
+    ctx.Output.SetStatus(202)
+    ctx.Output.JSON(JSON{
+        "success": true,
+        "data":    data,
+    }, false, false)
+}
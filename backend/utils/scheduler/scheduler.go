@@ -1,24 +1,32 @@
 package scheduler
 
 import (
+	"fmt"
+	"sort"
 	"sync"
 
 	"github.com/robfig/cron/v3"
 )
 
 var (
-	c   *cron.Cron
-	mu  sync.Mutex
-	reg = map[string]cron.EntryID{}
+	c       *cron.Cron
+	started bool
+	mu      sync.Mutex
+	reg     = map[string]cron.EntryID{}
+	funcs   = map[string]func(){}
 )
 
-// Start starts the global scheduler (idempotent).
+// Start starts the global scheduler (idempotent). Jobs registered before
+// it only fire once it has started.
 func Start() {
 	mu.Lock()
 	defer mu.Unlock()
 	if c == nil {
 		c = cron.New(cron.WithSeconds())
+	}
+	if !started {
 		c.Start()
+		started = true
 	}
 }
 
@@ -27,9 +35,12 @@ func Stop() {
 	mu.Lock()
 	defer mu.Unlock()
 	if c != nil {
-		c.Stop()
-		c = nil
+		if started {
+			c.Stop()
+		}
+		c, started = nil, false
 		reg = map[string]cron.EntryID{}
+		funcs = map[string]func(){}
 	}
 }
 
@@ -38,7 +49,9 @@ func Stop() {
 func Register(name, spec string, fn func()) (cron.EntryID, error) {
 	mu.Lock()
 	defer mu.Unlock()
-	if c == nil { Start() }
+	if c == nil {
+		c = cron.New(cron.WithSeconds())
+	}
 	if id, ok := reg[name]; ok {
 		// remove previous before adding again
 		c.Remove(id)
@@ -46,5 +59,30 @@ func Register(name, spec string, fn func()) (cron.EntryID, error) {
 	id, err := c.AddFunc(spec, fn)
 	if err != nil { return 0, err }
 	reg[name] = id
+	funcs[name] = fn
 	return id, nil
 }
+
+// Names returns the names of the registered jobs, sorted.
+func Names() []string {
+	mu.Lock()
+	defer mu.Unlock()
+	names := make([]string, 0, len(funcs))
+	for name := range funcs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Run runs the named job now, in the calling goroutine.
+func Run(name string) error {
+	mu.Lock()
+	fn, ok := funcs[name]
+	mu.Unlock()
+	if !ok {
+		return fmt.Errorf("scheduler: no job named %q", name)
+	}
+	fn()
+	return nil
+}
@@ -0,0 +1,202 @@
+package validators
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/beego/beego/v2/server/web"
+
+	"github.com/mymi14s/goconda/utils/hash"
+)
+
+// PasswordPolicy describes what a new password must satisfy. It is read
+// from [password]:
+//
+//	min_length = 8
+//	max_length = 128
+//	require_upper = false
+//	require_lower = false
+//	require_digit = false
+//	require_symbol = false
+//	banned_file = conf/banned_passwords.txt   ; one password per line
+//	breach_dir =                             ; offline hash-prefix list
+//
+// breach_dir holds files named by the first five hex characters of a
+// password's SHA-1 (e.g. "5BAA6.txt"), each listing "SUFFIX:COUNT" lines,
+// which is the layout of the Have I Been Pwned range downloads.
+//
+// MaxBytes is not configured: it is the limit of the hashing algorithm
+// (72 for bcrypt), so a password the policy accepts can always be hashed.
+type PasswordPolicy struct {
+	MinLength     int
+	MaxLength     int
+	MaxBytes      int
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+	BannedFile    string
+	BreachDir     string
+}
+
+// PasswordError lists every rule a password failed.
+type PasswordError struct {
+	Problems []string
+}
+
+func (e *PasswordError) Error() string {
+	return "password " + strings.Join(e.Problems, "; ")
+}
+
+// CurrentPasswordPolicy returns the configured policy.
+func CurrentPasswordPolicy() PasswordPolicy {
+	cfg := web.AppConfig
+	p := PasswordPolicy{
+		MinLength:     cfg.DefaultInt("password::min_length", 8),
+		MaxLength:     cfg.DefaultInt("password::max_length", 128),
+		MaxBytes:      hash.CurrentParams().MaxBytes(),
+		RequireUpper:  cfg.DefaultBool("password::require_upper", false),
+		RequireLower:  cfg.DefaultBool("password::require_lower", false),
+		RequireDigit:  cfg.DefaultBool("password::require_digit", false),
+		RequireSymbol: cfg.DefaultBool("password::require_symbol", false),
+		BannedFile:    cfg.DefaultString("password::banned_file", ""),
+		BreachDir:     cfg.DefaultString("password::breach_dir", ""),
+	}
+	if p.MinLength < 1 {
+		p.MinLength = 1
+	}
+	if p.MaxLength < p.MinLength {
+		p.MaxLength = 128
+	}
+	return p
+}
+
+// ValidatePassword checks pw against the configured policy. personal holds
+// values the password must not contain, such as the email or names.
+func ValidatePassword(pw string, personal ...string) error {
+	return CurrentPasswordPolicy().Validate(pw, personal...)
+}
+
+// Validate checks pw and returns a *PasswordError listing every failure.
+func (p PasswordPolicy) Validate(pw string, personal ...string) error {
+	var problems []string
+	n := utf8.RuneCountInString(pw)
+	if n < p.MinLength {
+		problems = append(problems, "must be at least "+strconv.Itoa(p.MinLength)+" characters")
+	}
+	if n > p.MaxLength {
+		problems = append(problems, "must be at most "+strconv.Itoa(p.MaxLength)+" characters")
+	} else if p.MaxBytes > 0 && len(pw) > p.MaxBytes {
+		problems = append(problems, "must be at most "+strconv.Itoa(p.MaxBytes)+" bytes")
+	}
+	var upper, lower, digit, symbol bool
+	for _, r := range pw {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		case !unicode.IsSpace(r):
+			symbol = true
+		}
+	}
+	if p.RequireUpper && !upper {
+		problems = append(problems, "must contain an uppercase letter")
+	}
+	if p.RequireLower && !lower {
+		problems = append(problems, "must contain a lowercase letter")
+	}
+	if p.RequireDigit && !digit {
+		problems = append(problems, "must contain a digit")
+	}
+	if p.RequireSymbol && !symbol {
+		problems = append(problems, "must contain a symbol")
+	}
+
+	lowerPW := strings.ToLower(pw)
+	for _, v := range personal {
+		v = strings.ToLower(strings.TrimSpace(v))
+		if i := strings.IndexByte(v, '@'); i > 0 {
+			v = v[:i]
+		}
+		if len(v) >= 3 && strings.Contains(lowerPW, v) {
+			problems = append(problems, "must not contain your name or email")
+			break
+		}
+	}
+	if p.BannedFile != "" && bannedPasswords(p.BannedFile)[lowerPW] {
+		problems = append(problems, "is too common")
+	} else if p.BreachDir != "" && breached(p.BreachDir, pw) {
+		problems = append(problems, "has appeared in a data breach")
+	}
+	if len(problems) > 0 {
+		return &PasswordError{Problems: problems}
+	}
+	return nil
+}
+
+var banned struct {
+	sync.Mutex
+	path  string
+	mtime time.Time
+	set   map[string]bool
+}
+
+// bannedPasswords loads path once and again whenever it changes.
+func bannedPasswords(path string) map[string]bool {
+	banned.Lock()
+	defer banned.Unlock()
+	st, err := os.Stat(path)
+	if err != nil {
+		return nil
+	}
+	if banned.path == path && banned.mtime.Equal(st.ModTime()) {
+		return banned.set
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	set := map[string]bool{}
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			set[strings.ToLower(line)] = true
+		}
+	}
+	banned.path, banned.mtime, banned.set = path, st.ModTime(), set
+	return set
+}
+
+// breached looks up pw in the offline k-anonymity list: only the file for
+// its 5-character SHA-1 prefix is read.
+func breached(dir, pw string) bool {
+	sum := sha1.Sum([]byte(pw))
+	h := strings.ToUpper(hex.EncodeToString(sum[:]))
+	f, err := os.Open(filepath.Join(dir, h[:5]+".txt"))
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		suffix, _, _ := strings.Cut(strings.TrimSpace(sc.Text()), ":")
+		if strings.EqualFold(suffix, h[5:]) {
+			return true
+		}
+	}
+	return false
+}
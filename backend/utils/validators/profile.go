@@ -0,0 +1,35 @@
+package validators
+
+import (
+	"errors"
+	"regexp"
+	"time"
+	// embedded zone database, so hosts without one still validate names
+	_ "time/tzdata"
+)
+
+// localeRe matches BCP 47 language tags such as "en", "en-GB" or
+// "zh-Hant-TW".
+var localeRe = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z0-9]{2,8})*$`)
+
+// ValidateLocale accepts an empty value or a BCP 47 language tag.
+func ValidateLocale(s string) error {
+	if s != "" && (len(s) > 35 || !localeRe.MatchString(s)) {
+		return errors.New("locale must be a language tag such as en-GB")
+	}
+	return nil
+}
+
+// ValidateTimezone accepts an empty value or an IANA time zone name.
+func ValidateTimezone(s string) error {
+	if s == "" {
+		return nil
+	}
+	if s == "Local" || len(s) > 64 {
+		return errors.New("timezone must be an IANA name such as Europe/London")
+	}
+	if _, err := time.LoadLocation(s); err != nil {
+		return errors.New("timezone must be an IANA name such as Europe/London")
+	}
+	return nil
+}
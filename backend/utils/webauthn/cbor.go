@@ -0,0 +1,118 @@
+package webauthn
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// A minimal CBOR (RFC 8949) decoder covering what authenticators send:
+// integers, byte/text strings, arrays, maps, booleans and null. Indefinite
+// lengths, tags and floats are rejected.
+
+var errCBOR = errors.New("webauthn: malformed CBOR")
+
+const maxCBORDepth = 16
+
+// decodeCBOR decodes one item from b and returns it with the bytes read.
+// Integers decode to int64, maps to map[any]any with int64 or string keys.
+func decodeCBOR(b []byte) (any, int, error) {
+	return decodeItem(b, 0)
+}
+
+func decodeItem(b []byte, depth int) (any, int, error) {
+	if len(b) == 0 || depth > maxCBORDepth {
+		return nil, 0, errCBOR
+	}
+	major, info := b[0]>>5, b[0]&0x1f
+	arg, n, err := readArg(b, info)
+	if err != nil {
+		return nil, 0, err
+	}
+	switch major {
+	case 0:
+		if arg > math.MaxInt64 {
+			return nil, 0, errCBOR
+		}
+		return int64(arg), n, nil
+	case 1:
+		if arg > math.MaxInt64 {
+			return nil, 0, errCBOR
+		}
+		return -1 - int64(arg), n, nil
+	case 2, 3:
+		if uint64(len(b)-n) < arg {
+			return nil, 0, errCBOR
+		}
+		end := n + int(arg)
+		if major == 3 {
+			return string(b[n:end]), end, nil
+		}
+		return append([]byte(nil), b[n:end]...), end, nil
+	case 4:
+		if arg > uint64(len(b)) {
+			return nil, 0, errCBOR
+		}
+		out := make([]any, 0, arg)
+		for i := uint64(0); i < arg; i++ {
+			v, m, err := decodeItem(b[n:], depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			out = append(out, v)
+			n += m
+		}
+		return out, n, nil
+	case 5:
+		if arg > uint64(len(b)) {
+			return nil, 0, errCBOR
+		}
+		out := make(map[any]any, arg)
+		for i := uint64(0); i < arg; i++ {
+			k, m, err := decodeItem(b[n:], depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			n += m
+			switch k.(type) {
+			case int64, string:
+			default:
+				return nil, 0, errCBOR
+			}
+			v, m, err := decodeItem(b[n:], depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			n += m
+			out[k] = v
+		}
+		return out, n, nil
+	case 7:
+		switch info {
+		case 20:
+			return false, 1, nil
+		case 21:
+			return true, 1, nil
+		case 22:
+			return nil, 1, nil
+		}
+	}
+	return nil, 0, errCBOR
+}
+
+// readArg reads the argument that follows the initial byte.
+func readArg(b []byte, info byte) (uint64, int, error) {
+	switch {
+	case info < 24:
+		return uint64(info), 1, nil
+	case info == 24 && len(b) >= 2:
+		return uint64(b[1]), 2, nil
+	case info == 25 && len(b) >= 3:
+		return uint64(binary.BigEndian.Uint16(b[1:])), 3, nil
+	case info == 26 && len(b) >= 5:
+		return uint64(binary.BigEndian.Uint32(b[1:])), 5, nil
+	case info == 27 && len(b) >= 9:
+		return binary.BigEndian.Uint64(b[1:]), 9, nil
+	}
+	return 0, 0, errCBOR
+}
@@ -0,0 +1,92 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// COSE algorithm identifiers we accept (RFC 9053).
+const (
+	AlgES256 = -7
+	AlgEdDSA = -8
+	AlgRS256 = -257
+)
+
+// coseKey is a parsed COSE_Key.
+type coseKey struct {
+	alg int64
+	pub crypto.PublicKey
+}
+
+// parseCOSEKey decodes a COSE_Key (EC2 P-256, OKP Ed25519 or RSA).
+func parseCOSEKey(raw []byte) (*coseKey, error) {
+	v, _, err := decodeCBOR(raw)
+	if err != nil {
+		return nil, err
+	}
+	m, ok := v.(map[any]any)
+	if !ok {
+		return nil, errors.New("webauthn: COSE key is not a map")
+	}
+	kty, _ := m[int64(1)].(int64)
+	alg, _ := m[int64(3)].(int64)
+	bytesAt := func(label int64) []byte { b, _ := m[label].([]byte); return b }
+
+	switch {
+	case kty == 2 && alg == AlgES256:
+		if crv, _ := m[int64(-1)].(int64); crv != 1 {
+			return nil, errors.New("webauthn: only P-256 EC keys are supported")
+		}
+		x, y := bytesAt(-2), bytesAt(-3)
+		if len(x) != 32 || len(y) != 32 {
+			return nil, errors.New("webauthn: bad EC2 coordinates")
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, errors.New("webauthn: EC point not on curve")
+		}
+		return &coseKey{alg: alg, pub: pub}, nil
+	case kty == 1 && alg == AlgEdDSA:
+		if crv, _ := m[int64(-1)].(int64); crv != 6 {
+			return nil, errors.New("webauthn: only Ed25519 OKP keys are supported")
+		}
+		x := bytesAt(-2)
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("webauthn: bad Ed25519 key")
+		}
+		return &coseKey{alg: alg, pub: ed25519.PublicKey(x)}, nil
+	case kty == 3 && alg == AlgRS256:
+		n, e := bytesAt(-1), bytesAt(-2)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("webauthn: bad RSA key")
+		}
+		return &coseKey{alg: alg, pub: &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}}, nil
+	}
+	return nil, fmt.Errorf("webauthn: unsupported key (kty %d, alg %d)", kty, alg)
+}
+
+// verify checks sig over data.
+func (k *coseKey) verify(data, sig []byte) error {
+	ok := false
+	switch pub := k.pub.(type) {
+	case *ecdsa.PublicKey:
+		sum := sha256.Sum256(data)
+		ok = ecdsa.VerifyASN1(pub, sum[:], sig)
+	case ed25519.PublicKey:
+		ok = ed25519.Verify(pub, data, sig)
+	case *rsa.PublicKey:
+		sum := sha256.Sum256(data)
+		ok = rsa.VerifyPKCS1v15(pub, crypto.SHA256, sum[:], sig) == nil
+	}
+	if !ok {
+		return errors.New("webauthn: invalid signature")
+	}
+	return nil
+}
@@ -0,0 +1,291 @@
+// Package webauthn implements the relying-party side of WebAuthn (passkeys):
+// building the options for navigator.credentials.create/get and verifying
+// the authenticator's responses.
+//
+// Attestation is not evaluated: registrations ask for "none" conveyance and
+// any statement the client still sends is ignored, except that "packed"
+// self-attestation signatures are checked. Supported credential algorithms
+// are ES256, EdDSA and RS256.
+package webauthn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Authenticator data flags.
+const (
+	flagUserPresent    = 0x01
+	flagUserVerified   = 0x04
+	flagBackupEligible = 0x08
+	flagAttestedData   = 0x40
+)
+
+var (
+	ErrChallengeMismatch = errors.New("webauthn: challenge mismatch")
+	ErrOriginMismatch    = errors.New("webauthn: origin not allowed")
+	ErrCounterRegressed  = errors.New("webauthn: signature counter went backwards (possible cloned authenticator)")
+)
+
+// B64 encodes WebAuthn binary fields for JSON (base64url, no padding).
+var B64 = base64.RawURLEncoding
+
+// RelyingParty describes this site to authenticators.
+type RelyingParty struct {
+	ID      string   // effective domain, e.g. "example.com"
+	Name    string   // shown by the authenticator
+	Origins []string // allowed origins, e.g. "https://app.example.com"
+	// RequireUserVerification demands PIN/biometric, not just presence.
+	RequireUserVerification bool
+	Timeout                 time.Duration
+}
+
+// User identifies the account a credential is created for. ID is an opaque
+// handle (not the email) of at most 64 bytes.
+type User struct {
+	ID          []byte
+	Name        string
+	DisplayName string
+}
+
+// Credential is a verified new credential to store.
+type Credential struct {
+	ID             []byte
+	PublicKey      []byte // COSE_Key
+	SignCount      uint32
+	AAGUID         []byte
+	UserVerified   bool
+	BackupEligible bool
+}
+
+// Assertion is the result of a verified login.
+type Assertion struct {
+	SignCount    uint32
+	UserVerified bool
+}
+
+// NewChallenge returns a random base64url challenge.
+func NewChallenge() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return B64.EncodeToString(b), nil
+}
+
+func (rp *RelyingParty) timeoutMS() int64 {
+	if rp.Timeout <= 0 {
+		return 300000
+	}
+	return rp.Timeout.Milliseconds()
+}
+
+func (rp *RelyingParty) userVerification() string {
+	if rp.RequireUserVerification {
+		return "required"
+	}
+	return "preferred"
+}
+
+func descriptors(ids []string) []map[string]any {
+	out := make([]map[string]any, 0, len(ids))
+	for _, id := range ids {
+		out = append(out, map[string]any{"type": "public-key", "id": id})
+	}
+	return out
+}
+
+// CreationOptions returns PublicKeyCredentialCreationOptions (JSON form)
+// for navigator.credentials.create. exclude lists the user's existing
+// credential IDs so the same authenticator is not registered twice.
+func (rp *RelyingParty) CreationOptions(challenge string, u User, exclude []string) map[string]any {
+	return map[string]any{
+		"challenge": challenge,
+		"rp":        map[string]any{"id": rp.ID, "name": rp.Name},
+		"user": map[string]any{
+			"id":          B64.EncodeToString(u.ID),
+			"name":        u.Name,
+			"displayName": u.DisplayName,
+		},
+		"pubKeyCredParams": []map[string]any{
+			{"type": "public-key", "alg": AlgES256},
+			{"type": "public-key", "alg": AlgEdDSA},
+			{"type": "public-key", "alg": AlgRS256},
+		},
+		"timeout":     rp.timeoutMS(),
+		"attestation": "none",
+		"authenticatorSelection": map[string]any{
+			"residentKey":      "preferred",
+			"userVerification": rp.userVerification(),
+		},
+		"excludeCredentials": descriptors(exclude),
+	}
+}
+
+// RequestOptions returns PublicKeyCredentialRequestOptions (JSON form) for
+// navigator.credentials.get. An empty allow list lets the user pick any
+// discoverable passkey for this site.
+func (rp *RelyingParty) RequestOptions(challenge string, allow []string) map[string]any {
+	return map[string]any{
+		"challenge":        challenge,
+		"rpId":             rp.ID,
+		"timeout":          rp.timeoutMS(),
+		"userVerification": rp.userVerification(),
+		"allowCredentials": descriptors(allow),
+	}
+}
+
+type clientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+func (rp *RelyingParty) checkClientData(raw []byte, typ, challenge string) error {
+	var cd clientData
+	if err := json.Unmarshal(raw, &cd); err != nil {
+		return errors.New("webauthn: invalid clientDataJSON")
+	}
+	if cd.Type != typ {
+		return fmt.Errorf("webauthn: unexpected client data type %q", cd.Type)
+	}
+	if challenge == "" || subtle.ConstantTimeCompare([]byte(cd.Challenge), []byte(challenge)) != 1 {
+		return ErrChallengeMismatch
+	}
+	for _, o := range rp.Origins {
+		if cd.Origin == o {
+			return nil
+		}
+	}
+	return ErrOriginMismatch
+}
+
+type authData struct {
+	raw       []byte
+	flags     byte
+	signCount uint32
+	aaguid    []byte
+	credID    []byte
+	credKey   []byte
+}
+
+func (rp *RelyingParty) parseAuthData(raw []byte) (*authData, error) {
+	if len(raw) < 37 {
+		return nil, errors.New("webauthn: authenticator data too short")
+	}
+	rpHash := sha256.Sum256([]byte(rp.ID))
+	if !bytes.Equal(raw[:32], rpHash[:]) {
+		return nil, errors.New("webauthn: rp id hash mismatch")
+	}
+	ad := &authData{raw: raw, flags: raw[32], signCount: binary.BigEndian.Uint32(raw[33:37])}
+	if ad.flags&flagUserPresent == 0 {
+		return nil, errors.New("webauthn: user not present")
+	}
+	if rp.RequireUserVerification && ad.flags&flagUserVerified == 0 {
+		return nil, errors.New("webauthn: user not verified")
+	}
+	if ad.flags&flagAttestedData != 0 {
+		rest := raw[37:]
+		if len(rest) < 18 {
+			return nil, errors.New("webauthn: attested credential data too short")
+		}
+		ad.aaguid = rest[:16]
+		n := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if n == 0 || n > 1023 || len(rest) < n {
+			return nil, errors.New("webauthn: bad credential id")
+		}
+		ad.credID = rest[:n]
+		_, m, err := decodeCBOR(rest[n:])
+		if err != nil {
+			return nil, err
+		}
+		ad.credKey = rest[n : n+m]
+	}
+	return ad, nil
+}
+
+// FinishRegistration verifies an attestation response against the challenge
+// issued by CreationOptions and returns the credential to store.
+func (rp *RelyingParty) FinishRegistration(challenge string, clientDataJSON, attestationObject []byte) (*Credential, error) {
+	if err := rp.checkClientData(clientDataJSON, "webauthn.create", challenge); err != nil {
+		return nil, err
+	}
+	v, _, err := decodeCBOR(attestationObject)
+	if err != nil {
+		return nil, err
+	}
+	att, ok := v.(map[any]any)
+	if !ok {
+		return nil, errors.New("webauthn: invalid attestation object")
+	}
+	rawAuth, _ := att["authData"].([]byte)
+	ad, err := rp.parseAuthData(rawAuth)
+	if err != nil {
+		return nil, err
+	}
+	if ad.credID == nil {
+		return nil, errors.New("webauthn: no credential in attestation")
+	}
+	key, err := parseCOSEKey(ad.credKey)
+	if err != nil {
+		return nil, err
+	}
+	if format, _ := att["fmt"].(string); format == "packed" {
+		stmt, _ := att["attStmt"].(map[any]any)
+		if _, hasCert := stmt["x5c"]; !hasCert {
+			// self attestation: signed by the credential key itself
+			alg, _ := stmt["alg"].(int64)
+			sig, _ := stmt["sig"].([]byte)
+			cdHash := sha256.Sum256(clientDataJSON)
+			if alg != key.alg {
+				return nil, errors.New("webauthn: attestation algorithm mismatch")
+			}
+			if err := key.verify(append(append([]byte(nil), ad.raw...), cdHash[:]...), sig); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return &Credential{
+		ID:             append([]byte(nil), ad.credID...),
+		PublicKey:      append([]byte(nil), ad.credKey...),
+		SignCount:      ad.signCount,
+		AAGUID:         append([]byte(nil), ad.aaguid...),
+		UserVerified:   ad.flags&flagUserVerified != 0,
+		BackupEligible: ad.flags&flagBackupEligible != 0,
+	}, nil
+}
+
+// FinishLogin verifies an assertion made with the stored credential
+// publicKey. storedCount is the last signature counter seen for it.
+func (rp *RelyingParty) FinishLogin(challenge string, publicKey []byte, storedCount uint32, clientDataJSON, authenticatorData, signature []byte) (*Assertion, error) {
+	if err := rp.checkClientData(clientDataJSON, "webauthn.get", challenge); err != nil {
+		return nil, err
+	}
+	ad, err := rp.parseAuthData(authenticatorData)
+	if err != nil {
+		return nil, err
+	}
+	key, err := parseCOSEKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	cdHash := sha256.Sum256(clientDataJSON)
+	signed := append(append([]byte(nil), authenticatorData...), cdHash[:]...)
+	if err := key.verify(signed, signature); err != nil {
+		return nil, err
+	}
+	// counters are optional (0); when used they must increase
+	if (ad.signCount != 0 || storedCount != 0) && ad.signCount <= storedCount {
+		return nil, ErrCounterRegressed
+	}
+	return &Assertion{SignCount: ad.signCount, UserVerified: ad.flags&flagUserVerified != 0}, nil
+}